@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"ollama-proxy/logger"
+)
+
+// redactedValue replaces secret values in exported configuration
+const redactedValue = "[REDACTED]"
+
+// secretConfigKeys lists configuration variables whose values must never be exported
+var secretConfigKeys = map[string]bool{
+	"EXTERNAL_SERVER_API_KEY":     true,
+	"EXTERNAL_SERVER_HMAC_SECRET": true,
+	"ADMIN_API_KEY":               true,
+	"EPHEMERAL_TOKEN_SECRET":      true,
+	"ZERO_RETENTION_KEYS":         true,
+}
+
+// effectiveConfig records the value each configuration variable resolved to, from the environment or its default
+var effectiveConfig = struct {
+	sync.Mutex
+	values map[string]string
+}{values: make(map[string]string)}
+
+// recordConfig stores the effective value of a configuration variable
+func recordConfig(key, value string) {
+	effectiveConfig.Lock()
+	defer effectiveConfig.Unlock()
+	effectiveConfig.values[key] = value
+}
+
+// configEnvFormat renders the effective configuration as a shell export script with secrets redacted
+func configEnvFormat() string {
+	effectiveConfig.Lock()
+	defer effectiveConfig.Unlock()
+
+	keys := make([]string, 0, len(effectiveConfig.values))
+	for key := range effectiveConfig.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		value := effectiveConfig.values[key]
+		if secretConfigKeys[key] && value != "" {
+			value = redactedValue
+		}
+		fmt.Fprintf(&b, "export %s=%s\n", key, shellQuote(value))
+	}
+	return b.String()
+}
+
+// shellQuote double-quotes a value, escaping the characters the shell expands inside double quotes
+func shellQuote(value string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"', '\\', '$', '`':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// adminConfigEnvHandler serves GET /admin/config/env-format
+func adminConfigEnvHandler(w http.ResponseWriter, r *http.Request) {
+	if adminAPIKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !isAdminRequest(r) {
+		logger.Warning("Unauthorized: Invalid admin key", map[string]interface{}{
+			"endpoint": r.URL.Path,
+		})
+		http.Error(w, "Unauthorized: Invalid admin key", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(configEnvFormat()))
+}
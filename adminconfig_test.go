@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestAdminConfigEnvFormat tests exporting the effective configuration as shell exports
+func TestAdminConfigEnvFormat(t *testing.T) {
+	t.Setenv("OLLAMA_URL", "http://ollama.internal:11434")
+	t.Setenv("API_KEY_HEADER_NAME", "X-API-Key")
+	t.Setenv("ADMIN_API_KEY", "admin-secret")
+	t.Setenv("EXTERNAL_SERVER_API_KEY", "server-secret")
+	t.Setenv("VALIDATION_BATCH_SIZE", "not-a-number")
+	t.Setenv("REPLAY_CAPTURE_PATH", `/tmp/"$HOME"`)
+	loadConfig()
+	defer func() { adminAPIKey = "" }()
+
+	req := httptest.NewRequest("GET", "/admin/config/env-format", nil)
+	req.Header.Set("X-API-Key", "admin-secret")
+	rr := httptest.NewRecorder()
+	adminConfigEnvHandler(rr, req)
+	assertResponseStatus(t, rr, http.StatusOK)
+	body := rr.Body.String()
+
+	for _, line := range []string{
+		`export OLLAMA_URL="http://ollama.internal:11434"`,
+		`export ADMIN_API_KEY="[REDACTED]"`,
+		`export EXTERNAL_SERVER_API_KEY="[REDACTED]"`,
+		`export EPHEMERAL_TOKEN_SECRET=""`,
+		`export PROXY_PORT="8080"`,
+		`export VALIDATION_BATCH_SIZE="1"`,
+		`export VALIDATION_BATCH_WAIT="10ms"`,
+		`export REPLAY_CAPTURE_PATH="/tmp/\"\$HOME\""`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected %s in export script", line)
+		}
+	}
+	if strings.Contains(body, "admin-secret") || strings.Contains(body, "server-secret") {
+		t.Errorf("Expected secrets to be redacted, got %s", body)
+	}
+
+	// Only the admin key may read the configuration
+	req.Header.Set("X-API-Key", "not-admin")
+	rr = httptest.NewRecorder()
+	adminConfigEnvHandler(rr, req)
+	assertResponseStatus(t, rr, http.StatusUnauthorized)
+}
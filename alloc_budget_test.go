@@ -0,0 +1,204 @@
+//go:build !race
+
+// The race detector instruments allocations, so budgets are only measured without it.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"ollama-proxy/logger"
+)
+
+// allocBudgets caps the average allocations per request through proxyHandler for each hot path
+// scenario, including building the request and recorder. The budgets sit about 10% above the
+// measured counts (chat 171, streaming_chat 234, embed_batch 748), so a field or two doesn't need a
+// bump but a regression on the hot path does. Lower a budget when a change saves allocations;
+// raising one needs a reason in the commit that does it.
+var allocBudgets = map[string]float64{
+	"chat":           190,
+	"streaming_chat": 260,
+	"embed_batch":    820,
+}
+
+// roundTripFunc serves upstream requests in-process so the measurements don't include sockets
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// hotPathScenario is one kind of request measured by the benchmarks and budgets
+type hotPathScenario struct {
+	name     string
+	path     string
+	body     []byte
+	upstream func(r *http.Request) []byte
+}
+
+func hotPathScenarios() []hotPathScenario {
+	chat, _ := json.Marshal(map[string]interface{}{
+		"model":    "llama3",
+		"messages": []ChatMessage{{Role: "user", Content: "Why is the sky blue?"}},
+		"stream":   false,
+	})
+	streamingChat, _ := json.Marshal(map[string]interface{}{
+		"model":    "llama3",
+		"messages": []ChatMessage{{Role: "user", Content: "Why is the sky blue?"}},
+	})
+	embed, _ := json.Marshal(EmbedRequest{Model: "nomic-embed", Input: embedInputs(32)})
+
+	chatResponse, _ := json.Marshal(ChatResponse{
+		Model:           "llama3",
+		Message:         ChatMessage{Role: "assistant", Content: "Rayleigh scattering."},
+		Done:            true,
+		PromptEvalCount: 8,
+		EvalCount:       4,
+	})
+	var stream bytes.Buffer
+	for _, word := range strings.Fields("Sunlight scatters off the molecules in the air") {
+		line, _ := json.Marshal(ChatResponse{Model: "llama3", Message: ChatMessage{Role: "assistant", Content: word + " "}})
+		stream.Write(append(line, '\n'))
+	}
+	done, _ := json.Marshal(ChatResponse{Model: "llama3", Done: true, DoneReason: "stop", PromptEvalCount: 8, EvalCount: 8})
+	stream.Write(append(done, '\n'))
+
+	return []hotPathScenario{
+		{
+			name:     "chat",
+			path:     "/api/chat",
+			body:     chat,
+			upstream: func(*http.Request) []byte { return chatResponse },
+		},
+		{
+			name:     "streaming_chat",
+			path:     "/api/chat",
+			body:     streamingChat,
+			upstream: func(*http.Request) []byte { return stream.Bytes() },
+		},
+		{
+			name: "embed_batch",
+			path: "/api/embed",
+			body: embed,
+			upstream: func(r *http.Request) []byte {
+				var req struct {
+					Input []string `json:"input"`
+				}
+				json.NewDecoder(r.Body).Decode(&req)
+				resp := EmbedResponse{Model: "nomic-embed", PromptEvalCount: len(req.Input)}
+				for range req.Input {
+					resp.Embeddings = append(resp.Embeddings, []float32{0.1, 0.2, 0.3, 0.4})
+				}
+				data, _ := json.Marshal(resp)
+				return data
+			},
+		},
+	}
+}
+
+// setupHotPath runs the handler with the default configuration against in-process validation and
+// upstream. Metrics are reported asynchronously, so they're left off and stream termination keeps
+// response capture on instead.
+func setupHotPath(tb testing.TB, scenario hotPathScenario) {
+	loadConfig()
+	useProxyTargets(tb, "http://ollama.invalid", "", "")
+	useValidator(tb, mockValidator{})
+	validationMockValid = true
+	metricsEnabled = false
+	appendDoneChunk = true
+	embedMaxBatch = 8
+	embedSplitParallelism = 4
+	logger.SetOutput(io.Discard)
+	tb.Cleanup(func() {
+		metricsEnabled = true
+		appendDoneChunk = false
+		embedMaxBatch = 0
+		logger.SetOutput(os.Stdout)
+		resetReverseProxy()
+	})
+
+	resetReverseProxy()
+	contentType := "application/json; charset=utf-8"
+	if scenario.name == "streaming_chat" {
+		contentType = "application/x-ndjson"
+	}
+	getReverseProxy().Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		body := scenario.upstream(r)
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Content-Type": {contentType}},
+			Body:          io.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       r,
+		}, nil
+	})
+}
+
+// serveHotPath sends one request through the handler
+func serveHotPath(tb testing.TB, scenario hotPathScenario) {
+	req := httptest.NewRequest("POST", scenario.path, bytes.NewReader(scenario.body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "test-key")
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, req)
+	if rr.Code != http.StatusOK {
+		tb.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func benchmarkHotPath(b *testing.B, name string) {
+	for _, scenario := range hotPathScenarios() {
+		if scenario.name != name {
+			continue
+		}
+		setupHotPath(b, scenario)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			serveHotPath(b, scenario)
+		}
+	}
+}
+
+func BenchmarkProxyHandlerChat(b *testing.B) {
+	benchmarkHotPath(b, "chat")
+}
+
+func BenchmarkProxyHandlerStreamingChat(b *testing.B) {
+	benchmarkHotPath(b, "streaming_chat")
+}
+
+func BenchmarkProxyHandlerEmbedBatch(b *testing.B) {
+	benchmarkHotPath(b, "embed_batch")
+}
+
+// TestAllocBudget fails when a hot path scenario allocates more per request than its budget
+func TestAllocBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("allocation budgets are measured in full test runs")
+	}
+	for _, scenario := range hotPathScenarios() {
+		t.Run(scenario.name, func(t *testing.T) {
+			budget, ok := allocBudgets[scenario.name]
+			if !ok {
+				t.Fatalf("No allocation budget for %s", scenario.name)
+			}
+			setupHotPath(t, scenario)
+			serveHotPath(t, scenario) // warm up lazily created state
+
+			allocs := testing.AllocsPerRun(200, func() { serveHotPath(t, scenario) })
+			if allocs > budget {
+				t.Errorf("%s allocates %.0f times per request, over its budget of %.0f", scenario.name, allocs, budget)
+			} else {
+				t.Logf("%s allocates %.0f times per request (budget %.0f)", scenario.name, allocs, budget)
+			}
+		})
+	}
+}
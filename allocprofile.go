@@ -0,0 +1,51 @@
+package main
+
+import (
+	"runtime"
+
+	"ollama-proxy/logger"
+)
+
+// Allocation profiling configuration
+var allocProfilingThresholdBytes int64 // 0 disables
+
+// readTotalAlloc returns the bytes allocated since the process started; replaced in tests
+var readTotalAlloc = func() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.TotalAlloc
+}
+
+// allocProbe measures the bytes allocated while a request is served. The count is process-wide, so
+// concurrent requests inflate it, and reading it briefly stops the world; enable it while
+// investigating GC pressure rather than permanently.
+type allocProbe struct {
+	start uint64
+}
+
+// newAllocProbe starts measuring, returning nil when ALLOC_PROFILING_THRESHOLD_BYTES is unset
+func newAllocProbe() *allocProbe {
+	if allocProfilingThresholdBytes <= 0 {
+		return nil
+	}
+	return &allocProbe{start: readTotalAlloc()}
+}
+
+// report warns when the request allocated more than the threshold
+func (p *allocProbe) report(requestID, model, endpoint string, requestBytes, responseBytes int64) {
+	if p == nil {
+		return
+	}
+	allocated := int64(readTotalAlloc() - p.start)
+	if allocated <= allocProfilingThresholdBytes {
+		return
+	}
+	logger.Warning("High allocation request", map[string]interface{}{
+		"request_id":     requestID,
+		"model":          model,
+		"endpoint":       endpoint,
+		"request_bytes":  requestBytes,
+		"response_bytes": responseBytes,
+		"alloc_bytes":    allocated,
+	})
+}
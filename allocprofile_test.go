@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"ollama-proxy/logger"
+)
+
+// TestAllocProbe tests that only requests allocating more than the threshold are reported
+func TestAllocProbe(t *testing.T) {
+	var total uint64
+	original := readTotalAlloc
+	readTotalAlloc = func() uint64 { return total }
+	defer func() {
+		readTotalAlloc = original
+		allocProfilingThresholdBytes = 0
+	}()
+
+	if newAllocProbe() != nil {
+		t.Fatal("Expected no probe while allocation profiling is disabled")
+	}
+	allocProfilingThresholdBytes = 1 << 20
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+
+	probe := newAllocProbe()
+	total += 512 << 10
+	probe.report("req-1", "llama2", "/api/chat", 100, 200)
+	if logs.Len() != 0 {
+		t.Errorf("Expected no warning below the threshold, got %s", logs.String())
+	}
+
+	probe = newAllocProbe()
+	total += 3 << 20
+	probe.report("req-2", "llava", "/api/generate", 4096, 8192)
+	var entry struct {
+		Message string                 `json:"message"`
+		Fields  map[string]interface{} `json:"fields"`
+	}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected one JSON log entry, got %s", logs.String())
+	}
+	f := entry.Fields
+	if entry.Message != "High allocation request" || f["model"] != "llava" || f["endpoint"] != "/api/generate" ||
+		f["request_bytes"] != float64(4096) || f["response_bytes"] != float64(8192) || f["alloc_bytes"] != float64(3<<20) {
+		t.Errorf("Expected a warning with the request's sizes and allocations, got %s", logs.String())
+	}
+}
+
+// TestProxyHandlerAllocProfiling tests that proxied requests are measured when profiling is enabled
+func TestProxyHandlerAllocProfiling(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+	allocProfilingThresholdBytes = 1
+	defer func() { allocProfilingThresholdBytes = 0 }()
+	resetReverseProxy()
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", map[string]interface{}{
+		"model":    "llama2",
+		"messages": []ChatMessage{{Role: "user", Content: "hi"}},
+		"stream":   false,
+	}, "test-api-key"))
+	assertResponseStatus(t, rr, http.StatusOK)
+	if !strings.Contains(logs.String(), `"message":"High allocation request"`) {
+		t.Errorf("Expected a high allocation warning, got %s", logs.String())
+	}
+}
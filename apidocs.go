@@ -0,0 +1,35 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+
+	"ollama-proxy/logger"
+)
+
+// apiReference is the Markdown reference generated from types.go by cmd/apidoc
+//
+//go:embed docs/api.md
+var apiReference string
+
+// adminDocsHandler serves GET /admin/docs
+func adminDocsHandler(w http.ResponseWriter, r *http.Request) {
+	if adminAPIKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !isAdminRequest(r) {
+		logger.Warning("Unauthorized: Invalid admin key", map[string]interface{}{
+			"endpoint": r.URL.Path,
+		})
+		http.Error(w, "Unauthorized: Invalid admin key", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Write([]byte(apiReference))
+}
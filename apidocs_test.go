@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestAdminDocs tests serving the generated API reference to admins only
+func TestAdminDocs(t *testing.T) {
+	adminAPIKey = "admin-secret"
+	defer func() { adminAPIKey = "" }()
+
+	req := httptest.NewRequest("GET", "/admin/docs", nil)
+	rr := httptest.NewRecorder()
+	adminDocsHandler(rr, req)
+	assertResponseStatus(t, rr, http.StatusUnauthorized)
+
+	req.Header.Set("X-API-Key", "admin-secret")
+	rr = httptest.NewRecorder()
+	adminDocsHandler(rr, req)
+	assertResponseStatus(t, rr, http.StatusOK)
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/markdown") {
+		t.Errorf("Expected Markdown content type, got %q", ct)
+	}
+	if !strings.Contains(rr.Body.String(), "## MetricsData") {
+		t.Error("Expected the MetricsData reference in the docs")
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Bearer token configuration
+var (
+	acceptBearerToken bool
+	stripBearerToken  bool
+)
+
+// apiKeyFromRequest returns the key from API_KEY_HEADER_NAME or, with ACCEPT_BEARER_TOKEN, from an
+// Authorization: Bearer header, which OpenAI SDKs send and can't easily replace. bearer reports
+// whether the key came from the Authorization header.
+func apiKeyFromRequest(r *http.Request) (key string, bearer bool) {
+	if key := r.Header.Get(apiKeyHeaderName); key != "" || !acceptBearerToken {
+		return key, false
+	}
+	scheme, token, ok := strings.Cut(strings.TrimSpace(r.Header.Get("Authorization")), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
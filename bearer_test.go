@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAPIKeyFromRequest tests taking the key from the configured header or a bearer token
+func TestAPIKeyFromRequest(t *testing.T) {
+	apiKeyHeaderName = "X-API-Key"
+	defer func() { acceptBearerToken = false }()
+
+	testCases := []struct {
+		name          string
+		acceptBearer  bool
+		apiKey        string
+		authorization string
+		expectedKey   string
+		bearer        bool
+	}{
+		{"Configured Header", true, "header-key", "Bearer sk-other", "header-key", false},
+		{"Bearer Disabled", false, "", "Bearer sk-tenant", "", false},
+		{"Bearer Token", true, "", "Bearer sk-tenant", "sk-tenant", true},
+		{"Scheme Is Case Insensitive", true, "", "bearer sk-tenant", "sk-tenant", true},
+		{"Whitespace Trimmed", true, "", "  Bearer   sk-tenant  ", "sk-tenant", true},
+		{"Other Scheme", true, "", "Basic dXNlcjpwYXNz", "", false},
+		{"Empty Token", true, "", "Bearer ", "", false},
+		{"No Credentials", true, "", "", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			acceptBearerToken = tc.acceptBearer
+			r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+			if tc.apiKey != "" {
+				r.Header.Set("X-API-Key", tc.apiKey)
+			}
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+			key, bearer := apiKeyFromRequest(r)
+			if key != tc.expectedKey || bearer != tc.bearer {
+				t.Errorf("Expected (%q, %v), got (%q, %v)", tc.expectedKey, tc.bearer, key, bearer)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerBearerToken tests that bearer keys are validated and metered like header keys, and
+// optionally kept from Ollama
+func TestProxyHandlerBearerToken(t *testing.T) {
+	authorizations := make(chan string, 1)
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations <- r.Header.Get("Authorization")
+		json.NewEncoder(w).Encode(ChatResponse{Model: "llama2", Done: true, PromptEvalCount: 3, EvalCount: 2})
+	}))
+	defer ollamaServer.Close()
+	validatedKeys := make(chan string, 1)
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var details RequestDetails
+		json.NewDecoder(r.Body).Decode(&details)
+		validatedKeys <- details.APIKey
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true})
+	}))
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+	acceptBearerToken = true
+	defer func() {
+		acceptBearerToken = false
+		stripBearerToken = false
+	}()
+	resetReverseProxy()
+
+	for _, strip := range []bool{false, true} {
+		stripBearerToken = strip
+		req := createTestRequest(t, "POST", "/api/chat", map[string]interface{}{
+			"model":    "llama2",
+			"messages": []ChatMessage{{Role: "user", Content: "hi"}},
+			"stream":   false,
+		}, "")
+		req.Header.Set("Authorization", "Bearer sk-tenant")
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, req)
+		assertResponseStatus(t, rr, http.StatusOK)
+
+		if key := <-validatedKeys; key != "sk-tenant" {
+			t.Errorf("Expected validation of sk-tenant, got %q", key)
+		}
+		if metrics := waitForMetrics(t, received); metrics.APIKey != "sk-tenant" {
+			t.Errorf("Expected metrics for sk-tenant, got %q", metrics.APIKey)
+		}
+		expected := "Bearer sk-tenant"
+		if strip {
+			expected = ""
+		}
+		if got := <-authorizations; got != expected {
+			t.Errorf("Expected Ollama to see Authorization %q with STRIP_BEARER_TOKEN=%v, got %q", expected, strip, got)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// bodySizeBuckets span 1KB to 10MB
+var bodySizeBuckets = []float64{1 << 10, 10 << 10, 100 << 10, 1 << 20, 10 << 20}
+
+var (
+	requestBodyBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "proxy_request_body_bytes",
+		Help:    "Size of request bodies forwarded to Ollama.",
+		Buckets: bodySizeBuckets,
+	})
+	responseBodyBytes = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "proxy_response_body_bytes",
+		Help:    "Size of response bodies written to clients.",
+		Buckets: bodySizeBuckets,
+	})
+)
+
+// observeBodySizes records a proxied request's payload sizes
+func observeBodySizes(requestBytes, responseBytes int64) {
+	requestBodyBytes.Observe(float64(requestBytes))
+	responseBodyBytes.Observe(float64(responseBytes))
+}
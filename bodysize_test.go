@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// scrapeMetric returns the value of a sample line from the Prometheus endpoint
+func scrapeMetric(t *testing.T, sample string) string {
+	rr := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	scanner := bufio.NewScanner(rr.Body)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), sample+" "); ok {
+			return value
+		}
+	}
+	t.Fatalf("No %s sample in metrics", sample)
+	return ""
+}
+
+// TestProxyHandlerBodySizeHistograms tests that request and response sizes land in their buckets
+func TestProxyHandlerBodySizeHistograms(t *testing.T) {
+	response := `{"model":"llama2","message":{"role":"assistant","content":"` + strings.Repeat("x", 20<<10) + `"},"done":true}`
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(response))
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+
+	requestsUnder1KB := scrapeMetric(t, `proxy_request_body_bytes_bucket{le="1024"}`)
+	responsesUnder100KB := scrapeMetric(t, `proxy_response_body_bytes_bucket{le="102400"}`)
+	responsesUnder10KB := scrapeMetric(t, `proxy_response_body_bytes_bucket{le="10240"}`)
+
+	chat := ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "Hello"}}}
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", chat, "test-api-key"))
+	assertResponseStatus(t, rr, http.StatusOK)
+
+	for _, tc := range []struct {
+		sample string
+		before string
+		grew   bool
+	}{
+		{`proxy_request_body_bytes_bucket{le="1024"}`, requestsUnder1KB, true},
+		{`proxy_response_body_bytes_bucket{le="102400"}`, responsesUnder100KB, true},
+		{`proxy_response_body_bytes_bucket{le="10240"}`, responsesUnder10KB, false},
+	} {
+		if after := scrapeMetric(t, tc.sample); (after != tc.before) != tc.grew {
+			t.Errorf("Expected %s to grow=%v, went from %s to %s", tc.sample, tc.grew, tc.before, after)
+		}
+	}
+}
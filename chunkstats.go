@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math/bits"
+	"time"
+)
+
+// Chunk cadence configuration
+var (
+	streamStallThreshold time.Duration
+)
+
+// gapSubBuckets splits each power-of-two range of gaps so percentiles are accurate to within 25%
+const gapSubBuckets = 4
+
+// chunkDigest summarizes inter-chunk arrival gaps incrementally without storing timestamps
+type chunkDigest struct {
+	chunks  int
+	last    time.Time
+	min     time.Duration
+	max     time.Duration
+	sum     time.Duration
+	buckets [64 * gapSubBuckets]uint32
+}
+
+// observe records a chunk arriving at now and returns the gap since the previous chunk
+func (d *chunkDigest) observe(now time.Time) time.Duration {
+	d.chunks++
+	if d.chunks == 1 {
+		d.last = now
+		return 0
+	}
+
+	gap := now.Sub(d.last)
+	d.last = now
+	if gap < 0 {
+		gap = 0
+	}
+	if d.chunks == 2 || gap < d.min {
+		d.min = gap
+	}
+	if gap > d.max {
+		d.max = gap
+	}
+	d.sum += gap
+	d.buckets[gapBucket(uint64(gap/time.Microsecond))]++
+	return gap
+}
+
+// gapBucket maps a gap in microseconds to its histogram bucket
+func gapBucket(us uint64) int {
+	if us < gapSubBuckets {
+		return int(us)
+	}
+	exp := bits.Len64(us) - 1
+	sub := (us >> (exp - 2)) & (gapSubBuckets - 1)
+	return exp*gapSubBuckets + int(sub)
+}
+
+// gapBucketUpper returns the largest gap in microseconds that falls into a bucket
+func gapBucketUpper(bucket int) uint64 {
+	if bucket < gapSubBuckets {
+		return uint64(bucket)
+	}
+	exp := bucket / gapSubBuckets
+	sub := uint64(bucket % gapSubBuckets)
+	width := uint64(1) << (exp - 2)
+	return (gapSubBuckets+sub)*width + width - 1
+}
+
+// gaps returns the number of inter-chunk gaps observed
+func (d *chunkDigest) gaps() int {
+	if d.chunks < 2 {
+		return 0
+	}
+	return d.chunks - 1
+}
+
+// mean returns the average gap between chunks
+func (d *chunkDigest) mean() time.Duration {
+	if d.gaps() == 0 {
+		return 0
+	}
+	return d.sum / time.Duration(d.gaps())
+}
+
+// percentile estimates the gap below which the given fraction of gaps fall
+func (d *chunkDigest) percentile(p float64) time.Duration {
+	total := d.gaps()
+	if total == 0 {
+		return 0
+	}
+
+	rank := uint32(p*float64(total) + 0.999999)
+	var seen uint32
+	for bucket, count := range d.buckets {
+		seen += count
+		if seen >= rank {
+			estimate := time.Duration(gapBucketUpper(bucket)) * time.Microsecond
+			if estimate > d.max {
+				return d.max
+			}
+			if estimate < d.min {
+				return d.min
+			}
+			return estimate
+		}
+	}
+	return d.max
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"ollama-proxy/logger"
+)
+
+// TestChunkDigest tests the incremental inter-chunk gap digest
+func TestChunkDigest(t *testing.T) {
+	var d chunkDigest
+	now := time.Now()
+	d.observe(now)
+	for i := 0; i < 19; i++ {
+		now = now.Add(10 * time.Millisecond)
+		d.observe(now)
+	}
+	now = now.Add(200 * time.Millisecond)
+	d.observe(now)
+
+	if d.chunks != 21 || d.gaps() != 20 {
+		t.Errorf("Expected 21 chunks and 20 gaps, got %d and %d", d.chunks, d.gaps())
+	}
+	if d.min != 10*time.Millisecond {
+		t.Errorf("Expected min gap 10ms, got %v", d.min)
+	}
+	if d.max != 200*time.Millisecond {
+		t.Errorf("Expected max gap 200ms, got %v", d.max)
+	}
+	if d.mean() != 19500*time.Microsecond {
+		t.Errorf("Expected mean gap 19.5ms, got %v", d.mean())
+	}
+	if p95 := d.percentile(0.95); p95 < 10*time.Millisecond || p95 > 12500*time.Microsecond {
+		t.Errorf("Expected p95 gap near 10ms, got %v", p95)
+	}
+	if p99 := d.percentile(0.99); p99 != 200*time.Millisecond {
+		t.Errorf("Expected p99 gap 200ms, got %v", p99)
+	}
+}
+
+// TestChunkDigestNoAllocations tests that observing a chunk does not allocate
+func TestChunkDigestNoAllocations(t *testing.T) {
+	var d chunkDigest
+	now := time.Now()
+	allocs := testing.AllocsPerRun(1000, func() {
+		now = now.Add(time.Millisecond)
+		d.observe(now)
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations per chunk, got %v", allocs)
+	}
+}
+
+// TestProxyHandlerChunkCadence tests digest metrics and the stall warning for a streamed response
+func TestProxyHandlerChunkCadence(t *testing.T) {
+	ollamaServer := mockStreamingOllamaServer(t, []string{
+		`{"model":"llama2","response":"a","done":false}`,
+		`{"model":"llama2","response":"b","done":false}`,
+		`{"model":"llama2","response":"c","done":false}`,
+		`{"model":"llama2","response":"","done":true,"eval_count":3}`,
+	}, 40*time.Millisecond)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	streamStallThreshold = 30 * time.Millisecond
+	defer func() { streamStallThreshold = 0 }()
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+
+	req := createTestRequest(t, "POST", "/api/generate", map[string]interface{}{
+		"model":  "llama2",
+		"prompt": "hi",
+	}, "test-api-key")
+	proxyHandler(httptest.NewRecorder(), req)
+	metrics := waitForMetrics(t, received)
+
+	if metrics.ChunkCount != 4 {
+		t.Errorf("Expected 4 chunks, got %d", metrics.ChunkCount)
+	}
+	if metrics.ChunkGapMinUs < 35000 || metrics.ChunkGapMinUs > metrics.ChunkGapMeanUs {
+		t.Errorf("Expected min gap of about 40ms below the mean, got min %dus mean %dus", metrics.ChunkGapMinUs, metrics.ChunkGapMeanUs)
+	}
+	if metrics.ChunkGapP95Us < metrics.ChunkGapMinUs || metrics.ChunkGapP95Us > metrics.LongestStallUs {
+		t.Errorf("Expected p95 between min and max, got %dus (min %dus, max %dus)", metrics.ChunkGapP95Us, metrics.ChunkGapMinUs, metrics.LongestStallUs)
+	}
+	if count := strings.Count(logs.String(), `"message":"Stream stalled"`); count != 1 {
+		t.Errorf("Expected one stall warning, got %d in %s", count, logs.String())
+	}
+}
+
+func BenchmarkChunkDigestObserve(b *testing.B) {
+	var d chunkDigest
+	now := time.Now()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		now = now.Add(time.Millisecond)
+		d.observe(now)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"ollama-proxy/logger"
+)
+
+// Client address configuration
+var (
+	trustProxyHeaders bool // take the client address from X-Forwarded-For, for proxies behind a load balancer
+)
+
+// clientIP returns the address the request came from. With TRUST_PROXY_HEADERS it is the last
+// X-Forwarded-For entry, the one the load balancer in front of the proxy added; earlier entries are
+// whatever the client sent and can't be trusted.
+func clientIP(r *http.Request) (netip.Addr, bool) {
+	if trustProxyHeaders {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			last := forwarded[len(forwarded)-1]
+			if i := strings.LastIndexByte(last, ','); i >= 0 {
+				last = last[i+1:]
+			}
+			if addr, err := netip.ParseAddr(strings.TrimSpace(last)); err == nil {
+				return addr.Unmap(), true
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// clientAddress returns the client address sent to the validation service: clientIP when it parses,
+// otherwise the raw remote address
+func clientAddress(r *http.Request) string {
+	if addr, ok := clientIP(r); ok {
+		return addr.String()
+	}
+	return r.RemoteAddr
+}
+
+// clientIPAllowed reports whether the request comes from one of the validation service's allowedCIDRs.
+// A list with an entry that doesn't parse is logged and ignored, so a bad answer doesn't cut off traffic.
+func clientIPAllowed(cidrs []string, r *http.Request, fields map[string]interface{}) bool {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			logger.Warning("Ignoring allowedCIDRs with an invalid range", map[string]interface{}{
+				"api_key":  fields["api_key"],
+				"endpoint": fields["endpoint"],
+				"cidr":     cidr,
+				"error":    err.Error(),
+			})
+			return true
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	addr, ok := clientIP(r)
+	if !ok {
+		return false
+	}
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
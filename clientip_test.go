@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"ollama-proxy/logger"
+)
+
+// TestClientIP tests where the client address comes from with and without TRUST_PROXY_HEADERS
+func TestClientIP(t *testing.T) {
+	defer func() { trustProxyHeaders = false }()
+
+	testCases := []struct {
+		name       string
+		trust      bool
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		{"Remote Address", false, "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"Forwarded Ignored Without Trust", false, "10.0.0.1:5000", []string{"203.0.113.7"}, "10.0.0.1"},
+		{"Forwarded Trusted", true, "10.0.0.1:5000", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"Last Forwarded Entry", true, "10.0.0.1:5000", []string{"198.51.100.1, 203.0.113.7"}, "203.0.113.7"},
+		{"Last Forwarded Header", true, "10.0.0.1:5000", []string{"198.51.100.1", "203.0.113.7"}, "203.0.113.7"},
+		{"Invalid Forwarded Falls Back", true, "10.0.0.1:5000", []string{"unknown"}, "10.0.0.1"},
+		{"IPv6 Remote Address", false, "[2001:db8::1]:5000", nil, "2001:db8::1"},
+		{"IPv6 Forwarded", true, "10.0.0.1:5000", []string{"2001:db8::7"}, "2001:db8::7"},
+		{"IPv4-Mapped IPv6", false, "[::ffff:203.0.113.7]:5000", nil, "203.0.113.7"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			trustProxyHeaders = tc.trust
+			req := httptest.NewRequest("GET", "/api/tags", nil)
+			req.RemoteAddr = tc.remoteAddr
+			for _, value := range tc.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+			addr, ok := clientIP(req)
+			if !ok || addr.String() != tc.expected {
+				t.Errorf("Expected %s, got %s (%v)", tc.expected, addr, ok)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerValidationIPAddress tests that the validation service is sent the client IP,
+// taken from X-Forwarded-For only with TRUST_PROXY_HEADERS
+func TestProxyHandlerValidationIPAddress(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	defer func() { trustProxyHeaders = false }()
+
+	var received RequestDetails
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true})
+	}))
+	defer validationServer.Close()
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+
+	testCases := []struct {
+		name     string
+		trust    bool
+		expected string
+	}{
+		{"Remote Address", false, "10.0.0.1"},
+		{"Trusted Forwarded", true, "203.0.113.7"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			trustProxyHeaders = tc.trust
+			req := createTestRequest(t, "POST", "/api/chat", ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "Hi"}}}, "test-key")
+			req.RemoteAddr = "10.0.0.1:5000"
+			req.Header.Set("X-Forwarded-For", "203.0.113.7")
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, req)
+			assertResponseStatus(t, rr, http.StatusOK)
+			if received.IPAddress != tc.expected {
+				t.Errorf("Expected ipAddress %s, got %s", tc.expected, received.IPAddress)
+			}
+		})
+	}
+}
+
+// TestClientIPAllowed tests IPv4 and IPv6 ranges and invalid lists
+func TestClientIPAllowed(t *testing.T) {
+	testCases := []struct {
+		name       string
+		cidrs      []string
+		remoteAddr string
+		expected   bool
+	}{
+		{"IPv4 Inside", []string{"203.0.113.0/24"}, "203.0.113.7:5000", true},
+		{"IPv4 Outside", []string{"203.0.113.0/24"}, "198.51.100.7:5000", false},
+		{"Single Address", []string{"203.0.113.7/32"}, "203.0.113.7:5000", true},
+		{"Any Of Several", []string{"198.51.100.0/24", "203.0.113.0/24"}, "203.0.113.7:5000", true},
+		{"IPv6 Inside", []string{"2001:db8::/32"}, "[2001:db8:abcd::1]:5000", true},
+		{"IPv6 Outside", []string{"2001:db8::/32"}, "[2001:db9::1]:5000", false},
+		{"IPv4 Client Against IPv6 Range", []string{"2001:db8::/32"}, "203.0.113.7:5000", false},
+		{"IPv4-Mapped Client Against IPv4 Range", []string{"203.0.113.0/24"}, "[::ffff:203.0.113.7]:5000", true},
+		{"Host Bits Set", []string{"203.0.113.9/24"}, "203.0.113.7:5000", true},
+		{"Empty List", []string{}, "203.0.113.7:5000", false},
+		{"Invalid Range Ignores List", []string{"203.0.113.0/24", "not-a-cidr"}, "198.51.100.7:5000", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/tags", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if allowed := clientIPAllowed(tc.cidrs, req, map[string]interface{}{}); allowed != tc.expected {
+				t.Errorf("Expected %v, got %v", tc.expected, allowed)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerAllowedCIDRs tests that keys pinned to IP ranges get 403 from elsewhere
+func TestProxyHandlerAllowedCIDRs(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	useProxyTargets(t, ollamaServer.URL, "", "")
+	defer func() { trustProxyHeaders = false }()
+
+	testCases := []struct {
+		name           string
+		cidrs          []string
+		trust          bool
+		remoteAddr     string
+		forwarded      string
+		expectedStatus int
+	}{
+		{"No Restriction", nil, false, "198.51.100.7:5000", "", http.StatusOK},
+		{"Allowed IPv4", []string{"203.0.113.0/24"}, false, "203.0.113.7:5000", "", http.StatusOK},
+		{"Denied IPv4", []string{"203.0.113.0/24"}, false, "198.51.100.7:5000", "", http.StatusForbidden},
+		{"Allowed IPv6", []string{"2001:db8::/32"}, false, "[2001:db8::1]:5000", "", http.StatusOK},
+		{"Denied IPv6", []string{"2001:db8::/32"}, false, "[2001:db9::1]:5000", "", http.StatusForbidden},
+		{"Spoofed Forwarded Ignored", []string{"203.0.113.0/24"}, false, "198.51.100.7:5000", "203.0.113.7", http.StatusForbidden},
+		{"Trusted Forwarded", []string{"203.0.113.0/24"}, true, "10.0.0.1:5000", "203.0.113.7", http.StatusOK},
+		{"Invalid Range", []string{"203.0.113.0/33"}, false, "198.51.100.7:5000", "", http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			trustProxyHeaders = tc.trust
+			validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, AllowedCIDRs: tc.cidrs})
+			defer validationServer.Close()
+			externalValidationURL = validationServer.URL
+
+			var logs bytes.Buffer
+			logger.SetOutput(&logs)
+			defer logger.SetOutput(os.Stdout)
+
+			req := createTestRequest(t, "POST", "/api/chat", ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "Hi"}}}, "test-key")
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, req)
+			assertResponseStatus(t, rr, tc.expectedStatus)
+			if tc.expectedStatus == http.StatusForbidden && !strings.Contains(rr.Body.String(), `"code":"ip_not_allowed"`) {
+				t.Errorf("Expected code ip_not_allowed, got %s", rr.Body.String())
+			}
+			if tc.name == "Invalid Range" && !strings.Contains(logs.String(), "Ignoring allowedCIDRs with an invalid range") {
+				t.Errorf("Expected the invalid range to be logged, got %s", logs.String())
+			}
+		})
+	}
+}
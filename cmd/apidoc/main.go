@@ -0,0 +1,282 @@
+// Command apidoc generates a Markdown reference for the JSON types declared in a Go source file,
+// taking descriptions from doc comments and field names from json tags.
+//
+// Usage:
+//
+//	go run ./cmd/apidoc -input types.go -output docs/api.md
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	input := flag.String("input", "types.go", "Go source file declaring the types to document")
+	output := flag.String("output", "docs/api.md", "Markdown file to write")
+	flag.Parse()
+
+	src, err := os.ReadFile(*input)
+	if err != nil {
+		log.Fatalf("Failed to read %s: %v", *input, err)
+	}
+	doc, err := generate(*input, src)
+	if err != nil {
+		log.Fatalf("Failed to generate API reference: %v", err)
+	}
+	if err := os.WriteFile(*output, []byte(doc), 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+}
+
+// structType is an exported struct declared in the source, in declaration order
+type structType struct {
+	name string
+	doc  string
+	typ  *ast.StructType
+}
+
+// jsonField is a struct field as it appears in JSON
+type jsonField struct {
+	name      string
+	omitEmpty bool
+	typ       ast.Expr
+	doc       string
+}
+
+// generate renders the Markdown reference for every exported struct in src
+func generate(filename string, src []byte) (string, error) {
+	file, err := parser.ParseFile(token.NewFileSet(), filename, src, parser.ParseComments)
+	if err != nil {
+		return "", err
+	}
+
+	var types []structType
+	structs := make(map[string]*ast.StructType)
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			ts := spec.(*ast.TypeSpec)
+			st, ok := ts.Type.(*ast.StructType)
+			if !ok || !ts.Name.IsExported() {
+				continue
+			}
+			doc := ts.Doc
+			if doc == nil && len(gen.Specs) == 1 {
+				doc = gen.Doc
+			}
+			types = append(types, structType{name: ts.Name.Name, doc: commentText(doc), typ: st})
+			structs[ts.Name.Name] = st
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("# API Reference\n\n")
+	fmt.Fprintf(&b, "<!-- Generated by cmd/apidoc from %s; run `go generate` instead of editing. -->\n\n", filename)
+	b.WriteString("JSON types exchanged with clients, Ollama, and the validation and metrics services.\n")
+
+	for _, t := range types {
+		fmt.Fprintf(&b, "\n## %s\n\n", t.name)
+		if t.doc != "" {
+			b.WriteString(t.doc + "\n\n")
+		}
+
+		fields := jsonFields(t.typ)
+		if len(fields) == 0 {
+			b.WriteString("No JSON fields.\n")
+			continue
+		}
+		b.WriteString("| Field | Type | Description |\n")
+		b.WriteString("|-------|------|-------------|\n")
+		for _, f := range fields {
+			description := f.doc
+			if f.omitEmpty {
+				if description != "" && !strings.HasSuffix(description, ".") {
+					description += "."
+				}
+				description = strings.TrimSpace(description + " Omitted when empty.")
+			}
+			fmt.Fprintf(&b, "| `%s` | %s | %s |\n", f.name, typeName(f.typ, structs), escapeCell(description))
+		}
+
+		example, err := json.MarshalIndent(exampleValue(t.typ, structs, map[string]bool{t.name: true}), "", "  ")
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "\n```json\n%s\n```\n", example)
+	}
+	return b.String(), nil
+}
+
+// jsonFields lists the fields encoding/json writes for a struct, skipping unexported and "-" fields
+func jsonFields(st *ast.StructType) []jsonField {
+	var fields []jsonField
+	for _, field := range st.Fields.List {
+		var tag reflect.StructTag
+		if field.Tag != nil {
+			raw, _ := strconv.Unquote(field.Tag.Value)
+			tag = reflect.StructTag(raw)
+		}
+		name, options, _ := strings.Cut(tag.Get("json"), ",")
+		if name == "-" && options == "" {
+			continue
+		}
+
+		doc := commentText(field.Doc)
+		if doc == "" {
+			doc = commentText(field.Comment)
+		}
+		for _, ident := range field.Names {
+			if !ident.IsExported() {
+				continue
+			}
+			fieldName := name
+			if fieldName == "" {
+				fieldName = ident.Name
+			}
+			fields = append(fields, jsonField{
+				name:      fieldName,
+				omitEmpty: strings.Contains(","+options+",", ",omitempty,"),
+				typ:       field.Type,
+				doc:       doc,
+			})
+		}
+	}
+	return fields
+}
+
+// typeName describes a field's JSON type, linking struct types documented on the same page
+func typeName(expr ast.Expr, structs map[string]*ast.StructType) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "string":
+			return "string"
+		case "bool":
+			return "boolean"
+		case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
+			return "integer"
+		case "float32", "float64":
+			return "number"
+		}
+		if _, ok := structs[t.Name]; ok {
+			return fmt.Sprintf("[%s](#%s)", t.Name, strings.ToLower(t.Name))
+		}
+		return t.Name
+	case *ast.StarExpr:
+		return typeName(t.X, structs)
+	case *ast.ArrayType:
+		return "array of " + typeName(t.Elt, structs)
+	case *ast.MapType:
+		return "object of " + typeName(t.Value, structs)
+	case *ast.StructType:
+		return "object"
+	case *ast.SelectorExpr:
+		switch t.Sel.Name {
+		case "RawMessage":
+			return "any JSON"
+		case "Time":
+			return "string (RFC 3339 timestamp)"
+		}
+		return t.Sel.Name
+	case *ast.InterfaceType:
+		return "any"
+	}
+	return "any"
+}
+
+// exampleValue builds a placeholder value for a type; struct types expand in field order, except
+// where they would recurse
+func exampleValue(expr ast.Expr, structs map[string]*ast.StructType, expanding map[string]bool) interface{} {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "string":
+			return "string"
+		case "bool":
+			return false
+		case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64", "float32", "float64":
+			return 0
+		}
+		st, ok := structs[t.Name]
+		if !ok || expanding[t.Name] {
+			return orderedObject{}
+		}
+		expanding[t.Name] = true
+		defer delete(expanding, t.Name)
+		return exampleValue(st, structs, expanding)
+	case *ast.StarExpr:
+		return exampleValue(t.X, structs, expanding)
+	case *ast.ArrayType:
+		return []interface{}{exampleValue(t.Elt, structs, expanding)}
+	case *ast.MapType:
+		return orderedObject{{"key", exampleValue(t.Value, structs, expanding)}}
+	case *ast.StructType:
+		var object orderedObject
+		for _, f := range jsonFields(t) {
+			object = append(object, objectField{f.name, exampleValue(f.typ, structs, expanding)})
+		}
+		return object
+	case *ast.SelectorExpr:
+		switch t.Sel.Name {
+		case "RawMessage":
+			return orderedObject{}
+		case "Time":
+			return "2024-01-01T00:00:00Z"
+		}
+	}
+	return nil
+}
+
+type objectField struct {
+	name  string
+	value interface{}
+}
+
+// orderedObject marshals as a JSON object keeping the fields in declaration order
+type orderedObject []objectField
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.name)
+		value, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// commentText flattens a comment group to one line for a table cell
+func commentText(group *ast.CommentGroup) string {
+	if group == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(group.Text()), " ")
+}
+
+func escapeCell(text string) string {
+	return strings.ReplaceAll(text, "|", `\|`)
+}
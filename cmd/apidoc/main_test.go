@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestGenerate tests documenting fields from comments and json tags with an ordered example
+func TestGenerate(t *testing.T) {
+	src := `package main
+
+// Order is a purchase
+type Order struct {
+	// ID identifies the order
+	ID    string ` + "`json:\"id\"`" + `
+	Items []Item ` + "`json:\"items,omitempty\"`" + ` // Line items
+	note  string
+	Skip  bool ` + "`json:\"-\"`" + `
+	At    time.Time ` + "`json:\"at\"`" + `
+}
+
+// Item is one line of an order
+type Item struct {
+	Count int ` + "`json:\"count\"`" + `
+}
+
+type unexported struct{}
+`
+	doc, err := generate("order.go", []byte(src))
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+
+	for _, want := range []string{
+		"## Order\n\nOrder is a purchase\n",
+		"| `id` | string | ID identifies the order |",
+		"| `items` | array of [Item](#item) | Line items. Omitted when empty. |",
+		"| `at` | string (RFC 3339 timestamp) |  |",
+		"| `count` | integer |  |",
+		"{\n  \"id\": \"string\",\n  \"items\": [\n    {\n      \"count\": 0\n    }\n  ],\n  \"at\": \"2024-01-01T00:00:00Z\"\n}",
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("Expected %q in generated docs:\n%s", want, doc)
+		}
+	}
+	for _, unwanted := range []string{"note", "Skip", "unexported"} {
+		if strings.Contains(doc, unwanted) {
+			t.Errorf("Expected %s to be left out of generated docs", unwanted)
+		}
+	}
+}
+
+// TestReferenceUpToDate fails when docs/api.md wasn't regenerated after changing types.go
+func TestReferenceUpToDate(t *testing.T) {
+	src, err := os.ReadFile("../../types.go")
+	if err != nil {
+		t.Fatalf("Failed to read types.go: %v", err)
+	}
+	want, err := generate("types.go", src)
+	if err != nil {
+		t.Fatalf("Failed to generate: %v", err)
+	}
+	got, err := os.ReadFile("../../docs/api.md")
+	if err != nil {
+		t.Fatalf("Failed to read docs/api.md: %v", err)
+	}
+	if string(got) != want {
+		t.Error("docs/api.md is out of date; run go generate ./...")
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"ollama-proxy/logger"
+)
+
+// Upstream connection metrics configuration
+var (
+	metricsPath                  string
+	connectionReuseWarnThreshold float64
+	upstreamConns                = newConnReuseTracker()
+)
+
+const (
+	// connectionReuseMinSamples avoids warning before enough requests have been seen to judge reuse
+	connectionReuseMinSamples = 20
+	// connectionReuseWarnInterval bounds how often the low reuse warning is repeated
+	connectionReuseWarnInterval = time.Minute
+)
+
+var (
+	upstreamReuseRatio = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "proxy_upstream_connection_reuse_ratio",
+		Help: "Fraction of upstream requests sent on a reused connection.",
+	})
+	upstreamRequestsPerConn = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "proxy_upstream_requests_per_connection",
+		Help:    "Number of requests served by each upstream connection before it closed.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+	})
+)
+
+// connReuseTracker counts how many upstream requests share each connection
+type connReuseTracker struct {
+	total    atomic.Int64
+	reused   atomic.Int64
+	lastWarn atomic.Int64
+}
+
+func newConnReuseTracker() *connReuseTracker {
+	return &connReuseTracker{}
+}
+
+// trackedConn counts the requests sent over one upstream connection
+type trackedConn struct {
+	net.Conn
+	requests atomic.Int64
+	closed   atomic.Bool
+}
+
+func (c *trackedConn) Close() error {
+	if c.closed.CompareAndSwap(false, true) {
+		upstreamRequestsPerConn.Observe(float64(c.requests.Load()))
+	}
+	return c.Conn.Close()
+}
+
+// dialContext wraps a dialer so every upstream connection is tracked
+func (t *connReuseTracker) dialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &trackedConn{Conn: conn}, nil
+	}
+}
+
+// gotConn records which connection a request was sent on
+func (t *connReuseTracker) gotConn(info httptrace.GotConnInfo) {
+	if conn, ok := info.Conn.(*trackedConn); ok {
+		conn.requests.Add(1)
+	}
+
+	total := t.total.Add(1)
+	reused := t.reused.Load()
+	if info.Reused {
+		reused = t.reused.Add(1)
+	}
+	ratio := float64(reused) / float64(total)
+	upstreamReuseRatio.Set(ratio)
+
+	if connectionReuseWarnThreshold > 0 && total >= connectionReuseMinSamples && ratio < connectionReuseWarnThreshold {
+		t.warnLowReuse(ratio, total)
+	}
+}
+
+// ratio returns the fraction of requests sent on a reused connection
+func (t *connReuseTracker) ratio() float64 {
+	total := t.total.Load()
+	if total == 0 {
+		return 0
+	}
+	return float64(t.reused.Load()) / float64(total)
+}
+
+func (t *connReuseTracker) warnLowReuse(ratio float64, total int64) {
+	now := time.Now().UnixNano()
+	last := t.lastWarn.Load()
+	if last != 0 && now-last < int64(connectionReuseWarnInterval) {
+		return
+	}
+	if !t.lastWarn.CompareAndSwap(last, now) {
+		return
+	}
+
+	logger.Warning("Low upstream connection reuse, consider raising MaxIdleConnsPerHost", map[string]interface{}{
+		"reuse_ratio": ratio,
+		"threshold":   connectionReuseWarnThreshold,
+		"requests":    total,
+	})
+}
+
+// tracingTransport attaches the connection tracker to every upstream request
+type tracingTransport struct {
+	base    http.RoundTripper
+	tracker *connReuseTracker
+}
+
+func (tt *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := &httptrace.ClientTrace{GotConn: tt.tracker.gotConn}
+	return tt.base.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// newUpstreamTransport builds the transport used to reach Ollama, with connection reuse tracking
+func newUpstreamTransport(tracker *connReuseTracker, tlsConfig *tls.Config) http.RoundTripper {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	transport.DialContext = tracker.dialContext(dialer.DialContext)
+	return &tracingTransport{base: transport, tracker: tracker}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"ollama-proxy/logger"
+)
+
+// setupConnTracking points the proxy at an upstream and starts connection tracking from zero
+func setupConnTracking(t *testing.T, closeConns bool) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if closeConns {
+			w.Header().Set("Connection", "close")
+		}
+		json.NewEncoder(w).Encode(GenerateResponse{Model: "llama2", Done: true})
+	}))
+	t.Cleanup(ollamaServer.Close)
+	validationServer := mockValidationServer(t, true, false)
+	t.Cleanup(validationServer.Close)
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, validationServer.URL)
+	upstreamConns = newConnReuseTracker()
+	resetReverseProxy()
+}
+
+func sendGenerateRequests(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, createTestRequest(t, "POST", "/api/generate", GenerateRequest{Model: "llama2", Prompt: "hi"}, "test-api-key"))
+		assertResponseStatus(t, rr, http.StatusOK)
+	}
+}
+
+// TestConnectionReuseRatio tests that sequential requests reuse the upstream connection
+func TestConnectionReuseRatio(t *testing.T) {
+	setupConnTracking(t, false)
+	sendGenerateRequests(t, 5)
+
+	if ratio := upstreamConns.ratio(); ratio != 0.8 {
+		t.Errorf("Expected reuse ratio 0.8 for 5 sequential requests, got %v", ratio)
+	}
+
+	rr := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	for _, line := range []string{"proxy_upstream_connection_reuse_ratio 0.8", "proxy_upstream_requests_per_connection_bucket"} {
+		if !strings.Contains(rr.Body.String(), line) {
+			t.Errorf("Expected /metrics to contain %q", line)
+		}
+	}
+}
+
+// TestConnectionReuseWarning tests the low reuse warning
+func TestConnectionReuseWarning(t *testing.T) {
+	setupConnTracking(t, true)
+	connectionReuseWarnThreshold = 0.5
+	defer func() { connectionReuseWarnThreshold = 0 }()
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+
+	sendGenerateRequests(t, connectionReuseMinSamples-1)
+	if strings.Contains(logs.String(), "Low upstream connection reuse") {
+		t.Fatal("Expected no warning before enough requests were seen")
+	}
+
+	sendGenerateRequests(t, connectionReuseMinSamples)
+	if ratio := upstreamConns.ratio(); ratio != 0 {
+		t.Errorf("Expected no reuse when upstream closes connections, got %v", ratio)
+	}
+	if count := strings.Count(logs.String(), "Low upstream connection reuse"); count != 1 {
+		t.Errorf("Expected a single rate-limited warning, got %d", count)
+	}
+}
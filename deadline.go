@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+)
+
+// Write timeout configuration
+var (
+	writeTimeout      time.Duration
+	maxStreamDuration time.Duration
+	modelLoadTimeouts map[string]int
+)
+
+// modelLoadingStatus marks the chunk Ollama streams while it loads a model into memory
+var modelLoadingStatus = []byte(`"status":"loading model"`)
+
+// modelLoadTimeout returns how long a model may take to load, from MODEL_LOAD_TIMEOUT, using the "*"
+// entry for models without their own
+func modelLoadTimeout(model string) time.Duration {
+	seconds, ok := modelLoadTimeouts[model]
+	if !ok {
+		seconds = modelLoadTimeouts["*"]
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// deadlineWriter lets a streaming response outlive the server's write timeout: every successful
+// write pushes the deadline another writeTimeout ahead, but never past the stream's hard cap, so
+// a stalled stream is still cut off. Once Ollama reports it is loading the model, the deadline
+// stays at least loadTimeout ahead of that report, since a cold start can take minutes.
+type deadlineWriter struct {
+	http.ResponseWriter
+	rc          *http.ResponseController
+	hard        time.Time
+	loadTimeout time.Duration
+	loadUntil   time.Time
+}
+
+func newDeadlineWriter(w http.ResponseWriter, start time.Time, loadTimeout time.Duration) *deadlineWriter {
+	dw := &deadlineWriter{
+		ResponseWriter: w,
+		rc:             http.NewResponseController(w),
+		loadTimeout:    loadTimeout,
+	}
+	if maxStreamDuration > 0 {
+		dw.hard = start.Add(maxStreamDuration)
+		if writeTimeout <= 0 {
+			dw.rc.SetWriteDeadline(dw.hard)
+		}
+	}
+	return dw
+}
+
+func (dw *deadlineWriter) Write(b []byte) (int, error) {
+	n, err := dw.ResponseWriter.Write(b)
+	if dw.loadTimeout > 0 && dw.loadUntil.IsZero() && bytes.Contains(b, modelLoadingStatus) {
+		dw.loadUntil = time.Now().Add(dw.loadTimeout)
+	}
+	if err == nil && writeTimeout > 0 {
+		deadline := time.Now().Add(writeTimeout)
+		if dw.loadUntil.After(deadline) {
+			deadline = dw.loadUntil
+		}
+		if !dw.hard.IsZero() && deadline.After(dw.hard) {
+			deadline = dw.hard
+		}
+		dw.rc.SetWriteDeadline(deadline)
+	}
+	return n, err
+}
+
+func (dw *deadlineWriter) Flush() {
+	dw.rc.Flush()
+}
+
+func (dw *deadlineWriter) Unwrap() http.ResponseWriter {
+	return dw.ResponseWriter
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupWriteTimeoutProxy serves the proxy with a server write timeout in front of the given upstream
+func setupWriteTimeoutProxy(t *testing.T, upstream http.HandlerFunc, timeout, maxStream time.Duration) *httptest.Server {
+	ollamaServer := httptest.NewServer(upstream)
+	t.Cleanup(ollamaServer.Close)
+	validationServer := mockValidationServer(t, true, false)
+	t.Cleanup(validationServer.Close)
+	metricsServer, _ := recordingMetricsServer(t)
+	t.Cleanup(metricsServer.Close)
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+	writeTimeout = timeout
+	maxStreamDuration = maxStream
+	t.Cleanup(func() {
+		writeTimeout = 0
+		maxStreamDuration = 0
+	})
+
+	proxyServer := httptest.NewUnstartedServer(http.HandlerFunc(proxyHandler))
+	proxyServer.Config.WriteTimeout = timeout
+	proxyServer.Start()
+	t.Cleanup(proxyServer.Close)
+	return proxyServer
+}
+
+// slowStreamingUpstream streams chunks at the given interval for the given duration
+func slowStreamingUpstream(interval, duration time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for end := time.Now().Add(duration); time.Now().Before(end); {
+			w.Write([]byte(`{"model":"llama2","response":"x","done":false}` + "\n"))
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(interval):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		w.Write([]byte(`{"model":"llama2","response":"","done":true}` + "\n"))
+	}
+}
+
+// generate posts a generate request to the proxy and returns the body read so far and any error
+func generate(t *testing.T, proxyURL string, stream bool) (string, error) {
+	body, _ := json.Marshal(map[string]interface{}{"model": "llama2", "prompt": "Hi", "stream": stream})
+	req, _ := http.NewRequest("POST", proxyURL+"/api/generate", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "test-key")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	return string(data), err
+}
+
+// TestStreamingWriteDeadline tests that streams outlive the write timeout while non-streaming requests do not
+func TestStreamingWriteDeadline(t *testing.T) {
+	t.Run("Long Stream Completes", func(t *testing.T) {
+		// The stream runs for several times the write timeout, like a long generation
+		proxyServer := setupWriteTimeoutProxy(t, slowStreamingUpstream(50*time.Millisecond, time.Second), 200*time.Millisecond, time.Hour)
+		body, err := generate(t, proxyServer.URL, true)
+		if err != nil || !strings.Contains(body, `"done":true`) {
+			t.Errorf("Expected the stream to complete, got err=%v body=%q", err, body)
+		}
+	})
+
+	t.Run("Stream Hits Hard Cap", func(t *testing.T) {
+		proxyServer := setupWriteTimeoutProxy(t, slowStreamingUpstream(50*time.Millisecond, time.Second), 200*time.Millisecond, 400*time.Millisecond)
+		body, _ := generate(t, proxyServer.URL, true)
+		if strings.Contains(body, `"done":true`) {
+			t.Error("Expected the stream to be cut at MAX_STREAM_DURATION")
+		}
+	})
+
+	t.Run("Hung Non-Streaming Request", func(t *testing.T) {
+		proxyServer := setupWriteTimeoutProxy(t, func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(time.Second):
+			case <-r.Context().Done():
+				return
+			}
+			json.NewEncoder(w).Encode(GenerateResponse{Model: "llama2", Done: true})
+		}, 200*time.Millisecond, time.Hour)
+		body, err := generate(t, proxyServer.URL, false)
+		if err == nil && strings.Contains(body, `"done":true`) {
+			t.Error("Expected the non-streaming request to be cut at the write timeout")
+		}
+	})
+}
+
+// deadlineRecorder records the write deadlines set through http.ResponseController
+type deadlineRecorder struct {
+	*httptest.ResponseRecorder
+	deadline time.Time
+}
+
+func (d *deadlineRecorder) SetWriteDeadline(deadline time.Time) error {
+	d.deadline = deadline
+	return nil
+}
+
+// TestModelLoadTimeout tests that once Ollama reports a model loading, the write deadline stays
+// MODEL_LOAD_TIMEOUT ahead of the report instead of WRITE_TIMEOUT
+func TestModelLoadTimeout(t *testing.T) {
+	writeTimeout = time.Second
+	maxStreamDuration = time.Hour
+	defer func() {
+		writeTimeout = 0
+		maxStreamDuration = 0
+		modelLoadTimeouts = nil
+	}()
+
+	testCases := []struct {
+		name     string
+		timeouts map[string]int
+		expected time.Duration
+	}{
+		{"Not Configured", nil, time.Second},
+		{"Model Entry", map[string]int{"llama3:70b": 120, "*": 30}, 120 * time.Second},
+		{"Wildcard Entry", map[string]int{"*": 30}, 30 * time.Second},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			modelLoadTimeouts = tc.timeouts
+			rec := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+			dw := newDeadlineWriter(rec, time.Now(), modelLoadTimeout("llama3:70b"))
+
+			// A heartbeat while the model loads must not pull the deadline back in
+			for _, chunk := range []string{`{"status":"loading model"}` + "\n", "\n"} {
+				start := time.Now()
+				dw.Write([]byte(chunk))
+				if remaining := rec.deadline.Sub(start); remaining < tc.expected-100*time.Millisecond || remaining > tc.expected+100*time.Millisecond {
+					t.Errorf("Expected a deadline %v ahead after %q, got %v", tc.expected, chunk, remaining)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"ollama-proxy/logger"
+)
+
+// discoveryPath is the well-known path of the proxy's discovery document
+const discoveryPath = "/.well-known/ollama-proxy"
+
+// Discovery document configuration
+var (
+	discoveryRequireKey bool
+)
+
+// proxiedEndpoints lists the Ollama and OpenAI-compatible endpoints the proxy understands
+var proxiedEndpoints = []string{
+	"/api/chat",
+	"/api/generate",
+	"/api/embed",
+	"/api/embeddings",
+	"/api/create",
+	"/api/pull",
+	"/api/push",
+	"/api/show",
+	"/api/delete",
+	"/api/copy",
+	"/api/tags",
+	"/v1/chat/completions",
+	"/proxy/models",
+}
+
+// DiscoveryDocument describes the features a client can rely on. It is built from live
+// configuration on every request and must never include internal details such as backend URLs.
+type DiscoveryDocument struct {
+	Endpoints []string          `json:"endpoints"`
+	Auth      DiscoveryAuth     `json:"auth"`
+	Limits    DiscoveryLimits   `json:"limits"`
+	Features  DiscoveryFeatures `json:"features"`
+	Models    []ProxyModel      `json:"models"`
+	Aliases   map[string]string `json:"aliases"`
+}
+
+// DiscoveryAuth describes how clients authenticate
+type DiscoveryAuth struct {
+	Header          string `json:"header"`
+	EphemeralTokens bool   `json:"ephemeralTokens"`
+}
+
+// DiscoveryLimits describes per-key limits; zero means unlimited
+type DiscoveryLimits struct {
+	RateLimit                float64 `json:"rateLimit"`
+	RateLimitBurst           int     `json:"rateLimitBurst"`
+	MaxStreamingConnsPerKey  int     `json:"maxStreamingConnsPerKey"`
+	MaxStreamDurationSeconds float64 `json:"maxStreamDurationSeconds"`
+}
+
+// DiscoveryFeatures describes the conveniences the proxy applies to requests and responses
+type DiscoveryFeatures struct {
+	SSE               bool  `json:"sse"`
+	StreamUsage       bool  `json:"streamUsage"`
+	StreamHeartbeat   bool  `json:"streamHeartbeat"`
+	AppendDoneChunk   bool  `json:"appendDoneChunk"`
+	ForceNonStreaming bool  `json:"forceNonStreaming"`
+	DefaultThink      *bool `json:"defaultThink"`
+}
+
+// buildDiscoveryDocument describes the proxy from its current configuration, listing only the allowed
+// models when allowedModels is set
+func buildDiscoveryDocument(allowedModels []string) DiscoveryDocument {
+	doc := DiscoveryDocument{
+		Endpoints: proxiedEndpoints,
+		Auth: DiscoveryAuth{
+			Header:          apiKeyHeaderName,
+			EphemeralTokens: tokenSigner != nil,
+		},
+		Limits: DiscoveryLimits{
+			RateLimit:                rateLimit,
+			RateLimitBurst:           rateLimitBurst,
+			MaxStreamingConnsPerKey:  maxStreamingConnsPerKey,
+			MaxStreamDurationSeconds: maxStreamDuration.Seconds(),
+		},
+		Features: DiscoveryFeatures{
+			SSE:               true,
+			StreamUsage:       true,
+			StreamHeartbeat:   streamHeartbeat,
+			AppendDoneChunk:   appendDoneChunk,
+			ForceNonStreaming: forceNonStreaming,
+			DefaultThink:      defaultThink,
+		},
+		Models:  []ProxyModel{},
+		Aliases: modelRegistry,
+	}
+
+	// The model list is best effort; the rest of the document is still useful without it
+	if tags, err := fetchModelTags(); err == nil {
+		for _, model := range tags.Models {
+			if allowedModels != nil && !modelAllowed(allowedModels, model.Name) {
+				continue
+			}
+			doc.Models = append(doc.Models, ProxyModel{
+				ModelInfo:    ModelInfo{Name: model.Name, Model: model.Model},
+				Capabilities: capabilitiesForModel(model.Name),
+			})
+		}
+	}
+	return doc
+}
+
+// discoveryHandler serves GET /.well-known/ollama-proxy
+func discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var allowedModels []string
+	if discoveryRequireKey {
+		apiKey := r.Header.Get(apiKeyHeaderName)
+		if apiKey == "" {
+			http.Error(w, "Unauthorized: Missing API key", http.StatusUnauthorized)
+			return
+		}
+		allowed, ok, err := modelListAuthorized(r, apiKey)
+		if err != nil {
+			logger.Error("Validation service unavailable", err, map[string]interface{}{
+				"api_key":  apiKey,
+				"endpoint": r.URL.Path,
+			})
+			http.Error(w, "Service Unavailable: Request could not be validated", http.StatusServiceUnavailable)
+			return
+		}
+		if !ok {
+			logger.Warning("Unauthorized: Invalid request", map[string]interface{}{
+				"api_key":  apiKey,
+				"endpoint": r.URL.Path,
+			})
+			http.Error(w, "Unauthorized: Invalid request", http.StatusUnauthorized)
+			return
+		}
+		allowedModels = allowed
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(buildDiscoveryDocument(allowedModels))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// getDiscovery fetches the discovery document with the given key
+func getDiscovery(t *testing.T, apiKey string) (*httptest.ResponseRecorder, map[string]json.RawMessage) {
+	req := httptest.NewRequest("GET", discoveryPath, nil)
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	rr := httptest.NewRecorder()
+	discoveryHandler(rr, req)
+
+	var doc map[string]json.RawMessage
+	if rr.Code == http.StatusOK {
+		if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+			t.Fatalf("Error decoding discovery document: %v", err)
+		}
+	}
+	return rr, doc
+}
+
+// TestDiscoveryDocument tests that the document follows live configuration and hides internals
+func TestDiscoveryDocument(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(TagsResponse{Models: []ModelInfo{
+			{Name: "llama2:7b", Model: "llama2:7b", Digest: "sha256:abc", Size: 100},
+			{Name: "nomic-embed-text:latest", Model: "nomic-embed-text:latest"},
+		}})
+	}))
+	defer ollamaServer.Close()
+	useProxyTargets(t, ollamaServer.URL, "", "")
+	defer func() {
+		streamHeartbeat = false
+		rateLimit = 0
+		maxStreamingConnsPerKey = 0
+		maxStreamDuration = 0
+	}()
+
+	rr, doc := getDiscovery(t, "")
+	assertResponseStatus(t, rr, http.StatusOK)
+	var features DiscoveryFeatures
+	json.Unmarshal(doc["features"], &features)
+	if features.StreamHeartbeat || !features.SSE {
+		t.Errorf("Expected default features, got %+v", features)
+	}
+
+	// Toggled configuration is reflected on the next request
+	streamHeartbeat = true
+	rateLimit = 5
+	maxStreamingConnsPerKey = 2
+	maxStreamDuration = time.Hour
+	rr, doc = getDiscovery(t, "")
+	json.Unmarshal(doc["features"], &features)
+	var limits DiscoveryLimits
+	json.Unmarshal(doc["limits"], &limits)
+	if !features.StreamHeartbeat {
+		t.Error("Expected heartbeats to be advertised once enabled")
+	}
+	if limits.RateLimit != 5 || limits.MaxStreamingConnsPerKey != 2 || limits.MaxStreamDurationSeconds != 3600 {
+		t.Errorf("Expected configured limits, got %+v", limits)
+	}
+
+	var models []ProxyModel
+	json.Unmarshal(doc["models"], &models)
+	if len(models) != 2 || models[1].Capabilities[0] != "embed" {
+		t.Errorf("Expected models with capabilities, got %+v", models)
+	}
+
+	// Backend addresses and model storage details stay internal
+	body := rr.Body.String()
+	host := strings.TrimPrefix(ollamaServer.URL, "http://")
+	for _, internal := range []string{host, "sha256:abc", externalValidationURL, externalMetricsURL} {
+		if internal != "" && strings.Contains(body, internal) {
+			t.Errorf("Expected %q to be hidden, got %s", internal, body)
+		}
+	}
+}
+
+// TestDiscoveryRequireKey tests that the document can be gated behind a valid key
+func TestDiscoveryRequireKey(t *testing.T) {
+	validationServer := mockValidationServer(t, false, false)
+	defer validationServer.Close()
+	useProxyTargets(t, ollamaURL, validationServer.URL, "")
+	discoveryRequireKey = true
+	defer func() { discoveryRequireKey = false }()
+
+	rr, _ := getDiscovery(t, "")
+	assertResponseStatus(t, rr, http.StatusUnauthorized)
+	rr, _ = getDiscovery(t, "bad-key")
+	assertResponseStatus(t, rr, http.StatusUnauthorized)
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"ollama-proxy/version"
+)
+
+// Embed batch splitting configuration
+var (
+	embedMaxBatch         int // 0 disables splitting
+	embedSplitParallelism int
+)
+
+// embedSplit is an /api/embed request whose input list is sent to Ollama in sub-batches
+type embedSplit struct {
+	request map[string]interface{}
+	batches [][]interface{}
+}
+
+// splitEmbedRequest splits an embed request's inputs into sub-batches of at most EMBED_MAX_BATCH,
+// returning nil when splitting is disabled or the request fits in one batch
+func splitEmbedRequest(path string, body []byte) *embedSplit {
+	if embedMaxBatch <= 0 || !strings.HasSuffix(path, "/api/embed") {
+		return nil
+	}
+	var request map[string]interface{}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil
+	}
+	inputs, ok := request["input"].([]interface{})
+	if !ok || len(inputs) <= embedMaxBatch {
+		return nil
+	}
+
+	split := &embedSplit{request: request}
+	for start := 0; start < len(inputs); start += embedMaxBatch {
+		end := min(start+embedMaxBatch, len(inputs))
+		split.batches = append(split.batches, inputs[start:end])
+	}
+	return split
+}
+
+// serveSplitEmbed sends the sub-batches to Ollama with at most EMBED_SPLIT_PARALLELISM in flight and
+// writes one response with the embeddings in input order. Any failed sub-batch fails the whole request,
+// and the client going away cancels the sub-batches still outstanding.
+func serveSplitEmbed(w http.ResponseWriter, r *http.Request, split *embedSplit) (aborted bool) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	results := make([]EmbedResponse, len(split.batches))
+	var errOnce sync.Once
+	var firstErr error
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	sem := make(chan struct{}, max(embedSplitParallelism, 1))
+	var wg sync.WaitGroup
+	for i, batch := range split.batches {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, batch []interface{}) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			resp, err := embedSubBatch(ctx, r, split.request, batch)
+			if err != nil {
+				fail(fmt.Errorf("embed sub-batch %d of %d failed: %v", i+1, len(split.batches), err))
+				return
+			}
+			results[i] = resp
+		}(i, batch)
+	}
+	wg.Wait()
+
+	if r.Context().Err() != nil {
+		return true
+	}
+	if firstErr != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: firstErr.Error(), Code: "embed_batch_failed"})
+		return false
+	}
+
+	// Reassemble in input order, sharing the sub-batch vectors instead of copying them
+	combined := EmbedResponse{Model: results[0].Model}
+	for _, result := range results {
+		combined.Embeddings = append(combined.Embeddings, result.Embeddings...)
+		combined.TotalDuration += result.TotalDuration
+		combined.LoadDuration += result.LoadDuration
+		combined.PromptEvalCount += result.PromptEvalCount
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(combined)
+	return false
+}
+
+// embedSubBatch posts one sub-batch to Ollama through the proxy's upstream transport
+func embedSubBatch(ctx context.Context, r *http.Request, request map[string]interface{}, batch []interface{}) (EmbedResponse, error) {
+	subRequest := make(map[string]interface{}, len(request))
+	for key, value := range request {
+		subRequest[key] = value
+	}
+	subRequest["input"] = batch
+	body, err := json.Marshal(subRequest)
+	if err != nil {
+		return EmbedResponse{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", strings.TrimSuffix(currentOllamaURL(), "/")+r.URL.Path, bytes.NewReader(body))
+	if err != nil {
+		return EmbedResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(version.Header, version.String())
+	req.Header.Set(requestIDHeader, r.Header.Get(requestIDHeader))
+
+	client := &http.Client{Transport: getReverseProxy().Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return EmbedResponse{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var upstream struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, &upstream) != nil || upstream.Error == "" {
+			upstream.Error = strings.TrimSpace(string(data))
+		}
+		return EmbedResponse{}, fmt.Errorf("Ollama returned status %d: %s", resp.StatusCode, upstream.Error)
+	}
+
+	var embedResp EmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&embedResp); err != nil {
+		return EmbedResponse{}, err
+	}
+	if len(embedResp.Embeddings) != len(batch) {
+		return EmbedResponse{}, fmt.Errorf("Ollama returned %d embeddings for %d inputs", len(embedResp.Embeddings), len(batch))
+	}
+	return embedResp, nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// embedInputs returns the inputs "0" through "n-1"
+func embedInputs(n int) []string {
+	inputs := make([]string, n)
+	for i := range inputs {
+		inputs[i] = strconv.Itoa(i)
+	}
+	return inputs
+}
+
+// setupEmbedSplit points the proxy at an Ollama stand-in and enables splitting
+func setupEmbedSplit(t *testing.T, handler http.HandlerFunc, maxBatch, parallelism int) chan MetricsData {
+	ollamaServer := httptest.NewServer(handler)
+	t.Cleanup(ollamaServer.Close)
+	validationServer := mockValidationServer(t, true, false)
+	t.Cleanup(validationServer.Close)
+	metricsServer, received := recordingMetricsServer(t)
+	t.Cleanup(metricsServer.Close)
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	embedMaxBatch = maxBatch
+	embedSplitParallelism = parallelism
+	t.Cleanup(func() { embedMaxBatch = 0 })
+	return received
+}
+
+// TestSplitEmbedOrdering tests that sub-batches completing out of order are reassembled in input order with summed
+// counts, each sent under the client's request ID
+func TestSplitEmbedOrdering(t *testing.T) {
+	var inFlight, maxInFlight int32
+	received := setupEmbedSplit(t, func(w http.ResponseWriter, r *http.Request) {
+		if id := r.Header.Get(requestIDHeader); id != "embed-req" {
+			t.Errorf("Expected request ID embed-req on the sub-batch, got %q", id)
+		}
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			peak := atomic.LoadInt32(&maxInFlight)
+			if current <= peak || atomic.CompareAndSwapInt32(&maxInFlight, peak, current) {
+				break
+			}
+		}
+
+		var req struct {
+			Model string   `json:"model"`
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		first, _ := strconv.Atoi(req.Input[0])
+
+		// Earlier sub-batches finish last
+		time.Sleep(time.Duration(10-first) * 5 * time.Millisecond)
+		resp := EmbedResponse{Model: req.Model, TotalDuration: 100, LoadDuration: 10, PromptEvalCount: len(req.Input)}
+		for _, input := range req.Input {
+			n, _ := strconv.Atoi(input)
+			resp.Embeddings = append(resp.Embeddings, []float32{float32(n)})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}, 3, 2)
+
+	req := createTestRequest(t, "POST", "/api/embed", EmbedRequest{Model: "nomic-embed", Input: embedInputs(10)}, "test-key")
+	req.Header.Set(requestIDHeader, "embed-req")
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, req)
+	assertResponseStatus(t, rr, http.StatusOK)
+
+	var resp EmbedResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if len(resp.Embeddings) != 10 {
+		t.Fatalf("Expected 10 embeddings, got %d", len(resp.Embeddings))
+	}
+	for i, embedding := range resp.Embeddings {
+		if embedding[0] != float32(i) {
+			t.Errorf("Expected embedding %d in position %d, got %v", i, i, embedding[0])
+		}
+	}
+	if resp.Model != "nomic-embed" || resp.PromptEvalCount != 10 || resp.TotalDuration != 400 || resp.LoadDuration != 40 {
+		t.Errorf("Expected aggregated counts and durations, got %+v", resp)
+	}
+	if peak := atomic.LoadInt32(&maxInFlight); peak != 2 {
+		t.Errorf("Expected at most 2 sub-batches in flight, peaked at %d", peak)
+	}
+
+	if metrics := waitForMetrics(t, received); metrics.InputTokenLength != 10 {
+		t.Errorf("Expected 10 input tokens in metrics, got %d", metrics.InputTokenLength)
+	}
+}
+
+// TestSplitEmbedFailure tests that one failed sub-batch fails the whole request without partial results
+func TestSplitEmbedFailure(t *testing.T) {
+	setupEmbedSplit(t, func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Input []string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Input[0] == "4" {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(`{"error":"out of memory"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(EmbedResponse{Embeddings: make([][]float32, len(req.Input))})
+	}, 2, 4)
+
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/embed", EmbedRequest{Model: "nomic-embed", Input: embedInputs(6)}, "test-key"))
+	assertResponseStatus(t, rr, http.StatusBadGateway)
+
+	var errResp ErrorResponse
+	json.Unmarshal(rr.Body.Bytes(), &errResp)
+	expected := "embed sub-batch 3 of 3 failed: Ollama returned status 500: out of memory"
+	if errResp.Error != expected || errResp.Code != "embed_batch_failed" {
+		t.Errorf("Expected %q, got %+v", expected, errResp)
+	}
+}
+
+// TestSplitEmbedCancellation tests that a client going away cancels the outstanding sub-batches
+func TestSplitEmbedCancellation(t *testing.T) {
+	var started sync.WaitGroup
+	started.Add(2)
+	release := make(chan struct{})
+	var calls int32
+	setupEmbedSplit(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		started.Done()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}, 1, 2)
+	defer close(release)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := createTestRequest(t, "POST", "/api/embed", EmbedRequest{Model: "nomic-embed", Input: embedInputs(4)}, "test-key").WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		// Like an aborted proxy, the handler ends by aborting the response
+		defer func() {
+			if rec := recover(); rec != http.ErrAbortHandler {
+				t.Errorf("Expected the handler to abort, got %v", rec)
+			}
+			close(done)
+		}()
+		proxyHandler(httptest.NewRecorder(), req)
+	}()
+
+	// The handler waits for its sub-batches, so it only returns once the blocked ones are canceled
+	started.Wait()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected outstanding sub-batches to be canceled with the client request")
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("Expected no sub-batches started after cancellation, got %d calls", n)
+	}
+}
+
+// TestSplitEmbedRequest tests which requests are split
+func TestSplitEmbedRequest(t *testing.T) {
+	embedMaxBatch = 2
+	defer func() { embedMaxBatch = 0 }()
+
+	if split := splitEmbedRequest("/api/embed", []byte(`{"model":"m","input":["a","b","c"],"truncate":true}`)); split == nil || len(split.batches) != 2 || split.request["truncate"] != true {
+		t.Errorf("Expected 2 sub-batches keeping other fields, got %+v", split)
+	}
+	for _, body := range []string{`{"model":"m","input":["a","b"]}`, `{"model":"m","input":"a"}`, `not json`} {
+		if split := splitEmbedRequest("/api/embed", []byte(body)); split != nil {
+			t.Errorf("Expected %s not to be split", body)
+		}
+	}
+	if split := splitEmbedRequest("/api/chat", []byte(`{"model":"m","input":["a","b","c"]}`)); split != nil {
+		t.Error("Expected only embed requests to be split")
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Public, protected and exposed endpoint configuration
+var (
+	publicEndpoints    map[string]bool
+	protectedEndpoints map[string]bool
+	allowedEndpoints   []string
+	deniedEndpoints    []string
+)
+
+// Validation skip configuration
+var validationSkipPaths []string // path suffixes whose API keys are trusted without calling the validation service
+
+// Path prefix and strict routing configuration
+var (
+	pathPrefix    string
+	strictRouting bool
+)
+
+// ollamaAPIPaths are the paths STRICT_ROUTING forwards, besides blobs; Ollama's OpenAI-compatible
+// endpoints are included so OpenAI clients keep working
+var ollamaAPIPaths = map[string]bool{
+	"/api/chat":            true,
+	"/api/generate":        true,
+	"/api/embed":           true,
+	"/api/embeddings":      true,
+	"/api/tags":            true,
+	"/api/ps":              true,
+	"/api/show":            true,
+	"/api/pull":            true,
+	"/api/push":            true,
+	"/api/create":          true,
+	"/api/copy":            true,
+	"/api/delete":          true,
+	"/api/version":         true,
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+	"/v1/models":           true,
+}
+
+// adminScope is the validation scope that grants access to PROTECTED_ENDPOINTS
+const adminScope = "admin"
+
+// defaultProtectedEndpoints change the shared Ollama instance rather than just using it
+const defaultProtectedEndpoints = "/api/delete,/api/create,/api/pull,/api/push"
+
+// endpointClass groups Ollama endpoints by how the proxy handles their responses
+type endpointClass int
+
+const (
+	// endpointInference responses are captured for token counting and stream inspection
+	endpointInference endpointClass = iota
+	// endpointTransfer responses are long model progress streams passed straight through
+	endpointTransfer
+	// endpointReadOnly requests are bodiless GETs whose responses carry no model or tokens
+	endpointReadOnly
+	// endpointBlob requests upload or check model layers; uploads can be gigabytes, so their
+	// bodies are streamed to Ollama unread
+	endpointBlob
+)
+
+// classifyEndpoint returns the handling class for a request path
+func classifyEndpoint(path string) endpointClass {
+	switch {
+	case strings.Contains(path, "/api/blobs/"):
+		return endpointBlob
+	case strings.HasSuffix(path, "/api/pull"), strings.HasSuffix(path, "/api/push"):
+		return endpointTransfer
+	case strings.HasSuffix(path, "/api/tags"), strings.HasSuffix(path, "/api/ps"), strings.HasSuffix(path, "/api/version"):
+		return endpointReadOnly
+	default:
+		return endpointInference
+	}
+}
+
+// canonicalEndpoint names the capability a request path exercises, so OpenAI-compatible and legacy
+// paths share the name of their native Ollama endpoint
+func canonicalEndpoint(path string) string {
+	switch {
+	case strings.HasSuffix(path, "/api/chat"), strings.HasSuffix(path, "/v1/chat/completions"):
+		return "chat"
+	case strings.HasSuffix(path, "/api/generate"), strings.HasSuffix(path, "/v1/completions"):
+		return "generate"
+	case strings.HasSuffix(path, "/api/embed"), strings.HasSuffix(path, "/api/embeddings"), strings.HasSuffix(path, "/v1/embeddings"):
+		return "embed"
+	case strings.Contains(path, "/api/blobs/"):
+		return "blobs"
+	}
+	if i := strings.LastIndex(path, "/api/"); i >= 0 {
+		return path[i+len("/api/"):]
+	}
+	return strings.TrimPrefix(path, "/")
+}
+
+// endpointAllowed reports whether a key's allowed endpoints permit the path; a nil list allows everything
+func endpointAllowed(allowed []string, path string) bool {
+	if allowed == nil {
+		return true
+	}
+	endpoint := canonicalEndpoint(path)
+	for _, name := range allowed {
+		if name == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// parseEndpointList parses comma-separated endpoint paths into canonical endpoint names
+func parseEndpointList(raw string) map[string]bool {
+	endpoints := make(map[string]bool)
+	for endpoint := range parseKeyList(raw) {
+		endpoints[canonicalEndpoint(path.Clean(endpoint))] = true
+	}
+	return endpoints
+}
+
+// endpointRequiresScope reports whether the path is protected and the key's scopes lack admin. Like the
+// other endpoint checks, it expects the cleaned path proxyHandler works with, so a trailing slash or
+// doubled separator can't slip past the list.
+func endpointRequiresScope(scopes []string, requestPath string) bool {
+	if !protectedEndpoints[canonicalEndpoint(requestPath)] {
+		return false
+	}
+	for _, scope := range scopes {
+		if scope == adminScope {
+			return false
+		}
+	}
+	return true
+}
+
+// capturesResponse reports whether responses for the class are buffered for inspection
+func (c endpointClass) capturesResponse() bool {
+	return c == endpointInference
+}
+
+// readsBody reports whether request bodies for the class are read to find the model
+func (c endpointClass) readsBody() bool {
+	return c != endpointReadOnly && c != endpointBlob
+}
+
+// validationSkipped reports whether the path ends in one of VALIDATION_SKIP_PATHS. Unlike public
+// endpoints these still need an API key; it just isn't checked with the validation service.
+func validationSkipped(requestPath string) bool {
+	for _, suffix := range validationSkipPaths {
+		if strings.HasSuffix(requestPath, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// endpointExposed reports whether ALLOWED_ENDPOINTS and DENIED_ENDPOINTS let the proxy forward the
+// path at all; the deny list wins, and an empty allow list allows everything it doesn't deny
+func endpointExposed(requestPath string) bool {
+	for _, pattern := range deniedEndpoints {
+		if endpointMatches(pattern, requestPath) {
+			return false
+		}
+	}
+	if len(allowedEndpoints) == 0 {
+		return true
+	}
+	for _, pattern := range allowedEndpoints {
+		if endpointMatches(pattern, requestPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// endpointMatches matches a glob against the whole path, and any other pattern as a path suffix
+func endpointMatches(pattern, requestPath string) bool {
+	if strings.ContainsAny(pattern, "*?[") {
+		matched, _ := path.Match(pattern, requestPath)
+		return matched
+	}
+	return strings.HasSuffix(requestPath, pattern)
+}
+
+// validateEndpointPatterns checks that ALLOWED_ENDPOINTS and DENIED_ENDPOINTS globs are well formed,
+// since a malformed one would silently never match
+func validateEndpointPatterns() error {
+	for _, pattern := range append(append([]string(nil), allowedEndpoints...), deniedEndpoints...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid endpoint pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// normalizePathPrefix turns PATH_PREFIX into "/prefix" form, with "" for no prefix
+func normalizePathPrefix(prefix string) string {
+	prefix = strings.Trim(strings.TrimSpace(prefix), "/")
+	if prefix == "" {
+		return ""
+	}
+	return "/" + prefix
+}
+
+// stripPathPrefix returns p without pathPrefix, reporting false when p isn't under it
+func stripPathPrefix(p string) (string, bool) {
+	if pathPrefix == "" {
+		return p, true
+	}
+	if p == pathPrefix {
+		return "/", true
+	}
+	if !strings.HasPrefix(p, pathPrefix+"/") {
+		return p, false
+	}
+	return p[len(pathPrefix):], true
+}
+
+// endpointRouted reports whether the path may be forwarded to Ollama; everything is unless
+// STRICT_ROUTING limits the proxy to Ollama's own API paths
+func endpointRouted(requestPath string) bool {
+	if !strictRouting {
+		return true
+	}
+	if ollamaAPIPaths[requestPath] {
+		return true
+	}
+	digest := strings.TrimPrefix(requestPath, "/api/blobs/")
+	return digest != requestPath && digest != "" && !strings.Contains(digest, "/")
+}
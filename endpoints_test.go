@@ -0,0 +1,729 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"testing"
+
+	"ollama-proxy/logger"
+)
+
+// TestClassifyEndpoint tests endpoint classification
+func TestClassifyEndpoint(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected endpointClass
+	}{
+		{"/api/pull", endpointTransfer},
+		{"/api/push", endpointTransfer},
+		{"/api/chat", endpointInference},
+		{"/api/generate", endpointInference},
+		{"/api/tags", endpointReadOnly},
+		{"/api/ps", endpointReadOnly},
+		{"/api/version", endpointReadOnly},
+		{"/api/blobs/sha256:6a0746a1ec1a", endpointBlob},
+	}
+
+	for _, tc := range testCases {
+		if got := classifyEndpoint(tc.path); got != tc.expected {
+			t.Errorf("%s: expected class %d, got %d", tc.path, tc.expected, got)
+		}
+	}
+}
+
+// TestProxyHandlerPullPassthrough tests that pull progress streams are forwarded without being buffered
+func TestProxyHandlerPullPassthrough(t *testing.T) {
+	const chunkCount = 64 * 1024
+	progress := []byte(`{"status":"pulling 6a0746a1ec1a","digest":"sha256:6a0746a1ec1a","total":2000000000,"completed":1000000000}` + "\n")
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		for i := 0; i < chunkCount; i++ {
+			w.Write(progress)
+		}
+		w.Write([]byte(`{"status":"success"}` + "\n"))
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, Scopes: []string{adminScope}})
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	proxyServer := httptest.NewServer(http.HandlerFunc(proxyHandler))
+	defer proxyServer.Close()
+
+	req, _ := http.NewRequest("POST", proxyServer.URL+"/api/pull", strings.NewReader(`{"name":"llama3:8b"}`))
+	req.Header.Set("X-API-Key", "test-api-key")
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %v", err)
+	}
+	n, _ := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	metrics := waitForMetrics(t, received)
+	runtime.ReadMemStats(&after)
+
+	expected := int64(chunkCount*len(progress) + len(`{"status":"success"}`+"\n"))
+	if n != expected {
+		t.Fatalf("Expected %d bytes, got %d", expected, n)
+	}
+	if metrics.Model != "llama3:8b" {
+		t.Errorf("Expected model from name field, got %q", metrics.Model)
+	}
+	if metrics.BytesTransferred != expected {
+		t.Errorf("Expected %d bytes transferred, got %d", expected, metrics.BytesTransferred)
+	}
+
+	// Buffering the stream would allocate at least its full size
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > uint64(expected/2) {
+		t.Errorf("Expected pull stream not to be buffered, allocated %d bytes for a %d byte stream", allocated, expected)
+	}
+}
+
+// TestProxyHandlerBlobUpload tests that blob uploads stream to Ollama without being read into memory
+func TestProxyHandlerBlobUpload(t *testing.T) {
+	const uploadSize = 64 << 20
+	var uploaded int64
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/blobs/sha256:6a0746a1ec1a" {
+			t.Errorf("Unexpected upstream path %s", r.URL.Path)
+		}
+		uploaded, _ = io.Copy(io.Discard, r.Body)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	proxyServer := httptest.NewServer(http.HandlerFunc(proxyHandler))
+	defer proxyServer.Close()
+
+	body := io.LimitReader(zeroReader{}, uploadSize)
+	req, _ := http.NewRequest("POST", proxyServer.URL+"/api/blobs/sha256:6a0746a1ec1a", body)
+	req.ContentLength = uploadSize
+	req.Header.Set("X-API-Key", "test-api-key")
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %v", err)
+	}
+	resp.Body.Close()
+	metrics := waitForMetrics(t, received)
+	runtime.ReadMemStats(&after)
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", resp.StatusCode)
+	}
+	if uploaded != uploadSize {
+		t.Errorf("Expected Ollama to receive %d bytes, got %d", uploadSize, uploaded)
+	}
+	if metrics.ContentLength != uploadSize {
+		t.Errorf("Expected content length %d in metrics, got %d", uploadSize, metrics.ContentLength)
+	}
+	if metrics.InputTokenLength != 0 || metrics.Model != "" {
+		t.Errorf("Expected no model or tokens for a blob upload, got %q with %d tokens", metrics.Model, metrics.InputTokenLength)
+	}
+
+	// Reading the upload into memory would allocate at least its full size
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > uploadSize/4 {
+		t.Errorf("Expected blob upload not to be buffered, allocated %d bytes for a %d byte upload", allocated, uploadSize)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// TestResponseWriterPassthrough tests that uncaptured responses are counted but not buffered
+func TestResponseWriterPassthrough(t *testing.T) {
+	rw := &responseWriter{ResponseWriter: httptest.NewRecorder()}
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(rw, `{"status":"pulling","completed":%d}`+"\n", i)
+	}
+	if rw.captured() != nil {
+		t.Errorf("Expected no captured body, got %q", rw.captured())
+	}
+	if rw.bytesWritten != int64(len(bytes.Repeat([]byte(`{"status":"pulling","completed":0}`+"\n"), 3))) {
+		t.Errorf("Unexpected byte count %d", rw.bytesWritten)
+	}
+}
+
+// TestCanonicalEndpoint tests that native, legacy and OpenAI-compatible paths share canonical names
+func TestCanonicalEndpoint(t *testing.T) {
+	testCases := []struct {
+		path     string
+		expected string
+	}{
+		{"/api/chat", "chat"},
+		{"/v1/chat/completions", "chat"},
+		{"/api/generate", "generate"},
+		{"/api/embed", "embed"},
+		{"/api/embeddings", "embed"},
+		{"/api/tags", "tags"},
+		{"/api/pull", "pull"},
+	}
+
+	for _, tc := range testCases {
+		if got := canonicalEndpoint(tc.path); got != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.path, tc.expected, got)
+		}
+	}
+}
+
+// TestProxyHandlerAllowedEndpoints tests that a key validated for embedding only is refused other endpoints
+func TestProxyHandlerAllowedEndpoints(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, AllowedEndpoints: []string{"embed"}})
+	defer validationServer.Close()
+	metricsServer := mockMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	testCases := []struct {
+		name           string
+		method         string
+		path           string
+		body           interface{}
+		expectedStatus int
+	}{
+		{"Chat", "POST", "/api/chat", ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "Hi"}}}, http.StatusForbidden},
+		{"Tags", "GET", "/api/tags", nil, http.StatusForbidden},
+		{"Embed", "POST", "/api/embed", EmbedRequest{Model: "nomic-embed", Input: "Hi"}, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, tc.method, tc.path, tc.body, "test-api-key"))
+			assertResponseStatus(t, rr, tc.expectedStatus)
+
+			if tc.expectedStatus == http.StatusForbidden {
+				var errResp ErrorResponse
+				json.NewDecoder(rr.Body).Decode(&errResp)
+				if errResp.Code != "endpoint_not_allowed" {
+					t.Errorf("Expected endpoint_not_allowed code, got %+v", errResp)
+				}
+			}
+		})
+	}
+}
+
+// TestProxyHandlerTransferValidation tests that the validation service sees the model being pulled or pushed
+func TestProxyHandlerTransferValidation(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(`{"status":"success"}` + "\n"))
+	}))
+	defer ollamaServer.Close()
+	validated := make(chan RequestDetails, 1)
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var details RequestDetails
+		json.NewDecoder(r.Body).Decode(&details)
+		validated <- details
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true, Scopes: []string{adminScope}})
+	}))
+	defer validationServer.Close()
+	metricsServer := mockMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	testCases := []struct {
+		path string
+		body string
+	}{
+		{"/api/pull", `{"name":"llama3:8b"}`},
+		{"/api/pull", `{"model":"llama3:8b"}`},
+		{"/api/push", `{"name":"llama3:8b"}`},
+		{"/api/push", `{"model":"llama3:8b"}`},
+	}
+
+	for _, tc := range testCases {
+		req := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+		req.Header.Set("X-API-Key", "test-key")
+		proxyHandler(httptest.NewRecorder(), req)
+
+		details := <-validated
+		if details.Model != "llama3:8b" || details.Endpoint != tc.path {
+			t.Errorf("%s %s: expected validation of llama3:8b, got %+v", tc.path, tc.body, details)
+		}
+	}
+}
+
+// TestProxyHandlerPublicEndpoints tests that listed endpoints work without an API key and still report metrics
+func TestProxyHandlerPublicEndpoints(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/version":
+			w.Write([]byte(`{"version":"0.6.0"}`))
+		case "/api/tags":
+			w.Write([]byte(`{"models":[{"name":"llama3:8b"}]}`))
+		}
+	}))
+	defer ollamaServer.Close()
+	var validations int
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		validations++
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true})
+	}))
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	publicEndpoints = parseKeyList("/api/version")
+	defer func() { publicEndpoints = nil }()
+
+	// Public endpoints skip authentication entirely
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "GET", "/api/version", nil, ""))
+	assertResponseStatus(t, rr, http.StatusOK)
+	metrics := waitForMetrics(t, received)
+	if metrics.Endpoint != "/api/version" || metrics.Model != "" || metrics.InputTokenLength != 0 || metrics.KeySource != "public" {
+		t.Errorf("Expected public metrics without model or tokens, got %+v", metrics)
+	}
+	if validations != 0 {
+		t.Errorf("Expected no validation for a public endpoint, got %d", validations)
+	}
+
+	// Other endpoints still need a key
+	rr = httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "GET", "/api/tags", nil, ""))
+	assertResponseStatus(t, rr, http.StatusUnauthorized)
+
+	// Read-only endpoints with a key are validated but skip body parsing
+	rr = httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "GET", "/api/tags", nil, "test-key"))
+	assertResponseStatus(t, rr, http.StatusOK)
+	if metrics := waitForMetrics(t, received); metrics.Model != "" || metrics.KeySource != "external" || validations != 1 {
+		t.Errorf("Expected validated metrics without a model, got %+v after %d validations", metrics, validations)
+	}
+}
+
+// TestValidationSkipped tests matching paths against VALIDATION_SKIP_PATHS suffixes
+func TestValidationSkipped(t *testing.T) {
+	validationSkipPaths = parseURLList("/api/version, /healthz")
+	defer func() { validationSkipPaths = nil }()
+
+	testCases := map[string]bool{
+		"/api/version":       true,
+		"/proxy/api/version": true,
+		"/healthz":           true,
+		"/api/tags":          false,
+		"/api/version/x":     false,
+	}
+	for path, expected := range testCases {
+		if got := validationSkipped(path); got != expected {
+			t.Errorf("%s: expected %v, got %v", path, expected, got)
+		}
+	}
+}
+
+// TestProxyHandlerValidationSkipPaths tests that skipped paths need a key but never call the validation service
+func TestProxyHandlerValidationSkipPaths(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/version":
+			w.Write([]byte(`{"version":"0.6.0"}`))
+		case "/api/tags":
+			w.Write([]byte(`{"models":[{"name":"llama3:8b"}]}`))
+		}
+	}))
+	defer ollamaServer.Close()
+	var validations int
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		validations++
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true})
+	}))
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	validationSkipPaths = parseURLList("/api/version")
+	defer func() { validationSkipPaths = nil }()
+
+	// The key is still required
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "GET", "/api/version", nil, ""))
+	assertResponseStatus(t, rr, http.StatusUnauthorized)
+
+	// With one, the request is proxied and metered as unvalidated
+	rr = httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "GET", "/api/version", nil, "test-key"))
+	assertResponseStatus(t, rr, http.StatusOK)
+	if metrics := waitForMetrics(t, received); metrics.APIKey != "test-key" || metrics.KeySource != "unvalidated" {
+		t.Errorf("Expected unvalidated metrics for the key, got %+v", metrics)
+	}
+	if validations != 0 {
+		t.Errorf("Expected no validation for a skipped path, got %d", validations)
+	}
+
+	// Other paths are still validated
+	rr = httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "GET", "/api/tags", nil, "test-key"))
+	assertResponseStatus(t, rr, http.StatusOK)
+	if metrics := waitForMetrics(t, received); metrics.KeySource != "external" || validations != 1 {
+		t.Errorf("Expected validated metrics, got %+v after %d validations", metrics, validations)
+	}
+}
+
+// TestProxyHandlerProtectedEndpoints tests that only keys with the admin scope reach protected endpoints
+func TestProxyHandlerProtectedEndpoints(t *testing.T) {
+	var upstreamCalls int
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls++
+		json.NewEncoder(w).Encode(ChatResponse{Model: "llama3:8b", Done: true})
+	}))
+	defer ollamaServer.Close()
+	tenantServer := mockValidationServerWith(t, ValidationResponse{Valid: true, Scopes: []string{"read"}})
+	defer tenantServer.Close()
+	adminServer := mockValidationServerWith(t, ValidationResponse{Valid: true, Scopes: []string{"read", adminScope}})
+	defer adminServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, "", "")
+	defer func() { protectedEndpoints = nil }()
+
+	testCases := []struct {
+		name      string
+		protected string
+		validator *httptest.Server
+		method    string
+		path      string
+		body      interface{}
+		status    int
+	}{
+		{"Delete Without Scope", defaultProtectedEndpoints, tenantServer, "DELETE", "/api/delete", DeleteRequest{Model: "llama3:8b"}, http.StatusForbidden},
+		{"Pull Without Scope", defaultProtectedEndpoints, tenantServer, "POST", "/api/pull", PullRequest{Model: "llama3:8b"}, http.StatusForbidden},
+		{"Trailing Slash Without Scope", defaultProtectedEndpoints, tenantServer, "POST", "/api/create/", map[string]string{"model": "mine"}, http.StatusForbidden},
+		{"Delete With Admin Scope", defaultProtectedEndpoints, adminServer, "DELETE", "/api/delete", DeleteRequest{Model: "llama3:8b"}, http.StatusOK},
+		{"Inference Without Scope", defaultProtectedEndpoints, tenantServer, "POST", "/api/chat", ChatRequest{Model: "llama3:8b"}, http.StatusOK},
+		{"Configured List Protects Copy", "/api/copy", tenantServer, "POST", "/api/copy", CopyRequest{Source: "llama3:8b", Destination: "mine"}, http.StatusForbidden},
+		{"Configured List Frees Delete", "/api/copy", tenantServer, "DELETE", "/api/delete", DeleteRequest{Model: "llama3:8b"}, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			protectedEndpoints = parseEndpointList(tc.protected)
+			externalValidationURL = tc.validator.URL
+			upstreamCalls = 0
+
+			var logs bytes.Buffer
+			logger.SetOutput(&logs)
+			defer logger.SetOutput(os.Stdout)
+
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, tc.method, tc.path, tc.body, "tenant-key"))
+			assertResponseStatus(t, rr, tc.status)
+			if tc.status != http.StatusForbidden {
+				return
+			}
+
+			var errResp ErrorResponse
+			if json.Unmarshal(rr.Body.Bytes(), &errResp); errResp.Code != "admin_scope_required" {
+				t.Errorf("Expected code admin_scope_required, got %s", rr.Body.String())
+			}
+			if upstreamCalls != 0 {
+				t.Errorf("Expected the request to stop at the proxy, got %d upstream calls", upstreamCalls)
+			}
+			log := logs.String()
+			if !strings.Contains(log, `"message":"Forbidden: Endpoint requires admin scope"`) || !strings.Contains(log, `"api_key":"tenant-key"`) || !strings.Contains(log, `"endpoint":"`+path.Clean(tc.path)+`"`) {
+				t.Errorf("Expected the rejection logged with key and endpoint, got %s", log)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerUncleanPath tests that trailing slashes and doubled separators can't change how a request is handled
+func TestProxyHandlerUncleanPath(t *testing.T) {
+	paths := make(chan string, 4)
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+		json.NewEncoder(w).Encode(ChatResponse{Model: "llama3:8b", Done: true})
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, AllowedModels: []string{"llama3:*"}})
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+	deniedEndpoints = []string{"/api/delete"}
+	strictRouting = true
+	defer func() {
+		deniedEndpoints = nil
+		strictRouting = false
+	}()
+
+	testCases := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+		status int
+		code   string
+	}{
+		{"Model Checked With Trailing Slash", "POST", "/api/chat/", ChatRequest{Model: "mistral"}, http.StatusForbidden, "model_not_allowed"},
+		{"Forwarded Cleaned", "POST", "/api//chat/", ChatRequest{Model: "llama3:8b"}, http.StatusOK, ""},
+		{"Denied With Doubled Separator", "DELETE", "/api//delete/", DeleteRequest{Model: "llama3:8b"}, http.StatusForbidden, "endpoint_not_exposed"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, tc.method, tc.path, tc.body, "test-api-key"))
+			assertResponseStatus(t, rr, tc.status)
+			if tc.code != "" {
+				var resp ErrorResponse
+				if json.Unmarshal(rr.Body.Bytes(), &resp); resp.Code != tc.code {
+					t.Errorf("Expected code %s, got %s", tc.code, rr.Body.String())
+				}
+				return
+			}
+			if got := <-paths; got != path.Clean(tc.path) {
+				t.Errorf("Expected Ollama to receive %s, got %s", path.Clean(tc.path), got)
+			}
+		})
+	}
+}
+
+// TestEndpointExposed tests the allow and deny lists, with suffixes and globs
+func TestEndpointExposed(t *testing.T) {
+	defer func() {
+		allowedEndpoints = nil
+		deniedEndpoints = nil
+	}()
+
+	testCases := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		path    string
+		exposed bool
+	}{
+		{"Empty Lists Allow All", nil, nil, "/api/pull", true},
+		{"Allowed Suffix", []string{"/api/chat", "/api/generate", "/api/embed"}, nil, "/api/chat", true},
+		{"Allowed Suffix Under Prefix", []string{"/api/chat"}, nil, "/ollama/api/chat", true},
+		{"Not Allowed", []string{"/api/chat", "/api/generate", "/api/embed"}, nil, "/api/pull", false},
+		{"Suffix Is Not Substring", []string{"/api/embed"}, nil, "/api/embeddings", false},
+		{"Allowed Glob", []string{"/api/*"}, nil, "/api/tags", true},
+		{"Glob Matches Whole Path", []string{"/api/*"}, nil, "/api/blobs/sha256:abc", false},
+		{"Deny Wins", []string{"/api/*"}, []string{"/api/delete"}, "/api/delete", false},
+		{"Deny Glob Without Allow List", nil, []string{"/api/blobs/*"}, "/api/blobs/sha256:abc", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			allowedEndpoints = tc.allowed
+			deniedEndpoints = tc.denied
+			if got := endpointExposed(tc.path); got != tc.exposed {
+				t.Errorf("Expected exposed=%v for %s, got %v", tc.exposed, tc.path, got)
+			}
+		})
+	}
+
+	allowedEndpoints = []string{"/api/[chat"}
+	if err := validateEndpointPatterns(); err == nil {
+		t.Error("Expected an error for a malformed glob")
+	}
+}
+
+// TestProxyHandlerEndpointNotExposed tests that unexposed endpoints are refused before validation
+func TestProxyHandlerEndpointNotExposed(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected no validation call for an unexposed endpoint")
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true, Scopes: []string{adminScope}})
+	}))
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+	allowedEndpoints = []string{"/api/chat", "/api/generate", "/api/embed"}
+	defer func() { allowedEndpoints = nil }()
+	resetReverseProxy()
+
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/pull", PullRequest{Model: "llama3:8b"}, "test-api-key"))
+	assertResponseStatus(t, rr, http.StatusForbidden)
+
+	var resp ErrorResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Expected a JSON error: %v", err)
+	}
+	if resp.Code != "endpoint_not_exposed" || !strings.Contains(resp.Error, "/api/pull") {
+		t.Errorf("Expected endpoint_not_exposed naming /api/pull, got %+v", resp)
+	}
+}
+
+// TestStripPathPrefix tests PATH_PREFIX normalization and stripping
+func TestStripPathPrefix(t *testing.T) {
+	defer func() { pathPrefix = "" }()
+
+	testCases := []struct {
+		name     string
+		prefix   string
+		path     string
+		expected string
+		ok       bool
+	}{
+		{"No Prefix", "", "/api/chat", "/api/chat", true},
+		{"Prefix", "/llm", "/llm/api/chat", "/api/chat", true},
+		{"Trailing Slash", "/llm/", "/llm/api/chat", "/api/chat", true},
+		{"No Leading Slash", "llm", "/llm/api/chat", "/api/chat", true},
+		{"Nested Prefix", "/team/llm/", "/team/llm/api/generate", "/api/generate", true},
+		{"Prefix Alone", "/llm/", "/llm", "/", true},
+		{"Root Prefix", "/", "/api/chat", "/api/chat", true},
+		{"Outside Prefix", "/llm", "/api/chat", "/api/chat", false},
+		{"Prefix Is Not Path Segment", "/llm", "/llmx/api/chat", "/llmx/api/chat", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pathPrefix = normalizePathPrefix(tc.prefix)
+			got, ok := stripPathPrefix(tc.path)
+			if got != tc.expected || ok != tc.ok {
+				t.Errorf("Expected (%q, %v) for %s under %q, got (%q, %v)", tc.expected, tc.ok, tc.path, tc.prefix, got, ok)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerPathPrefix tests that prefixed requests reach Ollama without the prefix and others 404
+func TestProxyHandlerPathPrefix(t *testing.T) {
+	paths := make(chan string, 4)
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths <- r.URL.Path
+		json.NewEncoder(w).Encode(ChatResponse{Model: "llama2", Done: true, Message: ChatMessage{Role: "assistant", Content: "Hi"}})
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+	defer func() { pathPrefix = "" }()
+	resetReverseProxy()
+
+	for _, prefix := range []string{"/llm", "/llm/"} {
+		t.Run(prefix, func(t *testing.T) {
+			pathPrefix = normalizePathPrefix(prefix)
+
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/llm/api/chat", map[string]interface{}{
+				"model":    "llama2",
+				"messages": []ChatMessage{{Role: "user", Content: "hi"}},
+				"stream":   false,
+			}, "test-api-key"))
+			assertResponseStatus(t, rr, http.StatusOK)
+			if got := <-paths; got != "/api/chat" {
+				t.Errorf("Expected Ollama to receive /api/chat, got %s", got)
+			}
+			metrics := waitForMetrics(t, received)
+			if metrics.Endpoint != "/api/chat" || metrics.Model != "llama2" {
+				t.Errorf("Expected /api/chat metrics for llama2, got endpoint %q model %q", metrics.Endpoint, metrics.Model)
+			}
+
+			rr = httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", map[string]interface{}{"model": "llama2"}, "test-api-key"))
+			assertResponseStatus(t, rr, http.StatusNotFound)
+			select {
+			case got := <-paths:
+				t.Errorf("Expected nothing proxied outside the prefix, got %s", got)
+			default:
+			}
+		})
+	}
+}
+
+// TestEndpointRouted tests which paths STRICT_ROUTING forwards
+func TestEndpointRouted(t *testing.T) {
+	defer func() { strictRouting = false }()
+
+	testCases := []struct {
+		name   string
+		strict bool
+		path   string
+		routed bool
+	}{
+		{"Permissive By Default", false, "/admin", true},
+		{"Chat", true, "/api/chat", true},
+		{"Embeddings", true, "/api/embeddings", true},
+		{"Version", true, "/api/version", true},
+		{"OpenAI Chat", true, "/v1/chat/completions", true},
+		{"Blob", true, "/api/blobs/sha256:abc", true},
+		{"Blobs Without Digest", true, "/api/blobs", false},
+		{"Nested Blob Path", true, "/api/blobs/sha256:abc/extra", false},
+		{"Unknown API Path", true, "/api/unknown", false},
+		{"Admin Probe", true, "/admin", false},
+		{"Scanner Path", true, "/wp-login.php", false},
+		{"Suffix Under Other Path", true, "/x/api/chat", false},
+		{"Root", true, "/", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			strictRouting = tc.strict
+			if got := endpointRouted(tc.path); got != tc.routed {
+				t.Errorf("Expected routed=%v for %s, got %v", tc.routed, tc.path, got)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerStrictRouting tests that unknown paths get a 404 without validation or proxying
+func TestProxyHandlerStrictRouting(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected no Ollama call, got %s", r.URL.Path)
+	}))
+	defer ollamaServer.Close()
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected no validation call for an unknown endpoint")
+	}))
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+	strictRouting = true
+	defer func() { strictRouting = false }()
+	resetReverseProxy()
+
+	for _, apiKey := range []string{"test-api-key", ""} {
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, createTestRequest(t, "GET", "/admin/login", nil, apiKey))
+		assertResponseStatus(t, rr, http.StatusNotFound)
+
+		var resp ErrorResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Expected a JSON error: %v", err)
+		}
+		if resp.Code != "endpoint_not_found" || !strings.Contains(resp.Error, "/admin/login") {
+			t.Errorf("Expected endpoint_not_found naming /admin/login, got %+v", resp)
+		}
+	}
+}
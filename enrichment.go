@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"ollama-proxy/logger"
+	"ollama-proxy/version"
+)
+
+// Metadata enrichment configuration
+var (
+	metadataEnrichmentURL string        // empty disables
+	metadataCacheTTL      time.Duration // how long a key's tags are served before refreshing
+	metadataTags          = newMetadataCache()
+)
+
+// metadataEntry is a key's cached tags
+type metadataEntry struct {
+	tags       map[string]string
+	fetchedAt  time.Time
+	refreshing bool
+}
+
+// metadataCache remembers each key's business tags between requests
+type metadataCache struct {
+	mu      sync.Mutex
+	entries map[string]*metadataEntry
+	now     func() time.Time
+}
+
+func newMetadataCache() *metadataCache {
+	return &metadataCache{entries: make(map[string]*metadataEntry), now: time.Now}
+}
+
+// get returns the tags for a key, fetching them on first use; stale tags are served while a background refresh runs
+func (c *metadataCache) get(apiKey string) map[string]string {
+	if metadataEnrichmentURL == "" || apiKey == "" {
+		return nil
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[apiKey]
+	if ok {
+		tags := entry.tags
+		if c.now().Sub(entry.fetchedAt) >= metadataCacheTTL && !entry.refreshing {
+			entry.refreshing = true
+			go c.refresh(apiKey)
+		}
+		c.mu.Unlock()
+		return tags
+	}
+	c.mu.Unlock()
+
+	return c.refresh(apiKey)
+}
+
+// refresh fetches a key's tags and caches them; failures keep any previous tags until the next refresh
+func (c *metadataCache) refresh(apiKey string) map[string]string {
+	tags, err := fetchMetadataTags(apiKey)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[apiKey]
+	if !ok {
+		entry = &metadataEntry{}
+		c.entries[apiKey] = entry
+	}
+	entry.fetchedAt = c.now()
+	entry.refreshing = false
+	if err != nil {
+		logger.Warning("Metadata enrichment failed", map[string]interface{}{
+			"api_key": apiKey,
+			"error":   err.Error(),
+		})
+		return entry.tags
+	}
+	entry.tags = tags
+	return tags
+}
+
+// fetchMetadataTags GETs {METADATA_ENRICHMENT_URL}/{api_key}, which answers with a JSON object of string tags
+func fetchMetadataTags(apiKey string) (map[string]string, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(metadataEnrichmentURL, "/")+"/"+url.PathEscape(apiKey), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-API-Key", externalServerAPIKey)
+	req.Header.Set(version.Header, version.String())
+	signExternalRequest(req, nil)
+
+	resp, err := getSecureHTTPClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Keys the service doesn't know simply carry no tags
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata service returned status %d", resp.StatusCode)
+	}
+
+	var tags map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockMetadataServer serves tags per key from a mutable map, counting lookups per key
+func mockMetadataServer(t *testing.T, tags map[string]map[string]string) (*httptest.Server, func(string) int, *sync.Mutex) {
+	var mu sync.Mutex
+	lookups := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		lookups[r.URL.Path[1:]]++
+		keyTags, ok := tags[r.URL.Path[1:]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(keyTags)
+	}))
+	return server, func(key string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return lookups[key]
+	}, &mu
+}
+
+// TestProxyHandlerMetadataTags tests that tags from the metadata service are included in the metrics payload
+func TestProxyHandlerMetadataTags(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+	metadataServer, lookups, _ := mockMetadataServer(t, map[string]map[string]string{
+		"tagged-key": {"department": "research", "project": "atlas"},
+	})
+	defer metadataServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	metadataEnrichmentURL = metadataServer.URL
+	metadataCacheTTL = time.Minute
+	metadataTags = newMetadataCache()
+	defer func() {
+		metadataEnrichmentURL = ""
+		metadataTags = newMetadataCache()
+	}()
+
+	for i := 0; i < 2; i++ {
+		req := createTestRequest(t, "POST", "/api/generate", GenerateRequest{Model: "llama2", Prompt: "Hi"}, "tagged-key")
+		proxyHandler(httptest.NewRecorder(), req)
+
+		metrics := waitForMetrics(t, received)
+		if metrics.Tags["department"] != "research" || metrics.Tags["project"] != "atlas" {
+			t.Errorf("Expected metadata tags in metrics, got %v", metrics.Tags)
+		}
+	}
+	if n := lookups("tagged-key"); n != 1 {
+		t.Errorf("Expected the second request to use cached tags, got %d lookups", n)
+	}
+}
+
+// TestMetadataCache tests caching, background refresh of stale tags and failure handling
+func TestMetadataCache(t *testing.T) {
+	tags := map[string]map[string]string{"known": {"environment": "staging"}}
+	metadataServer, lookups, mu := mockMetadataServer(t, tags)
+	defer metadataServer.Close()
+
+	metadataEnrichmentURL = metadataServer.URL
+	metadataCacheTTL = time.Minute
+	defer func() { metadataEnrichmentURL = "" }()
+
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	cache := newMetadataCache()
+	cache.now = clock.Now
+
+	if got := cache.get("known"); got["environment"] != "staging" {
+		t.Fatalf("Expected staging tags, got %v", got)
+	}
+	if got := cache.get("unknown"); got != nil {
+		t.Errorf("Expected no tags for an unknown key, got %v", got)
+	}
+	if n := lookups("known") + lookups("unknown"); n != 2 {
+		t.Errorf("Expected 2 lookups, got %d", n)
+	}
+
+	// Stale tags are served while the refresh runs in the background
+	mu.Lock()
+	tags["known"] = map[string]string{"environment": "production"}
+	mu.Unlock()
+	clock.Advance(2 * time.Minute)
+	if got := cache.get("known"); got["environment"] != "staging" {
+		t.Errorf("Expected stale tags during refresh, got %v", got)
+	}
+	deadline := time.Now().Add(time.Second)
+	for cache.get("known")["environment"] != "production" {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the background refresh to pick up new tags")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// A failing service keeps the last known tags
+	metadataServer.Close()
+	clock.Advance(2 * time.Minute)
+	if got := cache.refresh("known"); got["environment"] != "production" {
+		t.Errorf("Expected previous tags after a failed refresh, got %v", got)
+	}
+
+	metadataEnrichmentURL = ""
+	if got := cache.get("known"); got != nil {
+		t.Errorf("Expected no tags with enrichment disabled, got %v", got)
+	}
+}
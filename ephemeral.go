@@ -0,0 +1,373 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"ollama-proxy/logger"
+)
+
+// ephemeralTokenPrefix marks tokens minted by the proxy rather than issued by the validation server
+const ephemeralTokenPrefix = "opx_"
+
+// Ephemeral token configuration
+var (
+	adminAPIKey          string
+	ephemeralTokenSecret string
+	ephemeralTokenTTL    time.Duration
+	ephemeralTokenMaxTTL time.Duration
+	tokenSigner          TokenSigner
+	ephemeralTokens      = newEphemeralTokenStore()
+)
+
+// TokenSigner derives signatures for ephemeral token payloads
+type TokenSigner interface {
+	Sign(payload []byte) []byte
+	Verify(payload, signature []byte) bool
+}
+
+// newTokenSigner builds the signer selected by the ephemeral token configuration
+func newTokenSigner() TokenSigner {
+	if ephemeralTokenSecret == "" {
+		return nil
+	}
+	return hmacSigner{key: []byte(ephemeralTokenSecret)}
+}
+
+// hmacSigner signs payloads with HMAC-SHA256 using a proxy-local secret
+type hmacSigner struct {
+	key []byte
+}
+
+func (s hmacSigner) Sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (s hmacSigner) Verify(payload, signature []byte) bool {
+	return hmac.Equal(s.Sign(payload), signature)
+}
+
+// EphemeralClaims are the limits embedded in an ephemeral token
+type EphemeralClaims struct {
+	ID        string   `json:"id"`
+	Tier      string   `json:"tier"`
+	Models    []string `json:"models,omitempty"`
+	Quota     int      `json:"quota"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// MintTokenRequest is the body accepted by POST /admin/tokens
+type MintTokenRequest struct {
+	Tier       string   `json:"tier"`
+	Models     []string `json:"models"`
+	Quota      int      `json:"quota"`
+	TTLSeconds int      `json:"ttlSeconds"`
+}
+
+// MintTokenResponse is returned when a token is minted
+type MintTokenResponse struct {
+	Token     string    `json:"token"`
+	ID        string    `json:"id"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// tokenError carries the status and error code returned to clients for a rejected ephemeral token
+type tokenError struct {
+	status  int
+	message string
+	code    string
+}
+
+func (e *tokenError) Error() string {
+	return e.message
+}
+
+var (
+	errTokenInvalid    = &tokenError{http.StatusUnauthorized, "Unauthorized: Invalid token", "invalid_token"}
+	errTokenExpired    = &tokenError{http.StatusUnauthorized, "Unauthorized: Token expired", "token_expired"}
+	errTokenRevoked    = &tokenError{http.StatusUnauthorized, "Unauthorized: Token revoked", "token_revoked"}
+	errTokenModel      = &tokenError{http.StatusForbidden, "Forbidden: Model not allowed for token", "model_not_allowed"}
+	errTokenQuotaSpent = &tokenError{http.StatusTooManyRequests, "Too Many Requests: Token quota exhausted", "token_quota_exhausted"}
+)
+
+// tokenUsage counts requests made with a token until it expires
+type tokenUsage struct {
+	count     int
+	expiresAt time.Time
+}
+
+// ephemeralTokenPruneInterval is how often usage and revocations of expired tokens are forgotten
+const ephemeralTokenPruneInterval = time.Minute
+
+// ephemeralTokenStore tracks quota usage and the denylist of revoked token IDs in memory. Revoked IDs are kept
+// until their token expires.
+type ephemeralTokenStore struct {
+	mu      sync.Mutex
+	usage   map[string]*tokenUsage
+	revoked map[string]time.Time
+}
+
+func newEphemeralTokenStore() *ephemeralTokenStore {
+	return &ephemeralTokenStore{
+		usage:   make(map[string]*tokenUsage),
+		revoked: make(map[string]time.Time),
+	}
+}
+
+// track starts counting a newly minted token's usage, so its expiry is known if it is revoked unused
+func (s *ephemeralTokenStore) track(claims EphemeralClaims) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.usage[claims.ID] = &tokenUsage{expiresAt: time.Unix(claims.ExpiresAt, 0)}
+}
+
+// consume records one request against the token, failing if it is revoked or out of quota
+func (s *ephemeralTokenStore) consume(claims EphemeralClaims) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, revoked := s.revoked[claims.ID]; revoked {
+		return errTokenRevoked
+	}
+
+	usage, ok := s.usage[claims.ID]
+	if !ok {
+		usage = &tokenUsage{expiresAt: time.Unix(claims.ExpiresAt, 0)}
+		s.usage[claims.ID] = usage
+	}
+	if claims.Quota > 0 && usage.count >= claims.Quota {
+		return errTokenQuotaSpent
+	}
+	usage.count++
+	return nil
+}
+
+// isRevoked reports whether a token ID is on the denylist
+func (s *ephemeralTokenStore) isRevoked(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, revoked := s.revoked[id]
+	return revoked
+}
+
+// revoke adds a token ID to the denylist until the token expires. Tokens the store hasn't seen, such as
+// ones minted before a restart, are denied until unknownExpiry instead.
+func (s *ephemeralTokenStore) revoke(id string, unknownExpiry time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	expiresAt := unknownExpiry
+	if usage, ok := s.usage[id]; ok {
+		expiresAt = usage.expiresAt
+	}
+	s.revoked[id] = expiresAt
+}
+
+// prune forgets usage and revocations of tokens that have expired by now
+func (s *ephemeralTokenStore) prune(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, usage := range s.usage {
+		if now.After(usage.expiresAt) {
+			delete(s.usage, id)
+		}
+	}
+	for id, expiresAt := range s.revoked {
+		if now.After(expiresAt) {
+			delete(s.revoked, id)
+		}
+	}
+}
+
+// start prunes expired tokens periodically until stop is closed
+func (s *ephemeralTokenStore) start(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				s.prune(now)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// isEphemeralToken reports whether an API key is a proxy-minted token
+func isEphemeralToken(apiKey string) bool {
+	return tokenSigner != nil && strings.HasPrefix(apiKey, ephemeralTokenPrefix)
+}
+
+// mintEphemeralToken encodes and signs the claims into a token string
+func mintEphemeralToken(claims EphemeralClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoding := base64.RawURLEncoding
+	return ephemeralTokenPrefix + encoding.EncodeToString(payload) + "." + encoding.EncodeToString(tokenSigner.Sign(payload)), nil
+}
+
+// parseEphemeralToken verifies a token's signature and expiry and returns its claims
+func parseEphemeralToken(token string) (EphemeralClaims, error) {
+	var claims EphemeralClaims
+
+	encodedPayload, encodedSignature, ok := strings.Cut(strings.TrimPrefix(token, ephemeralTokenPrefix), ".")
+	if !ok {
+		return claims, errTokenInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return claims, errTokenInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil || !tokenSigner.Verify(payload, signature) {
+		return claims, errTokenInvalid
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, errTokenInvalid
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return claims, errTokenExpired
+	}
+	return claims, nil
+}
+
+// authorizeEphemeralToken validates a proxy-minted token locally and checks its models. Requests naming no
+// model, such as GET /api/tags, aren't held to the token's models. The token's quota isn't touched, so the
+// caller consumes it once the request has passed its other checks.
+func authorizeEphemeralToken(token, model string) (EphemeralClaims, error) {
+	claims, err := parseEphemeralToken(token)
+	if err != nil {
+		return claims, err
+	}
+	if ephemeralTokens.isRevoked(claims.ID) {
+		return claims, errTokenRevoked
+	}
+	if len(claims.Models) > 0 && model != "" && !modelAllowed(claims.Models, model) {
+		return claims, errTokenModel
+	}
+	return claims, nil
+}
+
+// tokenStatus returns the HTTP status, message and error code for a token error
+func tokenStatus(err error) (int, string, string) {
+	var tokenErr *tokenError
+	if errors.As(err, &tokenErr) {
+		return tokenErr.status, tokenErr.message, tokenErr.code
+	}
+	return errTokenInvalid.status, errTokenInvalid.message, errTokenInvalid.code
+}
+
+// isAdminRequest checks the admin key in the API key header
+func isAdminRequest(r *http.Request) bool {
+	key := r.Header.Get(apiKeyHeaderName)
+	return adminAPIKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(adminAPIKey)) == 1
+}
+
+// adminTokensHandler mints tokens on POST /admin/tokens and revokes them by ID on DELETE /admin/tokens/{id}
+func adminTokensHandler(w http.ResponseWriter, r *http.Request) {
+	if tokenSigner == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if !isAdminRequest(r) {
+		logger.Warning("Unauthorized: Invalid admin key", map[string]interface{}{
+			"endpoint": r.URL.Path,
+		})
+		http.Error(w, "Unauthorized: Invalid admin key", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		mintTokenHandler(w, r)
+	case http.MethodDelete:
+		revokeTokenHandler(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func mintTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var req MintTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ttl := ephemeralTokenTTL
+	if req.TTLSeconds > 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	// Revoking a token the store has never seen relies on no token outliving the maximum
+	if ttl > ephemeralTokenMaxTTL {
+		http.Error(w, "ttlSeconds exceeds EPHEMERAL_TOKEN_MAX_TTL", http.StatusBadRequest)
+		return
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		logger.Error("Error generating token ID", err, nil)
+		http.Error(w, "Error minting token", http.StatusInternalServerError)
+		return
+	}
+
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	claims := EphemeralClaims{
+		ID:        hex.EncodeToString(id),
+		Tier:      req.Tier,
+		Models:    req.Models,
+		Quota:     req.Quota,
+		ExpiresAt: expiresAt.Unix(),
+	}
+	token, err := mintEphemeralToken(claims)
+	if err != nil {
+		logger.Error("Error minting token", err, nil)
+		http.Error(w, "Error minting token", http.StatusInternalServerError)
+		return
+	}
+	if !validAPIKeyFormat(token) {
+		http.Error(w, "Token too long: list fewer models or raise MAX_API_KEY_LENGTH", http.StatusBadRequest)
+		return
+	}
+	ephemeralTokens.track(claims)
+
+	logger.Info("Minted ephemeral token", map[string]interface{}{
+		"token_id":   claims.ID,
+		"tier":       claims.Tier,
+		"quota":      claims.Quota,
+		"expires_at": expiresAt.UTC().Format(time.RFC3339),
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(MintTokenResponse{Token: token, ID: claims.ID, ExpiresAt: expiresAt})
+}
+
+func revokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/admin/tokens/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "Missing token ID", http.StatusBadRequest)
+		return
+	}
+
+	ephemeralTokens.revoke(id, time.Now().Add(ephemeralTokenMaxTTL))
+	logger.Info("Revoked ephemeral token", map[string]interface{}{
+		"token_id": id,
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
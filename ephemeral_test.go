@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// setupEphemeralTokens enables proxy-minted tokens against a mock Ollama and a validator that rejects everything
+func setupEphemeralTokens(t *testing.T) (*httptest.Server, chan MetricsData) {
+	ollamaServer := mockOllamaServer(t)
+	t.Cleanup(ollamaServer.Close)
+	validationServer := mockValidationServer(t, false, false)
+	t.Cleanup(validationServer.Close)
+	metricsServer, received := recordingMetricsServer(t)
+	t.Cleanup(metricsServer.Close)
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	adminAPIKey = "admin-key"
+	ephemeralTokenSecret = "test-secret"
+	ephemeralTokenTTL = time.Hour
+	ephemeralTokenMaxTTL = 24 * time.Hour
+	tokenSigner = newTokenSigner()
+	ephemeralTokens = newEphemeralTokenStore()
+	t.Cleanup(func() {
+		adminAPIKey = ""
+		ephemeralTokenSecret = ""
+		tokenSigner = nil
+	})
+
+	return ollamaServer, received
+}
+
+// mintTestToken mints a token through the admin endpoint
+func mintTestToken(t *testing.T, req MintTokenRequest) MintTokenResponse {
+	body, _ := json.Marshal(req)
+	httpReq := httptest.NewRequest("POST", "/admin/tokens", bytes.NewReader(body))
+	httpReq.Header.Set("X-API-Key", "admin-key")
+	rr := httptest.NewRecorder()
+	adminTokensHandler(rr, httpReq)
+	assertResponseStatus(t, rr, http.StatusCreated)
+
+	var resp MintTokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Error decoding mint response: %v", err)
+	}
+	return resp
+}
+
+// generateWithToken sends a generate request authenticated with the given key
+func generateWithToken(t *testing.T, model, token string) *httptest.ResponseRecorder {
+	req := createTestRequest(t, "POST", "/api/generate", GenerateRequest{
+		Model:  model,
+		Prompt: "Hello",
+	}, token)
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, req)
+	return rr
+}
+
+// TestEphemeralTokenLifecycle tests minting, using, exhausting and revoking proxy-minted tokens
+func TestEphemeralTokenLifecycle(t *testing.T) {
+	_, received := setupEphemeralTokens(t)
+
+	minted := mintTestToken(t, MintTokenRequest{Tier: "trial", Models: []string{"llama2"}, Quota: 2})
+	if minted.ID == "" || !isEphemeralToken(minted.Token) {
+		t.Fatalf("Expected a prefixed token with an ID, got %+v", minted)
+	}
+
+	// Used within limits without consulting the (rejecting) validator
+	assertResponseStatus(t, generateWithToken(t, "llama2", minted.Token), http.StatusOK)
+	metrics := waitForMetrics(t, received)
+	if metrics.KeySource != "ephemeral" {
+		t.Errorf("Expected key source ephemeral, got %q", metrics.KeySource)
+	}
+
+	// Models outside the allowlist are refused without consuming quota
+	assertResponseStatus(t, generateWithToken(t, "mistral", minted.Token), http.StatusForbidden)
+
+	assertResponseStatus(t, generateWithToken(t, "llama2", minted.Token), http.StatusOK)
+	waitForMetrics(t, received)
+	assertResponseStatus(t, generateWithToken(t, "llama2", minted.Token), http.StatusTooManyRequests)
+
+	// Revoked tokens are rejected even with quota left
+	other := mintTestToken(t, MintTokenRequest{Tier: "trial", Quota: 10})
+	req := httptest.NewRequest("DELETE", "/admin/tokens/"+other.ID, nil)
+	req.Header.Set("X-API-Key", "admin-key")
+	rr := httptest.NewRecorder()
+	adminTokensHandler(rr, req)
+	assertResponseStatus(t, rr, http.StatusNoContent)
+	assertResponseStatus(t, generateWithToken(t, "llama2", other.Token), http.StatusUnauthorized)
+
+	// Requests naming no model, like listing models, aren't held to the token's models
+	listing := mintTestToken(t, MintTokenRequest{Tier: "trial", Models: []string{"llama2"}, Quota: 1})
+	rr = httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "GET", "/api/tags", nil, listing.Token))
+	if rr.Code == http.StatusForbidden {
+		t.Errorf("Expected a model-less request to pass the token's model list, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Token models match the way allowedModels do, and requests refused after the model check spend no quota
+	family := mintTestToken(t, MintTokenRequest{Tier: "trial", Models: []string{"Llama*"}, Quota: 1})
+	protectedEndpoints = parseEndpointList(defaultProtectedEndpoints)
+	defer func() { protectedEndpoints = nil }()
+	rr = httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/pull", PullRequest{Model: "llama2"}, family.Token))
+	assertResponseStatus(t, rr, http.StatusForbidden)
+	assertResponseStatus(t, generateWithToken(t, "llama2", family.Token), http.StatusOK)
+	waitForMetrics(t, received)
+
+	// Lifetimes beyond the maximum are refused, so revocations of unseen tokens can expire
+	body, _ := json.Marshal(MintTokenRequest{Tier: "trial", TTLSeconds: 2 * 24 * 60 * 60})
+	req = httptest.NewRequest("POST", "/admin/tokens", bytes.NewReader(body))
+	req.Header.Set("X-API-Key", "admin-key")
+	rr = httptest.NewRecorder()
+	adminTokensHandler(rr, req)
+	assertResponseStatus(t, rr, http.StatusBadRequest)
+}
+
+// TestEphemeralTokenStorePrune tests that revocations are forgotten once their tokens expire
+func TestEphemeralTokenStorePrune(t *testing.T) {
+	store := newEphemeralTokenStore()
+	now := time.Now()
+	minted := EphemeralClaims{ID: "minted", ExpiresAt: now.Add(time.Minute).Unix()}
+	store.track(minted)
+	store.revoke(minted.ID, now.Add(24*time.Hour))
+	store.revoke("unseen", now.Add(24*time.Hour))
+
+	store.prune(now.Add(2 * time.Minute))
+	if store.isRevoked(minted.ID) {
+		t.Error("Expected the minted token's revocation to be forgotten once it expired")
+	}
+	if !store.isRevoked("unseen") {
+		t.Error("Expected an unseen token to stay revoked until the maximum lifetime")
+	}
+
+	store.prune(now.Add(25 * time.Hour))
+	if store.isRevoked("unseen") {
+		t.Error("Expected the unseen token's revocation to be forgotten after the maximum lifetime")
+	}
+}
+
+// TestEphemeralTokenRejected tests expired, tampered and foreign tokens
+func TestEphemeralTokenRejected(t *testing.T) {
+	setupEphemeralTokens(t)
+
+	expired, err := mintEphemeralToken(EphemeralClaims{ID: "expired", ExpiresAt: time.Now().Add(-time.Second).Unix()})
+	if err != nil {
+		t.Fatalf("Error minting token: %v", err)
+	}
+	valid, _ := mintEphemeralToken(EphemeralClaims{ID: "valid", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+	tokenSigner = hmacSigner{key: []byte("other-secret")}
+	foreign, _ := mintEphemeralToken(EphemeralClaims{ID: "foreign", ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	tokenSigner = newTokenSigner()
+
+	testCases := []struct {
+		name  string
+		token string
+	}{
+		{"Expired", expired},
+		{"Tampered", valid[:len(valid)-2] + "xx"},
+		{"Foreign Secret", foreign},
+		{"Garbage", ephemeralTokenPrefix + "not-a-token"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assertResponseStatus(t, generateWithToken(t, "llama2", tc.token), http.StatusUnauthorized)
+		})
+	}
+}
+
+// TestAdminTokensRequiresAdminKey tests that minting requires the admin key
+func TestAdminTokensRequiresAdminKey(t *testing.T) {
+	setupEphemeralTokens(t)
+
+	req := httptest.NewRequest("POST", "/admin/tokens", bytes.NewReader([]byte(`{"quota":1}`)))
+	req.Header.Set("X-API-Key", "not-admin")
+	rr := httptest.NewRecorder()
+	adminTokensHandler(rr, req)
+	assertResponseStatus(t, rr, http.StatusUnauthorized)
+
+	// Without a secret the endpoint does not exist
+	tokenSigner = nil
+	rr = httptest.NewRecorder()
+	adminTokensHandler(rr, httptest.NewRequest("POST", "/admin/tokens", nil))
+	assertResponseStatus(t, rr, http.StatusNotFound)
+}
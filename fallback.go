@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+
+	"ollama-proxy/logger"
+)
+
+// Model fallback configuration
+var (
+	modelFallbacks       map[string]string
+	modelFallbackMaxHops int
+)
+
+// fallbackModel returns the model to retry with when Ollama doesn't have model, using the "*" entry
+// for models without their own
+func fallbackModel(path, model string) (string, bool) {
+	switch canonicalEndpoint(path) {
+	case "chat", "generate", "embed":
+	default:
+		return "", false
+	}
+	fallback, ok := modelFallbacks[model]
+	if !ok {
+		fallback, ok = modelFallbacks["*"]
+	}
+	return fallback, ok && fallback != "" && fallback != model
+}
+
+// fallbackWriter holds back Ollama's 404 so the request can be retried with a fallback model
+// before the client sees anything
+type fallbackWriter struct {
+	http.ResponseWriter
+	header         http.Header
+	notFound       bool
+	upstreamHeader http.Header
+	upstream       bytes.Buffer
+}
+
+// begin prepares for an attempt, remembering the headers so a held back 404 leaves no trace
+func (fw *fallbackWriter) begin() {
+	fw.header = fw.Header().Clone()
+	fw.notFound = false
+	fw.upstreamHeader = nil
+	fw.upstream.Reset()
+}
+
+func (fw *fallbackWriter) WriteHeader(statusCode int) {
+	if statusCode == http.StatusNotFound {
+		fw.notFound = true
+		fw.upstreamHeader = fw.Header().Clone()
+		replaceHeader(fw.Header(), fw.header)
+		return
+	}
+	fw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (fw *fallbackWriter) Write(b []byte) (int, error) {
+	if fw.notFound {
+		return fw.upstream.Write(b)
+	}
+	return fw.ResponseWriter.Write(b)
+}
+
+// replay passes the held back 404 on when no fallback is left to try
+func (fw *fallbackWriter) replay() {
+	replaceHeader(fw.Header(), fw.upstreamHeader)
+	fw.ResponseWriter.WriteHeader(http.StatusNotFound)
+	fw.ResponseWriter.Write(fw.upstream.Bytes())
+}
+
+func (fw *fallbackWriter) Flush() {
+	if !fw.notFound {
+		http.NewResponseController(fw.ResponseWriter).Flush()
+	}
+}
+
+func (fw *fallbackWriter) Unwrap() http.ResponseWriter {
+	return fw.ResponseWriter
+}
+
+func replaceHeader(dst, src http.Header) {
+	for key := range dst {
+		delete(dst, key)
+	}
+	for key, values := range src {
+		dst[key] = values
+	}
+}
+
+// serveWithFallbacks proxies the request, substituting fallback models for up to MODEL_FALLBACK_MAX_HOPS
+// models Ollama doesn't have. A fallback outside allowedModels (when set) is refused with 403 rather than
+// served. It returns the model that was served and the body sent for it.
+func serveWithFallbacks(proxy *httputil.ReverseProxy, w http.ResponseWriter, r *http.Request, body []byte, model, apiKey string, allowedModels []string) (aborted bool, served string, servedBody []byte) {
+	fw := &fallbackWriter{ResponseWriter: w}
+	tried := map[string]bool{model: true}
+	for hop := 1; ; hop++ {
+		fw.begin()
+		if aborted = serveProxy(proxy, fw, r); aborted || !fw.notFound {
+			return aborted, model, body
+		}
+
+		fallback, ok := fallbackModel(r.URL.Path, model)
+		if !ok || tried[fallback] || hop > modelFallbackMaxHops {
+			fw.replay()
+			return false, model, body
+		}
+		if allowedModels != nil && !modelAllowed(allowedModels, fallback) {
+			logger.Warning("Forbidden: Fallback model not allowed for key", map[string]interface{}{
+				"api_key":        apiKey,
+				"endpoint":       r.URL.Path,
+				"model":          model,
+				"fallback_model": fallback,
+			})
+			writeProxyError(w, r, http.StatusForbidden, "model_not_allowed", fmt.Sprintf("Forbidden: Model %s not allowed for key", fallback))
+			return false, model, body
+		}
+		rewritten := rewriteModelName(r, body, model, fallback)
+		if bytes.Equal(rewritten, body) {
+			fw.replay()
+			return false, model, body
+		}
+		logger.Warning("Model not found, substituting fallback", map[string]interface{}{
+			"api_key":        apiKey,
+			"endpoint":       r.URL.Path,
+			"model":          model,
+			"fallback_model": fallback,
+			"hop":            hop,
+		})
+		body = rewritten
+		tried[fallback] = true
+		model = fallback
+	}
+}
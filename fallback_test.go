@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"ollama-proxy/logger"
+)
+
+// TestProxyHandlerModelFallbacks tests substituting fallback models when Ollama doesn't have the requested one
+func TestProxyHandlerModelFallbacks(t *testing.T) {
+	var mu sync.Mutex
+	var requested []string
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ChatRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		requested = append(requested, req.Model)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if req.Model != "llama3:8b" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "model \"" + req.Model + "\" not found, try pulling it first"})
+			return
+		}
+		json.NewEncoder(w).Encode(ChatResponse{Model: req.Model, Message: ChatMessage{Role: "assistant", Content: "Hi"}, Done: true})
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+	defer func() {
+		modelFallbacks = nil
+		modelFallbackMaxHops = 0
+	}()
+
+	testCases := []struct {
+		name      string
+		fallbacks map[string]string
+		maxHops   int
+		model     string
+		status    int
+		requested []string
+	}{
+		{
+			name:      "Specific Fallback",
+			fallbacks: map[string]string{"llama3:70b": "llama3:8b"},
+			maxHops:   1,
+			model:     "llama3:70b",
+			status:    http.StatusOK,
+			requested: []string{"llama3:70b", "llama3:8b"},
+		},
+		{
+			name:      "Wildcard Fallback",
+			fallbacks: map[string]string{"llama3:70b": "mistral", "*": "llama3:8b"},
+			maxHops:   1,
+			model:     "phi3",
+			status:    http.StatusOK,
+			requested: []string{"phi3", "llama3:8b"},
+		},
+		{
+			name:      "Chained Fallbacks",
+			fallbacks: map[string]string{"llama3:70b": "llama3:13b", "llama3:13b": "llama3:8b"},
+			maxHops:   2,
+			model:     "llama3:70b",
+			status:    http.StatusOK,
+			requested: []string{"llama3:70b", "llama3:13b", "llama3:8b"},
+		},
+		{
+			name:      "Hop Limit",
+			fallbacks: map[string]string{"llama3:70b": "llama3:13b", "llama3:13b": "llama3:8b"},
+			maxHops:   1,
+			model:     "llama3:70b",
+			status:    http.StatusNotFound,
+			requested: []string{"llama3:70b", "llama3:13b"},
+		},
+		{
+			name:      "Fallback Loop",
+			fallbacks: map[string]string{"a": "b", "b": "a"},
+			maxHops:   5,
+			model:     "a",
+			status:    http.StatusNotFound,
+			requested: []string{"a", "b"},
+		},
+		{
+			name:      "No Fallback",
+			fallbacks: map[string]string{"llama3:70b": "llama3:8b"},
+			maxHops:   1,
+			model:     "phi3",
+			status:    http.StatusNotFound,
+			requested: []string{"phi3"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			modelFallbacks = tc.fallbacks
+			modelFallbackMaxHops = tc.maxHops
+			requested = nil
+
+			var logs bytes.Buffer
+			logger.SetOutput(&logs)
+			defer logger.SetOutput(os.Stdout)
+
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", ChatRequest{Model: tc.model}, "test-key"))
+			assertResponseStatus(t, rr, tc.status)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(requested, tc.requested) {
+				t.Errorf("Expected Ollama to be asked for %v, got %v", tc.requested, requested)
+			}
+			if values := rr.Header()["Content-Type"]; len(values) != 1 {
+				t.Errorf("Expected one Content-Type, got %v", values)
+			}
+			if substitutions := strings.Count(logs.String(), `"message":"Model not found, substituting fallback"`); substitutions != len(tc.requested)-1 {
+				t.Errorf("Expected %d substitution warnings, got %d", len(tc.requested)-1, substitutions)
+			}
+
+			last := tc.requested[len(tc.requested)-1]
+			if tc.status == http.StatusOK {
+				var resp ChatResponse
+				json.Unmarshal(rr.Body.Bytes(), &resp)
+				if resp.Model != last {
+					t.Errorf("Expected a response from %s, got %+v", last, resp)
+				}
+			} else if !strings.Contains(rr.Body.String(), `model \"`+last+`\" not found`) {
+				t.Errorf("Expected Ollama's not found error for %s, got %s", last, rr.Body.String())
+			}
+		})
+	}
+}
+
+// TestProxyHandlerFallbackAllowedModels tests that a fallback the key may not use is refused, not served
+func TestProxyHandlerFallbackAllowedModels(t *testing.T) {
+	setupEphemeralTokens(t)
+	var mu sync.Mutex
+	var requested []string
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req GenerateRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		mu.Lock()
+		requested = append(requested, req.Model)
+		mu.Unlock()
+		if req.Model != "llama3:8b" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "model \"" + req.Model + "\" not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(GenerateResponse{Model: req.Model, Response: "Hi", Done: true})
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, AllowedModels: []string{"phi3"}})
+	defer validationServer.Close()
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+	modelFallbacks = map[string]string{"phi3": "llama3:8b"}
+	modelFallbackMaxHops = 1
+	defer func() {
+		modelFallbacks = nil
+		modelFallbackMaxHops = 0
+	}()
+
+	token := mintTestToken(t, MintTokenRequest{Models: []string{"phi3"}}).Token
+	for name, key := range map[string]string{"Validation Allowed Models": "test-key", "Ephemeral Token Models": token} {
+		t.Run(name, func(t *testing.T) {
+			requested = nil
+			rr := generateWithToken(t, "phi3", key)
+			assertResponseStatus(t, rr, http.StatusForbidden)
+
+			var resp ErrorResponse
+			json.NewDecoder(rr.Body).Decode(&resp)
+			if resp.Code != "model_not_allowed" || !strings.Contains(resp.Error, "llama3:8b") {
+				t.Errorf("Expected a model_not_allowed error naming the fallback, got %+v", resp)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !reflect.DeepEqual(requested, []string{"phi3"}) {
+				t.Errorf("Expected the fallback not to be requested, got %v", requested)
+			}
+		})
+	}
+}
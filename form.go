@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"ollama-proxy/logger"
+)
+
+// formDecodeMiddleware converts form-encoded request bodies into the JSON Ollama expects
+func formDecodeMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/x-www-form-urlencoded" {
+			next(w, r)
+			return
+		}
+
+		bodyBytes, err := io.ReadAll(r.Body)
+		if err != nil {
+			logger.Error("Error reading form body", err, map[string]interface{}{
+				"endpoint": r.URL.Path,
+			})
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+
+		form, err := url.ParseQuery(string(bodyBytes))
+		if err != nil {
+			http.Error(w, "Invalid form body", http.StatusBadRequest)
+			return
+		}
+
+		jsonBody, err := formToJSON(r.URL.Path, form)
+		if err != nil {
+			// Validation hasn't run yet, so only the configured zero-retention keys are known here
+			apiKey, _ := apiKeyFromRequest(r)
+			policy := retentionPolicyFor(apiKey, ValidationResponse{})
+			logger.Warning("Invalid form field", policy.LogFields(map[string]interface{}{
+				"endpoint":    r.URL.Path,
+				"field_error": err.Error(),
+			}))
+			http.Error(w, "Invalid form body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		setRequestBody(r, jsonBody)
+		r.Header.Set("Content-Type", "application/json")
+		next(w, r)
+	}
+}
+
+// requestTypeForPath returns the request struct Ollama expects for an endpoint
+func requestTypeForPath(path string) reflect.Type {
+	switch {
+	case strings.HasSuffix(path, "/api/chat"):
+		return reflect.TypeOf(ChatRequest{})
+	case strings.HasSuffix(path, "/api/generate"):
+		return reflect.TypeOf(GenerateRequest{})
+	case strings.HasSuffix(path, "/api/embed"):
+		return reflect.TypeOf(EmbedRequest{})
+	case strings.HasSuffix(path, "/api/embeddings"):
+		return reflect.TypeOf(EmbeddingsRequest{})
+	case strings.HasSuffix(path, "/api/create"):
+		return reflect.TypeOf(CreateRequest{})
+	}
+	return nil
+}
+
+// formToJSON builds a JSON object from form values, typed after the endpoint's request struct
+func formToJSON(path string, form url.Values) ([]byte, error) {
+	fieldTypes := make(map[string]reflect.Type)
+	if reqType := requestTypeForPath(path); reqType != nil {
+		for i := 0; i < reqType.NumField(); i++ {
+			field := reqType.Field(i)
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name != "" && name != "-" {
+				fieldTypes[name] = field.Type
+			}
+		}
+	}
+
+	object := make(map[string]interface{}, len(form))
+	for key, values := range form {
+		fieldType, ok := fieldTypes[key]
+		if !ok {
+			// Unknown fields are passed through as plain strings
+			if len(values) == 1 {
+				object[key] = values[0]
+			} else {
+				object[key] = values
+			}
+			continue
+		}
+
+		value, err := convertFormValue(fieldType, values)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %v", key, err)
+		}
+		object[key] = value
+	}
+
+	return json.Marshal(object)
+}
+
+// convertFormValue converts raw form values into a value matching the target field type
+func convertFormValue(fieldType reflect.Type, values []string) (interface{}, error) {
+	if fieldType.Kind() == reflect.Ptr {
+		fieldType = fieldType.Elem()
+	}
+	value := values[0]
+
+	switch fieldType.Kind() {
+	case reflect.String:
+		return value, nil
+	case reflect.Bool:
+		return strconv.ParseBool(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(value, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(value, 64)
+	case reflect.Slice:
+		if fieldType.Elem().Kind() == reflect.String && !json.Valid([]byte(value)) {
+			return values, nil
+		}
+	}
+
+	// Structured fields (messages, options, format, input) may be sent as JSON text
+	if json.Valid([]byte(value)) {
+		return json.RawMessage(value), nil
+	}
+	if len(values) > 1 {
+		return values, nil
+	}
+	return value, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestFormDecodeMiddleware tests conversion of form-encoded bodies to JSON
+func TestFormDecodeMiddleware(t *testing.T) {
+	testCases := []struct {
+		name           string
+		path           string
+		contentType    string
+		body           string
+		expectedStatus int
+		expectedBody   map[string]interface{}
+	}{
+		{
+			name:           "Generate Request",
+			path:           "/api/generate",
+			contentType:    "application/x-www-form-urlencoded",
+			body:           "model=llama3&prompt=hello&stream=false&options=%7B%22temperature%22%3A0.5%7D",
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"model":   "llama3",
+				"prompt":  "hello",
+				"stream":  false,
+				"options": map[string]interface{}{"temperature": 0.5},
+			},
+		},
+		{
+			name:           "Chat Request With JSON Messages",
+			path:           "/api/chat",
+			contentType:    "application/x-www-form-urlencoded; charset=utf-8",
+			body:           "model=llama3&messages=%5B%7B%22role%22%3A%22user%22%2C%22content%22%3A%22hi%22%7D%5D",
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"model": "llama3",
+				"messages": []interface{}{
+					map[string]interface{}{"role": "user", "content": "hi"},
+				},
+			},
+		},
+		{
+			name:           "Repeated Images",
+			path:           "/api/generate",
+			contentType:    "application/x-www-form-urlencoded",
+			body:           "model=llava&images=aaa&images=bbb",
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"model":  "llava",
+				"images": []interface{}{"aaa", "bbb"},
+			},
+		},
+		{
+			name:           "Invalid Bool",
+			path:           "/api/generate",
+			contentType:    "application/x-www-form-urlencoded",
+			body:           "model=llama3&stream=maybe",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "JSON Passthrough",
+			path:           "/api/generate",
+			contentType:    "application/json",
+			body:           `{"model":"llama3","prompt":"hello"}`,
+			expectedStatus: http.StatusOK,
+			expectedBody: map[string]interface{}{
+				"model":  "llama3",
+				"prompt": "hello",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var received map[string]interface{}
+			var receivedContentType string
+			handler := formDecodeMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				receivedContentType = r.Header.Get("Content-Type")
+				body, _ := io.ReadAll(r.Body)
+				if r.ContentLength != int64(len(body)) {
+					t.Errorf("Expected ContentLength %d, got %d", len(body), r.ContentLength)
+				}
+				if err := json.Unmarshal(body, &received); err != nil {
+					t.Errorf("Expected JSON body, got %s", body)
+				}
+				w.WriteHeader(http.StatusOK)
+			})
+
+			req := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", tc.contentType)
+			rr := httptest.NewRecorder()
+			handler(rr, req)
+
+			assertResponseStatus(t, rr, tc.expectedStatus)
+			if tc.expectedStatus != http.StatusOK {
+				return
+			}
+			if receivedContentType != "application/json" {
+				t.Errorf("Expected Content-Type application/json, got %s", receivedContentType)
+			}
+			expected, _ := json.Marshal(tc.expectedBody)
+			actual, _ := json.Marshal(received)
+			if string(expected) != string(actual) {
+				t.Errorf("Expected body %s, got %s", expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// updateGolden rewrites golden files from the actual output instead of comparing against them
+var updateGolden bool
+
+func TestMain(m *testing.M) {
+	update := flag.Bool("update-golden", false, "regenerate golden files in testdata")
+	flag.Parse()
+	updateGolden = *update
+	os.Exit(m.Run())
+}
+
+// assertGolden compares actual against the JSON golden file at testdata/<name>.json
+func assertGolden[T any](t *testing.T, name string, actual T) {
+	t.Helper()
+	path := filepath.Join("testdata", name+".json")
+
+	if updateGolden {
+		data, err := json.MarshalIndent(actual, "", "  ")
+		if err != nil {
+			t.Fatalf("Error marshaling golden output: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Error creating golden directory: %v", err)
+		}
+		if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			t.Fatalf("Error writing golden file: %v", err)
+		}
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading golden file (run with -update-golden to create it): %v", err)
+	}
+	var expected T
+	if err := json.Unmarshal(data, &expected); err != nil {
+		t.Fatalf("Error decoding golden file %s: %v", path, err)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		expectedJSON, _ := json.Marshal(expected)
+		actualJSON, _ := json.Marshal(actual)
+		t.Errorf("Output does not match %s\nexpected: %s\nactual:   %s", path, expectedJSON, actualJSON)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"ollama-proxy/logger"
+	"ollama-proxy/version"
+)
+
+// gRPC methods called on validation URLs with a grpc:// or grpcs:// scheme; see proto/validation.proto
+const (
+	grpcValidateMethod    = "/ollamaproxy.validation.v1.ValidationService/Validate"
+	grpcHealthMethod      = "/grpc.health.v1.Health/Check"
+	grpcValidationService = "ollamaproxy.validation.v1.ValidationService"
+)
+
+// gRPC status codes the proxy tells apart
+const (
+	grpcStatusOK               = 0
+	grpcStatusDeadlineExceeded = 4
+	grpcStatusUnavailable      = 14
+)
+
+// grpcServingStatus is HealthCheckResponse.ServingStatus SERVING
+const grpcServingStatus = 1
+
+// grpcMaxMessageSize caps a validation or health response
+const grpcMaxMessageSize = 1 << 20
+
+// isGRPCValidationURL reports whether a validation URL uses the gRPC transport
+func isGRPCValidationURL(validationURL string) bool {
+	return strings.HasPrefix(validationURL, "grpc://") || strings.HasPrefix(validationURL, "grpcs://")
+}
+
+// grpcStatusError is a gRPC call that ended with a status other than OK
+type grpcStatusError struct {
+	code    int
+	message string
+}
+
+func (e *grpcStatusError) Error() string {
+	return fmt.Sprintf("validation server returned gRPC status %d: %s", e.code, e.message)
+}
+
+// grpcClients holds one HTTP/2 client per scheme, so calls share connections
+var grpcClients struct {
+	sync.Mutex
+	cleartext, tls *http.Client
+}
+
+// grpcHTTPClient returns the shared client for grpc:// (HTTP/2 without TLS) or grpcs:// URLs. Calls
+// are bounded by their context, not a client timeout.
+func grpcHTTPClient(secure bool) *http.Client {
+	grpcClients.Lock()
+	defer grpcClients.Unlock()
+	client := &grpcClients.cleartext
+	if secure {
+		client = &grpcClients.tls
+	}
+	if *client == nil {
+		transport := getSecureHTTPClient().Transport.(*http.Transport)
+		transport.Protocols = new(http.Protocols)
+		if secure {
+			transport.Protocols.SetHTTP2(true)
+		} else {
+			transport.Protocols.SetUnencryptedHTTP2(true)
+		}
+		*client = &http.Client{Transport: transport}
+	}
+	return *client
+}
+
+// grpcTimeout renders the time left before deadline as a grpc-timeout header, in milliseconds rounded up
+func grpcTimeout(deadline time.Time) string {
+	ms := (time.Until(deadline) + time.Millisecond - 1) / time.Millisecond
+	return strconv.FormatInt(max(int64(ms), 1), 10) + "m"
+}
+
+// grpcCall makes a unary gRPC call to method on the host of validationURL, within timeout and ctx's deadline
+func grpcCall(ctx context.Context, validationURL, method string, message []byte, timeout time.Duration) ([]byte, error) {
+	parsed, err := url.Parse(validationURL)
+	if err != nil {
+		return nil, err
+	}
+	secure := parsed.Scheme == "grpcs"
+	endpoint := url.URL{Scheme: "http", Host: parsed.Host, Path: method}
+	if secure {
+		endpoint.Scheme = "https"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	frame = append(frame, message...)
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint.String(), bytes.NewReader(frame))
+	if err != nil {
+		return nil, err
+	}
+
+	// Add gRPC and security headers
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("Grpc-Timeout", grpcTimeout(deadline))
+	req.Header.Set("X-API-Key", externalServerAPIKey)
+	req.Header.Set(version.Header, version.String())
+	req.Header.Set(requestIDHeader, requestIDFrom(ctx))
+	signExternalRequest(req, frame)
+
+	resp, err := grpcHTTPClient(secure).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &validationStatusError{status: resp.StatusCode}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 5+grpcMaxMessageSize))
+	if err != nil {
+		return nil, err
+	}
+
+	// Errors may come back as headers alone, without a body or trailers
+	status := resp.Header.Get("Grpc-Status")
+	statusMessage := resp.Header.Get("Grpc-Message")
+	if status == "" {
+		status, statusMessage = resp.Trailer.Get("Grpc-Status"), resp.Trailer.Get("Grpc-Message")
+	}
+	if code, err := strconv.Atoi(status); err != nil || code != grpcStatusOK {
+		if err != nil {
+			code = grpcStatusUnavailable
+			statusMessage = "missing grpc-status"
+		}
+		statusMessage, _ = url.PathUnescape(statusMessage)
+		return nil, &grpcStatusError{code: code, message: statusMessage}
+	}
+
+	if len(body) < 5 || body[0] != 0 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+		return nil, &validationDecodeError{err: errors.New("malformed or compressed gRPC message")}
+	}
+	return body[5:], nil
+}
+
+// callGRPCValidationService sends a validation request to a grpc:// or grpcs:// validation URL
+func callGRPCValidationService(ctx context.Context, target validationTarget, details RequestDetails) (ValidationResponse, error) {
+	fields := map[string]interface{}{
+		"api_key":        details.APIKey,
+		"endpoint":       details.Endpoint,
+		"validation_url": target.url,
+	}
+
+	// Wait longer on URLs that already failed a health check
+	timeout := validationTimeout
+	if !target.healthy {
+		timeout = max(validationTimeout, unhealthyValidationTimeout)
+	}
+	message, err := grpcCall(ctx, target.url, grpcValidateMethod, encodeGRPCRequestDetails(details), timeout)
+	if err != nil {
+		logger.Error("Error calling validation server", err, fields)
+		return ValidationResponse{}, err
+	}
+
+	validationResp, err := decodeGRPCValidationResponse(message)
+	if err != nil {
+		logger.Error("Error decoding validation response", err, fields)
+		return ValidationResponse{}, &validationDecodeError{err: err}
+	}
+	return validationResp, nil
+}
+
+// checkGRPCValidationURL checks that a gRPC validation service reports itself SERVING
+func checkGRPCValidationURL(validationURL string) error {
+	request := protowire.AppendTag(nil, 1, protowire.BytesType)
+	request = protowire.AppendString(request, grpcValidationService)
+	message, err := grpcCall(context.Background(), validationURL, grpcHealthMethod, request, validationTimeout)
+	if err != nil {
+		return err
+	}
+
+	status := 0
+	err = consumeGRPCFields(message, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if num == 1 && typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(value)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			status = int(v)
+		}
+		return nil
+	})
+	if err != nil {
+		return &validationDecodeError{err: err}
+	}
+	if status != grpcServingStatus {
+		return fmt.Errorf("validation service health status %d, not SERVING", status)
+	}
+	return nil
+}
+
+// encodeGRPCRequestDetails encodes details as a RequestDetails message
+func encodeGRPCRequestDetails(details RequestDetails) []byte {
+	var b []byte
+	appendString := func(num protowire.Number, s string) {
+		if s != "" {
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendString(b, s)
+		}
+	}
+	appendVarint := func(num protowire.Number, v uint64) {
+		if v != 0 {
+			b = protowire.AppendTag(b, num, protowire.VarintType)
+			b = protowire.AppendVarint(b, v)
+		}
+	}
+
+	appendString(1, details.APIKey)
+	appendString(2, details.IPAddress)
+	appendString(3, details.UserAgent)
+	names := make([]string, 0, len(details.Headers))
+	for name := range details.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var values []byte
+		for _, value := range details.Headers[name] {
+			values = protowire.AppendTag(values, 1, protowire.BytesType)
+			values = protowire.AppendString(values, value)
+		}
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, name)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, values)
+		b = protowire.AppendTag(b, 12, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	appendString(5, details.Model)
+	appendVarint(6, uint64(details.InputTokenLength))
+	appendVarint(7, protowire.EncodeBool(details.InputTokenEstimated))
+	appendString(8, details.Endpoint)
+	appendString(9, details.DestinationModel)
+	appendString(10, details.BodySHA256)
+	appendVarint(11, uint64(details.BodyBytes))
+	return b
+}
+
+// decodeGRPCValidationResponse decodes a ValidationResponse message, skipping fields it doesn't know
+func decodeGRPCValidationResponse(message []byte) (ValidationResponse, error) {
+	var resp ValidationResponse
+	err := consumeGRPCFields(message, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ == protowire.BytesType {
+			s, n := protowire.ConsumeString(value)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			switch num {
+			case 4:
+				resp.Reason = s
+			case 6:
+				resp.AllowedEndpoints = append(resp.AllowedEndpoints, s)
+			case 7:
+				resp.AllowedModels = append(resp.AllowedModels, s)
+			case 8:
+				resp.AllowedCIDRs = append(resp.AllowedCIDRs, s)
+			case 9:
+				resp.Scopes = append(resp.Scopes, s)
+			case 10:
+				resp.Tier = s
+			case 17:
+				resp.SessionToken = s
+			}
+			return nil
+		}
+		if typ != protowire.VarintType {
+			return nil
+		}
+		v, n := protowire.ConsumeVarint(value)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		i := int(int32(v))
+		switch num {
+		case 1:
+			resp.Valid = protowire.DecodeBool(v)
+		case 2:
+			resp.RateLimited = protowire.DecodeBool(v)
+		case 3:
+			resp.ZeroRetention = protowire.DecodeBool(v)
+		case 5:
+			resp.RetryAfterSeconds = i
+		case 11:
+			resp.MaxKeyAgeDays = i
+		case 12:
+			if v != 0 {
+				issuedAt := time.Unix(int64(v), 0).UTC()
+				resp.KeyIssuedAt = &issuedAt
+			}
+		case 13:
+			resp.MaxOutputTokens = i
+		case 14:
+			resp.RateLimitLimit = &i
+		case 15:
+			resp.RateLimitRemaining = &i
+		case 16:
+			resp.RateLimitResetSeconds = &i
+		case 18:
+			resp.SessionTTLSeconds = i
+		}
+		return nil
+	})
+	return resp, err
+}
+
+// consumeGRPCFields calls fn with the number, type and encoded value of each field in message
+func consumeGRPCFields(message []byte, fn func(num protowire.Number, typ protowire.Type, value []byte) error) error {
+	for len(message) > 0 {
+		num, typ, n := protowire.ConsumeTag(message)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		message = message[n:]
+		size := protowire.ConsumeFieldValue(num, typ, message)
+		if size < 0 {
+			return protowire.ParseError(size)
+		}
+		if err := fn(num, typ, message[:size]); err != nil {
+			return err
+		}
+		message = message[size:]
+	}
+	return nil
+}
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// grpcValidationServer serves gRPC over cleartext HTTP/2, answering each call's method and decoded
+// message with a response message and gRPC status. It returns the grpc:// URL and a call counter.
+func grpcValidationServer(t *testing.T, answer func(r *http.Request, method string, message []byte) ([]byte, int)) (string, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		if len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+			t.Errorf("Malformed gRPC request frame %q", body)
+			return
+		}
+		response, status := answer(r, r.URL.Path, body[5:])
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(http.StatusOK)
+		if status == grpcStatusOK {
+			frame := make([]byte, 5, 5+len(response))
+			binary.BigEndian.PutUint32(frame[1:], uint32(len(response)))
+			w.Write(append(frame, response...))
+		}
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(status))
+	}))
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	t.Cleanup(server.Close)
+	return strings.Replace(server.URL, "http://", "grpc://", 1), &calls
+}
+
+// useGRPCValidationURL points validation at url for the rest of the test
+func useGRPCValidationURL(t *testing.T, url string) {
+	t.Helper()
+	oldURLs := externalValidationURLs
+	t.Cleanup(func() {
+		externalValidationURLs = oldURLs
+		validationHealth = newValidationHealthTracker()
+	})
+	externalValidationURLs = []string{url}
+	validationHealth = newValidationHealthTracker()
+	useValidationRetries(t, 1, 0, time.Second)
+}
+
+// encodeTestValidationResponse encodes the ValidationResponse fields the tests use
+func encodeTestValidationResponse(valid, rateLimited bool, reason string) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, protowire.EncodeBool(valid))
+	b = protowire.AppendTag(b, 2, protowire.VarintType)
+	b = protowire.AppendVarint(b, protowire.EncodeBool(rateLimited))
+	b = protowire.AppendTag(b, 4, protowire.BytesType)
+	return protowire.AppendString(b, reason)
+}
+
+// decodeTestRequestDetails decodes the RequestDetails string fields and headers the proxy sends
+func decodeTestRequestDetails(t *testing.T, message []byte) RequestDetails {
+	details := RequestDetails{Headers: map[string][]string{}}
+	err := consumeGRPCFields(message, func(num protowire.Number, typ protowire.Type, value []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		s, _ := protowire.ConsumeBytes(value)
+		switch num {
+		case 1:
+			details.APIKey = string(s)
+		case 12:
+			var name string
+			var values []string
+			consumeGRPCFields(s, func(num protowire.Number, _ protowire.Type, value []byte) error {
+				v, _ := protowire.ConsumeBytes(value)
+				if num == 1 {
+					name = string(v)
+					return nil
+				}
+				return consumeGRPCFields(v, func(_ protowire.Number, _ protowire.Type, value []byte) error {
+					v, _ := protowire.ConsumeString(value)
+					values = append(values, v)
+					return nil
+				})
+			})
+			details.Headers[name] = values
+		case 5:
+			details.Model = string(s)
+		case 8:
+			details.Endpoint = string(s)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Failed to decode RequestDetails: %v", err)
+	}
+	return details
+}
+
+// TestGRPCValidation tests a validation call over gRPC, end to end
+func TestGRPCValidation(t *testing.T) {
+	var mu sync.Mutex
+	var received RequestDetails
+	var request *http.Request
+	url, _ := grpcValidationServer(t, func(r *http.Request, method string, message []byte) ([]byte, int) {
+		if method != grpcValidateMethod {
+			t.Errorf("Expected method %s, got %s", grpcValidateMethod, method)
+		}
+		mu.Lock()
+		received, request = decodeTestRequestDetails(t, message), r
+		mu.Unlock()
+		return encodeTestValidationResponse(received.APIKey == "good-key", false, ""), grpcStatusOK
+	})
+	useGRPCValidationURL(t, url)
+
+	details := RequestDetails{
+		APIKey:   "good-key",
+		Model:    "llama3",
+		Endpoint: "/api/chat",
+		Headers:  map[string][]string{"User-Agent": {"test"}, "X-Forwarded-For": {"203.0.113.7", "10.0.0.1"}},
+	}
+	if _, ok, _ := validateRequest(context.Background(), details); !ok {
+		t.Fatal("Expected valid key to be accepted over gRPC")
+	}
+	mu.Lock()
+	if !reflect.DeepEqual(received, details) {
+		t.Errorf("Expected server to receive %+v, got %+v", details, received)
+	}
+	if request.ProtoMajor != 2 {
+		t.Errorf("Expected HTTP/2, got %s", request.Proto)
+	}
+	for _, header := range []string{"Grpc-Timeout", "Te", "X-Api-Key"} {
+		if _, ok := request.Header[header]; !ok {
+			t.Errorf("Expected %s header on gRPC call", header)
+		}
+	}
+	mu.Unlock()
+
+	if _, ok, _ := validateRequest(context.Background(), RequestDetails{APIKey: "bad-key"}); ok {
+		t.Error("Expected invalid key to be refused over gRPC")
+	}
+}
+
+// TestDecodeGRPCValidationResponse tests that every ValidationResponse field decodes, and unknown fields are skipped
+func TestDecodeGRPCValidationResponse(t *testing.T) {
+	var b []byte
+	appendVarint := func(num protowire.Number, v uint64) {
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+		b = protowire.AppendVarint(b, v)
+	}
+	appendString := func(num protowire.Number, s string) {
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendString(b, s)
+	}
+	appendVarint(1, 1)
+	appendVarint(2, 1)
+	appendVarint(3, 1)
+	appendString(4, "rate_limited")
+	appendVarint(5, 30)
+	appendString(6, "/api/chat")
+	appendString(7, "llama3")
+	appendString(7, "mistral")
+	appendString(8, "10.0.0.0/8")
+	appendString(9, "chat")
+	appendString(10, "pro")
+	appendVarint(11, 90)
+	appendVarint(12, 1700000000)
+	appendVarint(13, 512)
+	appendVarint(14, 100)
+	appendVarint(15, 0)
+	appendVarint(16, 60)
+	appendString(17, "session")
+	appendVarint(18, 300)
+	appendString(99, "unknown")
+	b = protowire.AppendTag(b, 98, protowire.Fixed64Type)
+	b = protowire.AppendFixed64(b, 7)
+
+	issuedAt := time.Unix(1700000000, 0).UTC()
+	expected := ValidationResponse{
+		Valid: true, RateLimited: true, ZeroRetention: true,
+		Reason: "rate_limited", RetryAfterSeconds: 30,
+		AllowedEndpoints: []string{"/api/chat"}, AllowedModels: []string{"llama3", "mistral"},
+		AllowedCIDRs: []string{"10.0.0.0/8"}, Scopes: []string{"chat"}, Tier: "pro",
+		MaxKeyAgeDays: 90, KeyIssuedAt: &issuedAt, MaxOutputTokens: 512,
+		RateLimitLimit: intPtr(100), RateLimitRemaining: intPtr(0), RateLimitResetSeconds: intPtr(60),
+		SessionToken: "session", SessionTTLSeconds: 300,
+	}
+	resp, err := decodeGRPCValidationResponse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resp, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, resp)
+	}
+
+	if _, err := decodeGRPCValidationResponse(b[:len(b)-3]); err == nil {
+		t.Error("Expected truncated message to fail")
+	}
+}
+
+// protoMessagePattern and protoFieldPattern match the messages and fields of proto/validation.proto, which
+// has no nested messages, enums or oneofs
+var (
+	protoMessagePattern = regexp.MustCompile(`(?s)message (\w+) \{(.*?)\n\}`)
+	protoFieldPattern   = regexp.MustCompile(`^(optional |repeated )?(map<(\w+), (\w+)>|\w+) (\w+) = (\d+);$`)
+)
+
+// protoScalarTypes maps the scalar types the validation messages use to their descriptor types
+var protoScalarTypes = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"string": descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"bool":   descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	"int32":  descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	"int64":  descriptorpb.FieldDescriptorProto_TYPE_INT64,
+}
+
+// loadValidationProto builds descriptors from proto/validation.proto, so the hand-written codec is checked
+// against the file other services generate code from
+func loadValidationProto(t *testing.T) protoreflect.FileDescriptor {
+	t.Helper()
+	source, err := os.ReadFile("proto/validation.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := regexp.MustCompile(`//.*`).ReplaceAllString(string(source), "")
+	pkg := regexp.MustCompile(`package ([\w.]+);`).FindStringSubmatch(text)[1]
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("validation.proto"),
+		Package: proto.String(pkg),
+		Syntax:  proto.String("proto3"),
+	}
+
+	field := func(name string, number int32, label descriptorpb.FieldDescriptorProto_Label, typeName string) *descriptorpb.FieldDescriptorProto {
+		fd := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Label: label.Enum()}
+		if scalar, ok := protoScalarTypes[typeName]; ok {
+			fd.Type = scalar.Enum()
+		} else {
+			fd.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+			fd.TypeName = proto.String(typeName)
+		}
+		return fd
+	}
+
+	for _, m := range protoMessagePattern.FindAllStringSubmatch(text, -1) {
+		message := &descriptorpb.DescriptorProto{Name: proto.String(m[1])}
+		for _, line := range strings.Split(m[2], "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "reserved ") {
+				continue
+			}
+			f := protoFieldPattern.FindStringSubmatch(line)
+			if f == nil {
+				t.Fatalf("Unsupported line in %s: %q", m[1], line)
+			}
+			number, _ := strconv.Atoi(f[6])
+			messageType := func(name string) string {
+				if _, ok := protoScalarTypes[name]; ok {
+					return name
+				}
+				return "." + pkg + "." + name
+			}
+
+			label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+			typeName := messageType(f[2])
+			switch {
+			case f[3] != "":
+				entry := strings.ToUpper(f[5][:1]) + f[5][1:] + "Entry"
+				message.NestedType = append(message.NestedType, &descriptorpb.DescriptorProto{
+					Name: proto.String(entry),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("key", 1, label, messageType(f[3])),
+						field("value", 2, label, messageType(f[4])),
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				})
+				label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+				typeName = "." + pkg + "." + m[1] + "." + entry
+			case f[1] == "repeated ":
+				label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+			}
+			fd := field(f[5], int32(number), label, typeName)
+			if f[1] == "optional " {
+				// proto3 optional fields sit alone in a synthetic oneof
+				fd.Proto3Optional = proto.Bool(true)
+				fd.OneofIndex = proto.Int32(int32(len(message.OneofDecl)))
+				message.OneofDecl = append(message.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String("_" + f[5])})
+			}
+			message.Field = append(message.Field, fd)
+		}
+		file.MessageType = append(file.MessageType, message)
+	}
+
+	fd, err := protodesc.NewFile(file, nil)
+	if err != nil {
+		t.Fatalf("Error building descriptors from proto/validation.proto: %v", err)
+	}
+	return fd
+}
+
+// setProtoFields sets each named field of msg, failing the test for fields of msg left without a value
+func setProtoFields(t *testing.T, msg *dynamicpb.Message, values map[string]interface{}) {
+	t.Helper()
+	fields := msg.Descriptor().Fields()
+	for name, value := range values {
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			t.Fatalf("%s has no field %s", msg.Descriptor().Name(), name)
+		}
+		switch v := value.(type) {
+		case []string:
+			list := msg.Mutable(fd).List()
+			for _, s := range v {
+				list.Append(protoreflect.ValueOfString(s))
+			}
+		case map[string][]string:
+			entries := msg.Mutable(fd).Map()
+			for key, values := range v {
+				entry := dynamicpb.NewMessage(fd.MapValue().Message())
+				setProtoFields(t, entry, map[string]interface{}{"values": values})
+				entries.Set(protoreflect.ValueOfString(key).MapKey(), protoreflect.ValueOfMessage(entry))
+			}
+		default:
+			msg.Set(fd, protoreflect.ValueOf(value))
+		}
+	}
+	for i := 0; i < fields.Len(); i++ {
+		if !msg.Has(fields.Get(i)) {
+			t.Errorf("No test value for %s.%s; keep grpcvalidation.go and this test in step with the proto", msg.Descriptor().Name(), fields.Get(i).Name())
+		}
+	}
+}
+
+// TestGRPCValidationProto tests that the hand-written codec round-trips through messages built from
+// proto/validation.proto, field for field
+func TestGRPCValidationProto(t *testing.T) {
+	file := loadValidationProto(t)
+	messages := file.Messages()
+
+	details := RequestDetails{
+		APIKey:              "test-key",
+		IPAddress:           "192.0.2.1:1234",
+		UserAgent:           "test-agent",
+		Headers:             map[string][]string{"Accept": {"application/json"}, "X-Forwarded-For": {"10.0.0.1", "10.0.0.2"}},
+		Model:               "llama3",
+		InputTokenLength:    42,
+		InputTokenEstimated: true,
+		Endpoint:            "/api/chat",
+		DestinationModel:    "llama3-copy",
+		BodySHA256:          "abc123",
+		BodyBytes:           1024,
+	}
+	expectedDetails := dynamicpb.NewMessage(messages.ByName("RequestDetails"))
+	setProtoFields(t, expectedDetails, map[string]interface{}{
+		"api_key":               details.APIKey,
+		"ip_address":            details.IPAddress,
+		"user_agent":            details.UserAgent,
+		"headers":               details.Headers,
+		"model":                 details.Model,
+		"input_token_length":    int64(details.InputTokenLength),
+		"input_token_estimated": details.InputTokenEstimated,
+		"endpoint":              details.Endpoint,
+		"destination_model":     details.DestinationModel,
+		"body_sha256":           details.BodySHA256,
+		"body_bytes":            int64(details.BodyBytes),
+	})
+	decodedDetails := dynamicpb.NewMessage(messages.ByName("RequestDetails"))
+	if err := proto.Unmarshal(encodeGRPCRequestDetails(details), decodedDetails); err != nil {
+		t.Fatalf("Error decoding RequestDetails: %v", err)
+	}
+	if len(decodedDetails.GetUnknown()) > 0 {
+		t.Errorf("Expected every encoded field to be in the proto, got unknown bytes %x", decodedDetails.GetUnknown())
+	}
+	if !proto.Equal(decodedDetails, expectedDetails) {
+		t.Errorf("Expected %v, got %v", expectedDetails, decodedDetails)
+	}
+
+	response := dynamicpb.NewMessage(messages.ByName("ValidationResponse"))
+	setProtoFields(t, response, map[string]interface{}{
+		"valid":                    true,
+		"rate_limited":             true,
+		"zero_retention":           true,
+		"reason":                   "rate_limited",
+		"retry_after_seconds":      int32(30),
+		"allowed_endpoints":        []string{"chat"},
+		"allowed_models":           []string{"llama3", "mistral"},
+		"allowed_cidrs":            []string{"10.0.0.0/8"},
+		"scopes":                   []string{"admin"},
+		"tier":                     "pro",
+		"max_key_age_days":         int32(90),
+		"key_issued_at_unix":       int64(1700000000),
+		"max_output_tokens":        int32(512),
+		"rate_limit_limit":         int32(100),
+		"rate_limit_remaining":     int32(0),
+		"rate_limit_reset_seconds": int32(60),
+		"session_token":            "session",
+		"session_ttl_seconds":      int32(300),
+	})
+	message, err := proto.MarshalOptions{Deterministic: true}.Marshal(response)
+	if err != nil {
+		t.Fatalf("Error encoding ValidationResponse: %v", err)
+	}
+	issuedAt := time.Unix(1700000000, 0).UTC()
+	expected := ValidationResponse{
+		Valid: true, RateLimited: true, ZeroRetention: true,
+		Reason: "rate_limited", RetryAfterSeconds: 30,
+		AllowedEndpoints: []string{"chat"}, AllowedModels: []string{"llama3", "mistral"},
+		AllowedCIDRs: []string{"10.0.0.0/8"}, Scopes: []string{"admin"}, Tier: "pro",
+		MaxKeyAgeDays: 90, KeyIssuedAt: &issuedAt, MaxOutputTokens: 512,
+		RateLimitLimit: intPtr(100), RateLimitRemaining: intPtr(0), RateLimitResetSeconds: intPtr(60),
+		SessionToken: "session", SessionTTLSeconds: 300,
+	}
+	decoded, err := decodeGRPCValidationResponse(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, decoded)
+	}
+}
+
+// TestGRPCValidationRetry tests that only UNAVAILABLE and DEADLINE_EXCEEDED statuses are retried
+func TestGRPCValidationRetry(t *testing.T) {
+	testCases := []struct {
+		name          string
+		status        int
+		expectedOK    bool
+		expectedCalls int32
+	}{
+		{"Unavailable Then Success", grpcStatusUnavailable, true, 2},
+		{"Deadline Exceeded Then Success", grpcStatusDeadlineExceeded, true, 2},
+		{"Permission Denied Not Retried", 7, false, 1},
+		{"Internal Not Retried", 13, false, 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var failed atomic.Bool
+			url, calls := grpcValidationServer(t, func(r *http.Request, method string, message []byte) ([]byte, int) {
+				if !failed.Swap(true) {
+					return nil, tc.status
+				}
+				return encodeTestValidationResponse(true, false, ""), grpcStatusOK
+			})
+			useGRPCValidationURL(t, url)
+			useValidationRetries(t, 2, time.Millisecond, time.Second)
+
+			if _, ok, _ := validateRequest(context.Background(), RequestDetails{APIKey: "test-key"}); ok != tc.expectedOK {
+				t.Errorf("Expected ok=%v, got %v", tc.expectedOK, ok)
+			}
+			if calls.Load() != tc.expectedCalls {
+				t.Errorf("Expected %d calls, got %d", tc.expectedCalls, calls.Load())
+			}
+		})
+	}
+}
+
+// TestGRPCValidationConnectionReuse tests that gRPC calls share one HTTP/2 connection
+func TestGRPCValidationConnectionReuse(t *testing.T) {
+	var mu sync.Mutex
+	remotes := map[string]bool{}
+	url, _ := grpcValidationServer(t, func(r *http.Request, method string, message []byte) ([]byte, int) {
+		mu.Lock()
+		remotes[r.RemoteAddr] = true
+		mu.Unlock()
+		return encodeTestValidationResponse(true, false, ""), grpcStatusOK
+	})
+	useGRPCValidationURL(t, url)
+
+	for i := 0; i < 5; i++ {
+		if _, ok, _ := validateRequest(context.Background(), RequestDetails{APIKey: "test-key"}); !ok {
+			t.Fatal("Expected validation to succeed")
+		}
+	}
+	if len(remotes) != 1 {
+		t.Errorf("Expected one connection, got %d", len(remotes))
+	}
+}
+
+// TestGRPCValidationDeadline tests that a slow gRPC service is abandoned after VALIDATION_TIMEOUT
+func TestGRPCValidationDeadline(t *testing.T) {
+	url, _ := grpcValidationServer(t, func(r *http.Request, method string, message []byte) ([]byte, int) {
+		<-r.Context().Done()
+		return nil, grpcStatusDeadlineExceeded
+	})
+	useGRPCValidationURL(t, url)
+	oldTimeout := validationTimeout
+	defer func() { validationTimeout = oldTimeout }()
+	validationTimeout = 50 * time.Millisecond
+	validationHealth.set(url, true)
+
+	start := time.Now()
+	if _, ok, _ := validateRequest(context.Background(), RequestDetails{APIKey: "test-key"}); ok {
+		t.Error("Expected timed out validation to be refused")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected validation to give up after the timeout, took %v", elapsed)
+	}
+}
+
+// TestGRPCValidationHealth tests the gRPC health check used for startup, failover and URL swaps
+func TestGRPCValidationHealth(t *testing.T) {
+	testCases := []struct {
+		name          string
+		servingStatus uint64
+		status        int
+		expectHealthy bool
+	}{
+		{"Serving", grpcServingStatus, grpcStatusOK, true},
+		{"Not Serving", 2, grpcStatusOK, false},
+		{"Unimplemented", 0, 12, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			url, _ := grpcValidationServer(t, func(r *http.Request, method string, message []byte) ([]byte, int) {
+				if method != grpcHealthMethod {
+					t.Errorf("Expected method %s, got %s", grpcHealthMethod, method)
+				}
+				if service, _ := protowire.ConsumeString(message[1:]); service != grpcValidationService {
+					t.Errorf("Expected health check for %s, got %q", grpcValidationService, service)
+				}
+				response := protowire.AppendTag(nil, 1, protowire.VarintType)
+				return protowire.AppendVarint(response, tc.servingStatus), tc.status
+			})
+			useGRPCValidationURL(t, url)
+
+			if err := checkValidationURL(url); (err == nil) != tc.expectHealthy {
+				t.Errorf("Expected healthy=%v, got err=%v", tc.expectHealthy, err)
+			}
+			checkValidationHealth()
+			if validationHealth.healthy(url) != tc.expectHealthy {
+				t.Errorf("Expected health tracker to record healthy=%v", tc.expectHealthy)
+			}
+		})
+	}
+}
+
+// TestGRPCValidationBatchConfig tests that batch validation refuses a gRPC validation URL
+func TestGRPCValidationBatchConfig(t *testing.T) {
+	oldType, oldSize, oldMode, oldMock := externalValidationType, validationBatchSize, validationMode, validationMock
+	oldURL := swapValidationURL("grpc://validation:50051")
+	defer func() {
+		externalValidationType, validationBatchSize, validationMode, validationMock = oldType, oldSize, oldMode, oldMock
+		swapValidationURL(oldURL)
+	}()
+	validationMode, validationMock = "external", false
+
+	externalValidationType, validationBatchSize = "batch", 10
+	if _, err := newValidator(); err == nil {
+		t.Error("Expected batch validation with a gRPC URL to be refused")
+	}
+	externalValidationType = "single"
+	if _, err := newValidator(); err != nil {
+		t.Errorf("Expected single validation with a gRPC URL to be allowed, got %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Stream heartbeat configuration
+var (
+	streamHeartbeat         bool
+	streamHeartbeatInterval time.Duration
+)
+
+// Heartbeats are ignored by the consumers of each format: blank lines between NDJSON
+// documents are whitespace, and lines starting with a colon are SSE comments
+var (
+	ndjsonHeartbeat = []byte("\n")
+	sseHeartbeat    = []byte(": heartbeat\n\n")
+)
+
+// heartbeatWriter keeps an idle streaming connection alive while Ollama loads a model.
+// Until the first upstream byte it periodically commits a 200 stream response and writes
+// heartbeats; after that it passes writes straight through. If the heartbeat already sent the
+// headers, the upstream status can no longer change, so failures reach the client in the body,
+// as Ollama reports mid-stream errors.
+type heartbeatWriter struct {
+	http.ResponseWriter
+	header    http.Header
+	sse       bool
+	mu        sync.Mutex
+	committed bool
+	stopOnce  sync.Once
+	stopCh    chan struct{}
+	done      chan struct{}
+}
+
+// newHeartbeatWriter starts sending heartbeats every interval until upstream responds or ctx ends
+func newHeartbeatWriter(ctx context.Context, w http.ResponseWriter, sse bool, interval time.Duration) *heartbeatWriter {
+	hw := &heartbeatWriter{
+		ResponseWriter: w,
+		header:         w.Header().Clone(),
+		sse:            sse,
+		stopCh:         make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+
+	go func() {
+		defer close(hw.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				hw.beat()
+			case <-hw.stopCh:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return hw
+}
+
+// beat commits the stream response if needed and writes one heartbeat
+func (hw *heartbeatWriter) beat() {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+
+	if !hw.committed {
+		hw.committed = true
+		header := hw.ResponseWriter.Header()
+		header.Del("Content-Length")
+		header.Set("Cache-Control", "no-cache")
+		if hw.sse {
+			header.Set("Content-Type", "text/event-stream")
+		} else {
+			header.Set("Content-Type", "application/x-ndjson")
+		}
+		hw.ResponseWriter.WriteHeader(http.StatusOK)
+	}
+
+	beat := ndjsonHeartbeat
+	if hw.sse {
+		beat = sseHeartbeat
+	}
+	hw.ResponseWriter.Write(beat)
+	http.NewResponseController(hw.ResponseWriter).Flush()
+}
+
+// stop ends heartbeats and waits for any in-flight heartbeat to finish
+func (hw *heartbeatWriter) stop() {
+	hw.stopOnce.Do(func() { close(hw.stopCh) })
+	<-hw.done
+}
+
+// Header returns the upstream response headers, kept apart from those a heartbeat may have sent
+func (hw *heartbeatWriter) Header() http.Header {
+	return hw.header
+}
+
+func (hw *heartbeatWriter) WriteHeader(statusCode int) {
+	hw.stop()
+	if hw.committed {
+		return
+	}
+	hw.committed = true
+
+	header := hw.ResponseWriter.Header()
+	for k := range header {
+		delete(header, k)
+	}
+	for k, v := range hw.header {
+		header[k] = v
+	}
+	hw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (hw *heartbeatWriter) Write(b []byte) (int, error) {
+	hw.stop()
+	if !hw.committed {
+		hw.WriteHeader(http.StatusOK)
+	}
+	return hw.ResponseWriter.Write(b)
+}
+
+func (hw *heartbeatWriter) Flush() {
+	hw.stop()
+	if !hw.committed {
+		hw.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(hw.ResponseWriter).Flush()
+}
+
+func (hw *heartbeatWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
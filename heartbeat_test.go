@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// delayedOllamaServer waits before answering, like Ollama loading a cold model
+func delayedOllamaServer(t *testing.T, delay time.Duration) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model  string `json:"model"`
+			Stream *bool  `json:"stream"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+
+		if req.Model == "missing" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"model 'missing' not found"}`))
+			return
+		}
+		if req.Stream != nil && !*req.Stream {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"model":"llama2","message":{"role":"assistant","content":"Hi"},"done":true,"prompt_eval_count":3,"eval_count":1}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(`{"model":"llama2","message":{"role":"assistant","content":"Hi"},"done":false}` + "\n"))
+		w.Write([]byte(`{"model":"llama2","message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":3,"eval_count":1}` + "\n"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// setupHeartbeatProxy serves the proxy handler over a real connection in front of a delayed upstream
+func setupHeartbeatProxy(t *testing.T, delay, interval time.Duration) *httptest.Server {
+	ollamaServer := delayedOllamaServer(t, delay)
+	validationServer := mockValidationServer(t, true, false)
+	t.Cleanup(validationServer.Close)
+	metricsServer, _ := recordingMetricsServer(t)
+	t.Cleanup(metricsServer.Close)
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+	streamHeartbeat = true
+	streamHeartbeatInterval = interval
+	t.Cleanup(func() { streamHeartbeat = false })
+
+	proxyServer := httptest.NewServer(http.HandlerFunc(proxyHandler))
+	t.Cleanup(proxyServer.Close)
+	return proxyServer
+}
+
+// postChat sends a chat request to the proxy server
+func postChat(t *testing.T, proxyURL, model string, stream *bool, accept string) *http.Response {
+	request := map[string]interface{}{
+		"model":    model,
+		"messages": []ChatMessage{{Role: "user", Content: "Hello"}},
+	}
+	if stream != nil {
+		request["stream"] = *stream
+	}
+	body, _ := json.Marshal(request)
+	req, _ := http.NewRequest("POST", proxyURL+"/api/chat", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "test-key")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error calling proxy: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// TestStreamHeartbeatColdModel tests heartbeats while upstream takes 5s to send its first byte
+func TestStreamHeartbeatColdModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping 5s cold model simulation in short mode")
+	}
+	proxyServer := setupHeartbeatProxy(t, 5*time.Second, time.Second)
+
+	t.Run("NDJSON", func(t *testing.T) {
+		t.Parallel()
+		start := time.Now()
+		resp := postChat(t, proxyServer.URL, "llama2", nil, "")
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("Expected committed NDJSON stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected headers within the first heartbeat, got them after %v", elapsed)
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		beats := len(body) - len(bytes.TrimLeft(body, "\n"))
+		if beats < 3 {
+			t.Errorf("Expected at least 3 heartbeats before the first chunk, got %d", beats)
+		}
+
+		// A streaming JSON decoder reads straight through the heartbeats
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		var chunks []ChatResponse
+		for decoder.More() {
+			var chunk ChatResponse
+			if err := decoder.Decode(&chunk); err != nil {
+				t.Fatalf("Heartbeats corrupted the NDJSON stream: %v", err)
+			}
+			chunks = append(chunks, chunk)
+		}
+		if len(chunks) != 2 || !chunks[1].Done {
+			t.Errorf("Expected 2 chunks ending in done, got %+v", chunks)
+		}
+	})
+
+	t.Run("SSE", func(t *testing.T) {
+		t.Parallel()
+		resp := postChat(t, proxyServer.URL, "llama2", nil, "text/event-stream")
+		if resp.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("Expected event stream, got %s", resp.Header.Get("Content-Type"))
+		}
+
+		var comments, data []string
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, ":"):
+				comments = append(comments, line)
+			case strings.HasPrefix(line, "data: "):
+				data = append(data, strings.TrimPrefix(line, "data: "))
+			}
+		}
+		if len(comments) < 3 {
+			t.Errorf("Expected at least 3 heartbeat comments, got %d", len(comments))
+		}
+		if len(data) != 3 || data[2] != "[DONE]" {
+			t.Errorf("Expected 2 events and [DONE], got %v", data)
+		}
+	})
+}
+
+// TestStreamHeartbeatPassthrough tests that responses faster than the interval, and non-streaming ones, are untouched
+func TestStreamHeartbeatPassthrough(t *testing.T) {
+	noStream := false
+
+	t.Run("Non-Streaming", func(t *testing.T) {
+		proxyServer := setupHeartbeatProxy(t, 200*time.Millisecond, 20*time.Millisecond)
+		resp := postChat(t, proxyServer.URL, "llama2", &noStream, "")
+		body, _ := io.ReadAll(resp.Body)
+		if resp.Header.Get("Content-Type") != "application/json" || !json.Valid(body) || body[0] != '{' {
+			t.Errorf("Expected plain JSON without heartbeats, got %s %q", resp.Header.Get("Content-Type"), body)
+		}
+	})
+
+	t.Run("Upstream Error Before Heartbeat", func(t *testing.T) {
+		proxyServer := setupHeartbeatProxy(t, 0, time.Second)
+		resp := postChat(t, proxyServer.URL, "missing", nil, "")
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected upstream status to pass through, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("Upstream Error After Heartbeat", func(t *testing.T) {
+		proxyServer := setupHeartbeatProxy(t, 200*time.Millisecond, 20*time.Millisecond)
+		resp := postChat(t, proxyServer.URL, "missing", nil, "")
+		body, _ := io.ReadAll(resp.Body)
+		var chunk struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(bytes.TrimSpace(body), &chunk); err != nil || chunk.Error == "" {
+			t.Errorf("Expected the error in the committed stream, got %q", body)
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// estimateInputTokens approximates the prompt tokens of a request before it runs, at about four
+// characters per token, so the validation service can make token-budget decisions. It counts chat
+// messages, the generate or completion prompt and system prompt, or embedding input; ok is false for
+// endpoints without a prompt. Metrics still carry Ollama's exact counts after the response.
+func estimateInputTokens(path string, body []byte) (tokens int, ok bool) {
+	var req struct {
+		Messages []ChatMessage   `json:"messages"`
+		Prompt   json.RawMessage `json:"prompt"`
+		System   string          `json:"system"`
+		Input    json.RawMessage `json:"input"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return 0, false
+	}
+
+	chars := 0
+	switch {
+	case strings.HasSuffix(path, "/api/chat"), strings.HasSuffix(path, "/v1/chat/completions"):
+		for _, message := range req.Messages {
+			chars += len(message.Content)
+		}
+	case strings.HasSuffix(path, "/api/generate"), strings.HasSuffix(path, "/v1/completions"):
+		chars = promptTextLength(req.Prompt) + len(req.System)
+	case strings.HasSuffix(path, "/api/embeddings"):
+		chars = promptTextLength(req.Prompt)
+	case strings.HasSuffix(path, "/api/embed"), strings.HasSuffix(path, "/v1/embeddings"):
+		chars = promptTextLength(req.Input)
+	default:
+		return 0, false
+	}
+	return (chars + 3) / 4, true
+}
+
+// promptTextLength returns the length of a prompt given as a string or a list of strings, counting
+// string contents in the raw JSON so large embed batches aren't decoded twice; token arrays count as empty
+func promptTextLength(raw json.RawMessage) int {
+	length := 0
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '"' {
+			continue
+		}
+		for i++; i < len(raw) && raw[i] != '"'; i++ {
+			if raw[i] == '\\' && i+1 < len(raw) {
+				if i++; raw[i] == 'u' {
+					i += 4
+				}
+			}
+			length++
+		}
+	}
+	return length
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestEstimateInputTokens tests prompt token estimates for each endpoint shape
+func TestEstimateInputTokens(t *testing.T) {
+	testCases := []struct {
+		name           string
+		path           string
+		body           string
+		expectedTokens int
+		expectedOK     bool
+	}{
+		{"Chat", "/api/chat", `{"model":"llama2","messages":[{"role":"system","content":"Be brief"},{"role":"user","content":"Hello there"}]}`, 5, true},
+		{"OpenAI Chat", "/v1/chat/completions", `{"model":"llama2","messages":[{"role":"user","content":"Hello there"}]}`, 3, true},
+		{"Generate With System", "/api/generate", `{"model":"llama2","prompt":"Write a haiku","system":"Poet"}`, 5, true},
+		{"Completion Prompt List", "/v1/completions", `{"model":"llama2","prompt":["Once upon","a time"]}`, 4, true},
+		{"Embed String", "/api/embed", `{"model":"nomic-embed","input":"sixteen chars ok"}`, 4, true},
+		{"Escapes Count Once", "/api/generate", `{"model":"llama2","prompt":"éé\n\"\\"}`, 2, true},
+		{"Embed List", "/api/embed", `{"model":"nomic-embed","input":["abcd","efgh"]}`, 2, true},
+		{"Embed Token Arrays", "/v1/embeddings", `{"model":"nomic-embed","input":[[1,2,3]]}`, 0, true},
+		{"Legacy Embeddings", "/api/embeddings", `{"model":"nomic-embed","prompt":"abcdefgh"}`, 2, true},
+		{"No Prompt", "/api/show", `{"model":"llama2"}`, 0, false},
+		{"Invalid JSON", "/api/chat", `{"messages":`, 0, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tokens, ok := estimateInputTokens(tc.path, []byte(tc.body))
+			if tokens != tc.expectedTokens || ok != tc.expectedOK {
+				t.Errorf("Expected %d, %v, got %d, %v", tc.expectedTokens, tc.expectedOK, tokens, ok)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerInputTokenEstimate tests that validation sees the estimate and metrics keep Ollama's count
+func TestProxyHandlerInputTokenEstimate(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+	useProxyTargets(t, ollamaServer.URL, "", metricsServer.URL)
+
+	var seen RequestDetails
+	useValidator(t, validatorFunc(func(ctx context.Context, details RequestDetails) (ValidationResult, error) {
+		seen = details
+		return ValidationResult{ValidationResponse: ValidationResponse{Valid: true}, Allowed: true}, nil
+	}))
+
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "Hello there, how are you?"}}}, "test-key"))
+	assertResponseStatus(t, rr, http.StatusOK)
+	if seen.InputTokenLength != 7 || !seen.InputTokenEstimated {
+		t.Errorf("Expected validation to see an estimate of 7 tokens, got %d (estimated %v)", seen.InputTokenLength, seen.InputTokenEstimated)
+	}
+	if metrics := waitForMetrics(t, received); metrics.InputTokenLength != 10 {
+		t.Errorf("Expected metrics to carry Ollama's 10 prompt tokens, got %d", metrics.InputTokenLength)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// keyExpiresInHeader tells clients how many seconds their key has left before it must be rotated
+const keyExpiresInHeader = "X-Key-Expires-In"
+
+// keyExpiresIn returns how long the key stays within the validation service's rotation limit,
+// reporting false when the key has no limit or its issue date is unknown
+func keyExpiresIn(validation ValidationResponse, now time.Time) (time.Duration, bool) {
+	if validation.MaxKeyAgeDays <= 0 || validation.KeyIssuedAt == nil {
+		return 0, false
+	}
+	expiresAt := validation.KeyIssuedAt.Add(time.Duration(validation.MaxKeyAgeDays) * 24 * time.Hour)
+	remaining := expiresAt.Sub(now)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
+// formatKeyExpiresIn renders the remaining key lifetime in whole seconds for keyExpiresInHeader
+func formatKeyExpiresIn(remaining time.Duration) string {
+	return strconv.FormatInt(int64(remaining/time.Second), 10)
+}
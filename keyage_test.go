@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestKeyExpiresIn tests the remaining key lifetime under a rotation limit
+func TestKeyExpiresIn(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	issued := func(daysAgo int) *time.Time {
+		at := now.Add(-time.Duration(daysAgo) * 24 * time.Hour)
+		return &at
+	}
+
+	testCases := []struct {
+		name       string
+		validation ValidationResponse
+		remaining  time.Duration
+		limited    bool
+	}{
+		{"No Limit", ValidationResponse{KeyIssuedAt: issued(400)}, 0, false},
+		{"Unknown Issue Date", ValidationResponse{MaxKeyAgeDays: 90}, 0, false},
+		{"Within Limit", ValidationResponse{MaxKeyAgeDays: 90, KeyIssuedAt: issued(80)}, 10 * 24 * time.Hour, true},
+		{"Expired", ValidationResponse{MaxKeyAgeDays: 90, KeyIssuedAt: issued(91)}, 0, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			remaining, limited := keyExpiresIn(tc.validation, now)
+			if remaining != tc.remaining || limited != tc.limited {
+				t.Errorf("Expected (%v, %v), got (%v, %v)", tc.remaining, tc.limited, remaining, limited)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerKeyAge tests that expired keys are refused and others told how long they have left
+func TestProxyHandlerKeyAge(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	useProxyTargets(t, ollamaServer.URL, "", "")
+
+	chat := map[string]interface{}{
+		"model":    "llama2",
+		"messages": []ChatMessage{{Role: "user", Content: "hi"}},
+		"stream":   false,
+	}
+
+	t.Run("Expired", func(t *testing.T) {
+		issuedAt := time.Now().Add(-31 * 24 * time.Hour)
+		validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, MaxKeyAgeDays: 30, KeyIssuedAt: &issuedAt})
+		defer validationServer.Close()
+		externalValidationURL = validationServer.URL
+
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", chat, "test-api-key"))
+		assertResponseStatus(t, rr, http.StatusUnauthorized)
+		if got := rr.Header().Get(keyExpiresInHeader); got != "0" {
+			t.Errorf("Expected %s: 0, got %q", keyExpiresInHeader, got)
+		}
+		var resp ErrorResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Expected a JSON error: %v", err)
+		}
+		if resp.Error != "API key expired, please rotate" || resp.Code != "key_expired" {
+			t.Errorf("Expected key_expired error, got %+v", resp)
+		}
+	})
+
+	t.Run("Within Limit", func(t *testing.T) {
+		issuedAt := time.Now().Add(-29 * 24 * time.Hour)
+		validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, MaxKeyAgeDays: 30, KeyIssuedAt: &issuedAt})
+		defer validationServer.Close()
+		externalValidationURL = validationServer.URL
+
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", chat, "test-api-key"))
+		assertResponseStatus(t, rr, http.StatusOK)
+		if got := rr.Header().Get(keyExpiresInHeader); got == "" || got == "0" {
+			t.Errorf("Expected a day of lifetime in %s, got %q", keyExpiresInHeader, got)
+		}
+	})
+}
@@ -3,6 +3,7 @@ package logger
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"time"
@@ -31,6 +32,11 @@ var (
 	defaultLogger = log.New(os.Stdout, "", 0)
 )
 
+// SetOutput redirects log output, which defaults to stdout
+func SetOutput(w io.Writer) {
+	defaultLogger.SetOutput(w)
+}
+
 // Log writes a structured log entry
 func Log(level LogLevel, message string, fields map[string]interface{}) {
 	entry := LogEntry{
@@ -2,8 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
@@ -11,17 +15,25 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"os/signal"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"ollama-proxy/logger"
+	"ollama-proxy/signing"
+	"ollama-proxy/version"
 )
 
 // Configuration variables
 var (
 	ollamaURL             string
+	ollamaHealthPath      string
 	externalValidationURL string
 	externalMetricsURL    string
 	apiKeyHeaderName      string
@@ -29,19 +41,50 @@ var (
 	reverseProxy          *httputil.ReverseProxy
 	proxyOnce             sync.Once
 
+	// Metrics configuration; disabling metrics runs the proxy in minimal mode
+	metricsEnabled          = true
+	metricsUseResponseModel bool
+
 	// Security configuration
-	externalServerAPIKey string
-	externalServerCert   string
-	skipTLSVerify        bool
+	externalServerAPIKey     string
+	externalServerHMACSecret string // signs requests to the validation and metrics services when set
+	externalServerCert       string
+	skipTLSVerify            bool
+
+	// Client timeouts; validation holds up the client request, so it fails fast
+	validationTimeout   = defaultValidationTimeout
+	metricsTimeout      = defaultMetricsTimeout
+	ollamaHealthTimeout = defaultOllamaHealthTimeout
+)
+
+const (
+	defaultValidationTimeout   = 2 * time.Second
+	defaultMetricsTimeout      = 10 * time.Second
+	defaultOllamaHealthTimeout = 5 * time.Second
+
+	// externalRequestTimeout bounds calls to external services without a timeout of their own
+	externalRequestTimeout = 10 * time.Second
 )
 
 type responseWriter struct {
 	http.ResponseWriter
-	body       *bytes.Buffer
-	statusCode int
+	body         *bytes.Buffer
+	statusCode   int
+	firstWriteAt time.Time
+	ndjson       bool
+	bytesWritten int64
+	chunks       chunkDigest
+	stallWarned  bool
+	onStall      func(gap time.Duration)
 }
 
 func main() {
+	replayFile := flag.String("replay", "", "Replay a captured NDJSON file against -replay-target instead of serving")
+	replayTarget := flag.String("replay-target", "http://localhost:11434", "Target URL for -replay")
+	replaySpeed := flag.Float64("replay-speed", 1, "Speed multiplier applied to captured timing offsets")
+	replayAPIKey := flag.String("replay-api-key", "", "API key sent with replayed requests")
+	flag.Parse()
+
 	// Load .env in development
 	if os.Getenv("GO_ENV") != "production" {
 		if err := godotenv.Load(); err != nil {
@@ -53,6 +96,58 @@ func main() {
 
 	// Load configuration from environment variables
 	loadConfig()
+	if err := validateUpstreamTLSConfig(); err != nil {
+		logger.Error("Invalid Ollama TLS configuration", err, nil)
+		os.Exit(1)
+	}
+	if err := validateValidationMockConfig(); err != nil {
+		logger.Error("Invalid validation configuration", err, nil)
+		os.Exit(1)
+	}
+	if err := validateValidationModeConfig(); err != nil {
+		logger.Error("Invalid validation configuration", err, nil)
+		os.Exit(1)
+	}
+	if err := loadMetricsEncryption(); err != nil {
+		logger.Error("Invalid metrics encryption configuration", err, nil)
+		os.Exit(1)
+	}
+	if err := validateEndpointPatterns(); err != nil {
+		logger.Error("Invalid endpoint configuration", err, nil)
+		os.Exit(1)
+	}
+
+	if *replayFile != "" {
+		err := runReplay(*replayFile, replayOptions{
+			TargetURL:    *replayTarget,
+			Speed:        *replaySpeed,
+			APIKey:       *replayAPIKey,
+			APIKeyHeader: apiKeyHeaderName,
+		})
+		if err != nil {
+			logger.Error("Replay failed", err, nil)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Compile request tagging rules
+	rules, err := compileTagRules(tagRulesConfig)
+	if err != nil {
+		logger.Error("Invalid tag rules", err, nil)
+		os.Exit(1)
+	}
+	tagRules = rules
+
+	// Load the model registry
+	if modelRegistryPath != "" {
+		registry, err := loadModelRegistry(modelRegistryPath)
+		if err != nil {
+			logger.Error("Failed to load model registry", err, nil)
+			os.Exit(1)
+		}
+		modelRegistry = registry
+	}
 
 	// Validate external services
 	if err := validateExternalServices(); err != nil {
@@ -60,47 +155,363 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Start replay capture if configured
+	if replayCapturePath != "" {
+		recorder, err := startReplayRecorder(replayCapturePath)
+		if err != nil {
+			logger.Error("Failed to start replay capture", err, nil)
+			os.Exit(1)
+		}
+		replayCapture = recorder
+		logger.Info("Replay capture enabled", map[string]interface{}{
+			"path":        replayCapturePath,
+			"sample_rate": replaySampleRate,
+		})
+	}
+
+	// Start token count verification if configured
+	if tokenVerifySampleRate > 0 {
+		tokenVerifier = newTokenCountVerifier(tokenVerifySampleRate, tokenVerifyTolerance, heuristicTokenizer{})
+		tokenVerifier.start()
+		logger.Info("Token count verification enabled", map[string]interface{}{
+			"sample_rate": tokenVerifySampleRate,
+			"tolerance":   tokenVerifyTolerance,
+		})
+	}
+
+	// Set up rate limiting
+	limiter = newRateLimiter()
+
+	// Start health checks for validation failover
+	startValidationHealthChecks(nil)
+
+	// Pick up rotated keys in local validation mode
+	startLocalKeyReloads(nil)
+
+	// Start unloading idle models
+	if modelIdleUnload > 0 {
+		janitor = newModelJanitor(modelIdleUnload)
+		janitor.start(nil)
+	}
+
+	// Start heap profiling under memory pressure
+	if memoryProfileThresholdMB > 0 && memoryProfileInterval > 0 {
+		newMemoryProfiler(memoryProfileDir, memoryProfileThresholdMB, memoryProfileMaxFiles).start(memoryProfileInterval, nil)
+	}
+
+	// Set up proxy-minted ephemeral tokens
+	tokenSigner = newTokenSigner()
+	if tokenSigner != nil {
+		ephemeralTokens.start(ephemeralTokenPruneInterval, nil)
+	}
+
 	// Set up HTTP server
-	http.HandleFunc("/", proxyHandler)
+	http.Handle(metricsPath, promhttp.Handler())
+	http.HandleFunc("/proxy/models", modelsHandler)
+	http.HandleFunc(discoveryPath, discoveryHandler)
+	http.HandleFunc("/admin/config/env-format", adminConfigEnvHandler)
+	http.HandleFunc("/admin/docs", adminDocsHandler)
+	http.HandleFunc("/admin/config/validation-url", adminValidationURLHandler)
+	http.HandleFunc("/admin/tokens", adminTokensHandler)
+	http.HandleFunc("/admin/tokens/", adminTokensHandler)
+	http.HandleFunc("/stats", statsHandler)
+	http.HandleFunc("/", formDecodeMiddleware(proxyHandler))
 
 	// Start server
 	logger.Info("Starting Ollama proxy server", map[string]interface{}{
-		"port": proxyPort,
+		"port":    proxyPort,
+		"version": version.String(),
 	})
-	if err := http.ListenAndServe(":"+proxyPort, nil); err != nil {
-		logger.Error("Failed to start server", err, nil)
-		os.Exit(1)
+	server := newServer(":" + proxyPort)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("Failed to start server", err, nil)
+			os.Exit(1)
+		}
+	}()
+
+	// Shut down gracefully, ending long streams with a notice before the grace period runs out
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	<-stop
+	logger.Info("Shutting down Ollama proxy server", map[string]interface{}{
+		"grace_period_secs": shutdownGracePeriod.Seconds(),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+	defer cancel()
+	streamShutdown.schedule(shutdownGracePeriod - shutdownStreamMargin)
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Error("Graceful shutdown did not complete", err, nil)
+	}
+	if err := waitForMetricsDeliveries(ctx); err != nil {
+		logger.Error("Metrics still being delivered at shutdown", err, nil)
+	}
+	if replayCapture != nil {
+		if err := replayCapture.Close(); err != nil {
+			logger.Error("Error closing replay capture", err, nil)
+		}
 	}
 }
 
 func loadConfig() {
 	ollamaURL = getEnvOrDefault("OLLAMA_URL", "http://localhost:11434")
+	ollamaHealthPath = getEnvOrDefault("OLLAMA_HEALTH_PATH", defaultOllamaHealthPath)
+	followOllamaRedirects = getEnvOrDefault("FOLLOW_OLLAMA_REDIRECTS", "false") == "true"
+	ollamaMaxRedirects = getEnvInt("OLLAMA_MAX_REDIRECTS", 3)
 	externalValidationURL = getEnvOrDefault("EXTERNAL_VALIDATION_URL", "http://external-server.com/validate")
+	externalValidationURLs = parseURLList(getEnvOrDefault("EXTERNAL_VALIDATION_URLS", ""))
+	if len(externalValidationURLs) > 0 {
+		externalValidationURL = externalValidationURLs[0]
+	}
+	validationHealthCheckInterval = time.Duration(getEnvInt("VALIDATION_HEALTH_CHECK_INTERVAL", 10)) * time.Second
+	validationMock = getEnvOrDefault("VALIDATION_MOCK", "false") == "true"
+	validationMockValid = getEnvOrDefault("VALIDATION_MOCK_VALID", "true") == "true"
+	validationMockRateLimited = getEnvOrDefault("VALIDATION_MOCK_RATE_LIMITED", "false") == "true"
+	validationMode = getEnvOrDefault("VALIDATION_MODE", "external")
+	apiKeysFile = getEnvOrDefault("API_KEYS_FILE", "")
+	apiKeysReloadInterval = getEnvDuration("API_KEYS_RELOAD_INTERVAL", 10*time.Second)
+	validationCacheTTL = getEnvDuration("VALIDATION_CACHE_TTL", 0)
+	validationCacheRateLimitedTTL = getEnvDuration("VALIDATION_CACHE_RATE_LIMITED_TTL", time.Second)
+	validationCacheSize = getEnvInt("VALIDATION_CACHE_SIZE", defaultValidationCacheSize)
+	validationDeduplicate = getEnvOrDefault("VALIDATION_DEDUPLICATE", "false") == "true"
+	validationRetryAttempts = getEnvInt("VALIDATION_RETRY_ATTEMPTS", defaultValidationRetryAttempts)
+	validationRetryBackoff = getEnvDuration("VALIDATION_RETRY_BACKOFF", defaultValidationRetryBackoff)
+	validationRetryBudget = getEnvDuration("VALIDATION_RETRY_BUDGET", defaultValidationRetryBudget)
+	validationTimeout = getEnvDuration("VALIDATION_TIMEOUT", defaultValidationTimeout)
+	metricsTimeout = getEnvDuration("METRICS_TIMEOUT", defaultMetricsTimeout)
+	ollamaHealthTimeout = getEnvDuration("OLLAMA_HEALTH_TIMEOUT", defaultOllamaHealthTimeout)
 	externalMetricsURL = getEnvOrDefault("EXTERNAL_METRICS_URL", "http://external-server.com/log_metrics")
+	metadataEnrichmentURL = getEnvOrDefault("METADATA_ENRICHMENT_URL", "")
+	metadataCacheTTL = getEnvDuration("METADATA_CACHE_TTL", 5*time.Minute)
 	apiKeyHeaderName = getEnvOrDefault("API_KEY_HEADER_NAME", "X-API-Key")
+	acceptBearerToken = getEnvOrDefault("ACCEPT_BEARER_TOKEN", "false") == "true"
+	stripBearerToken = getEnvOrDefault("STRIP_BEARER_TOKEN", "false") == "true"
+	metricsEnabled = getEnvOrDefault("METRICS_ENABLED", "true") != "false"
+	metricsUseResponseModel = getEnvOrDefault("METRICS_USE_RESPONSE_MODEL", "false") == "true"
+	metricsPath = getEnvOrDefault("METRICS_PATH", "/metrics")
+
+	// Load request ID configuration
+	requestIDHeader = getEnvOrDefault("REQUEST_ID_HEADER", defaultRequestIDHeader)
+
+	// Load request sanitization configuration
+	maxAPIKeyLength = getEnvInt("MAX_API_KEY_LENGTH", defaultMaxAPIKeyLength)
+	maxRequestValueLength = getEnvInt("MAX_REQUEST_VALUE_LENGTH", defaultMaxRequestValueLength)
+
+	// Load profiling configuration
+	profileLabels = getEnvOrDefault("PROFILE_LABELS", "false") == "true"
+
+	// Load metrics encryption configuration
+	metricsEncrypt = getEnvOrDefault("METRICS_ENCRYPT", "false") == "true"
+	metricsEncryptPublicKeyPEM = getEnvOrDefault("METRICS_ENCRYPT_PUBLIC_KEY", "")
+	connectionReuseWarnThreshold = getEnvFloat("CONNECTION_REUSE_WARN_THRESHOLD", 0)
 	proxyPort = getEnvOrDefault("PROXY_PORT", "8080")
+	writeTimeout = time.Duration(getEnvInt("WRITE_TIMEOUT", 30)) * time.Second
+	maxStreamDuration = getEnvDuration("MAX_STREAM_DURATION", time.Hour)
+	modelLoadTimeouts = nil
+	if raw := getEnvOrDefault("MODEL_LOAD_TIMEOUT", ""); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &modelLoadTimeouts); err != nil {
+			logger.Error("Ignoring invalid MODEL_LOAD_TIMEOUT", err, nil)
+			modelLoadTimeouts = nil
+		}
+	}
+	serverIdleTimeout = getEnvDuration("SERVER_IDLE_TIMEOUT", 2*time.Minute)
+	serverReadTimeout = getEnvDuration("SERVER_READ_TIMEOUT", 0)
+	serverWriteTimeout = getEnvDuration("SERVER_WRITE_TIMEOUT", writeTimeout)
+	serverReadHeaderTimeout = getEnvDuration("SERVER_READ_HEADER_TIMEOUT", 10*time.Second)
+	shutdownGracePeriod = getEnvDuration("SHUTDOWN_GRACE_PERIOD", 30*time.Second)
+	shutdownStreamMargin = getEnvDuration("SHUTDOWN_STREAM_MARGIN", 5*time.Second)
 
 	// Load security configuration
 	externalServerAPIKey = getEnvOrDefault("EXTERNAL_SERVER_API_KEY", "")
+	externalServerHMACSecret = getEnvOrDefault("EXTERNAL_SERVER_HMAC_SECRET", "")
 	externalServerCert = getEnvOrDefault("EXTERNAL_SERVER_CERT", "")
 	skipTLSVerify = getEnvOrDefault("SKIP_TLS_VERIFY", "false") == "true"
+	trustProxyHeaders = getEnvOrDefault("TRUST_PROXY_HEADERS", "false") == "true"
+
+	// Load Ollama TLS configuration
+	ollamaTLSCAFile = getEnvOrDefault("OLLAMA_TLS_CA_FILE", "")
+	ollamaTLSCertFile = getEnvOrDefault("OLLAMA_TLS_CERT_FILE", "")
+	ollamaTLSKeyFile = getEnvOrDefault("OLLAMA_TLS_KEY_FILE", "")
+	ollamaTLSServerName = getEnvOrDefault("OLLAMA_TLS_SERVER_NAME", "")
+	ollamaTLSInsecure = getEnvOrDefault("OLLAMA_TLS_INSECURE", "false") == "true"
+
+	// Load replay capture configuration
+	replayCapturePath = getEnvOrDefault("REPLAY_CAPTURE_PATH", "")
+	replaySampleRate = getEnvFloat("REPLAY_SAMPLE_RATE", 1)
+	replayAllowRawPrompts = getEnvOrDefault("REPLAY_ALLOW_RAW_PROMPTS", "false") == "true"
+
+	// Load token count verification configuration
+	tokenVerifySampleRate = getEnvFloat("TOKEN_VERIFY_SAMPLE_RATE", 0)
+	tokenVerifyTolerance = getEnvFloat("TOKEN_VERIFY_TOLERANCE", 0.25)
+	tokenVerifyMaxText = getEnvInt("TOKEN_VERIFY_MAX_TEXT", 65536)
+
+	// Load batch validation configuration
+	externalValidationType = getEnvOrDefault("EXTERNAL_VALIDATION_TYPE", "single")
+	validationBatchSize = getEnvInt("VALIDATION_BATCH_SIZE", 1)
+	validationBatchWait = getEnvDuration("VALIDATION_BATCH_WAIT", 10*time.Millisecond)
+
+	// Load rate limiting configuration
+	rateLimit = getEnvFloat("RATE_LIMIT", 0)
+	rateLimitBurst = getEnvInt("RATE_LIMIT_BURST", 0)
+	rateLimitBackend = getEnvOrDefault("RATE_LIMIT_BACKEND", "local")
+	rateLimitFailMode = getEnvOrDefault("RATE_LIMIT_FAIL_MODE", "local")
+	redisAddr = getEnvOrDefault("REDIS_ADDR", "localhost:6379")
+	redisTimeout = getEnvDuration("REDIS_TIMEOUT", 50*time.Millisecond)
+
+	// Load model list configuration
+	publicModelList = getEnvOrDefault("PUBLIC_MODEL_LIST", "false") == "true"
+	modelCapabilities = nil
+	if raw := getEnvOrDefault("MODEL_CAPABILITIES", ""); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &modelCapabilities); err != nil {
+			logger.Error("Ignoring invalid MODEL_CAPABILITIES", err, nil)
+			modelCapabilities = nil
+		}
+	}
+
+	// Load model registry configuration
+	modelRegistryPath = getEnvOrDefault("MODEL_REGISTRY", "")
+	modelVersionPins = nil
+	if raw := getEnvOrDefault("MODEL_VERSION_PINS", ""); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &modelVersionPins); err != nil {
+			logger.Error("Ignoring invalid MODEL_VERSION_PINS", err, nil)
+			modelVersionPins = nil
+		}
+	}
+
+	// Load model fallback configuration
+	modelFallbacks = nil
+	if raw := getEnvOrDefault("MODEL_FALLBACKS", ""); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &modelFallbacks); err != nil {
+			logger.Error("Ignoring invalid MODEL_FALLBACKS", err, nil)
+			modelFallbacks = nil
+		}
+	}
+	modelFallbackMaxHops = getEnvInt("MODEL_FALLBACK_MAX_HOPS", 1)
+
+	// Load discovery document configuration
+	discoveryRequireKey = getEnvOrDefault("DISCOVERY_REQUIRE_KEY", "false") == "true"
+
+	// Load idle model unloading configuration
+	modelIdleUnload = getEnvDuration("MODEL_IDLE_UNLOAD", 0)
+	protectedModels = parseKeyList(getEnvOrDefault("PROTECTED_MODELS", ""))
+
+	// Load ephemeral token configuration
+	adminAPIKey = getEnvOrDefault("ADMIN_API_KEY", "")
+	ephemeralTokenSecret = getEnvOrDefault("EPHEMERAL_TOKEN_SECRET", "")
+	ephemeralTokenTTL = getEnvDuration("EPHEMERAL_TOKEN_TTL", time.Hour)
+	ephemeralTokenMaxTTL = max(getEnvDuration("EPHEMERAL_TOKEN_MAX_TTL", 24*time.Hour), ephemeralTokenTTL)
+
+	// Load zero-retention configuration
+	zeroRetentionKeys = parseKeyList(getEnvOrDefault("ZERO_RETENTION_KEYS", ""))
+
+	// Load stream handling configuration
+	appendDoneChunk = getEnvOrDefault("STREAM_APPEND_DONE_CHUNK", "false") == "true"
+	maxStreamingConnsPerKey = getEnvInt("MAX_STREAMING_CONNS_PER_KEY", 0)
+	streamStallThreshold = getEnvDuration("STREAM_STALL_THRESHOLD", 10*time.Second)
+	streamHeartbeat = getEnvOrDefault("STREAM_HEARTBEAT", "false") == "true"
+	streamHeartbeatInterval = getEnvDuration("STREAM_HEARTBEAT_INTERVAL", 5*time.Second)
+
+	// Load embed batch splitting configuration
+	embedMaxBatch = getEnvInt("EMBED_MAX_BATCH", 0)
+	embedSplitParallelism = getEnvInt("EMBED_SPLIT_PARALLELISM", 4)
+
+	// Load memory profiling configuration
+	memoryProfileInterval = time.Duration(getEnvInt("MEMORY_PROFILE_INTERVAL", 30)) * time.Second
+	memoryProfileThresholdMB = getEnvInt("MEMORY_PROFILE_THRESHOLD_MB", 0)
+	memoryProfileDir = getEnvOrDefault("MEMORY_PROFILE_DIR", "profiles")
+	memoryProfileMaxFiles = getEnvInt("MEMORY_PROFILE_MAX_FILES", 10)
+	allocProfilingThresholdBytes = int64(getEnvInt("ALLOC_PROFILING_THRESHOLD_BYTES", 0))
+
+	// Load path prefix and routing configuration
+	pathPrefix = normalizePathPrefix(getEnvOrDefault("PATH_PREFIX", ""))
+	strictRouting = getEnvOrDefault("STRICT_ROUTING", "false") == "true"
+
+	// Load public endpoint configuration
+	publicEndpoints = parseKeyList(getEnvOrDefault("PUBLIC_ENDPOINTS", ""))
+	validationSkipPaths = parseURLList(getEnvOrDefault("VALIDATION_SKIP_PATHS", ""))
+	protectedEndpoints = parseEndpointList(getEnvOrDefault("PROTECTED_ENDPOINTS", defaultProtectedEndpoints))
+	allowedEndpoints = parseURLList(getEnvOrDefault("ALLOWED_ENDPOINTS", ""))
+	deniedEndpoints = parseURLList(getEnvOrDefault("DENIED_ENDPOINTS", ""))
+
+	// Load request tagging configuration
+	tagRulesConfig = getEnvOrDefault("TAG_RULES", "")
+
+	// Load response preview configuration
+	logResponsePreviewBytes = getEnvInt("LOG_RESPONSE_PREVIEW_BYTES", 0)
+
+	// Load request rewrite configuration
+	forceNonStreaming = getEnvOrDefault("FORCE_NON_STREAMING", "false") == "true"
+	allowHeaderOptions = getEnvOrDefault("ALLOW_HEADER_OPTIONS", "false") == "true"
+	defaultThink = nil
+	if raw := getEnvOrDefault("DEFAULT_THINK", ""); raw != "" {
+		if think, err := strconv.ParseBool(raw); err == nil {
+			defaultThink = &think
+		} else {
+			logger.Error("Ignoring invalid DEFAULT_THINK", err, nil)
+		}
+	}
+	injectGPUOptions = nil
+	if raw := getEnvOrDefault("INJECT_GPU_OPTIONS", ""); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &injectGPUOptions); err != nil {
+			logger.Error("Ignoring invalid INJECT_GPU_OPTIONS", err, nil)
+			injectGPUOptions = nil
+		}
+	}
 }
 
 func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+	value := os.Getenv(key)
+	if value == "" {
+		value = defaultValue
 	}
-	return defaultValue
+	recordConfig(key, value)
+	return value
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(getEnvOrDefault(key, ""))
+	if err != nil {
+		value = defaultValue
+	}
+	recordConfig(key, strconv.Itoa(value))
+	return value
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(getEnvOrDefault(key, ""))
+	if err != nil {
+		value = defaultValue
+	}
+	recordConfig(key, value.String())
+	return value
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(getEnvOrDefault(key, ""), 64)
+	if err != nil {
+		value = defaultValue
+	}
+	recordConfig(key, strconv.FormatFloat(value, 'g', -1, 64))
+	return value
 }
 
 func getReverseProxy() *httputil.ReverseProxy {
 	proxyOnce.Do(func() {
-		targetURL, err := url.Parse(ollamaURL)
+		targetURL, err := url.Parse(currentOllamaURL())
 		if err != nil {
 			log.Fatalf("Failed to parse Ollama URL: %v", err)
 		}
+		tlsConfig, err := upstreamTLSConfig()
+		if err != nil {
+			log.Fatalf("Failed to load Ollama TLS configuration: %v", err)
+		}
 
+		transport := newUpstreamTransport(upstreamConns, tlsConfig)
+		if followOllamaRedirects {
+			transport = newRedirectTransport(transport)
+		}
 		reverseProxy = &httputil.ReverseProxy{
+			Transport: transport,
 			Director: func(req *http.Request) {
 				req.URL.Scheme = targetURL.Scheme
 				req.URL.Host = targetURL.Host
@@ -130,126 +541,829 @@ func singleJoiningSlash(a, b string) string {
 
 func proxyHandler(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
+	allocs := newAllocProbe()
+	// Pass the client's request ID through, or assign one, so Ollama, the client and the logs share it
+	requestID := requestIDFor(r)
+	r.Header.Set(requestIDHeader, requestID)
+	w.Header().Set(requestIDHeader, requestID)
+	r.Header.Set(version.Header, version.String())
+	w.Header().Set(version.Header, version.String())
+
+	// Clean the path once, so routing, access checks, model extraction and Ollama all see the same
+	// endpoint whatever trailing slashes or doubled separators the client sent
+	if cleaned := path.Clean(r.URL.Path); cleaned != r.URL.Path {
+		r.URL.Path = cleaned
+		r.URL.RawPath = ""
+	}
+
+	// Drop the prefix the proxy is served under, so the handler and Ollama only see API paths
+	if pathPrefix != "" {
+		stripped, ok := stripPathPrefix(r.URL.Path)
+		if !ok {
+			logger.Warning("Not found: Path outside PATH_PREFIX", map[string]interface{}{
+				"request_id": requestID,
+				"endpoint":   capRequestValue(r.URL.Path),
+			})
+			http.NotFound(w, r)
+			return
+		}
+		r.URL.Path = stripped
+		if rawPath, ok := stripPathPrefix(r.URL.RawPath); ok {
+			r.URL.RawPath = rawPath
+		} else {
+			r.URL.RawPath = ""
+		}
+	}
+
 	fields := map[string]interface{}{
-		"user_agent": r.Header.Get("User-Agent"),
-		"endpoint":   r.URL.Path,
+		"request_id": requestID,
+		"user_agent": capRequestValue(r.Header.Get("User-Agent")),
+		"endpoint":   capRequestValue(r.URL.Path),
+	}
+
+	// Unknown paths never reach the validation service or Ollama in strict mode
+	if !endpointRouted(r.URL.Path) {
+		logger.Warning("Not found: Unknown endpoint", fields)
+		writeProxyError(w, r, http.StatusNotFound, "endpoint_not_found", fmt.Sprintf("Not found: %s is not an Ollama API endpoint", capRequestValue(r.URL.Path)))
+		return
 	}
 
-	// Extract API key
-	apiKey := r.Header.Get(apiKeyHeaderName)
-	if apiKey == "" {
+	// Extract API key; endpoints the operator made public may be called without one
+	apiKey, bearer := apiKeyFromRequest(r)
+	if bearer && stripBearerToken {
+		// Ollama never needs the tenant's credentials
+		r.Header.Del("Authorization")
+	}
+	public := apiKey == "" && publicEndpoints[r.URL.Path]
+	if apiKey == "" && !public {
 		logger.Warning("Unauthorized: Missing API key", fields)
-		http.Error(w, "Unauthorized: Missing API key", http.StatusUnauthorized)
+		writeProxyError(w, r, http.StatusUnauthorized, "missing_api_key", "Unauthorized: Missing API key")
+		return
+	}
+	if !validAPIKeyFormat(apiKey) {
+		// The key itself is never logged, since it could be megabytes of garbage
+		fields["api_key_length"] = len(apiKey)
+		logger.Warning("Unauthorized: Invalid API key format", fields)
+		writeProxyError(w, r, http.StatusUnauthorized, "invalid_key_format", "Unauthorized: Invalid API key format")
 		return
 	}
 	fields["api_key"] = apiKey
 
+	// Reject endpoints the operator doesn't expose, whatever the validation service would say
+	if !endpointExposed(r.URL.Path) {
+		logger.Warning("Forbidden: Endpoint not exposed", fields)
+		writeProxyError(w, r, http.StatusForbidden, "endpoint_not_exposed", fmt.Sprintf("Forbidden: Endpoint %s is not exposed by this proxy", capRequestValue(r.URL.Path)))
+		return
+	}
+	class := classifyEndpoint(r.URL.Path)
+
 	// Extract request details
 	details := RequestDetails{
 		APIKey:    apiKey,
-		IPAddress: r.RemoteAddr,
+		IPAddress: clientAddress(r),
 		UserAgent: r.Header.Get("User-Agent"),
-		Headers:   make(map[string]string),
+		Headers:   requestHeaders(r.Header),
 		Endpoint:  r.URL.Path,
 	}
 
-	// Copy headers
-	for k, v := range r.Header {
-		details.Headers[k] = v[0]
+	// Parse request body to get model and estimate token length; read-only endpoints have neither,
+	// and blob uploads stream through to Ollama without being held in memory
+	var bodyBytes []byte
+	if class.readsBody() {
+		var err error
+		bodyBytes, err = io.ReadAll(r.Body)
+		if err != nil {
+			logger.Error("Error reading request body", err, fields)
+			writeProxyError(w, r, http.StatusBadRequest, "invalid_request_body", "Error reading request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+		allowBodyReplay(r, bodyBytes)
+		// Prompts are only fingerprinted once the retention policy is known to allow it
+		details.BodySHA256, details.BodyBytes = retentionPolicyBeforeValidation(apiKey).Fingerprint(bodyBytes)
+
+		// Get model from request based on endpoint
+		details.Model = getModelFromRequest(r.URL.Path, bodyBytes)
+		details.DestinationModel = getCopyDestination(r.URL.Path, bodyBytes)
+		details.InputTokenLength, details.InputTokenEstimated = estimateInputTokens(r.URL.Path, bodyBytes)
+	}
+
+	// Resolve short model names to full Ollama references
+	full, aliased := resolveModelAlias(details.Model)
+	if aliased {
+		bodyBytes = rewriteModelName(r, bodyBytes, details.Model, full)
+		fields["model_alias"] = details.Model
+		details.Model = full
 	}
 
-	// Parse request body to get model and estimate token length
-	bodyBytes, err := io.ReadAll(r.Body)
-	if err != nil {
-		logger.Error("Error reading request body", err, fields)
-		http.Error(w, "Error reading request body", http.StatusBadRequest)
-		return
+	// Substitute pinned model versions so Ollama updates don't change behavior underneath clients
+	if pinned, ok := pinnedModelVersion(r.URL.Path, details.Model); ok {
+		bodyBytes = rewriteModelName(r, bodyBytes, details.Model, pinned)
+		logger.Info("Model version pinned", map[string]interface{}{
+			"api_key":      apiKey,
+			"endpoint":     r.URL.Path,
+			"model":        details.Model,
+			"pinned_model": pinned,
+		})
+		fields["model_pinned_from"] = details.Model
+		details.Model = pinned
 	}
-	r.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
 
-	// Get model from request based on endpoint
-	details.Model = getModelFromRequest(r.URL.Path, bodyBytes)
+	// Cap client-supplied values before they reach logs, validation or metrics
+	sanitizeRequestDetails(&details)
 	fields["model"] = details.Model
+	if details.DestinationModel != "" {
+		fields["destination_model"] = details.DestinationModel
+	}
+
+	// Attribute profiles to the endpoint and model when enabled
+	r, restoreLabels := withProfileLabels(r, details.Model)
+	defer restoreLabels()
+
+	// Validate request, checking proxy-minted tokens locally instead of calling the validator and
+	// skipping the call on VALIDATION_SKIP_PATHS
+	keySource := "external"
+	var validation ValidationResponse
+	var claims EphemeralClaims
+	var ok bool
+	if public {
+		keySource = "public"
+		fields["key_source"] = keySource
+	} else if isEphemeralToken(apiKey) {
+		keySource = "ephemeral"
+		fields["key_source"] = keySource
+		var err error
+		claims, err = authorizeEphemeralToken(apiKey, details.Model)
+		fields["token_id"] = claims.ID
+		if err != nil {
+			status, message, code := tokenStatus(err)
+			logger.Warning(message, fields)
+			writeProxyError(w, r, status, code, message)
+			return
+		}
+		validation.Tier = claims.Tier
+		if len(claims.Models) > 0 {
+			validation.AllowedModels = claims.Models
+		}
+	} else if validationSkipped(r.URL.Path) {
+		// Trust the key on cheap read-only paths; metrics carry the key source so usage isn't taken as validated
+		keySource = "unvalidated"
+		fields["key_source"] = keySource
+	} else {
+		var cached bool
+		var err error
+		validation, ok, cached, err = validateRequestCached(withRequestID(r.Context(), requestID), details)
+		if err != nil {
+			// An outage isn't the key's fault, so clients are told to retry rather than that it's invalid
+			logger.Error("Validation service unavailable", err, fields)
+			writeProxyError(w, r, http.StatusServiceUnavailable, "validation_unavailable", "Service Unavailable: Request could not be validated")
+			return
+		}
+		if cached {
+			fields["validation_cached"] = true
+		}
+		setRateLimitHeaders(w.Header(), validation)
+		if ok {
+			retentionAnswers.remember(apiKey, validation.ZeroRetention)
+		}
+		if !ok {
+			status, code, message := validationRejection(validation, details.Model)
+			logger.Warning(message, fields)
+			writeProxyError(w, r, status, code, message)
+			return
+		}
+	}
+	// Refuse keys older than the validation service's rotation limit, telling clients how long the rest have
+	if remaining, limited := keyExpiresIn(validation, startTime); limited {
+		w.Header().Set(keyExpiresInHeader, formatKeyExpiresIn(remaining))
+		if remaining <= 0 {
+			fields["key_issued_at"] = validation.KeyIssuedAt.Format(time.RFC3339)
+			fields["max_key_age_days"] = validation.MaxKeyAgeDays
+			logger.Warning("Unauthorized: API key expired", fields)
+			writeProxyError(w, r, http.StatusUnauthorized, "key_expired", "API key expired, please rotate")
+			return
+		}
+	}
+	if !endpointAllowed(validation.AllowedEndpoints, r.URL.Path) {
+		logger.Warning("Forbidden: Endpoint not allowed for key", fields)
+		writeProxyError(w, r, http.StatusForbidden, "endpoint_not_allowed", "Forbidden: Endpoint not allowed for key")
+		return
+	}
 
-	// Validate request
-	if !validateRequest(details) {
-		logger.Warning("Unauthorized: Invalid request", fields)
-		http.Error(w, "Unauthorized: Invalid request", http.StatusUnauthorized)
+	// Keys limited to a model list may only name those models, including as a copy's destination, and
+	// must name one where the endpoint takes one, since an unreadable model can't be checked
+	if validation.AllowedModels != nil && details.Model == "" && requestNamesModel(r.URL.Path) {
+		logger.Warning("Bad Request: Model not named", fields)
+		writeProxyError(w, r, http.StatusBadRequest, "model_required", "Bad Request: Request must name a model")
 		return
 	}
+	if validation.AllowedModels != nil {
+		for _, model := range []string{details.Model, details.DestinationModel} {
+			if model != "" && !modelAllowed(validation.AllowedModels, model) {
+				logger.Warning("Forbidden: Model not allowed for key", fields)
+				writeProxyError(w, r, http.StatusForbidden, "model_not_allowed", fmt.Sprintf("Forbidden: Model %s not allowed for key", model))
+				return
+			}
+		}
+	}
+
+	// Keys pinned to IP ranges may only be used from them
+	if validation.AllowedCIDRs != nil && !clientIPAllowed(validation.AllowedCIDRs, r, fields) {
+		logger.Warning("Forbidden: Client IP not allowed for key", fields)
+		writeProxyError(w, r, http.StatusForbidden, "ip_not_allowed", "Forbidden: Client IP not allowed for key")
+		return
+	}
+
+	if endpointRequiresScope(validation.Scopes, r.URL.Path) {
+		logger.Warning("Forbidden: Endpoint requires admin scope", fields)
+		writeProxyError(w, r, http.StatusForbidden, "admin_scope_required", "Forbidden: Endpoint requires admin scope")
+		return
+	}
+
+	// Count the request against its token's quota only once nothing above has refused it
+	if keySource == "ephemeral" {
+		if err := ephemeralTokens.consume(claims); err != nil {
+			status, message, code := tokenStatus(err)
+			logger.Warning(message, fields)
+			writeProxyError(w, r, status, code, message)
+			return
+		}
+	}
+
+	// Every content-touching feature below consults the retention policy
+	policy := retentionPolicyFor(apiKey, validation)
+	if policy.ZeroRetention() {
+		fields["zero_retention"] = true
+	}
+
+	// Tag the request from operator rules, so every later dimension can carry the tags
+	tags := evaluateTagRules(tagRules, requestAttributes{
+		Endpoint:  r.URL.Path,
+		Model:     details.Model,
+		BodySize:  len(bodyBytes),
+		Stream:    requestStreams(r.URL.Path, bodyBytes),
+		UserAgent: r.Header.Get("User-Agent"),
+		KeyTier:   validation.Tier,
+	})
+	if len(tags) > 0 {
+		r = r.WithContext(withRequestTags(r.Context(), tags))
+		fields["tags"] = tags
+	}
+
+	// Enforce per-key rate limits, and per-client ones on public endpoints
+	if limiter != nil && !limiter.Allow(r.Context(), rateLimitKey(r, apiKey)) {
+		logger.Warning("Too Many Requests: Rate limit exceeded", policy.LogFields(fields))
+		writeProxyError(w, r, http.StatusTooManyRequests, "rate_limit_exceeded", "Too Many Requests: Rate limit exceeded")
+		return
+	}
+
+	// Apply configured body rewrites before forwarding
+	bodyBytes = applyRequestRewrites(r, bodyBytes)
+
+	// Merge operator option overrides from headers, which win over client values
+	if allowHeaderOptions {
+		overrides, err := getHeaderOptions(r)
+		if err != nil {
+			logger.Warning("Bad Request: Invalid option header", policy.LogFields(fields))
+			writeProxyError(w, r, http.StatusBadRequest, "invalid_option_header", "Bad Request: "+err.Error())
+			return
+		}
+		bodyBytes = applyHeaderOptions(r, bodyBytes, overrides)
+	}
+
+	// Hold the output length to the key's cap, after header overrides so they can't lift it
+	if validation.MaxOutputTokens > 0 {
+		var exceeded bool
+		bodyBytes, exceeded = clampMaxOutputTokens(r, bodyBytes, validation.MaxOutputTokens)
+		if exceeded {
+			w.Header().Set(clampedMaxTokensHeader, strconv.Itoa(validation.MaxOutputTokens))
+			fields["clamped_max_tokens"] = validation.MaxOutputTokens
+		}
+	}
+	if think := getThinkFromRequest(r.URL.Path, bodyBytes); think != nil {
+		fields["think"] = *think
+	}
+	quantize := getQuantizeFromRequest(r.URL.Path, bodyBytes)
+	if quantize != "" {
+		fields["quantize"] = quantize
+	}
+	embedInputCount := getEmbedInputCountFromRequest(r.URL.Path, bodyBytes)
+	if embedInputCount > 0 {
+		fields["embed_input_count"] = embedInputCount
+	}
+
+	// Cap concurrent streaming responses per key, since each holds resources until it finishes
+	if requestStreams(r.URL.Path, bodyBytes) {
+		release, ok := acquireStreamingConn(apiKey)
+		if !ok {
+			logger.Warning("Too Many Requests: Streaming connection limit exceeded", policy.LogFields(fields))
+			writeProxyError(w, r, http.StatusTooManyRequests, "streaming_limit_exceeded", "Too Many Requests: Streaming connection limit exceeded")
+			return
+		}
+		defer release()
+	}
+
+	// Track model activity so idle models can be unloaded, but never while in use
+	if janitor != nil && details.Model != "" && class == endpointInference {
+		defer janitor.begin(details.Model)()
+	}
+
+	// Sample the request into the replay file; blob uploads can't be replayed without their contents
+	if replayCapture != nil && policy.AllowReplayCapture() && class != endpointBlob {
+		replayCapture.Capture(r, apiKey, details.Model, bodyBytes, startTime)
+	}
+
+	// Streaming responses extend the write deadline as chunks arrive; convert them to Server-Sent
+	// Events when the client asks for them, and keep the connection alive with heartbeats while a
+	// cold model loads
+	var sse *sseWriter
+	clientWriter := w
+	streams := requestStreams(r.URL.Path, bodyBytes)
+	if streams {
+		clientWriter = newDeadlineWriter(clientWriter, startTime, modelLoadTimeout(details.Model))
+	}
+	useSSE := wantsSSE(r) && streams
+	if streamHeartbeat && streams && streamHeartbeatInterval > 0 {
+		heartbeat := newHeartbeatWriter(r.Context(), clientWriter, useSSE, streamHeartbeatInterval)
+		defer heartbeat.stop()
+		clientWriter = heartbeat
+	}
+	if useSSE {
+		sse = newSSEWriter(clientWriter)
+		clientWriter = sse
+	}
+
+	// Synthesize the OpenAI usage chunk when Ollama would silently ignore stream_options.include_usage
+	var usage *streamUsageWriter
+	if wantsStreamUsage(r.URL.Path, bodyBytes) && !ollamaHonorsIncludeUsage() {
+		bodyBytes = stripStreamOptions(r, bodyBytes)
+		usage = newStreamUsageWriter(clientWriter, estimatePromptTokens(bodyBytes))
+		clientWriter = usage
+	}
+
+	// Point clients at the registered aliases when Ollama doesn't know an unregistered name either
+	var aliasErrors *aliasErrorWriter
+	if len(modelRegistry) > 0 && !aliased && details.Model != "" {
+		aliasErrors = newAliasErrorWriter(clientWriter)
+		clientWriter = aliasErrors
+	}
 
-	// Create response writer to capture the response
+	// Hide models the key may not use from the installed and loaded model lists
+	var modelFilter *modelListFilterWriter
+	if validation.AllowedModels != nil && listsModels(r.URL.Path) {
+		// The list is rewritten, so ask Ollama for an uncompressed body
+		r.Header.Del("Accept-Encoding")
+		modelFilter = newModelListFilterWriter(clientWriter, r.URL.Path, validation.AllowedModels)
+		clientWriter = modelFilter
+	}
+
+	// Create response writer to capture the response; model transfers stream through uncaptured,
+	// and nothing is captured in minimal mode unless stream termination or previews need to inspect it
+	captured := class.capturesResponse() && (metricsEnabled || appendDoneChunk || logResponsePreviewBytes > 0 || tokenVerifier != nil)
 	responseWriter := &responseWriter{
-		ResponseWriter: w,
-		body:           &bytes.Buffer{},
+		ResponseWriter: clientWriter,
+	}
+	if captured {
+		responseWriter.body = &bytes.Buffer{}
+	}
+	responseWriter.onStall = func(gap time.Duration) {
+		logger.Warning("Stream stalled", policy.LogFields(map[string]interface{}{
+			"api_key":  apiKey,
+			"endpoint": r.URL.Path,
+			"model":    details.Model,
+			"stall_ms": gap.Milliseconds(),
+			"chunk":    responseWriter.chunks.chunks,
+		}))
 	}
 
-	// Proxy the request
+	// Proxy the request; streams still running at the shutdown cutoff are ended early
 	proxy := getReverseProxy()
-	proxy.ServeHTTP(responseWriter, r)
+	proxyReq := r
+	var cutoff *streamCutoff
+	if streams {
+		proxyReq, cutoff = watchStreamCutoff(r)
+		defer cutoff.stop()
+	}
+	var aborted bool
+	if split := splitEmbedRequest(r.URL.Path, bodyBytes); split != nil {
+		fields["embed_sub_batches"] = len(split.batches)
+		aborted = serveSplitEmbed(responseWriter, proxyReq, split)
+	} else if len(modelFallbacks) > 0 && details.Model != "" {
+		var served string
+		aborted, served, bodyBytes = serveWithFallbacks(proxy, responseWriter, proxyReq, bodyBytes, details.Model, apiKey, validation.AllowedModels)
+		if served != details.Model {
+			fields["model_fallback_from"] = details.Model
+			fields["model"] = served
+			details.Model = served
+		}
+	} else {
+		aborted = serveProxy(proxy, responseWriter, proxyReq)
+	}
+	shutdownCut := aborted && cutoff != nil && cutoff.fired()
+	if usage != nil {
+		usage.finish()
+	}
+	if aliasErrors != nil {
+		aliasErrors.finish()
+	}
+	if modelFilter != nil {
+		modelFilter.finish()
+	}
+	if sse != nil && shutdownCut {
+		sse.shutdown()
+	} else if sse != nil {
+		sse.finish(aborted)
+	}
+	clientAborted := aborted && !shutdownCut && clientGone(r)
+
+	// Ollama reports failures inside a stream after the 200 status has been sent
+	var upstreamError string
+	var summary streamSummary
+	if responseWriter.ndjson && captured {
+		summary = summarizeStream(responseWriter.captured())
+		upstreamError = summary.Error
+		if appendDoneChunk && sse == nil && !clientAborted && !shutdownCut && !summary.SawDone {
+			model := summary.Model
+			if model == "" {
+				model = details.Model
+			}
+			clientWriter.Write(terminalDoneChunk(r.URL.Path, model, "error"))
+			responseWriter.Flush()
+		}
+	}
+
+	// Tell the client the proxy ended the stream, instead of resetting the connection
+	if shutdownCut && sse == nil {
+		model := summary.Model
+		if model == "" {
+			model = details.Model
+		}
+		if notice := shutdownNotice(r.URL.Path, model, responseWriter.Header()); notice != nil {
+			clientWriter.Write(notice)
+			responseWriter.Flush()
+		}
+	}
 
 	// Calculate metrics
 	duration := time.Since(startTime)
 
-	// Get token counts from Ollama response
-	inputTokens, outputTokens := getTokenCountsFromResponse(r.URL.Path, responseWriter.body.Bytes())
-	fields["input_tokens"] = inputTokens
-	fields["output_tokens"] = outputTokens
+	// Get token counts from Ollama response, when it was captured
+	var inputTokens, outputTokens int
+	var tokenSource, doneReason, createStatus string
+	var toolCallCount, embeddingCount, embeddingDim int
+	metricsModel := details.Model
+	if captured {
+		inputTokens, outputTokens = getTokenCountsFromResponse(r.URL.Path, responseWriter.captured())
+		tokenSource = tokenSourceOllama
+		if responseWriter.ndjson && !summary.SawDone {
+			// Only the done chunk carries exact counts, so estimate them from the chunks that arrived
+			inputTokens, outputTokens = summary.estimatedTokens()
+			tokenSource = tokenSourceEstimated
+		}
+		if strings.HasSuffix(r.URL.Path, "/api/embeddings") && inputTokens == 0 {
+			inputTokens = estimateEmbeddingsTokens(bodyBytes)
+			tokenSource = tokenSourceEstimated
+		}
+		if inputTokens == 0 && outputTokens == 0 && (strings.HasSuffix(r.URL.Path, "/v1/chat/completions") || strings.HasSuffix(r.URL.Path, "/v1/completions")) {
+			// Streams only carry usage when the client asked for it, so count the chunks instead
+			if in, out, ok := estimateSSETokens(r.URL.Path, bodyBytes, responseWriter.captured()); ok {
+				inputTokens, outputTokens = in, out
+				tokenSource = tokenSourceEstimated
+			}
+		}
+		fields["input_tokens"] = inputTokens
+		fields["output_tokens"] = outputTokens
+		fields["token_source"] = tokenSource
+		doneReason = getDoneReasonFromResponse(r.URL.Path, responseWriter.captured())
+		if doneReason != "" {
+			fields["done_reason"] = doneReason
+		}
+		createStatus = getCreateStatusFromResponse(r.URL.Path, responseWriter.captured())
+		if createStatus != "" {
+			fields["create_status"] = createStatus
+		}
+		if metricsUseResponseModel {
+			if served := getModelFromResponse(r.URL.Path, responseWriter.captured()); served != "" && served != metricsModel {
+				fields["response_model"] = served
+				metricsModel = served
+			}
+		}
+		toolCallCount = getToolCallCountFromResponse(r.URL.Path, responseWriter.captured())
+		if toolCallCount > 0 {
+			fields["tool_call_count"] = toolCallCount
+		}
+		embeddingCount, embeddingDim = getEmbeddingShapeFromResponse(r.URL.Path, responseWriter.captured())
+		if embeddingCount > 0 {
+			fields["embedding_count"] = embeddingCount
+			fields["embedding_dim"] = embeddingDim
+		}
+		if preview := getResponsePreview(r.URL.Path, responseWriter.captured(), logResponsePreviewBytes); preview != "" {
+			fields["response_preview"] = preview
+		}
+	}
+	if shutdownCut {
+		doneReason = doneReasonProxyShutdown
+		fields["done_reason"] = doneReason
+		fields["shutdown_terminated"] = true
+	}
+	stream := requestStreams(r.URL.Path, bodyBytes)
+	fields["stream"] = stream
 	fields["duration_ms"] = duration.Milliseconds()
+	ttft := responseWriter.timeToFirstWrite(startTime)
+	fields["ttft_ms"] = ttft.Milliseconds()
+	fields["bytes_transferred"] = responseWriter.bytesWritten
+	var contentLength int64
+	if class == endpointBlob && r.ContentLength > 0 {
+		contentLength = r.ContentLength
+		fields["content_length"] = contentLength
+	}
+
+	// Blob uploads stream through unread, so their size comes from the request header
+	requestBytes := int64(len(bodyBytes))
+	if class == endpointBlob {
+		requestBytes = contentLength
+	}
+	observeBodySizes(requestBytes, responseWriter.bytesWritten)
+	if responseWriter.chunks.gaps() > 0 {
+		fields["chunk_count"] = responseWriter.chunks.chunks
+		fields["chunk_gap_p95_ms"] = responseWriter.chunks.percentile(0.95).Milliseconds()
+		fields["longest_stall_ms"] = responseWriter.chunks.max.Milliseconds()
+	}
+	if upstreamError != "" {
+		fields["failed"] = true
+		fields["upstream_error"] = upstreamError
+		logger.Error("Upstream error mid-stream", nil, policy.LogFields(fields))
+	}
+	if clientAborted {
+		fields["client_aborted"] = true
+		logger.Warning("Client disconnected mid-stream", policy.LogFields(fields))
+	}
+
+	// Ollama refusing a request made under a session token means the session is no longer good
+	if validation.SessionToken != "" && responseWriter.status() == http.StatusUnauthorized {
+		evictValidationSession(details.APIKey, validation.SessionToken, "ollama")
+	}
 
 	// Log the request
-	logger.RequestLog(r.Method, r.URL.Path, r.RemoteAddr, responseWriter.statusCode, duration, fields)
-
-	// Send metrics asynchronously
-	go sendMetrics(MetricsData{
-		APIKey:            apiKey,
-		Model:             details.Model,
-		InputTokenLength:  inputTokens,
-		OutputTokenLength: outputTokens,
-		RequestDurationMs: duration.Milliseconds(),
-		Endpoint:          details.Endpoint,
-	})
+	logger.RequestLog(r.Method, r.URL.Path, r.RemoteAddr, responseWriter.status(), duration, policy.LogFields(fields))
+	allocs.report(requestID, details.Model, r.URL.Path, requestBytes, responseWriter.bytesWritten)
+
+	// Send metrics asynchronously; copies also carry both names so derived models can be traced
+	if metricsEnabled {
+		var sourceModel string
+		if details.DestinationModel != "" {
+			sourceModel = details.Model
+		}
+		sendMetricsAsync(externalMetricsURL, requestID, policy.Metrics(MetricsData{
+			APIKey:             apiKey,
+			Model:              metricsModel,
+			InputTokenLength:   inputTokens,
+			OutputTokenLength:  outputTokens,
+			TokenSource:        tokenSource,
+			Stream:             stream,
+			DoneReason:         doneReason,
+			ToolCallCount:      toolCallCount,
+			RequestDurationMs:  duration.Milliseconds(),
+			TTFTMs:             ttft.Milliseconds(),
+			Endpoint:           details.Endpoint,
+			Failed:             upstreamError != "",
+			UpstreamError:      upstreamError,
+			ClientAborted:      clientAborted,
+			ShutdownTerminated: shutdownCut,
+			KeySource:          keySource,
+			ProxyVersion:       version.String(),
+			BytesTransferred:   responseWriter.bytesWritten,
+			ContentLength:      contentLength,
+			ChunkCount:         responseWriter.chunks.chunks,
+			ChunkGapMinUs:      responseWriter.chunks.min.Microseconds(),
+			ChunkGapMeanUs:     responseWriter.chunks.mean().Microseconds(),
+			ChunkGapP95Us:      responseWriter.chunks.percentile(0.95).Microseconds(),
+			LongestStallUs:     responseWriter.chunks.max.Microseconds(),
+			CreateStatus:       createStatus,
+			Quantize:           quantize,
+			EmbedInputCount:    embedInputCount,
+			EmbeddingCount:     embeddingCount,
+			EmbeddingDim:       embeddingDim,
+			SourceModel:        sourceModel,
+			DestinationModel:   details.DestinationModel,
+			RuleTags:           requestTagsFromContext(r.Context()),
+			SessionToken:       validation.SessionToken,
+		}))
+	}
+
+	// Sample exact counts for verification against the local estimate
+	if tokenVerifier != nil && captured && policy.AllowTokenVerification() && tokenSource == tokenSourceOllama && upstreamError == "" && !aborted {
+		tokenVerifier.Sample(r.URL.Path, details.Model, bodyBytes, responseWriter.captured(), inputTokens, outputTokens)
+	}
+
+	// Abandon the connection so the client sees the truncated response, now that metrics are reported
+	if aborted && sse == nil && !shutdownCut {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// serveProxy forwards the request and reports whether the response copy was aborted mid-stream
+func serveProxy(proxy *httputil.ReverseProxy, w http.ResponseWriter, r *http.Request) (aborted bool) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			if rec != http.ErrAbortHandler {
+				panic(rec)
+			}
+			aborted = true
+		}
+	}()
+	proxy.ServeHTTP(w, r)
+	return false
 }
 
 func (rw *responseWriter) Write(b []byte) (int, error) {
-	rw.body.Write(b)
-	return rw.ResponseWriter.Write(b)
+	// Like net/http, a body written without a header implies 200 OK
+	if rw.statusCode == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if len(b) > 0 && (rw.ndjson || rw.firstWriteAt.IsZero()) {
+		now := time.Now()
+		if rw.firstWriteAt.IsZero() {
+			rw.firstWriteAt = now
+		}
+		if rw.ndjson {
+			rw.observeChunk(now)
+		}
+	}
+	if rw.body != nil {
+		rw.body.Write(b)
+	}
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytesWritten += int64(n)
+	return n, err
+}
+
+// observeChunk records a streamed chunk's arrival and reports the first stall beyond the threshold
+func (rw *responseWriter) observeChunk(now time.Time) {
+	gap := rw.chunks.observe(now)
+	if streamStallThreshold > 0 && gap > streamStallThreshold && !rw.stallWarned && rw.onStall != nil {
+		rw.stallWarned = true
+		rw.onStall(gap)
+	}
+}
+
+// captured returns the buffered response body, or nil when the response was not captured
+func (rw *responseWriter) captured() []byte {
+	if rw.body == nil {
+		return nil
+	}
+	return rw.body.Bytes()
+}
+
+// timeToFirstWrite returns how long after start the first body bytes were written
+func (rw *responseWriter) timeToFirstWrite(start time.Time) time.Duration {
+	if rw.firstWriteAt.IsZero() {
+		return 0
+	}
+	return rw.firstWriteAt.Sub(start)
 }
 
 func (rw *responseWriter) WriteHeader(statusCode int) {
 	rw.statusCode = statusCode
+	rw.ndjson = isNDJSONResponse(rw.Header())
 	rw.ResponseWriter.WriteHeader(statusCode)
 }
 
+// status returns the response status, treating a response that never wrote a header as 200 OK
+func (rw *responseWriter) status() int {
+	if rw.statusCode == 0 {
+		return http.StatusOK
+	}
+	return rw.statusCode
+}
+
+func (rw *responseWriter) Flush() {
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// requestModel holds only the fields requests name models with, so fields the proxy doesn't model, such as
+// OpenAI content part arrays or array prompts, can't stop the model from being read
+type requestModel struct {
+	Model       string `json:"model"`
+	Name        string `json:"name"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+// getModelFromRequest returns the model a request names, or "" when the endpoint names none or the
+// body doesn't say
 func getModelFromRequest(path string, body []byte) string {
+	if !requestNamesModel(path) {
+		return ""
+	}
+	var req requestModel
+	if err := json.Unmarshal(body, &req); err != nil {
+		return ""
+	}
+	switch {
+	case strings.HasSuffix(path, "/api/copy"):
+		// The source is the model acted on; the destination is a new name for it
+		return req.Source
+	case strings.HasSuffix(path, "/api/show"), strings.HasSuffix(path, "/api/delete"),
+		strings.HasSuffix(path, "/api/pull"), strings.HasSuffix(path, "/api/push"):
+		return transferModel(req.Model, req.Name)
+	default:
+		return req.Model
+	}
+}
+
+// requestNamesModel reports whether requests to the path name a model
+func requestNamesModel(path string) bool {
+	return modelEndpoints[canonicalEndpoint(path)]
+}
+
+// getModelFromResponse returns the model Ollama reports serving a chat or generate request, from the
+// final chunk of a stream
+func getModelFromResponse(path string, responseBody []byte) string {
+	responseBody = finalChunk(responseBody)
+
 	switch {
 	case strings.HasSuffix(path, "/api/chat"):
-		var chatReq ChatRequest
-		if err := json.Unmarshal(body, &chatReq); err == nil {
-			return chatReq.Model
+		var chatResp ChatResponse
+		if err := json.Unmarshal(responseBody, &chatResp); err == nil {
+			return chatResp.Model
 		}
 	case strings.HasSuffix(path, "/api/generate"):
-		var genReq GenerateRequest
-		if err := json.Unmarshal(body, &genReq); err == nil {
-			return genReq.Model
-		}
-	case strings.HasSuffix(path, "/api/embed"):
-		var embedReq EmbedRequest
-		if err := json.Unmarshal(body, &embedReq); err == nil {
-			return embedReq.Model
-		}
-	case strings.HasSuffix(path, "/api/create"):
-		var createReq CreateRequest
-		if err := json.Unmarshal(body, &createReq); err == nil {
-			return createReq.Model
+		var genResp GenerateResponse
+		if err := json.Unmarshal(responseBody, &genResp); err == nil {
+			return genResp.Model
 		}
 	}
 	return ""
 }
 
+// getCopyDestination returns the new name a copy request creates, which is empty for other endpoints
+func getCopyDestination(path string, body []byte) string {
+	if !strings.HasSuffix(path, "/api/copy") {
+		return ""
+	}
+	var req requestModel
+	if err := json.Unmarshal(body, &req); err != nil {
+		return ""
+	}
+	return req.Destination
+}
+
+// setRequestBody replaces the request body and keeps the content length in sync
+func setRequestBody(r *http.Request, body []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	allowBodyReplay(r, body)
+}
+
+// requestStreams reports whether Ollama will stream the response, which it does by default
+func requestStreams(path string, body []byte) bool {
+	if !strings.HasSuffix(path, "/api/chat") && !strings.HasSuffix(path, "/api/generate") {
+		return false
+	}
+
+	var req struct {
+		Stream *bool `json:"stream"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return false
+	}
+	return req.Stream == nil || *req.Stream
+}
+
+// finalChunk returns the last line of an NDJSON stream, or the body itself if it is a single JSON document
+func finalChunk(body []byte) []byte {
+	trimmed := bytes.TrimSpace(body)
+	if json.Valid(trimmed) {
+		return trimmed
+	}
+	if idx := bytes.LastIndexByte(trimmed, '\n'); idx >= 0 {
+		return trimmed[idx+1:]
+	}
+	return trimmed
+}
+
 func getTokenCountsFromResponse(path string, responseBody []byte) (int, int) {
 	var inputTokens, outputTokens int
 
+	// OpenAI responses carry a usage block; event streams end with [DONE], so their usage chunk is
+	// searched for in the whole body. Embeddings have no completion tokens.
+	if strings.HasSuffix(path, "/v1/chat/completions") || strings.HasSuffix(path, "/v1/completions") || strings.HasSuffix(path, "/v1/embeddings") {
+		if usage := openAIUsage(responseBody); usage != nil {
+			inputTokens = usage.PromptTokens
+			outputTokens = usage.CompletionTokens
+		}
+		return inputTokens, outputTokens
+	}
+
+	// Streamed responses carry the counts on the final chunk
+	responseBody = finalChunk(responseBody)
+
 	switch {
 	case strings.HasSuffix(path, "/api/chat"):
 		var chatResp ChatResponse
@@ -270,11 +1384,191 @@ func getTokenCountsFromResponse(path string, responseBody []byte) (int, int) {
 			// Embeddings don't have output tokens in the same way
 			outputTokens = 0
 		}
+	case strings.HasSuffix(path, "/api/embeddings"):
+		var embeddingsResp EmbeddingsResponse
+		if err := json.Unmarshal(responseBody, &embeddingsResp); err == nil {
+			inputTokens = embeddingsResp.PromptEvalCount
+		}
 	}
 
 	return inputTokens, outputTokens
 }
 
+// estimateEmbeddingsTokens approximates the prompt tokens of a legacy embeddings request, whose response
+// carries no counts, at about four characters per token
+func estimateEmbeddingsTokens(body []byte) int {
+	var req EmbeddingsRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return 0
+	}
+	return (len(req.Prompt) + 3) / 4
+}
+
+// requestHeaders lists every value of each header for the validation service, keyed by canonical name
+// so differently cased duplicates are merged. The value slices are shared with h, capped so appending
+// copies them; sanitizeRequestDetails copies any it has to shorten.
+func requestHeaders(h http.Header) map[string][]string {
+	headers := make(map[string][]string, len(h))
+	for name, values := range h {
+		values = values[:len(values):len(values)]
+		name = http.CanonicalHeaderKey(name)
+		if existing, ok := headers[name]; ok {
+			values = append(existing, values...)
+		}
+		headers[name] = values
+	}
+	return headers
+}
+
+// bodyFingerprint returns the hex SHA-256 and size of a raw request body, so the validation service
+// can spot identical prompts sprayed across keys without the proxy sending their contents
+func bodyFingerprint(body []byte) (string, int) {
+	if len(body) == 0 {
+		return "", 0
+	}
+	sum := sha256.Sum256(body)
+	var digest [2 * sha256.Size]byte
+	hex.Encode(digest[:], sum[:])
+	return string(digest[:]), len(body)
+}
+
+// getDoneReasonFromResponse returns why generation stopped, from the final chat or generate response
+func getDoneReasonFromResponse(path string, responseBody []byte) string {
+	responseBody = finalChunk(responseBody)
+
+	switch {
+	case strings.HasSuffix(path, "/api/chat"):
+		var chatResp ChatResponse
+		if err := json.Unmarshal(responseBody, &chatResp); err == nil && chatResp.Done {
+			return chatResp.DoneReason
+		}
+	case strings.HasSuffix(path, "/api/generate"):
+		var genResp GenerateResponse
+		if err := json.Unmarshal(responseBody, &genResp); err == nil && genResp.Done {
+			return genResp.DoneReason
+		}
+	}
+	return ""
+}
+
+// getCreateStatusFromResponse returns the outcome of a model creation: the status of the last
+// progress chunk, "success" once the model is built, or Ollama's error message
+func getCreateStatusFromResponse(path string, responseBody []byte) string {
+	if !strings.HasSuffix(path, "/api/create") {
+		return ""
+	}
+	var progress struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	if err := json.Unmarshal(finalChunk(responseBody), &progress); err != nil {
+		return ""
+	}
+	if progress.Error != "" {
+		return progress.Error
+	}
+	return progress.Status
+}
+
+// getQuantizeFromRequest returns the quantization a model creation asks for
+func getQuantizeFromRequest(path string, body []byte) string {
+	if !strings.HasSuffix(path, "/api/create") {
+		return ""
+	}
+	var createReq CreateRequest
+	if err := json.Unmarshal(body, &createReq); err != nil {
+		return ""
+	}
+	return createReq.Quantize
+}
+
+// getEmbedInputCountFromRequest counts the inputs of an embed request: one for a single string,
+// otherwise the length of the input list
+func getEmbedInputCountFromRequest(path string, body []byte) int {
+	if !strings.HasSuffix(path, "/api/embed") {
+		return 0
+	}
+	var embedReq struct {
+		Input json.RawMessage `json:"input"`
+	}
+	if err := json.Unmarshal(body, &embedReq); err != nil || len(embedReq.Input) == 0 {
+		return 0
+	}
+	if embedReq.Input[0] == '"' {
+		return 1
+	}
+	count, _ := jsonArrayLength(embedReq.Input)
+	return count
+}
+
+// getEmbeddingShapeFromResponse returns how many embeddings an embed response holds and the
+// dimension of the first
+func getEmbeddingShapeFromResponse(path string, responseBody []byte) (count, dim int) {
+	if !strings.HasSuffix(path, "/api/embed") {
+		return 0, 0
+	}
+	var embedResp struct {
+		Embeddings json.RawMessage `json:"embeddings"`
+	}
+	if err := json.Unmarshal(responseBody, &embedResp); err != nil {
+		return 0, 0
+	}
+	count, first := jsonArrayLength(embedResp.Embeddings)
+	dim, _ = jsonArrayLength(first)
+	return count, dim
+}
+
+// jsonArrayLength counts the elements of a JSON array and returns the first one, without decoding
+// them, since embed inputs and vectors can number in the thousands. Anything but an array counts as 0.
+func jsonArrayLength(raw []byte) (int, []byte) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) < 2 || raw[0] != '[' {
+		return 0, nil
+	}
+	if len(bytes.TrimSpace(raw[1:len(raw)-1])) == 0 {
+		return 0, nil
+	}
+
+	count, depth, firstEnd := 1, 0, -1
+	inString, escaped := false, false
+	for i := 1; i < len(raw)-1; i++ {
+		c := raw[i]
+		switch {
+		case inString:
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			count++
+			if firstEnd < 0 {
+				firstEnd = i
+			}
+		}
+	}
+	if firstEnd < 0 {
+		firstEnd = len(raw) - 1
+	}
+	return count, bytes.TrimSpace(raw[1:firstEnd])
+}
+
+// getToolCallCountFromResponse counts the tool calls in a chat response; streams may spread them across chunks
+func getToolCallCountFromResponse(path string, responseBody []byte) int {
+	if !strings.HasSuffix(path, "/api/chat") {
+		return 0
+	}
+	return summarizeStream(responseBody).ToolCalls
+}
+
 func getSecureHTTPClient() *http.Client {
 	// Create a custom transport with TLS configuration
 	transport := &http.Transport{
@@ -295,69 +1589,102 @@ func getSecureHTTPClient() *http.Client {
 
 	return &http.Client{
 		Transport: transport,
-		Timeout:   10 * time.Second, // Add timeout for external requests
+		Timeout:   externalRequestTimeout,
 	}
 }
 
-func validateRequest(details RequestDetails) bool {
-	jsonData, err := json.Marshal(details)
-	if err != nil {
-		logger.Error("Error marshaling validation request", err, map[string]interface{}{
-			"api_key":  details.APIKey,
-			"endpoint": details.Endpoint,
-		})
-		return false
-	}
+// getValidationHTTPClient returns the secure client with VALIDATION_TIMEOUT
+func getValidationHTTPClient() *http.Client {
+	client := getSecureHTTPClient()
+	client.Timeout = validationTimeout
+	return client
+}
 
-	// Create request with authentication
-	req, err := http.NewRequest("POST", externalValidationURL, bytes.NewBuffer(jsonData))
-	if err != nil {
-		logger.Error("Error creating validation request", err, map[string]interface{}{
-			"api_key":  details.APIKey,
-			"endpoint": details.Endpoint,
-		})
-		return false
+// getMetricsHTTPClient returns the secure client with METRICS_TIMEOUT
+func getMetricsHTTPClient() *http.Client {
+	client := getSecureHTTPClient()
+	client.Timeout = metricsTimeout
+	return client
+}
+
+// signExternalRequest adds X-Timestamp and an HMAC X-Signature over body to a request for the
+// external server when EXTERNAL_SERVER_HMAC_SECRET is set
+func signExternalRequest(req *http.Request, body []byte) {
+	if externalServerHMACSecret != "" {
+		signing.Sign(req, externalServerHMACSecret, body, time.Now())
 	}
+}
 
-	// Add security headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-API-Key", externalServerAPIKey)
-	req.Header.Set("X-Request-ID", fmt.Sprintf("%d", time.Now().UnixNano()))
+// getOllamaHealthHTTPClient returns the Ollama client with OLLAMA_HEALTH_TIMEOUT
+func getOllamaHealthHTTPClient() *http.Client {
+	client := getOllamaHTTPClient()
+	client.Timeout = ollamaHealthTimeout
+	return client
+}
 
-	// Use secure client
-	client := getSecureHTTPClient()
-	resp, err := client.Do(req)
-	if err != nil {
-		logger.Error("Error calling validation server", err, map[string]interface{}{
-			"api_key":  details.APIKey,
-			"endpoint": details.Endpoint,
-		})
-		return false
+// validationRejection returns the status, error code and message for a request the validation service refused;
+// unknown keys and failed validation calls both get 401
+func validationRejection(validation ValidationResponse, model string) (int, string, string) {
+	switch {
+	case validation.RateLimited:
+		return http.StatusTooManyRequests, "rate_limit_exceeded", "Too Many Requests: Rate limit exceeded"
+	case validation.Reason == "model_not_allowed":
+		return http.StatusForbidden, "model_not_allowed", fmt.Sprintf("Forbidden: Model %s not allowed for key", model)
+	case validation.Reason == "endpoint_not_allowed":
+		return http.StatusForbidden, "endpoint_not_allowed", "Forbidden: Endpoint not allowed for key"
+	default:
+		return http.StatusUnauthorized, "invalid_api_key", "Unauthorized: Invalid request"
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		logger.Warning("Validation server returned non-OK status", map[string]interface{}{
-			"api_key":     details.APIKey,
-			"endpoint":    details.Endpoint,
-			"status_code": resp.StatusCode,
-		})
-		return false
+func sendMetrics(metrics MetricsData) {
+	sendMetricsTo(externalMetricsURL, newRequestID(), metrics)
+}
+
+// metricsDeliveries counts metrics posts still in flight, so shutdown can wait for them
+var metricsDeliveries sync.WaitGroup
+
+// sendMetricsAsync posts metrics in the background, without holding up the response
+func sendMetricsAsync(metricsURL, requestID string, metrics MetricsData) {
+	metricsDeliveries.Add(1)
+	go func() {
+		defer metricsDeliveries.Done()
+		sendMetricsTo(metricsURL, requestID, metrics)
+	}()
+}
+
+// waitForMetricsDeliveries waits for metrics posts in flight, giving up when ctx is done
+func waitForMetricsDeliveries(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		metricsDeliveries.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	var validationResp ValidationResponse
-	if err := json.NewDecoder(resp.Body).Decode(&validationResp); err != nil {
-		logger.Error("Error decoding validation response", err, map[string]interface{}{
-			"api_key":  details.APIKey,
-			"endpoint": details.Endpoint,
+// sendMetricsTo posts metrics to metricsURL, which callers resolve before sending asynchronously, under the
+// ID of the request they describe
+func sendMetricsTo(metricsURL, requestID string, metrics MetricsData) {
+	if err := validateMetricsData(metrics); err != nil {
+		logger.Warning("Skipping invalid metrics", map[string]interface{}{
+			"error":    err.Error(),
+			"api_key":  metrics.APIKey,
+			"model":    metrics.Model,
+			"endpoint": metrics.Endpoint,
 		})
-		return false
+		return
 	}
 
-	return validationResp.Valid && !validationResp.RateLimited
-}
+	if metrics.Tags == nil {
+		metrics.Tags = metadataTags.get(metrics.APIKey)
+	}
 
-func sendMetrics(metrics MetricsData) {
 	jsonData, err := json.Marshal(metrics)
 	if err != nil {
 		logger.Error("Error marshaling metrics", err, map[string]interface{}{
@@ -368,8 +1695,22 @@ func sendMetrics(metrics MetricsData) {
 		return
 	}
 
+	contentType := "application/json"
+	if metricsRecipient != nil {
+		jsonData, err = metricsRecipient.encrypt(jsonData)
+		if err != nil {
+			logger.Error("Error encrypting metrics", err, map[string]interface{}{
+				"api_key":  metrics.APIKey,
+				"model":    metrics.Model,
+				"endpoint": metrics.Endpoint,
+			})
+			return
+		}
+		contentType = "application/octet-stream"
+	}
+
 	// Create request with authentication
-	req, err := http.NewRequest("POST", externalMetricsURL, bytes.NewBuffer(jsonData))
+	req, err := http.NewRequest("POST", metricsURL, bytes.NewBuffer(jsonData))
 	if err != nil {
 		logger.Error("Error creating metrics request", err, map[string]interface{}{
 			"api_key":  metrics.APIKey,
@@ -380,12 +1721,16 @@ func sendMetrics(metrics MetricsData) {
 	}
 
 	// Add security headers
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", contentType)
+	if metricsRecipient != nil {
+		req.Header.Set("X-Metrics-Encryption", metricsRecipient.scheme())
+	}
 	req.Header.Set("X-API-Key", externalServerAPIKey)
-	req.Header.Set("X-Request-ID", fmt.Sprintf("%d", time.Now().UnixNano()))
+	req.Header.Set(version.Header, version.String())
+	req.Header.Set(requestIDHeader, requestID)
+	signExternalRequest(req, jsonData)
 
-	// Use secure client
-	client := getSecureHTTPClient()
+	client := getMetricsHTTPClient()
 	resp, err := client.Do(req)
 	if err != nil {
 		logger.Error("Error sending metrics", err, map[string]interface{}{
@@ -405,6 +1750,14 @@ func sendMetrics(metrics MetricsData) {
 			"status_code": resp.StatusCode,
 		})
 	}
+
+	// A 401 naming the session token revokes it; one that doesn't is about the proxy's own credentials
+	if resp.StatusCode == http.StatusUnauthorized && metrics.SessionToken != "" {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, metricsErrorBodyLimit))
+		if bytes.Contains(body, []byte(metrics.SessionToken)) {
+			evictValidationSession(capRequestValue(metrics.APIKey), metrics.SessionToken, "metrics")
+		}
+	}
 }
 
 // validateExternalServices checks if all required external services are accessible
@@ -414,14 +1767,18 @@ func validateExternalServices() error {
 		return fmt.Errorf("Ollama service validation failed: %v", err)
 	}
 
-	// Validate external validation service
-	if err := validateExternalValidationService(); err != nil {
-		return fmt.Errorf("External validation service validation failed: %v", err)
+	// Validate external validation service, which mock and local validation never contact
+	if !validationMock && validationModeEnabled("external") {
+		if err := validateExternalValidationService(); err != nil {
+			return fmt.Errorf("External validation service validation failed: %v", err)
+		}
 	}
 
-	// Validate external metrics service
-	if err := validateExternalMetricsService(); err != nil {
-		return fmt.Errorf("External metrics service validation failed: %v", err)
+	// Validate external metrics service, which minimal mode never contacts
+	if metricsEnabled {
+		if err := validateExternalMetricsService(); err != nil {
+			return fmt.Errorf("External metrics service validation failed: %v", err)
+		}
 	}
 
 	return nil
@@ -429,8 +1786,8 @@ func validateExternalServices() error {
 
 // validateOllamaService checks if the Ollama service is accessible
 func validateOllamaService() error {
-	client := getSecureHTTPClient()
-	resp, err := client.Get(ollamaURL + "/api/tags")
+	client := getOllamaHealthHTTPClient()
+	resp, err := client.Get(currentOllamaURL() + "/api/tags")
 	if err != nil {
 		logger.Error("Failed to connect to Ollama service", err, nil)
 		return fmt.Errorf("failed to connect to Ollama service: %v", err)
@@ -447,10 +1804,46 @@ func validateOllamaService() error {
 	return nil
 }
 
+// defaultOllamaHealthPath answers HEAD requests on every Ollama version
+const defaultOllamaHealthPath = "/api/tags"
+
+// validateOllamaServiceLight checks Ollama with a HEAD request to OLLAMA_HEALTH_PATH, which returns
+// the status without the model list, so it is cheap enough for frequent health checks
+func validateOllamaServiceLight() error {
+	healthPath := ollamaHealthPath
+	if healthPath == "" {
+		healthPath = defaultOllamaHealthPath
+	}
+	client := getOllamaHealthHTTPClient()
+	resp, err := client.Head(strings.TrimSuffix(currentOllamaURL(), "/") + healthPath)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Ollama service: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Ollama service returned non-OK status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
 // validateExternalValidationService checks if the external validation service is accessible
 func validateExternalValidationService() error {
-	client := getSecureHTTPClient()
-	req, err := http.NewRequest("GET", externalValidationURL, nil)
+	return checkValidationURL(currentValidationURL())
+}
+
+// checkValidationURL checks that a validation service answers a GET with 200, or a gRPC health check with SERVING
+func checkValidationURL(validationURL string) error {
+	if isGRPCValidationURL(validationURL) {
+		if err := checkGRPCValidationURL(validationURL); err != nil {
+			logger.Error("Failed to health check gRPC validation service", err, nil)
+			return fmt.Errorf("validation service health check failed: %v", err)
+		}
+		return nil
+	}
+
+	client := getValidationHTTPClient()
+	req, err := http.NewRequest("GET", validationURL, nil)
 	if err != nil {
 		logger.Error("Failed to create validation request", err, nil)
 		return fmt.Errorf("failed to create validation request: %v", err)
@@ -458,7 +1851,9 @@ func validateExternalValidationService() error {
 
 	// Add security headers
 	req.Header.Set("X-API-Key", externalServerAPIKey)
-	req.Header.Set("X-Request-ID", fmt.Sprintf("%d", time.Now().UnixNano()))
+	req.Header.Set(version.Header, version.String())
+	req.Header.Set(requestIDHeader, newRequestID())
+	signExternalRequest(req, nil)
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -479,7 +1874,7 @@ func validateExternalValidationService() error {
 
 // validateExternalMetricsService checks if the external metrics service is accessible
 func validateExternalMetricsService() error {
-	client := getSecureHTTPClient()
+	client := getMetricsHTTPClient()
 	req, err := http.NewRequest("GET", externalMetricsURL, nil)
 	if err != nil {
 		logger.Error("Failed to create metrics request", err, nil)
@@ -488,7 +1883,9 @@ func validateExternalMetricsService() error {
 
 	// Add security headers
 	req.Header.Set("X-API-Key", externalServerAPIKey)
-	req.Header.Set("X-Request-ID", fmt.Sprintf("%d", time.Now().UnixNano()))
+	req.Header.Set(version.Header, version.String())
+	req.Header.Set(requestIDHeader, newRequestID())
+	signExternalRequest(req, nil)
 
 	resp, err := client.Do(req)
 	if err != nil {
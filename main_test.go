@@ -2,15 +2,30 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"ollama-proxy/logger"
+	"ollama-proxy/version"
 )
 
 // TestLoadConfig tests the configuration loading functionality
 func TestLoadConfig(t *testing.T) {
+	// loadConfig rewrites globals that metrics deliveries read
+	metricsDeliveries.Wait()
+
 	// Set test environment variables
 	os.Setenv("OLLAMA_URL", "http://test-ollama:11434")
 	os.Setenv("EXTERNAL_VALIDATION_URL", "http://test-validation:8080")
@@ -39,6 +54,57 @@ func TestLoadConfig(t *testing.T) {
 	}
 }
 
+// TestLoadConfigTimeouts tests that each external client gets its own timeout
+func TestLoadConfigTimeouts(t *testing.T) {
+	metricsDeliveries.Wait()
+	t.Setenv("VALIDATION_TIMEOUT", "1500ms")
+	t.Setenv("METRICS_TIMEOUT", "30s")
+	t.Setenv("OLLAMA_HEALTH_TIMEOUT", "3s")
+	loadConfig()
+	defer func() {
+		validationTimeout, metricsTimeout, ollamaHealthTimeout = defaultValidationTimeout, defaultMetricsTimeout, defaultOllamaHealthTimeout
+	}()
+
+	testCases := []struct {
+		name     string
+		client   *http.Client
+		expected time.Duration
+	}{
+		{"Validation", getValidationHTTPClient(), 1500 * time.Millisecond},
+		{"Metrics", getMetricsHTTPClient(), 30 * time.Second},
+		{"Ollama Health", getOllamaHealthHTTPClient(), 3 * time.Second},
+		{"Other External Services", getSecureHTTPClient(), externalRequestTimeout},
+	}
+	for _, tc := range testCases {
+		if tc.client.Timeout != tc.expected {
+			t.Errorf("%s: expected timeout %v, got %v", tc.name, tc.expected, tc.client.Timeout)
+		}
+	}
+}
+
+// TestValidationTimeout tests that a slow validation service fails the request after VALIDATION_TIMEOUT
+func TestValidationTimeout(t *testing.T) {
+	release := make(chan struct{})
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true})
+	}))
+	defer validationServer.Close()
+	defer close(release)
+	useProxyTargets(t, ollamaURL, validationServer.URL, "")
+	useValidationRetries(t, 1, time.Millisecond, time.Second)
+	validationTimeout = 100 * time.Millisecond
+	defer func() { validationTimeout = defaultValidationTimeout }()
+
+	start := time.Now()
+	if _, ok, _ := validateRequest(context.Background(), RequestDetails{APIKey: "test-key", Model: "llama2"}); ok {
+		t.Error("Expected the slow validation call to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected validation to give up after about 100ms, took %v", elapsed)
+	}
+}
+
 // TestProxyHandler tests the proxy handler functionality
 func TestProxyHandler(t *testing.T) {
 	// Create mock servers
@@ -49,16 +115,18 @@ func TestProxyHandler(t *testing.T) {
 	metricsServer := mockMetricsServer(t)
 	defer metricsServer.Close()
 
+	rateLimitedServer := mockValidationServerWith(t, ValidationResponse{Valid: true, RateLimited: true, RetryAfterSeconds: 30})
+	defer rateLimitedServer.Close()
+
 	// Set up test environment
-	ollamaURL = ollamaServer.URL
-	externalValidationURL = validationServer.URL
-	externalMetricsURL = metricsServer.URL
+	useProxyTargets(t, ollamaServer.URL, "", metricsServer.URL)
 
 	// Create test cases
 	testCases := []struct {
 		name           string
 		apiKey         string
 		requestBody    interface{}
+		rateLimited    bool
 		expectedStatus int
 	}{
 		{
@@ -101,6 +169,7 @@ func TestProxyHandler(t *testing.T) {
 					},
 				},
 			},
+			rateLimited:    true,
 			expectedStatus: http.StatusTooManyRequests,
 		},
 	}
@@ -113,7 +182,13 @@ func TestProxyHandler(t *testing.T) {
 				body, _ = json.Marshal(tc.requestBody)
 			}
 
+			externalValidationURL = validationServer.URL
+			if tc.rateLimited {
+				externalValidationURL = rateLimitedServer.URL
+			}
+
 			req := httptest.NewRequest("POST", "/api/chat", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
 			if tc.apiKey != "" {
 				req.Header.Set(apiKeyHeaderName, tc.apiKey)
 			}
@@ -128,66 +203,143 @@ func TestProxyHandler(t *testing.T) {
 			if rr.Code != tc.expectedStatus {
 				t.Errorf("Expected status %d, got %d", tc.expectedStatus, rr.Code)
 			}
+			if tc.rateLimited && rr.Header().Get("Retry-After") != "30" {
+				t.Errorf("Expected Retry-After: 30, got %q", rr.Header().Get("Retry-After"))
+			}
 		})
 	}
 }
 
+// modelExtraction is the golden output of getModelFromRequest
+type modelExtraction struct {
+	Model string `json:"model"`
+}
+
 // TestGetModelFromRequest tests the model extraction from different request types
 func TestGetModelFromRequest(t *testing.T) {
 	testCases := []struct {
-		name          string
-		path          string
-		requestBody   interface{}
-		expectedModel string
+		golden      string
+		path        string
+		requestBody interface{}
 	}{
 		{
-			name: "Chat Request",
-			path: "/api/chat",
+			golden: "chat_request",
+			path:   "/api/chat",
 			requestBody: ChatRequest{
 				Model: "llama2",
 			},
-			expectedModel: "llama2",
 		},
 		{
-			name: "Generate Request",
-			path: "/api/generate",
+			golden: "generate_request",
+			path:   "/api/generate",
 			requestBody: GenerateRequest{
 				Model: "mistral",
 			},
-			expectedModel: "mistral",
 		},
 		{
-			name: "Embed Request",
-			path: "/api/embed",
+			golden: "chat_completion_request",
+			path:   "/v1/chat/completions",
+			requestBody: ChatCompletionRequest{
+				Model:    "llama3",
+				Messages: []ChatMessage{{Role: "user", Content: "Hi"}},
+			},
+		},
+		{
+			golden:      "completion_request",
+			path:        "/v1/completions",
+			requestBody: OpenAICompletionRequest{Model: "mistral", Prompt: "Once upon a time", MaxTokens: 16},
+		},
+		{
+			golden:      "openai_embedding_request",
+			path:        "/v1/embeddings",
+			requestBody: OpenAIEmbeddingRequest{Model: "nomic-embed-text", Input: []string{"The sky is blue"}},
+		},
+		{
+			golden: "embed_request",
+			path:   "/api/embed",
 			requestBody: EmbedRequest{
 				Model: "nomic-embed",
 			},
-			expectedModel: "nomic-embed",
 		},
 		{
-			name: "Create Request",
-			path: "/api/create",
+			golden: "embeddings_request",
+			path:   "/api/embeddings",
+			requestBody: EmbeddingsRequest{
+				Model:  "nomic-embed-text",
+				Prompt: "The sky is blue",
+			},
+		},
+		{
+			golden: "create_request",
+			path:   "/api/create",
 			requestBody: CreateRequest{
 				Model: "custom-model",
 			},
-			expectedModel: "custom-model",
 		},
 		{
-			name:          "Invalid JSON",
-			path:          "/api/chat",
-			requestBody:   []byte("invalid json"),
-			expectedModel: "",
+			golden:      "pull_request_model",
+			path:        "/api/pull",
+			requestBody: PullRequest{Model: "llama3:8b"},
+		},
+		{
+			golden:      "pull_request_name",
+			path:        "/api/pull",
+			requestBody: []byte(`{"name":"llama3:8b","stream":false}`),
+		},
+		{
+			golden:      "push_request_model",
+			path:        "/api/push",
+			requestBody: PushRequest{Model: "registry.example.com/team/llama3:8b"},
+		},
+		{
+			golden:      "push_request_name",
+			path:        "/api/push",
+			requestBody: []byte(`{"name":"registry.example.com/team/llama3:8b","insecure":true}`),
+		},
+		{
+			golden:      "show_request",
+			path:        "/api/show",
+			requestBody: ShowRequest{Model: "llama3:8b", Verbose: true},
+		},
+		{
+			golden:      "delete_request_name",
+			path:        "/api/delete",
+			requestBody: []byte(`{"name":"llama3:8b"}`),
+		},
+		{
+			golden:      "copy_request",
+			path:        "/api/copy",
+			requestBody: CopyRequest{Source: "llama3:8b", Destination: "llama3-backup"},
 		},
 		{
-			name:          "Unknown Endpoint",
-			path:          "/api/unknown",
-			requestBody:   nil,
-			expectedModel: "",
+			golden:      "chat_request_unknown_fields",
+			path:        "/api/chat",
+			requestBody: []byte(`{"model":"llama3.1:8b","messages":[{"role":"user","content":"hi","images":null}],"tools":[],"think":true}`),
+		},
+		{
+			golden:      "chat_completion_content_parts",
+			path:        "/v1/chat/completions",
+			requestBody: []byte(`{"model":"llama3","messages":[{"role":"user","content":[{"type":"text","text":"Hi"},{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAA"}}]}]}`),
+		},
+		{
+			golden:      "completion_request_array_prompt",
+			path:        "/v1/completions",
+			requestBody: []byte(`{"model":"mistral","prompt":["Once upon a time","In a galaxy"]}`),
+		},
+		{
+			golden:      "invalid_json",
+			path:        "/api/chat",
+			requestBody: []byte("invalid json"),
+		},
+		{
+			golden:      "unknown_endpoint",
+			path:        "/api/unknown",
+			requestBody: nil,
 		},
 	}
 
 	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
+		t.Run(tc.golden, func(t *testing.T) {
 			var body []byte
 			if tc.requestBody != nil {
 				if b, ok := tc.requestBody.([]byte); ok {
@@ -197,69 +349,129 @@ func TestGetModelFromRequest(t *testing.T) {
 				}
 			}
 			model := getModelFromRequest(tc.path, body)
-			if model != tc.expectedModel {
-				t.Errorf("Expected model %s, got %s", tc.expectedModel, model)
-			}
+			assertGolden(t, "model_extraction/"+tc.golden, modelExtraction{Model: model})
 		})
 	}
 }
 
+// tokenCounts is the golden output of getTokenCountsFromResponse
+type tokenCounts struct {
+	InputTokens  int `json:"inputTokens"`
+	OutputTokens int `json:"outputTokens"`
+}
+
 // TestGetTokenCountsFromResponse tests token count extraction from responses
 func TestGetTokenCountsFromResponse(t *testing.T) {
 	testCases := []struct {
-		name           string
-		path           string
-		responseBody   interface{}
-		expectedInput  int
-		expectedOutput int
+		golden       string
+		path         string
+		responseBody interface{}
 	}{
 		{
-			name: "Chat Response",
-			path: "/api/chat",
+			golden: "chat_response",
+			path:   "/api/chat",
 			responseBody: ChatResponse{
 				PromptEvalCount: 10,
 				EvalCount:       20,
 			},
-			expectedInput:  10,
-			expectedOutput: 20,
 		},
 		{
-			name: "Generate Response",
-			path: "/api/generate",
+			golden: "generate_response",
+			path:   "/api/generate",
 			responseBody: GenerateResponse{
 				PromptEvalCount: 15,
 				EvalCount:       25,
 			},
-			expectedInput:  15,
-			expectedOutput: 25,
 		},
 		{
-			name: "Embed Response",
-			path: "/api/embed",
+			golden: "chat_completion_response",
+			path:   "/v1/chat/completions",
+			responseBody: ChatCompletionResponse{
+				Model: "llama3",
+				Usage: &ChatCompletionUsage{PromptTokens: 26, CompletionTokens: 9, TotalTokens: 35},
+			},
+		},
+		{
+			golden:       "chat_completion_response_without_usage",
+			path:         "/v1/chat/completions",
+			responseBody: []byte(`{"id":"chatcmpl-1","object":"chat.completion","model":"llama3","choices":[]}`),
+		},
+		{
+			golden: "chat_completion_stream",
+			path:   "/v1/chat/completions",
+			responseBody: []byte(`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"content":"Hi"},"finish_reason":null}]}
+
+data: {"id":"chatcmpl-1","object":"chat.completion.chunk","choices":[],"usage":{"prompt_tokens":9,"completion_tokens":2,"total_tokens":11}}
+
+data: [DONE]
+
+`),
+		},
+		{
+			golden: "completion_response",
+			path:   "/v1/completions",
+			responseBody: OpenAICompletionResponse{
+				Model:   "mistral",
+				Choices: []OpenAICompletionChoice{{Text: "there was"}},
+				Usage:   &ChatCompletionUsage{PromptTokens: 5, CompletionTokens: 16, TotalTokens: 21},
+			},
+		},
+		{
+			golden: "completion_stream",
+			path:   "/v1/completions",
+			responseBody: []byte(`data: {"id":"cmpl-1","object":"text_completion","choices":[{"index":0,"text":"there","finish_reason":null}]}
+
+data: {"id":"cmpl-1","object":"text_completion","choices":[{"index":0,"text":" was","finish_reason":"length"}]}
+
+data: {"id":"cmpl-1","object":"text_completion","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}
+
+data: [DONE]
+
+`),
+		},
+		{
+			golden: "openai_embedding_response",
+			path:   "/v1/embeddings",
+			responseBody: OpenAIEmbeddingResponse{
+				Object: "list",
+				Data:   []OpenAIEmbedding{{Object: "embedding", Embedding: []float32{0.1, 0.2, 0.3}}},
+				Model:  "nomic-embed-text",
+				Usage:  &OpenAIEmbeddingUsage{PromptTokens: 6, TotalTokens: 6},
+			},
+		},
+		{
+			golden: "embed_response",
+			path:   "/api/embed",
 			responseBody: EmbedResponse{
 				PromptEvalCount: 5,
 			},
-			expectedInput:  5,
-			expectedOutput: 0,
 		},
 		{
-			name:           "Invalid JSON",
-			path:           "/api/chat",
-			responseBody:   []byte("invalid json"),
-			expectedInput:  0,
-			expectedOutput: 0,
+			golden:       "embeddings_response",
+			path:         "/api/embeddings",
+			responseBody: []byte(`{"embedding":[0.1,0.2,0.3]}`),
+		},
+		{
+			golden: "chat_stream",
+			path:   "/api/chat",
+			responseBody: []byte(`{"model":"llama2","message":{"role":"assistant","content":"Hi"},"done":false}
+{"model":"llama2","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":12,"eval_count":2}
+`),
 		},
 		{
-			name:           "Unknown Endpoint",
-			path:           "/api/unknown",
-			responseBody:   nil,
-			expectedInput:  0,
-			expectedOutput: 0,
+			golden:       "invalid_json",
+			path:         "/api/chat",
+			responseBody: []byte("invalid json"),
+		},
+		{
+			golden:       "unknown_endpoint",
+			path:         "/api/unknown",
+			responseBody: nil,
 		},
 	}
 
 	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
+		t.Run(tc.golden, func(t *testing.T) {
 			var body []byte
 			if tc.responseBody != nil {
 				if b, ok := tc.responseBody.([]byte); ok {
@@ -269,16 +481,425 @@ func TestGetTokenCountsFromResponse(t *testing.T) {
 				}
 			}
 			inputTokens, outputTokens := getTokenCountsFromResponse(tc.path, body)
-			if inputTokens != tc.expectedInput {
-				t.Errorf("Expected input tokens %d, got %d", tc.expectedInput, inputTokens)
+			assertGolden(t, "token_counts/"+tc.golden, tokenCounts{InputTokens: inputTokens, OutputTokens: outputTokens})
+		})
+	}
+}
+
+// TestProxyHandlerValidationRejections tests the status and error code of each kind of validation rejection
+func TestProxyHandlerValidationRejections(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	useProxyTargets(t, ollamaServer.URL, "", "")
+
+	testCases := []struct {
+		name           string
+		validation     ValidationResponse
+		expectedStatus int
+		expectedCode   string
+		retryAfter     string
+	}{
+		{"Invalid Key", ValidationResponse{Valid: false, Reason: "invalid_key"}, http.StatusUnauthorized, "invalid_api_key", ""},
+		{"No Reason", ValidationResponse{Valid: false}, http.StatusUnauthorized, "invalid_api_key", ""},
+		{"Rate Limited", ValidationResponse{Valid: true, RateLimited: true, RetryAfterSeconds: 12}, http.StatusTooManyRequests, "rate_limit_exceeded", "12"},
+		{"Rate Limited Without Retry-After", ValidationResponse{Valid: true, RateLimited: true}, http.StatusTooManyRequests, "rate_limit_exceeded", ""},
+		{"Model Not Allowed", ValidationResponse{Valid: false, Reason: "model_not_allowed"}, http.StatusForbidden, "model_not_allowed", ""},
+		{"Endpoint Not Allowed", ValidationResponse{Valid: false, Reason: "endpoint_not_allowed"}, http.StatusForbidden, "endpoint_not_allowed", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			validationServer := mockValidationServerWith(t, tc.validation)
+			defer validationServer.Close()
+			externalValidationURL = validationServer.URL
+
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "Hi"}}}, "test-api-key"))
+			assertResponseStatus(t, rr, tc.expectedStatus)
+			if got := rr.Header().Get("Retry-After"); got != tc.retryAfter {
+				t.Errorf("Expected Retry-After %q, got %q", tc.retryAfter, got)
+			}
+
+			var resp ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatalf("Expected a JSON error: %v", err)
 			}
-			if outputTokens != tc.expectedOutput {
-				t.Errorf("Expected output tokens %d, got %d", tc.expectedOutput, outputTokens)
+			if resp.Code != tc.expectedCode {
+				t.Errorf("Expected code %q, got %+v", tc.expectedCode, resp)
+			}
+			if tc.expectedCode == "model_not_allowed" && !strings.Contains(resp.Error, "llama2") {
+				t.Errorf("Expected the error to name the model, got %q", resp.Error)
 			}
 		})
 	}
 }
 
+// TestProxyHandlerStreamAndDoneReason tests the stream flag and done reason sent with metrics
+func TestProxyHandlerStreamAndDoneReason(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/chat":
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Write([]byte(`{"model":"llama2","message":{"role":"assistant","content":"Hi"},"done":false}` + "\n"))
+			w.Write([]byte(`{"model":"llama2","message":{"role":"assistant","content":""},"done":true,"done_reason":"length"}` + "\n"))
+		case "/api/generate":
+			var req GenerateRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "invalid character 'o' in literal null"})
+				return
+			}
+			json.NewEncoder(w).Encode(GenerateResponse{Model: "mistral", Done: true, DoneReason: "stop"})
+		}
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	received := make(chan map[string]interface{}, 4)
+	metricsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var raw map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&raw)
+		received <- raw
+	}))
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	testCases := []struct {
+		name               string
+		path               string
+		body               interface{}
+		expectedStream     bool
+		expectedDoneReason string
+		expectMetrics      bool
+	}{
+		{"Streamed Chat", "/api/chat", map[string]interface{}{"model": "llama2", "messages": []ChatMessage{}}, true, "length", true},
+		{"Non-Streamed Generate", "/api/generate", GenerateRequest{Model: "mistral", Prompt: "hi"}, false, "stop", true},
+		// Without a model the record can't be attributed, so it's dropped instead of sent
+		{"Unparseable Body", "/api/generate", []byte("not json"), false, "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var req *http.Request
+			if raw, ok := tc.body.([]byte); ok {
+				req = httptest.NewRequest("POST", tc.path, bytes.NewReader(raw))
+				req.Header.Set("X-API-Key", "test-api-key")
+			} else {
+				req = createTestRequest(t, "POST", tc.path, tc.body, "test-api-key")
+			}
+			proxyHandler(httptest.NewRecorder(), req)
+
+			if !tc.expectMetrics {
+				select {
+				case metrics := <-received:
+					t.Fatalf("Expected no metrics, got %v", metrics)
+				case <-time.After(200 * time.Millisecond):
+				}
+				return
+			}
+
+			var metrics map[string]interface{}
+			select {
+			case metrics = <-received:
+			case <-time.After(2 * time.Second):
+				t.Fatal("Timed out waiting for metrics")
+			}
+			if metrics["stream"] != tc.expectedStream {
+				t.Errorf("Expected stream %v, got %v", tc.expectedStream, metrics["stream"])
+			}
+			if reason, ok := metrics["doneReason"]; !ok || reason != tc.expectedDoneReason {
+				t.Errorf("Expected doneReason %q, got %v", tc.expectedDoneReason, reason)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerLegacyEmbeddings tests that legacy /api/embeddings requests report their model and an estimated prompt size
+func TestProxyHandlerLegacyEmbeddings(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	req := createTestRequest(t, "POST", "/api/embeddings", EmbeddingsRequest{
+		Model:  "nomic-embed-text",
+		Prompt: "The sky is blue",
+	}, "test-api-key")
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, req)
+	assertResponseStatus(t, rr, http.StatusOK)
+
+	metrics := waitForMetrics(t, received)
+	if metrics.Model != "nomic-embed-text" || metrics.Endpoint != "/api/embeddings" {
+		t.Errorf("Expected model and endpoint in metrics, got %+v", metrics)
+	}
+	if metrics.InputTokenLength != 4 || metrics.OutputTokenLength != 0 || metrics.TokenSource != tokenSourceEstimated {
+		t.Errorf("Expected 4 estimated input tokens, got %d/%d (%s)", metrics.InputTokenLength, metrics.OutputTokenLength, metrics.TokenSource)
+	}
+}
+
+// TestJSONArrayLength tests counting array elements without decoding them
+func TestJSONArrayLength(t *testing.T) {
+	testCases := []struct {
+		raw   string
+		count int
+		first string
+	}{
+		{`[]`, 0, ""},
+		{` [ ] `, 0, ""},
+		{`[0.1, 0.2, 0.3]`, 3, "0.1"},
+		{`[[0.1,0.2],[0.3,0.4]]`, 2, "[0.1,0.2]"},
+		{`["a, b", "c\"]", {"k": [1, 2]}]`, 3, `"a, b"`},
+		{`"not a list"`, 0, ""},
+		{``, 0, ""},
+	}
+
+	for _, tc := range testCases {
+		count, first := jsonArrayLength([]byte(tc.raw))
+		if count != tc.count || string(first) != tc.first {
+			t.Errorf("Expected (%d, %q) for %s, got (%d, %q)", tc.count, tc.first, tc.raw, count, first)
+		}
+	}
+}
+
+// TestProxyHandlerEmbedMetrics tests that embed metrics report the inputs sent and the embeddings returned
+func TestProxyHandlerEmbedMetrics(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	testCases := []struct {
+		name       string
+		input      interface{}
+		inputCount int
+	}{
+		{"Single String", "The sky is blue", 1},
+		{"Input List", []string{"The sky is blue", "Grass is green", "Snow is white"}, 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/api/embed", EmbedRequest{Model: "nomic-embed", Input: tc.input}, "test-api-key"))
+			assertResponseStatus(t, rr, http.StatusOK)
+
+			metrics := waitForMetrics(t, received)
+			if metrics.EmbedInputCount != tc.inputCount {
+				t.Errorf("Expected %d inputs, got %d", tc.inputCount, metrics.EmbedInputCount)
+			}
+			// The mock answers every request with one 3-dimensional embedding
+			if metrics.EmbeddingCount != 1 || metrics.EmbeddingDim != 3 {
+				t.Errorf("Expected 1 embedding of 3 dimensions, got %d of %d", metrics.EmbeddingCount, metrics.EmbeddingDim)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerResponseModel tests reporting the model Ollama served instead of the requested alias
+func TestProxyHandlerResponseModel(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(`{"model":"llama3:8b-instruct-q4_K_M","message":{"role":"assistant","content":"Hi"},"done":false}` + "\n"))
+		w.Write([]byte(`{"model":"llama3:8b-instruct-q4_K_M","message":{"role":"assistant","content":""},"done":true,"done_reason":"stop"}` + "\n"))
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+	defer func() { metricsUseResponseModel = false }()
+	resetReverseProxy()
+
+	for _, tc := range []struct {
+		useResponseModel bool
+		expectedModel    string
+	}{
+		{false, "llama3"},
+		{true, "llama3:8b-instruct-q4_K_M"},
+	} {
+		metricsUseResponseModel = tc.useResponseModel
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", map[string]interface{}{
+			"model":    "llama3",
+			"messages": []ChatMessage{{Role: "user", Content: "hi"}},
+		}, "test-api-key"))
+		assertResponseStatus(t, rr, http.StatusOK)
+
+		if metrics := waitForMetrics(t, received); metrics.Model != tc.expectedModel {
+			t.Errorf("Expected model %q with METRICS_USE_RESPONSE_MODEL=%v, got %q", tc.expectedModel, tc.useResponseModel, metrics.Model)
+		}
+	}
+}
+
+// TestProxyHandlerModelAdministration tests that show, delete and copy requests are validated and reported per model
+func TestProxyHandlerModelAdministration(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/show" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"modelfile": "FROM llama3:8b"})
+		}
+	}))
+	defer ollamaServer.Close()
+	validated := make(chan RequestDetails, 1)
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var details RequestDetails
+		json.NewDecoder(r.Body).Decode(&details)
+		validated <- details
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true, Scopes: []string{adminScope}})
+	}))
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	testCases := []struct {
+		method      string
+		path        string
+		body        interface{}
+		destination string
+	}{
+		{"POST", "/api/show", ShowRequest{Model: "llama3:8b"}, ""},
+		{"DELETE", "/api/delete", DeleteRequest{Model: "llama3:8b"}, ""},
+		{"POST", "/api/copy", CopyRequest{Source: "llama3:8b", Destination: "llama3-backup"}, "llama3-backup"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, tc.method, tc.path, tc.body, "test-api-key"))
+			assertResponseStatus(t, rr, http.StatusOK)
+
+			details := <-validated
+			if details.Model != "llama3:8b" {
+				t.Errorf("Expected validation of llama3:8b, got %q", details.Model)
+			}
+			if details.DestinationModel != tc.destination {
+				t.Errorf("Expected destination %q in validation, got %q", tc.destination, details.DestinationModel)
+			}
+			metrics := waitForMetrics(t, received)
+			if metrics.Model != "llama3:8b" || metrics.Endpoint != tc.path || metrics.InputTokenLength != 0 || metrics.OutputTokenLength != 0 {
+				t.Errorf("Expected model metrics without tokens, got %+v", metrics)
+			}
+
+			// Copies record their lineage; the model field stays the source for existing consumers
+			expectedSource := ""
+			if tc.destination != "" {
+				expectedSource = "llama3:8b"
+			}
+			if metrics.SourceModel != expectedSource || metrics.DestinationModel != tc.destination {
+				t.Errorf("Expected source %q and destination %q, got %q and %q", expectedSource, tc.destination, metrics.SourceModel, metrics.DestinationModel)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerCreateMetrics tests that model builds report their outcome and quantization
+func TestProxyHandlerCreateMetrics(t *testing.T) {
+	testCases := []struct {
+		name     string
+		status   int
+		response string
+		expected string
+	}{
+		{
+			name:     "Success",
+			status:   http.StatusOK,
+			response: `{"status":"quantizing F16 model to Q4_K_M"}` + "\n" + `{"status":"writing manifest"}` + "\n" + `{"status":"success"}` + "\n",
+			expected: "success",
+		},
+		{
+			name:     "Error Mid-Stream",
+			status:   http.StatusOK,
+			response: `{"status":"quantizing F16 model to Q4_K_M"}` + "\n" + `{"error":"unsupported quantization type"}` + "\n",
+			expected: "unsupported quantization type",
+		},
+		{
+			name:     "Rejected",
+			status:   http.StatusBadRequest,
+			response: `{"error":"neither 'from' or 'files' was specified"}`,
+			expected: "neither 'from' or 'files' was specified",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/x-ndjson")
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.response))
+			}))
+			defer ollamaServer.Close()
+			validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, Scopes: []string{adminScope}})
+			defer validationServer.Close()
+			metricsServer, received := recordingMetricsServer(t)
+			defer metricsServer.Close()
+
+			useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+			create := CreateRequest{Model: "mario-q4", From: "llama3:8b", Quantize: "q4_K_M"}
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/api/create", create, "test-api-key"))
+			assertResponseStatus(t, rr, tc.status)
+
+			metrics := waitForMetrics(t, received)
+			if metrics.CreateStatus != tc.expected {
+				t.Errorf("Expected create status %q, got %q", tc.expected, metrics.CreateStatus)
+			}
+			if metrics.Quantize != "q4_K_M" || metrics.Model != "mario-q4" {
+				t.Errorf("Expected mario-q4 quantized to q4_K_M, got %q and %q", metrics.Model, metrics.Quantize)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerVersionHeader tests that every hop of a proxied request can tell which proxy version handled it
+func TestProxyHandlerVersionHeader(t *testing.T) {
+	seen := make(chan string, 3)
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- "ollama " + r.Header.Get(version.Header)
+		json.NewEncoder(w).Encode(ChatResponse{Model: "llama2", Done: true})
+	}))
+	defer ollamaServer.Close()
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- "validation " + r.Header.Get(version.Header)
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true})
+	}))
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	chat := ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "Hello"}}}
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", chat, "test-api-key"))
+	assertResponseStatus(t, rr, http.StatusOK)
+
+	if got := rr.Header().Get(version.Header); got != version.String() {
+		t.Errorf("Expected response header %q, got %q", version.String(), got)
+	}
+	for _, expected := range []string{"validation " + version.String(), "ollama " + version.String()} {
+		if got := <-seen; got != expected {
+			t.Errorf("Expected %q, got %q", expected, got)
+		}
+	}
+	if metrics := waitForMetrics(t, received); metrics.ProxyVersion != version.String() {
+		t.Errorf("Expected proxy version %q in metrics, got %q", version.String(), metrics.ProxyVersion)
+	}
+}
+
 // TestResponseWriter tests the custom response writer
 func TestResponseWriter(t *testing.T) {
 	// Create a test response writer
@@ -310,6 +931,67 @@ func TestResponseWriter(t *testing.T) {
 	}
 }
 
+// TestResponseWriterImplicitStatus tests that a body written without WriteHeader is recorded as 200 OK
+func TestResponseWriterImplicitStatus(t *testing.T) {
+	// A response that never writes anything is still logged as 200
+	if status := (&responseWriter{ResponseWriter: httptest.NewRecorder()}).status(); status != http.StatusOK {
+		t.Errorf("Expected status 200 for an empty response, got %d", status)
+	}
+
+	rr := httptest.NewRecorder()
+	rw := &responseWriter{ResponseWriter: rr}
+	rw.Header().Set("Content-Type", "application/x-ndjson")
+	rw.Write([]byte(`{"done":true}` + "\n"))
+
+	if rw.statusCode != http.StatusOK || rr.Code != http.StatusOK {
+		t.Errorf("Expected implicit status 200, got wrapper %d and client %d", rw.statusCode, rr.Code)
+	}
+	if !rw.ndjson {
+		t.Error("Expected content type to be inspected on the implicit header write")
+	}
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+	logger.RequestLog("POST", "/api/chat", "127.0.0.1", rw.status(), time.Millisecond, nil)
+	if !strings.Contains(logs.String(), `"status_code":200`) {
+		t.Errorf("Expected status_code 200 in request log, got %s", logs.String())
+	}
+}
+
+// TestResponseWriterTimeToFirstWrite tests first write timing on the custom response writer
+func TestResponseWriterTimeToFirstWrite(t *testing.T) {
+	rw := &responseWriter{
+		ResponseWriter: httptest.NewRecorder(),
+		body:           &bytes.Buffer{},
+	}
+	start := time.Now()
+
+	if ttft := rw.timeToFirstWrite(start); ttft != 0 {
+		t.Errorf("Expected zero TTFT before any write, got %v", ttft)
+	}
+
+	// Empty writes and header writes do not count as the first token
+	rw.WriteHeader(http.StatusOK)
+	rw.Write(nil)
+	if !rw.firstWriteAt.IsZero() {
+		t.Error("Expected empty write not to record first write time")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	rw.Write([]byte(`{"message":{"content":"Hel"},"done":false}`))
+	first := rw.firstWriteAt
+	time.Sleep(10 * time.Millisecond)
+	rw.Write([]byte(`{"message":{"content":"lo"},"done":true}`))
+
+	if rw.firstWriteAt != first {
+		t.Error("Expected later writes not to move the first write time")
+	}
+	if ttft := rw.timeToFirstWrite(start); ttft < 20*time.Millisecond {
+		t.Errorf("Expected TTFT of at least 20ms, got %v", ttft)
+	}
+}
+
 // TestGetSecureHTTPClient tests the secure HTTP client creation
 func TestGetSecureHTTPClient(t *testing.T) {
 	// Test with default settings
@@ -333,7 +1015,7 @@ func TestValidateRequest(t *testing.T) {
 	defer server.Close()
 
 	// Set validation URL to test server
-	externalValidationURL = server.URL
+	useProxyTargets(t, ollamaURL, server.URL, "")
 
 	// Test valid request
 	details := RequestDetails{
@@ -341,13 +1023,13 @@ func TestValidateRequest(t *testing.T) {
 		IPAddress: "127.0.0.1",
 		Model:     "llama2",
 	}
-	if !validateRequest(details) {
+	if _, ok, _ := validateRequest(context.Background(), details); !ok {
 		t.Error("Expected request to be valid")
 	}
 
 	// Test invalid request (simulate validation server error)
 	server.Close()
-	if validateRequest(details) {
+	if _, ok, _ := validateRequest(context.Background(), details); ok {
 		t.Error("Expected request to be invalid when validation server is down")
 	}
 
@@ -361,7 +1043,7 @@ func TestValidateRequest(t *testing.T) {
 	}))
 	defer server.Close()
 	externalValidationURL = server.URL
-	if validateRequest(details) {
+	if _, ok, _ := validateRequest(context.Background(), details); ok {
 		t.Error("Expected request to be invalid when rate limited")
 	}
 }
@@ -382,7 +1064,7 @@ func TestSendMetrics(t *testing.T) {
 	defer server.Close()
 
 	// Set metrics URL to test server
-	externalMetricsURL = server.URL
+	useProxyTargets(t, ollamaURL, "", server.URL)
 
 	// Test sending metrics
 	metrics := MetricsData{
@@ -438,9 +1120,7 @@ func TestValidateExternalServices(t *testing.T) {
 	defer metricsServer.Close()
 
 	// Set up test environment
-	ollamaURL = ollamaServer.URL
-	externalValidationURL = validationServer.URL
-	externalMetricsURL = metricsServer.URL
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
 	externalServerAPIKey = "test-api-key"
 
 	// Test successful validation
@@ -459,7 +1139,7 @@ func TestValidateExternalServices(t *testing.T) {
 		w.WriteHeader(http.StatusOK)
 	}))
 	defer ollamaServer.Close()
-	ollamaURL = ollamaServer.URL
+	useProxyTargets(t, ollamaServer.URL, "", "")
 	validationServer.Close()
 	if err := validateExternalServices(); err == nil {
 		t.Error("Expected validation error for validation service")
@@ -475,6 +1155,88 @@ func TestValidateExternalServices(t *testing.T) {
 	if err := validateExternalServices(); err == nil {
 		t.Error("Expected validation error for metrics service")
 	}
+
+	// Test minimal mode skips the metrics service
+	metricsEnabled = false
+	defer func() { metricsEnabled = true }()
+	if err := validateExternalServices(); err != nil {
+		t.Errorf("Expected metrics check to be skipped when metrics are disabled, got error: %v", err)
+	}
+}
+
+// TestProxyHandlerMetricsDisabled tests that minimal mode forwards requests without contacting the metrics service
+func TestProxyHandlerMetricsDisabled(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	var metricsCalls atomic.Int64
+	metricsServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	metricsServer.Config.ConnState = func(net.Conn, http.ConnState) { metricsCalls.Add(1) }
+	metricsServer.Start()
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	metricsEnabled = false
+	defer func() { metricsEnabled = true }()
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+
+	for _, path := range []string{"/api/chat", "/api/generate"} {
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, createTestRequest(t, "POST", path, map[string]interface{}{"model": "llama2", "stream": false}, "test-api-key"))
+		assertResponseStatus(t, rr, http.StatusOK)
+		if rr.Body.Len() == 0 {
+			t.Errorf("%s: expected the response to be forwarded", path)
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if calls := metricsCalls.Load(); calls != 0 {
+		t.Errorf("Expected no metrics connections, got %d", calls)
+	}
+	if strings.Contains(logs.String(), "input_tokens") {
+		t.Errorf("Expected token counts to be omitted from logs without capture, got %s", logs.String())
+	}
+}
+
+// BenchmarkProxyHandler compares the request path in full and minimal mode
+func BenchmarkProxyHandler(b *testing.B) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"model":"llama2","response":"Hello","done":true,"prompt_eval_count":5,"eval_count":1}`))
+	}))
+	defer ollamaServer.Close()
+	okServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true})
+	}))
+	defer okServer.Close()
+
+	useProxyTargets(b, ollamaServer.URL, okServer.URL, okServer.URL)
+	logger.SetOutput(io.Discard)
+	defer logger.SetOutput(os.Stdout)
+	defer func() { metricsEnabled = true }()
+
+	body := []byte(`{"model":"llama2","prompt":"hi","stream":false}`)
+	for _, mode := range []struct {
+		name    string
+		enabled bool
+	}{{"Full", true}, {"Minimal", false}} {
+		b.Run(mode.name, func(b *testing.B) {
+			metricsEnabled = mode.enabled
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				req := httptest.NewRequest("POST", "/api/generate", bytes.NewReader(body))
+				req.Header.Set("X-API-Key", "test-api-key")
+				proxyHandler(httptest.NewRecorder(), req)
+			}
+		})
+	}
 }
 
 // TestValidateOllamaService tests the Ollama service validation
@@ -490,7 +1252,7 @@ func TestValidateOllamaService(t *testing.T) {
 	}))
 	defer server.Close()
 
-	ollamaURL = server.URL
+	useProxyTargets(t, server.URL, "", "")
 	if err := validateOllamaService(); err != nil {
 		t.Errorf("Expected successful validation, got error: %v", err)
 	}
@@ -506,12 +1268,50 @@ func TestValidateOllamaService(t *testing.T) {
 		w.WriteHeader(http.StatusInternalServerError)
 	}))
 	defer server.Close()
-	ollamaURL = server.URL
+	useProxyTargets(t, server.URL, "", "")
 	if err := validateOllamaService(); err == nil {
 		t.Error("Expected validation error for non-OK status")
 	}
 }
 
+// TestValidateOllamaServiceLight tests the HEAD health check against the default and a custom path
+func TestValidateOllamaServiceLight(t *testing.T) {
+	var method, path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	useProxyTargets(t, server.URL, "", "")
+	defer func() { ollamaHealthPath = "" }()
+
+	if err := validateOllamaServiceLight(); err != nil {
+		t.Errorf("Expected successful validation, got error: %v", err)
+	}
+	if method != http.MethodHead || path != "/api/tags" {
+		t.Errorf("Expected HEAD /api/tags, got %s %s", method, path)
+	}
+
+	ollamaHealthPath = "/"
+	if err := validateOllamaServiceLight(); err != nil || path != "/" {
+		t.Errorf("Expected a successful check of /, got %v for %s", err, path)
+	}
+
+	ollamaHealthPath = "/down"
+	if err := validateOllamaServiceLight(); err == nil {
+		t.Error("Expected validation error for non-OK status")
+	}
+
+	server.Close()
+	if err := validateOllamaServiceLight(); err == nil {
+		t.Error("Expected validation error")
+	}
+}
+
 // TestValidateExternalValidationService tests the external validation service validation
 func TestValidateExternalValidationService(t *testing.T) {
 	// Test successful validation
@@ -525,7 +1325,7 @@ func TestValidateExternalValidationService(t *testing.T) {
 	}))
 	defer server.Close()
 
-	externalValidationURL = server.URL
+	useProxyTargets(t, ollamaURL, server.URL, "")
 	externalServerAPIKey = "test-api-key"
 	if err := validateExternalValidationService(); err != nil {
 		t.Errorf("Expected successful validation, got error: %v", err)
@@ -561,7 +1361,7 @@ func TestValidateExternalMetricsService(t *testing.T) {
 	}))
 	defer server.Close()
 
-	externalMetricsURL = server.URL
+	useProxyTargets(t, ollamaURL, "", server.URL)
 	externalServerAPIKey = "test-api-key"
 	if err := validateExternalMetricsService(); err != nil {
 		t.Errorf("Expected successful validation, got error: %v", err)
@@ -583,3 +1383,139 @@ func TestValidateExternalMetricsService(t *testing.T) {
 		t.Error("Expected validation error for unauthorized status")
 	}
 }
+
+// TestBodyFingerprint tests the hash and size of request bodies sent for validation
+func TestBodyFingerprint(t *testing.T) {
+	if digest, size := bodyFingerprint([]byte("abc")); digest != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" || size != 3 {
+		t.Errorf("Unexpected fingerprint %s, %d", digest, size)
+	}
+	if digest, size := bodyFingerprint(nil); digest != "" || size != 0 {
+		t.Errorf("Expected no fingerprint for an empty body, got %s, %d", digest, size)
+	}
+}
+
+// TestProxyHandlerBodyFingerprint tests that validation sees the hash of the raw body as the client sent it
+func TestProxyHandlerBodyFingerprint(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	useProxyTargets(t, ollamaServer.URL, "", "")
+
+	var seen RequestDetails
+	useValidator(t, validatorFunc(func(ctx context.Context, details RequestDetails) (ValidationResult, error) {
+		seen = details
+		return ValidationResult{ValidationResponse: ValidationResponse{Valid: true}, Allowed: true}, nil
+	}))
+
+	oldAnswers := retentionAnswers
+	retentionAnswers = newRetentionMemory(defaultRetentionMemorySize)
+	defer func() { retentionAnswers = oldAnswers }()
+
+	// A key's first request isn't fingerprinted, since the answer may make it zero-retention
+	body := []byte(`{"model": "llama2", "messages": [{"role": "user", "content": "Hi"}]}`)
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("POST", "/api/chat", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "test-key")
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, req)
+		assertResponseStatus(t, rr, http.StatusOK)
+		if i == 0 && (seen.BodySHA256 != "" || seen.BodyBytes != len(body)) {
+			t.Errorf("Expected only the body size before the key's retention is known, got %s, %d", seen.BodySHA256, seen.BodyBytes)
+		}
+	}
+
+	sum := sha256.Sum256(body)
+	if seen.BodySHA256 != hex.EncodeToString(sum[:]) || seen.BodyBytes != len(body) {
+		t.Errorf("Expected the raw body's hash and size, got %s, %d", seen.BodySHA256, seen.BodyBytes)
+	}
+
+	// Zero-retention keys send the size but no hash of their prompts, whether configured or marked by validation
+	zeroRetentionKeys = parseKeyList("zr-key")
+	defer func() { zeroRetentionKeys = nil }()
+	retentionAnswers.remember("marked-key", true)
+	for _, key := range []string{"zr-key", "marked-key"} {
+		req := httptest.NewRequest("POST", "/api/chat", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", key)
+		proxyHandler(httptest.NewRecorder(), req)
+		if seen.APIKey != key || seen.BodySHA256 != "" || seen.BodyBytes != len(body) {
+			t.Errorf("Expected only the body size for zero-retention key %s, got %s, %d", key, seen.BodySHA256, seen.BodyBytes)
+		}
+	}
+
+	seen = RequestDetails{}
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "GET", "/api/tags", nil, "test-key"))
+	if seen.APIKey != "test-key" || seen.BodySHA256 != "" || seen.BodyBytes != 0 {
+		t.Errorf("Expected no fingerprint for a request whose body isn't read, got %+v", seen)
+	}
+}
+
+// TestRequestHeaders tests that every header value reaches validation, under canonical names
+func TestRequestHeaders(t *testing.T) {
+	cookies := append(make([]string, 0, 4), "a=1", "b=2")
+	h := http.Header{
+		"X-Forwarded-For": {"203.0.113.7", "10.0.0.1"},
+		"Cookie":          cookies,
+		"x-team":          {"search"},
+	}
+	headers := requestHeaders(h)
+
+	expected := map[string][]string{
+		"X-Forwarded-For": {"203.0.113.7", "10.0.0.1"},
+		"Cookie":          {"a=1", "b=2"},
+		"X-Team":          {"search"},
+	}
+	if !reflect.DeepEqual(headers, expected) {
+		t.Errorf("Expected %v, got %v", expected, headers)
+	}
+
+	// The values are shared with the request, but appending to them copies
+	headers["Cookie"] = append(headers["Cookie"], "c=3")
+	if cookies[:3][2] != "" {
+		t.Error("Expected appending to leave the request's header values alone")
+	}
+
+	merged := requestHeaders(http.Header{"X-Team": {"search"}, "x-team": {"ads"}})
+	if values := merged["X-Team"]; len(values) != 2 || len(merged) != 1 {
+		t.Errorf("Expected differently cased duplicates to merge, got %v", merged)
+	}
+}
+
+// TestValidationPayloadHeaders tests the version 2 payload: a version field and every header value as an array
+func TestValidationPayloadHeaders(t *testing.T) {
+	payloads := make(chan []byte, 1)
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		payloads <- body
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true})
+	}))
+	defer validationServer.Close()
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	oldURL := swapValidationURL(validationServer.URL)
+	defer swapValidationURL(oldURL)
+	useProxyTargets(t, ollamaServer.URL, "", "")
+	useValidator(t, httpValidator{})
+
+	req := createTestRequest(t, "POST", "/api/chat", map[string]interface{}{"model": "llama2"}, "test-key")
+	req.Header.Add("X-Forwarded-For", "203.0.113.7")
+	req.Header.Add("X-Forwarded-For", "10.0.0.1")
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, req)
+	assertResponseStatus(t, rr, http.StatusOK)
+
+	var payload struct {
+		Version int                 `json:"version"`
+		Headers map[string][]string `json:"headers"`
+	}
+	if err := json.Unmarshal(<-payloads, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Version != requestDetailsVersion {
+		t.Errorf("Expected payload version %d, got %d", requestDetailsVersion, payload.Version)
+	}
+	if xff := payload.Headers["X-Forwarded-For"]; !reflect.DeepEqual(xff, []string{"203.0.113.7", "10.0.0.1"}) {
+		t.Errorf("Expected both X-Forwarded-For values in order, got %v", xff)
+	}
+}
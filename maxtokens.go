@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// clampedMaxTokensHeader tells clients their requested output length was lowered to the key's cap
+const clampedMaxTokensHeader = "X-Proxy-Clamped-Max-Tokens"
+
+// clampMaxOutputTokens caps the output length a request asks for at the key's limit, injecting the
+// limit when the request sets none. It returns the body to forward and whether the client asked for more.
+func clampMaxOutputTokens(r *http.Request, body []byte, limit int) ([]byte, bool) {
+	if limit <= 0 {
+		return body, false
+	}
+
+	path := r.URL.Path
+	ollama := strings.HasSuffix(path, "/api/chat") || strings.HasSuffix(path, "/api/generate")
+	openAI := strings.HasSuffix(path, "/v1/chat/completions") || strings.HasSuffix(path, "/v1/completions")
+	if !ollama && !openAI {
+		return body, false
+	}
+
+	exceeded := false
+	rewritten, changed := rewriteJSONBody(body, func(obj map[string]interface{}) bool {
+		if openAI {
+			return clampTokenField(obj, "max_tokens", limit, &exceeded)
+		}
+		options, ok := obj["options"].(map[string]interface{})
+		if !ok {
+			if obj["options"] != nil {
+				// Leave malformed options for Ollama to reject
+				return false
+			}
+			options = make(map[string]interface{})
+			obj["options"] = options
+		}
+		return clampTokenField(options, "num_predict", limit, &exceeded)
+	})
+	if !changed {
+		return body, false
+	}
+
+	setRequestBody(r, rewritten)
+	return rewritten, exceeded
+}
+
+// clampTokenField sets obj[key] to limit when it is missing or asks for more. Negative values,
+// which Ollama reads as unlimited, and anything that isn't a number count as asking for more.
+func clampTokenField(obj map[string]interface{}, key string, limit int, exceeded *bool) bool {
+	value, exists := obj[key]
+	if exists {
+		if n, ok := value.(json.Number); ok {
+			if requested, err := strconv.ParseFloat(n.String(), 64); err == nil && requested >= 0 && requested <= float64(limit) {
+				return false
+			}
+		}
+		*exceeded = true
+	}
+	obj[key] = limit
+	return true
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestClampMaxOutputTokens tests clamping, injecting and passing through the output length of each endpoint
+func TestClampMaxOutputTokens(t *testing.T) {
+	testCases := []struct {
+		name     string
+		path     string
+		body     string
+		expected string
+		exceeded bool
+	}{
+		{"Chat Over Cap", "/api/chat", `{"model":"llama2","options":{"num_predict":4096,"temperature":0.2}}`, `{"model":"llama2","options":{"num_predict":256,"temperature":0.2}}`, true},
+		{"Chat Unlimited", "/api/chat", `{"model":"llama2","options":{"num_predict":-1}}`, `{"model":"llama2","options":{"num_predict":256}}`, true},
+		{"Chat Missing Options", "/api/chat", `{"model":"llama2","stream":false}`, `{"model":"llama2","options":{"num_predict":256},"stream":false}`, false},
+		{"Generate Missing Num Predict", "/api/generate", `{"model":"llama2","options":{"seed":7}}`, `{"model":"llama2","options":{"num_predict":256,"seed":7}}`, false},
+		{"Generate Under Cap", "/api/generate", `{"model":"llama2","options":{"num_predict":100}}`, `{"model":"llama2","options":{"num_predict":100}}`, false},
+		{"Generate At Cap", "/api/generate", `{"model":"llama2","options":{"num_predict":256}}`, `{"model":"llama2","options":{"num_predict":256}}`, false},
+		{"Generate Non-Numeric", "/api/generate", `{"model":"llama2","options":{"num_predict":"9999"}}`, `{"model":"llama2","options":{"num_predict":256}}`, true},
+		{"Malformed Options", "/api/chat", `{"model":"llama2","options":"fast"}`, `{"model":"llama2","options":"fast"}`, false},
+		{"Chat Completion Over Cap", "/v1/chat/completions", `{"model":"llama2","max_tokens":1000,"messages":[]}`, `{"max_tokens":256,"messages":[],"model":"llama2"}`, true},
+		{"Completion Missing", "/v1/completions", `{"model":"llama2","prompt":"hi"}`, `{"max_tokens":256,"model":"llama2","prompt":"hi"}`, false},
+		{"Completion Under Cap", "/v1/completions", `{"model":"llama2","max_tokens":64}`, `{"model":"llama2","max_tokens":64}`, false},
+		{"Embeddings Untouched", "/api/embed", `{"model":"llama2","input":"hi"}`, `{"model":"llama2","input":"hi"}`, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+			body, exceeded := clampMaxOutputTokens(r, []byte(tc.body), 256)
+			if string(body) != tc.expected {
+				t.Errorf("Expected body %s, got %s", tc.expected, body)
+			}
+			if exceeded != tc.exceeded {
+				t.Errorf("Expected exceeded %v, got %v", tc.exceeded, exceeded)
+			}
+			if string(body) != tc.body && r.ContentLength != int64(len(body)) {
+				t.Errorf("Expected content length %d, got %d", len(body), r.ContentLength)
+			}
+			forwarded, _ := io.ReadAll(r.Body)
+			if string(forwarded) != tc.expected {
+				t.Errorf("Expected forwarded body %s, got %s", tc.expected, forwarded)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerMaxOutputTokens tests that Ollama receives the clamped request and clients are told about it
+func TestProxyHandlerMaxOutputTokens(t *testing.T) {
+	var received map[string]interface{}
+	var receivedLength string
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		receivedLength = r.Header.Get("Content-Length")
+		body, _ := io.ReadAll(r.Body)
+		if receivedLength != strconv.Itoa(len(body)) {
+			t.Errorf("Content-Length %s doesn't match the %d byte body", receivedLength, len(body))
+		}
+		received = nil
+		json.Unmarshal(body, &received)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"llama2","response":"hi","done":true}`))
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, MaxOutputTokens: 128})
+	defer validationServer.Close()
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+
+	testCases := []struct {
+		name       string
+		options    map[string]interface{}
+		numPredict float64
+		header     string
+	}{
+		{"Clamped", map[string]interface{}{"num_predict": 1000}, 128, "128"},
+		{"Injected", nil, 128, ""},
+		{"Under Cap", map[string]interface{}{"num_predict": 50}, 50, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/api/generate", GenerateRequest{
+				Model:   "llama2",
+				Prompt:  "hi",
+				Options: tc.options,
+			}, "test-key"))
+			assertResponseStatus(t, rr, http.StatusOK)
+
+			options, _ := received["options"].(map[string]interface{})
+			if options["num_predict"] != tc.numPredict {
+				t.Errorf("Expected num_predict %v, got %v", tc.numPredict, options["num_predict"])
+			}
+			if received["prompt"] != "hi" {
+				t.Errorf("Expected the prompt to be preserved, got %v", received["prompt"])
+			}
+			if got := rr.Header().Get(clampedMaxTokensHeader); got != tc.header {
+				t.Errorf("Expected %s %q, got %q", clampedMaxTokensHeader, tc.header, got)
+			}
+		})
+	}
+}
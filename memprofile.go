@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"time"
+
+	"ollama-proxy/logger"
+)
+
+// Memory profiling configuration
+var (
+	memoryProfileInterval    time.Duration
+	memoryProfileThresholdMB int // 0 disables
+	memoryProfileDir         string
+	memoryProfileMaxFiles    int
+)
+
+// memoryProfileTimeFormat names profiles so they sort oldest first
+const memoryProfileTimeFormat = "20060102T150405.000000000Z"
+
+// memoryProfiler snapshots the heap while it is above a threshold, keeping the most recent profiles
+type memoryProfiler struct {
+	dir            string
+	thresholdBytes uint64
+	maxFiles       int
+
+	// Replaced in tests
+	readMemStats func(*runtime.MemStats)
+	now          func() time.Time
+}
+
+func newMemoryProfiler(dir string, thresholdMB, maxFiles int) *memoryProfiler {
+	return &memoryProfiler{
+		dir:            dir,
+		thresholdBytes: uint64(thresholdMB) << 20,
+		maxFiles:       maxFiles,
+		readMemStats:   runtime.ReadMemStats,
+		now:            time.Now,
+	}
+}
+
+// check samples memory and writes a heap profile when HeapInuse exceeds the threshold, returning its path
+func (p *memoryProfiler) check() (string, error) {
+	var stats runtime.MemStats
+	p.readMemStats(&stats)
+	if stats.HeapInuse <= p.thresholdBytes {
+		return "", nil
+	}
+
+	if err := os.MkdirAll(p.dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create profile directory: %v", err)
+	}
+	path := filepath.Join(p.dir, p.now().UTC().Format(memoryProfileTimeFormat)+".prof")
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create heap profile: %v", err)
+	}
+	defer file.Close()
+	if err := pprof.WriteHeapProfile(file); err != nil {
+		return "", fmt.Errorf("failed to write heap profile: %v", err)
+	}
+
+	logger.Warning("Heap above threshold, wrote profile", map[string]interface{}{
+		"heap_inuse_mb": stats.HeapInuse >> 20,
+		"threshold_mb":  p.thresholdBytes >> 20,
+		"profile":       path,
+	})
+	return path, p.rotate()
+}
+
+// rotate removes the oldest profiles beyond maxFiles
+func (p *memoryProfiler) rotate() error {
+	profiles, err := filepath.Glob(filepath.Join(p.dir, "*.prof"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(profiles)
+	for len(profiles) > p.maxFiles && p.maxFiles > 0 {
+		if err := os.Remove(profiles[0]); err != nil {
+			return err
+		}
+		profiles = profiles[1:]
+	}
+	return nil
+}
+
+// start samples memory every interval until stop is closed
+func (p *memoryProfiler) start(interval time.Duration, stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if _, err := p.check(); err != nil {
+					logger.Error("Memory profiling failed", err, nil)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// TestMemoryProfiler tests that profiles are only written above the threshold and rotated
+func TestMemoryProfiler(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "profiles")
+	heapInuse := uint64(100 << 20)
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	profiler := newMemoryProfiler(dir, 512, 2)
+	profiler.readMemStats = func(stats *runtime.MemStats) { stats.HeapInuse = heapInuse }
+	profiler.now = clock.Now
+
+	if path, err := profiler.check(); path != "" || err != nil {
+		t.Fatalf("Expected no profile below the threshold, got %q, %v", path, err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected no profile directory below the threshold")
+	}
+
+	heapInuse = 600 << 20
+	var written []string
+	for i := 0; i < 3; i++ {
+		path, err := profiler.check()
+		if err != nil {
+			t.Fatalf("Error writing profile: %v", err)
+		}
+		written = append(written, path)
+		clock.Advance(time.Second)
+	}
+
+	if filepath.Base(written[0]) != "20231114T221320.000000000Z.prof" {
+		t.Errorf("Expected a timestamped profile name, got %s", filepath.Base(written[0]))
+	}
+	profiles, _ := filepath.Glob(filepath.Join(dir, "*.prof"))
+	if len(profiles) != 2 || profiles[0] != written[1] || profiles[1] != written[2] {
+		t.Errorf("Expected the 2 newest profiles to be kept, got %v", profiles)
+	}
+
+	// Heap profiles are gzipped protobuf
+	data, err := os.ReadFile(written[2])
+	if err != nil || !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		t.Errorf("Expected a gzipped heap profile, got %d bytes, %v", len(data), err)
+	}
+}
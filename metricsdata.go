@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// modelEndpoints name a model in every valid request, so their metrics are useless without one
+var modelEndpoints = map[string]bool{
+	"chat":     true,
+	"generate": true,
+	"embed":    true,
+	"create":   true,
+	"show":     true,
+	"delete":   true,
+	"copy":     true,
+	"pull":     true,
+	"push":     true,
+}
+
+// validateMetricsData rejects records the metrics service couldn't attribute or would miscount.
+// Durations may be 0, since requests faster than a millisecond round down to it.
+func validateMetricsData(m MetricsData) error {
+	if m.Endpoint == "" {
+		return errors.New("missing endpoint")
+	}
+	if m.APIKey == "" && m.KeySource != "public" {
+		return errors.New("missing API key")
+	}
+	if m.Model == "" && requestNamesModel(m.Endpoint) {
+		return fmt.Errorf("missing model for %s", m.Endpoint)
+	}
+	if m.InputTokenLength < 0 || m.OutputTokenLength < 0 {
+		return fmt.Errorf("negative token count (input %d, output %d)", m.InputTokenLength, m.OutputTokenLength)
+	}
+	if m.RequestDurationMs < 0 {
+		return fmt.Errorf("negative duration %dms", m.RequestDurationMs)
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"ollama-proxy/logger"
+)
+
+// TestValidateMetricsData tests which records are rejected before sending
+func TestValidateMetricsData(t *testing.T) {
+	valid := MetricsData{APIKey: "test-api-key", Endpoint: "/api/chat", Model: "llama2", InputTokenLength: 10, OutputTokenLength: 5, RequestDurationMs: 120}
+
+	testCases := []struct {
+		name    string
+		modify  func(m *MetricsData)
+		wantErr string
+	}{
+		{"Valid", func(m *MetricsData) {}, ""},
+		{"Sub-Millisecond Duration", func(m *MetricsData) { m.RequestDurationMs = 0 }, ""},
+		{"Missing Endpoint", func(m *MetricsData) { m.Endpoint = "" }, "missing endpoint"},
+		{"Missing API Key", func(m *MetricsData) { m.APIKey = "" }, "missing API key"},
+		{"Public Endpoint Without Key", func(m *MetricsData) { m.APIKey = ""; m.KeySource = "public" }, ""},
+		{"Missing Model", func(m *MetricsData) { m.Model = "" }, "missing model"},
+		{"Model Not Needed", func(m *MetricsData) { m.Model = ""; m.Endpoint = "/api/tags" }, ""},
+		{"Negative Input Tokens", func(m *MetricsData) { m.InputTokenLength = -1 }, "negative token count"},
+		{"Negative Output Tokens", func(m *MetricsData) { m.OutputTokenLength = -3 }, "negative token count"},
+		{"Negative Duration", func(m *MetricsData) { m.RequestDurationMs = -1 }, "negative duration"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := valid
+			tc.modify(&m)
+			err := validateMetricsData(m)
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestSendMetricsSkipsInvalid tests that invalid records are logged and never reach the metrics service
+func TestSendMetricsSkipsInvalid(t *testing.T) {
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+
+	sendMetricsTo(metricsServer.URL, "test-request-id", MetricsData{APIKey: "test-api-key", Endpoint: "/api/generate"})
+
+	select {
+	case metrics := <-received:
+		t.Fatalf("Expected no metrics, got %+v", metrics)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if !strings.Contains(logs.String(), `"message":"Skipping invalid metrics"`) {
+		t.Errorf("Expected a skip warning, got %s", logs.String())
+	}
+}
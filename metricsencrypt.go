@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+)
+
+// Metrics encryption configuration
+var (
+	metricsEncrypt             bool
+	metricsEncryptPublicKeyPEM string
+	metricsRecipient           *metricsRecipientKey
+)
+
+const (
+	metricsEncryptionRSA  = "rsa-oaep-sha256+aes-256-gcm"
+	metricsEncryptionECDH = "ecdh-hkdf-sha256+aes-256-gcm"
+
+	// metricsECDHInfo binds keys derived for metrics to this use
+	metricsECDHInfo = "ollama-proxy metrics key wrap"
+)
+
+// metricsRecipientKey is the metrics service's public key; exactly one of rsa and ecdh is set
+type metricsRecipientKey struct {
+	rsa  *rsa.PublicKey
+	ecdh *ecdh.PublicKey
+}
+
+// parseMetricsRecipientKey parses a PEM-encoded PKIX public key. ECDSA keys are used for ECDH on the same curve.
+func parseMetricsRecipientKey(data string) (*metricsRecipientKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("METRICS_ENCRYPT_PUBLIC_KEY is not PEM encoded")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse METRICS_ENCRYPT_PUBLIC_KEY: %v", err)
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("METRICS_ENCRYPT_PUBLIC_KEY RSA key must be at least 2048 bits, got %d", key.N.BitLen())
+		}
+		return &metricsRecipientKey{rsa: key}, nil
+	case *ecdh.PublicKey:
+		return &metricsRecipientKey{ecdh: key}, nil
+	case *ecdsa.PublicKey:
+		ecdhKey, err := key.ECDH()
+		if err != nil {
+			return nil, fmt.Errorf("METRICS_ENCRYPT_PUBLIC_KEY: %v", err)
+		}
+		return &metricsRecipientKey{ecdh: ecdhKey}, nil
+	default:
+		return nil, fmt.Errorf("METRICS_ENCRYPT_PUBLIC_KEY must be an RSA or ECDH public key, got %T", key)
+	}
+}
+
+// loadMetricsEncryption parses the recipient key when METRICS_ENCRYPT is on
+func loadMetricsEncryption() error {
+	metricsRecipient = nil
+	if !metricsEncrypt {
+		return nil
+	}
+	if metricsEncryptPublicKeyPEM == "" {
+		return fmt.Errorf("METRICS_ENCRYPT requires METRICS_ENCRYPT_PUBLIC_KEY")
+	}
+	key, err := parseMetricsRecipientKey(metricsEncryptPublicKeyPEM)
+	if err != nil {
+		return err
+	}
+	metricsRecipient = key
+	return nil
+}
+
+// scheme names the encryption scheme, sent as X-Metrics-Encryption
+func (k *metricsRecipientKey) scheme() string {
+	if k.rsa != nil {
+		return metricsEncryptionRSA
+	}
+	return metricsEncryptionECDH
+}
+
+// encrypt seals plaintext under a fresh AES-256 key and wraps that key for the recipient. The payload is
+// the wrapped key with a 2-byte big-endian length prefix, then the 12-byte GCM nonce and the ciphertext.
+//
+// For RSA the wrapped key is the RSA-OAEP (SHA-256) encryption of the AES key. For ECDH it is an ephemeral
+// public key followed by the AES key sealed with AES-GCM under HKDF-SHA256 of the shared secret.
+func (k *metricsRecipientKey) encrypt(plaintext []byte) ([]byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	var wrappedKey []byte
+	var err error
+	if k.rsa != nil {
+		wrappedKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, k.rsa, dataKey, nil)
+	} else {
+		wrappedKey, err = k.wrapECDH(dataKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap metrics key: %v", err)
+	}
+
+	sealed, err := sealAESGCM(dataKey, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(wrappedKey)+len(sealed)), uint16(len(wrappedKey)))
+	payload = append(payload, wrappedKey...)
+	return append(payload, sealed...), nil
+}
+
+func (k *metricsRecipientKey) wrapECDH(dataKey []byte) ([]byte, error) {
+	ephemeral, err := k.ecdh.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := ephemeral.ECDH(k.ecdh)
+	if err != nil {
+		return nil, err
+	}
+	ephemeralPublic := ephemeral.PublicKey().Bytes()
+	kek, err := hkdf.Key(sha256.New, shared, ephemeralPublic, metricsECDHInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+	sealedKey, err := sealAESGCM(kek, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(ephemeralPublic, sealedKey...), nil
+}
+
+// sealAESGCM encrypts plaintext under key, returning the random nonce followed by the ciphertext
+func sealAESGCM(key, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// publicKeyPEM encodes a public key as METRICS_ENCRYPT_PUBLIC_KEY expects it
+func publicKeyPEM(t *testing.T, key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("Error marshaling public key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// openAESGCM reverses sealAESGCM
+func openAESGCM(t *testing.T, key, sealed []byte) []byte {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatalf("Error creating cipher: %v", err)
+	}
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		t.Fatalf("Error opening ciphertext: %v", err)
+	}
+	return plaintext
+}
+
+// decryptMetrics decrypts a payload the way the metrics service does
+func decryptMetrics(t *testing.T, privateKey crypto.PrivateKey, payload []byte) []byte {
+	keyLen := int(binary.BigEndian.Uint16(payload))
+	wrappedKey, sealed := payload[2:2+keyLen], payload[2+keyLen:]
+
+	var dataKey []byte
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if dataKey, err = rsa.DecryptOAEP(sha256.New(), nil, key, wrappedKey, nil); err != nil {
+			t.Fatalf("Error unwrapping key: %v", err)
+		}
+	case *ecdh.PrivateKey:
+		pointLen := len(key.PublicKey().Bytes())
+		ephemeral, err := key.Curve().NewPublicKey(wrappedKey[:pointLen])
+		if err != nil {
+			t.Fatalf("Error parsing ephemeral key: %v", err)
+		}
+		shared, _ := key.ECDH(ephemeral)
+		kek, _ := hkdf.Key(sha256.New, shared, wrappedKey[:pointLen], metricsECDHInfo, 32)
+		dataKey = openAESGCM(t, kek, wrappedKey[pointLen:])
+	}
+	return openAESGCM(t, dataKey, sealed)
+}
+
+// TestSendMetricsEncrypted tests that each supported key type produces a payload the metrics service can decrypt
+func TestSendMetricsEncrypted(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecdsaECDH, _ := ecdsaKey.ECDH()
+	x25519Key, _ := ecdh.X25519().GenerateKey(rand.Reader)
+
+	testCases := []struct {
+		name       string
+		publicKey  crypto.PublicKey
+		privateKey crypto.PrivateKey
+		scheme     string
+	}{
+		{"RSA", &rsaKey.PublicKey, rsaKey, metricsEncryptionRSA},
+		{"ECDSA P-256", &ecdsaKey.PublicKey, ecdsaECDH, metricsEncryptionECDH},
+		{"X25519", x25519Key.PublicKey(), x25519Key, metricsEncryptionECDH},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			type request struct {
+				header http.Header
+				body   []byte
+			}
+			received := make(chan request, 1)
+			metricsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				received <- request{r.Header, body}
+			}))
+			defer metricsServer.Close()
+
+			metricsEncrypt = true
+			metricsEncryptPublicKeyPEM = publicKeyPEM(t, tc.publicKey)
+			defer func() {
+				metricsEncrypt = false
+				metricsRecipient = nil
+			}()
+			if err := loadMetricsEncryption(); err != nil {
+				t.Fatalf("Error loading key: %v", err)
+			}
+
+			sendMetricsTo(metricsServer.URL, "test-request-id", MetricsData{APIKey: "secret-key", Model: "llama3", Endpoint: "/api/chat", Tags: map[string]string{}})
+
+			var req request
+			select {
+			case req = <-received:
+			case <-time.After(2 * time.Second):
+				t.Fatal("Timed out waiting for metrics")
+			}
+			if req.header.Get("Content-Type") != "application/octet-stream" || req.header.Get("X-Metrics-Encryption") != tc.scheme {
+				t.Errorf("Expected encrypted payload headers for %s, got %v", tc.scheme, req.header)
+			}
+			if strings.Contains(string(req.body), "secret-key") {
+				t.Error("Expected the API key not to appear in the encrypted payload")
+			}
+
+			var metrics MetricsData
+			if err := json.Unmarshal(decryptMetrics(t, tc.privateKey, req.body), &metrics); err != nil {
+				t.Fatalf("Error decoding decrypted metrics: %v", err)
+			}
+			if metrics.APIKey != "secret-key" || metrics.Model != "llama3" {
+				t.Errorf("Expected the original metrics after decryption, got %+v", metrics)
+			}
+		})
+	}
+}
+
+// TestLoadMetricsEncryptionInvalid tests that unusable keys are rejected at startup
+func TestLoadMetricsEncryptionInvalid(t *testing.T) {
+	smallKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	defer func() {
+		metricsEncrypt = false
+		metricsEncryptPublicKeyPEM = ""
+		metricsRecipient = nil
+	}()
+
+	testCases := []struct {
+		key      string
+		expected string
+	}{
+		{"", "METRICS_ENCRYPT requires METRICS_ENCRYPT_PUBLIC_KEY"},
+		{"not a key", "not PEM encoded"},
+		{"-----BEGIN PUBLIC KEY-----\nAAAA\n-----END PUBLIC KEY-----\n", "failed to parse METRICS_ENCRYPT_PUBLIC_KEY"},
+		{publicKeyPEM(t, &smallKey.PublicKey), "must be at least 2048 bits, got 1024"},
+	}
+
+	metricsEncrypt = true
+	for _, tc := range testCases {
+		metricsEncryptPublicKeyPEM = tc.key
+		if err := loadMetricsEncryption(); err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("%q: expected error containing %q, got %v", tc.key, tc.expected, err)
+		}
+	}
+
+	metricsEncrypt = false
+	if err := loadMetricsEncryption(); err != nil || metricsRecipient != nil {
+		t.Errorf("Expected encryption off without METRICS_ENCRYPT, got %v", err)
+	}
+}
@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"os"
+	"time"
+
+	"ollama-proxy/signing"
 )
 
 // ValidationResponse represents the response from the validation service
@@ -15,12 +21,23 @@ type ValidationResponse struct {
 
 // RequestDetails represents the request details sent to the validation service
 type RequestDetails struct {
-	APIKey    string            `json:"apiKey"`
-	IPAddress string            `json:"ipAddress"`
-	UserAgent string            `json:"userAgent"`
-	Headers   map[string]string `json:"headers"`
-	Endpoint  string            `json:"endpoint"`
-	Model     string            `json:"model"`
+	Version   int                 `json:"version"`
+	APIKey    string              `json:"apiKey"`
+	IPAddress string              `json:"ipAddress"`
+	UserAgent string              `json:"userAgent"`
+	Headers   map[string][]string `json:"headers"`
+	Endpoint  string              `json:"endpoint"`
+	Model     string              `json:"model"`
+}
+
+// BatchValidationRequest represents a batch of request details sent to the validation service
+type BatchValidationRequest struct {
+	Requests []RequestDetails `json:"requests"`
+}
+
+// BatchValidationResponse represents the responses to a batch validation request
+type BatchValidationResponse struct {
+	Responses []ValidationResponse `json:"responses"`
 }
 
 // MetricsData represents the metrics data sent to the metrics service
@@ -37,13 +54,58 @@ var (
 	mainAPIKey        = "main-api-key"
 	validAPIKey       = "test-api-key"
 	rateLimitedAPIKey = "rate-limited-key"
+
+	// Request signing, verified when EXTERNAL_SERVER_HMAC_SECRET is set
+	hmacSecret  = os.Getenv("EXTERNAL_SERVER_HMAC_SECRET")
+	hmacMaxSkew = 5 * time.Minute
 )
 
+// authorized checks the API key and, when a signing secret is configured, the request signature.
+// The body is read for verification and replaced so handlers can still decode it.
+func authorized(r *http.Request) bool {
+	if r.Header.Get("X-API-Key") != mainAPIKey {
+		return false
+	}
+	if hmacSecret == "" {
+		return true
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return false
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err := signing.Verify(r.Header, hmacSecret, body, hmacMaxSkew, time.Now()); err != nil {
+		log.Printf("Rejected %s %s: %v", r.Method, r.URL.Path, err)
+		return false
+	}
+	return true
+}
+
+// validateDetails applies the mock validation rules to a single request
+func validateDetails(details RequestDetails) ValidationResponse {
+	response := ValidationResponse{
+		Valid:       false,
+		RateLimited: false,
+	}
+
+	if details.APIKey == validAPIKey {
+		response.Valid = true
+	}
+
+	// Simulate rate limiting for specific API keys
+	if details.APIKey == rateLimitedAPIKey {
+		response.RateLimited = true
+	}
+
+	return response
+}
+
 func startMockService() {
 	// Validation endpoint handler
 	http.HandleFunc("/validate", func(w http.ResponseWriter, r *http.Request) {
-		// Check API key
-		if r.Header.Get("X-API-Key") != mainAPIKey {
+		// Check API key and signature
+		if !authorized(r) {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -56,29 +118,28 @@ func startMockService() {
 
 		// Handle POST request (validation)
 		if r.Method == http.MethodPost {
-			var details RequestDetails
-			if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+			var payload struct {
+				RequestDetails
+				Requests []RequestDetails `json:"requests"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 				http.Error(w, "Invalid request body", http.StatusBadRequest)
 				return
 			}
 
-			// Simple validation logic
-			response := ValidationResponse{
-				Valid:       false,
-				RateLimited: false,
-			}
-
-			if details.APIKey == validAPIKey {
-				response.Valid = true
-			}
+			w.Header().Set("Content-Type", "application/json")
 
-			// Simulate rate limiting for specific API keys
-			if details.APIKey == rateLimitedAPIKey {
-				response.RateLimited = true
+			// Batch payloads carry a list of requests
+			if payload.Requests != nil {
+				var response BatchValidationResponse
+				for _, details := range payload.Requests {
+					response.Responses = append(response.Responses, validateDetails(details))
+				}
+				json.NewEncoder(w).Encode(response)
+				return
 			}
 
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
+			json.NewEncoder(w).Encode(validateDetails(payload.RequestDetails))
 			return
 		}
 
@@ -87,8 +148,8 @@ func startMockService() {
 
 	// Metrics endpoint handler
 	http.HandleFunc("/log_metrics", func(w http.ResponseWriter, r *http.Request) {
-		// Check API key
-		if r.Header.Get("X-API-Key") != mainAPIKey {
+		// Check API key and signature
+		if !authorized(r) {
 			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
@@ -125,5 +186,12 @@ func startMockService() {
 }
 
 func main() {
+	if skew := os.Getenv("EXTERNAL_SERVER_HMAC_MAX_SKEW"); skew != "" {
+		d, err := time.ParseDuration(skew)
+		if err != nil {
+			log.Fatalf("Invalid EXTERNAL_SERVER_HMAC_MAX_SKEW: %v", err)
+		}
+		hmacMaxSkew = d
+	}
 	startMockService()
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// modelAllowed reports whether a model name is in a key's allowed models, ignoring case. An allowed name
+// without a tag allows every tag of that model, an untagged model name is the same model as name:latest,
+// and an allowed name ending in * allows every model starting with the rest, e.g. llama3:* or llama*.
+func modelAllowed(allowed []string, model string) bool {
+	model = strings.ToLower(model)
+	base, tag, tagged := strings.Cut(model, ":")
+	for _, name := range allowed {
+		name = strings.ToLower(name)
+		if prefix, glob := strings.CutSuffix(name, "*"); glob {
+			if strings.HasPrefix(model, prefix) || (!tagged && strings.HasPrefix(model+":latest", prefix)) {
+				return true
+			}
+			continue
+		}
+		switch {
+		case name == model:
+			return true
+		case !strings.Contains(name, ":") && name == base:
+			return true
+		case !tagged && name == model+":latest":
+			return true
+		case tagged && tag == "latest" && name == base:
+			return true
+		}
+	}
+	return false
+}
+
+// listsModels reports whether the path returns a model list that is filtered by allowed models
+func listsModels(path string) bool {
+	return strings.HasSuffix(path, "/api/tags") || strings.HasSuffix(path, "/api/ps") || strings.HasSuffix(path, "/v1/models")
+}
+
+// modelListField names the array a model list response keeps its entries in: OpenAI's /v1/models
+// uses data, Ollama's own lists use models
+func modelListField(path string) string {
+	if strings.HasSuffix(path, "/v1/models") {
+		return "data"
+	}
+	return "models"
+}
+
+// filterModelList keeps the entries of a model list response's array that the key may use, leaving
+// every other field as Ollama sent it. OpenAI entries name the model in id, Ollama's in name and model.
+func filterModelList(path string, body []byte, allowed []string) ([]byte, error) {
+	var list map[string]json.RawMessage
+	if err := json.Unmarshal(body, &list); err != nil {
+		return nil, err
+	}
+	field := modelListField(path)
+	var models []json.RawMessage
+	if err := json.Unmarshal(list[field], &models); err != nil {
+		return nil, err
+	}
+
+	kept := make([]json.RawMessage, 0, len(models))
+	for _, raw := range models {
+		var entry struct {
+			ID    string `json:"id"`
+			Name  string `json:"name"`
+			Model string `json:"model"`
+		}
+		json.Unmarshal(raw, &entry)
+		if modelAllowed(allowed, entry.Name) || modelAllowed(allowed, entry.Model) || modelAllowed(allowed, entry.ID) {
+			kept = append(kept, raw)
+		}
+	}
+
+	list[field], _ = json.Marshal(kept)
+	return json.Marshal(list)
+}
+
+// modelListFilterWriter holds back a successful model list response so it can be filtered to the
+// key's allowed models before the client sees it
+type modelListFilterWriter struct {
+	http.ResponseWriter
+	path        string
+	allowed     []string
+	wroteHeader bool
+	filtering   bool
+	statusCode  int
+	upstream    bytes.Buffer
+}
+
+func newModelListFilterWriter(w http.ResponseWriter, path string, allowed []string) *modelListFilterWriter {
+	return &modelListFilterWriter{ResponseWriter: w, path: path, allowed: allowed}
+}
+
+func (fw *modelListFilterWriter) WriteHeader(statusCode int) {
+	fw.wroteHeader = true
+	if statusCode == http.StatusOK {
+		fw.filtering = true
+		fw.statusCode = statusCode
+		return
+	}
+	fw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (fw *modelListFilterWriter) Write(b []byte) (int, error) {
+	if !fw.wroteHeader {
+		fw.WriteHeader(http.StatusOK)
+	}
+	if fw.filtering {
+		return fw.upstream.Write(b)
+	}
+	return fw.ResponseWriter.Write(b)
+}
+
+// finish writes the filtered list with its recomputed length. A list that can't be parsed, including
+// one cut short, is never passed through since it could name models the key may not see.
+func (fw *modelListFilterWriter) finish() {
+	if !fw.filtering {
+		return
+	}
+	body, err := filterModelList(fw.path, fw.upstream.Bytes(), fw.allowed)
+	if err != nil {
+		body, _ = json.Marshal(ErrorResponse{
+			Error: "invalid model list from Ollama",
+			Code:  "invalid_upstream_response",
+		})
+		fw.statusCode = http.StatusBadGateway
+		fw.Header().Set("Content-Type", "application/json")
+	}
+	fw.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	fw.ResponseWriter.WriteHeader(fw.statusCode)
+	fw.ResponseWriter.Write(body)
+}
+
+// Flush is a no-op while filtering, since the list is only written once complete
+func (fw *modelListFilterWriter) Flush() {
+	if !fw.filtering {
+		http.NewResponseController(fw.ResponseWriter).Flush()
+	}
+}
+
+func (fw *modelListFilterWriter) Unwrap() http.ResponseWriter {
+	return fw.ResponseWriter
+}
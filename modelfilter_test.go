@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestModelAllowed tests matching model names with and without tags
+func TestModelAllowed(t *testing.T) {
+	allowed := []string{"llama3", "mistral:7b", "phi3:latest"}
+	testCases := []struct {
+		model    string
+		expected bool
+	}{
+		{"llama3", true},
+		{"llama3:latest", true},
+		{"llama3:70b", true},
+		{"mistral:7b", true},
+		{"mistral", false},
+		{"mistral:latest", false},
+		{"phi3", true},
+		{"phi3:mini", false},
+		{"llama", false},
+		{"", false},
+	}
+
+	for _, tc := range testCases {
+		if got := modelAllowed(allowed, tc.model); got != tc.expected {
+			t.Errorf("%q: expected %v, got %v", tc.model, tc.expected, got)
+		}
+	}
+}
+
+// TestModelAllowedGlobs tests glob suffixes and case-insensitive matching
+func TestModelAllowedGlobs(t *testing.T) {
+	allowed := []string{"llama3:*", "Mistral", "qwen*"}
+	testCases := []struct {
+		model    string
+		expected bool
+	}{
+		{"llama3:70b", true},
+		{"llama3:latest", true},
+		{"llama3", true},
+		{"LLaMA3:8B", true},
+		{"llama3.1:8b", false},
+		{"llama", false},
+		{"mistral:7b", true},
+		{"MISTRAL", true},
+		{"qwen2.5:14b", true},
+		{"qwen", true},
+		{"phi3", false},
+	}
+
+	for _, tc := range testCases {
+		if got := modelAllowed(allowed, tc.model); got != tc.expected {
+			t.Errorf("%q: expected %v, got %v", tc.model, tc.expected, got)
+		}
+	}
+}
+
+const testTagsResponse = `{"models":[` +
+	`{"name":"llama3:latest","model":"llama3:latest","size":4661224676,"details":{"family":"llama"}},` +
+	`{"name":"llama3:70b","model":"llama3:70b","size":39969745349,"details":{"family":"llama"}},` +
+	`{"name":"mistral:7b","model":"mistral:7b","size":4109865159,"details":{"family":"llama"}},` +
+	`{"name":"internal-finetune:latest","model":"internal-finetune:latest","size":4661224676,"details":{"family":"llama"}}` +
+	`]}`
+
+// TestProxyHandlerTagsFiltering tests that /api/tags only lists the key's allowed models
+func TestProxyHandlerTagsFiltering(t *testing.T) {
+	upstreamBody := testTagsResponse
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Content-Length", strconv.Itoa(len(upstreamBody)))
+		w.Write([]byte(upstreamBody))
+	}))
+	defer ollamaServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, "", "")
+
+	testCases := []struct {
+		name     string
+		allowed  []string
+		upstream string
+		status   int
+		expected []string
+	}{
+		{
+			name:     "Filtered To Allowed Models",
+			allowed:  []string{"llama3", "mistral:7b"},
+			upstream: testTagsResponse,
+			status:   http.StatusOK,
+			expected: []string{"llama3:latest", "llama3:70b", "mistral:7b"},
+		},
+		{
+			name:     "No Allowed Models Installed",
+			allowed:  []string{"gemma2"},
+			upstream: testTagsResponse,
+			status:   http.StatusOK,
+			expected: []string{},
+		},
+		{
+			name:     "Absent Passes Through",
+			allowed:  nil,
+			upstream: testTagsResponse,
+			status:   http.StatusOK,
+		},
+		{
+			name:     "Unparseable List Withheld",
+			allowed:  []string{"llama3"},
+			upstream: `{"models":[{"name":"internal-finetune:latest"`,
+			status:   http.StatusBadGateway,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			upstreamBody = tc.upstream
+			validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, AllowedModels: tc.allowed})
+			defer validationServer.Close()
+			externalValidationURL = validationServer.URL
+
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "GET", "/api/tags", nil, "test-key"))
+			assertResponseStatus(t, rr, tc.status)
+
+			if got := rr.Header().Get("Content-Length"); got != strconv.Itoa(rr.Body.Len()) {
+				t.Errorf("Expected Content-Length %d, got %s", rr.Body.Len(), got)
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+			if tc.allowed == nil {
+				if rr.Body.String() != testTagsResponse {
+					t.Errorf("Expected the upstream list unmodified, got %s", rr.Body.String())
+				}
+				return
+			}
+
+			var resp struct {
+				Models []struct {
+					Name    string `json:"name"`
+					Size    int64  `json:"size"`
+					Details struct {
+						Family string `json:"family"`
+					} `json:"details"`
+				} `json:"models"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Expected valid tags JSON, got %v", err)
+			}
+			if resp.Models == nil {
+				t.Fatal("Expected a models array")
+			}
+			names := []string{}
+			for _, model := range resp.Models {
+				names = append(names, model.Name)
+				if model.Size == 0 || model.Details.Family != "llama" {
+					t.Errorf("Expected %s to keep its fields, got %+v", model.Name, model)
+				}
+			}
+			if len(names) != len(tc.expected) {
+				t.Fatalf("Expected models %v, got %v", tc.expected, names)
+			}
+			for i := range names {
+				if names[i] != tc.expected[i] {
+					t.Errorf("Expected models %v, got %v", tc.expected, names)
+				}
+			}
+		})
+	}
+}
+
+const testPsResponse = `{"models":[` +
+	`{"name":"llama3:latest","model":"llama3:latest","size":5137025024,"digest":"365c0bd3c000","expires_at":"2024-06-04T14:38:31.83753-07:00","size_vram":5137025024},` +
+	`{"name":"internal-finetune:latest","model":"internal-finetune:latest","size":5137025024,"digest":"8f3c1e2a9b11","expires_at":"2024-06-04T14:40:02.10442-07:00","size_vram":5137025024},` +
+	`{"name":"mistral:7b","model":"mistral:7b","size":4109865159,"digest":"f974a74358d6","expires_at":"2024-06-04T14:41:12.50113-07:00","size_vram":4109865159}` +
+	`]}`
+
+// TestProxyHandlerPsFiltering tests that /api/ps hides loaded models the key can't use
+func TestProxyHandlerPsFiltering(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/ps" {
+			t.Errorf("Expected /api/ps, got %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write([]byte(testPsResponse))
+	}))
+	defer ollamaServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, "", "")
+
+	testCases := []struct {
+		name     string
+		allowed  []string
+		expected []string
+	}{
+		{"One Allowed Model Loaded", []string{"llama3"}, []string{"llama3:latest"}},
+		{"No Allowed Models Loaded", []string{"gemma2"}, []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, AllowedModels: tc.allowed})
+			defer validationServer.Close()
+			externalValidationURL = validationServer.URL
+
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "GET", "/api/ps", nil, "test-key"))
+			assertResponseStatus(t, rr, http.StatusOK)
+
+			var resp struct {
+				Models []struct {
+					Name     string `json:"name"`
+					SizeVRAM int64  `json:"size_vram"`
+				} `json:"models"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Expected valid ps JSON, got %v", err)
+			}
+			if resp.Models == nil {
+				t.Fatalf("Expected a models array, got %s", rr.Body.String())
+			}
+			if len(resp.Models) != len(tc.expected) {
+				t.Fatalf("Expected models %v, got %s", tc.expected, rr.Body.String())
+			}
+			for i, model := range resp.Models {
+				if model.Name != tc.expected[i] || model.SizeVRAM == 0 {
+					t.Errorf("Expected %s with its VRAM usage, got %+v", tc.expected[i], model)
+				}
+			}
+		})
+	}
+}
+
+const testOpenAIModelsResponse = `{"object":"list","data":[` +
+	`{"id":"llama3:latest","object":"model","created":1700000000,"owned_by":"library"},` +
+	`{"id":"mistral:7b","object":"model","created":1700000000,"owned_by":"library"},` +
+	`{"id":"nomic-embed-text:latest","object":"model","created":1700000000,"owned_by":"library"}]}`
+
+// TestProxyHandlerOpenAIModelsFiltering tests that /v1/models only lists the key's allowed models
+func TestProxyHandlerOpenAIModelsFiltering(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			t.Errorf("Expected /v1/models, got %s", r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(testOpenAIModelsResponse))
+	}))
+	defer ollamaServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, "", "")
+
+	testCases := []struct {
+		name     string
+		allowed  []string
+		expected []string
+	}{
+		{"No Allow List", nil, []string{"llama3:latest", "mistral:7b", "nomic-embed-text:latest"}},
+		{"Untagged Name Matches Latest", []string{"llama3"}, []string{"llama3:latest"}},
+		{"Exact Tags", []string{"nomic-embed-text:latest", "mistral:7b"}, []string{"mistral:7b", "nomic-embed-text:latest"}},
+		{"Nothing Allowed", []string{"gemma2"}, []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, AllowedModels: tc.allowed})
+			defer validationServer.Close()
+			externalValidationURL = validationServer.URL
+
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "GET", "/v1/models", nil, "test-key"))
+			assertResponseStatus(t, rr, http.StatusOK)
+
+			var resp struct {
+				Object string `json:"object"`
+				Data   []struct {
+					ID      string `json:"id"`
+					OwnedBy string `json:"owned_by"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Expected valid models JSON, got %v", err)
+			}
+			if resp.Object != "list" || resp.Data == nil || len(resp.Data) != len(tc.expected) {
+				t.Fatalf("Expected models %v, got %s", tc.expected, rr.Body.String())
+			}
+			for i, model := range resp.Data {
+				if model.ID != tc.expected[i] || model.OwnedBy != "library" {
+					t.Errorf("Expected %s as Ollama listed it, got %+v", tc.expected[i], model)
+				}
+			}
+		})
+	}
+}
+
+// TestProxyHandlerAllowedModels tests that requests naming a model outside the key's list are refused
+func TestProxyHandlerAllowedModels(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, AllowedModels: []string{"llama3:*", "mistral"}})
+	defer validationServer.Close()
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+
+	testCases := []struct {
+		name           string
+		model          string
+		expectedStatus int
+	}{
+		{"Glob Match", "llama3:8b", http.StatusOK},
+		{"Case Insensitive", "Mistral", http.StatusOK},
+		{"Not Allowed", "phi3", http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", ChatRequest{Model: tc.model, Messages: []ChatMessage{{Role: "user", Content: "Hi"}}}, "test-api-key"))
+			assertResponseStatus(t, rr, tc.expectedStatus)
+			if tc.expectedStatus != http.StatusForbidden {
+				return
+			}
+
+			var resp ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatalf("Expected a JSON error: %v", err)
+			}
+			if resp.Code != "model_not_allowed" || !strings.Contains(resp.Error, tc.model) {
+				t.Errorf("Expected a model_not_allowed error naming %s, got %+v", tc.model, resp)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerAllowedModelsCopyDestination tests that a copy can't create a model the key may not use
+func TestProxyHandlerAllowedModelsCopyDestination(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, AllowedModels: []string{"llama3:*"}})
+	defer validationServer.Close()
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/copy", map[string]string{"source": "llama3:8b", "destination": "mistral"}, "test-api-key"))
+	assertResponseStatus(t, rr, http.StatusForbidden)
+
+	var resp ErrorResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("Expected a JSON error: %v", err)
+	}
+	if resp.Code != "model_not_allowed" || !strings.Contains(resp.Error, "mistral") {
+		t.Errorf("Expected a model_not_allowed error naming the destination, got %+v", resp)
+	}
+}
+
+// TestProxyHandlerAllowedModelsUntypedBodies tests that bodies the proxy's types don't fit, like OpenAI content
+// part arrays, are still held to the key's models, and that restricted keys must name one
+func TestProxyHandlerAllowedModelsUntypedBodies(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		w.Write([]byte(`{}`))
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, AllowedModels: []string{"llama3:*"}})
+	defer validationServer.Close()
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+
+	testCases := []struct {
+		name           string
+		path           string
+		body           string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"Content Parts", "/v1/chat/completions", `{"model":"phi3","messages":[{"role":"user","content":[{"type":"text","text":"Hi"}]}]}`, http.StatusForbidden, "model_not_allowed"},
+		{"Array Prompt", "/v1/completions", `{"model":"phi3","prompt":["Once","upon"]}`, http.StatusForbidden, "model_not_allowed"},
+		{"No Model", "/api/chat", `{"messages":[{"role":"user","content":"Hi"}]}`, http.StatusBadRequest, "model_required"},
+		{"Unreadable Model", "/api/generate", `{"model":["phi3"],"prompt":"Hi"}`, http.StatusBadRequest, "model_required"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			upstreamCalls.Store(0)
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", tc.path, json.RawMessage(tc.body), "test-api-key"))
+			assertResponseStatus(t, rr, tc.expectedStatus)
+			// OpenAI paths nest the code in an error object, so look for it in either format
+			if !strings.Contains(rr.Body.String(), `"code":"`+tc.expectedCode+`"`) {
+				t.Errorf("Expected code %s, got %s", tc.expectedCode, rr.Body.String())
+			}
+			if upstreamCalls.Load() != 0 {
+				t.Errorf("Expected the request to stop at the proxy, got %d upstream calls", upstreamCalls.Load())
+			}
+		})
+	}
+}
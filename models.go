@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"ollama-proxy/logger"
+)
+
+// Model list configuration
+var (
+	publicModelList   bool
+	modelCapabilities map[string][]string
+)
+
+// Model capabilities advertised by /proxy/models
+const (
+	capabilityChat     = "chat"
+	capabilityGenerate = "generate"
+	capabilityEmbed    = "embed"
+)
+
+// ProxyModel is a model from Ollama enriched with the capabilities the proxy advertises
+type ProxyModel struct {
+	ModelInfo
+	Capabilities []string `json:"capabilities"`
+}
+
+// modelsHandler serves GET /proxy/models
+func modelsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var allowedModels []string
+	if !publicModelList {
+		apiKey := r.Header.Get(apiKeyHeaderName)
+		if apiKey == "" {
+			http.Error(w, "Unauthorized: Missing API key", http.StatusUnauthorized)
+			return
+		}
+		allowed, ok, err := modelListAuthorized(r, apiKey)
+		if err != nil {
+			logger.Error("Validation service unavailable", err, map[string]interface{}{
+				"api_key":  apiKey,
+				"endpoint": r.URL.Path,
+			})
+			http.Error(w, "Service Unavailable: Request could not be validated", http.StatusServiceUnavailable)
+			return
+		}
+		if !ok {
+			logger.Warning("Unauthorized: Invalid request", map[string]interface{}{
+				"api_key":  apiKey,
+				"endpoint": r.URL.Path,
+			})
+			http.Error(w, "Unauthorized: Invalid request", http.StatusUnauthorized)
+			return
+		}
+		allowedModels = allowed
+	}
+
+	tags, err := fetchModelTags()
+	if err != nil {
+		logger.Error("Error fetching models from Ollama", err, nil)
+		http.Error(w, "Error fetching models", http.StatusBadGateway)
+		return
+	}
+
+	models := make([]ProxyModel, 0, len(tags.Models))
+	for _, model := range tags.Models {
+		if allowedModels != nil && !modelAllowed(allowedModels, model.Name) {
+			continue
+		}
+		models = append(models, ProxyModel{
+			ModelInfo:    model,
+			Capabilities: capabilitiesForModel(model.Name),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(models)
+}
+
+// modelListAuthorized checks the caller's key without consuming request quota and returns the models
+// the key may use, nil when it may use any. An error means the key couldn't be checked.
+func modelListAuthorized(r *http.Request, apiKey string) ([]string, bool, error) {
+	if isEphemeralToken(apiKey) {
+		claims, err := parseEphemeralToken(apiKey)
+		if err != nil || ephemeralTokens.isRevoked(claims.ID) {
+			return nil, false, nil
+		}
+		if len(claims.Models) == 0 {
+			return nil, true, nil
+		}
+		return claims.Models, true, nil
+	}
+
+	validation, ok, err := validateRequest(r.Context(), RequestDetails{
+		APIKey:    apiKey,
+		IPAddress: clientAddress(r),
+		UserAgent: r.Header.Get("User-Agent"),
+		Endpoint:  r.URL.Path,
+	})
+	return validation.AllowedModels, ok, err
+}
+
+// fetchModelTags lists the models available in Ollama
+func fetchModelTags() (TagsResponse, error) {
+	var tags TagsResponse
+
+	client := getOllamaHTTPClient()
+	resp, err := client.Get(currentOllamaURL() + "/api/tags")
+	if err != nil {
+		return tags, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return tags, fmt.Errorf("Ollama returned non-OK status: %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return tags, fmt.Errorf("failed to decode tags response: %v", err)
+	}
+	return tags, nil
+}
+
+// capabilitiesForModel returns the configured capabilities for a model, falling back to its name
+func capabilitiesForModel(name string) []string {
+	if caps, ok := modelCapabilities[name]; ok {
+		return caps
+	}
+	base, _, _ := strings.Cut(name, ":")
+	if caps, ok := modelCapabilities[base]; ok {
+		return caps
+	}
+
+	if strings.Contains(strings.ToLower(base), "embed") {
+		return []string{capabilityEmbed}
+	}
+	return []string{capabilityChat, capabilityGenerate}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// TestModelsHandler tests that /proxy/models lists Ollama's models with their capabilities
+func TestModelsHandler(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			t.Errorf("Expected /api/tags, got %s", r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(TagsResponse{Models: []ModelInfo{
+			{Name: "llama2:7b", Model: "llama2:7b", Size: 100},
+			{Name: "nomic-embed-text:latest", Model: "nomic-embed-text:latest", Size: 10},
+			{Name: "llava:13b", Model: "llava:13b", Size: 200},
+		}})
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+	modelCapabilities = map[string][]string{"llava": {"chat", "vision"}}
+	defer func() { modelCapabilities = nil }()
+
+	req := httptest.NewRequest("GET", "/proxy/models", nil)
+	req.Header.Set("X-API-Key", "test-key")
+	rr := httptest.NewRecorder()
+	modelsHandler(rr, req)
+	assertResponseStatus(t, rr, http.StatusOK)
+
+	var models []ProxyModel
+	if err := json.NewDecoder(rr.Body).Decode(&models); err != nil {
+		t.Fatalf("Error decoding models: %v", err)
+	}
+	expected := map[string][]string{
+		"llama2:7b":               {"chat", "generate"},
+		"nomic-embed-text:latest": {"embed"},
+		"llava:13b":               {"chat", "vision"},
+	}
+	if len(models) != len(expected) {
+		t.Fatalf("Expected %d models, got %d", len(expected), len(models))
+	}
+	for _, model := range models {
+		if !reflect.DeepEqual(model.Capabilities, expected[model.Name]) {
+			t.Errorf("Expected capabilities %v for %s, got %v", expected[model.Name], model.Name, model.Capabilities)
+		}
+	}
+}
+
+// TestModelsHandlerAuth tests that the model list requires a key unless made public
+func TestModelsHandlerAuth(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"models":[]}`))
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, false, false)
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+	defer func() { publicModelList = false }()
+
+	testCases := []struct {
+		name     string
+		public   bool
+		apiKey   string
+		expected int
+	}{
+		{"Missing Key", false, "", http.StatusUnauthorized},
+		{"Invalid Key", false, "bad-key", http.StatusUnauthorized},
+		{"Public", true, "", http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			publicModelList = tc.public
+			req := httptest.NewRequest("GET", "/proxy/models", nil)
+			if tc.apiKey != "" {
+				req.Header.Set("X-API-Key", tc.apiKey)
+			}
+			rr := httptest.NewRecorder()
+			modelsHandler(rr, req)
+			assertResponseStatus(t, rr, tc.expected)
+		})
+	}
+}
+
+// TestModelsHandlerAllowedModels tests that the model list only names the models the key may use
+func TestModelsHandlerAllowedModels(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(TagsResponse{Models: []ModelInfo{
+			{Name: "llama2:7b", Model: "llama2:7b"},
+			{Name: "llama3:8b", Model: "llama3:8b"},
+			{Name: "mistral:latest", Model: "mistral:latest"},
+		}})
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, AllowedModels: []string{"llama3:*", "mistral"}})
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+
+	req := httptest.NewRequest("GET", "/proxy/models", nil)
+	req.Header.Set("X-API-Key", "test-key")
+	rr := httptest.NewRecorder()
+	modelsHandler(rr, req)
+	assertResponseStatus(t, rr, http.StatusOK)
+
+	var models []ProxyModel
+	if err := json.NewDecoder(rr.Body).Decode(&models); err != nil {
+		t.Fatalf("Error decoding models: %v", err)
+	}
+	var names []string
+	for _, model := range models {
+		names = append(names, model.Name)
+	}
+	if expected := []string{"llama3:8b", "mistral:latest"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected models %v, got %v", expected, names)
+	}
+}
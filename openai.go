@@ -0,0 +1,449 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// includeUsageMinOllamaVersion is the first Ollama release whose OpenAI endpoint honors stream_options.include_usage
+const includeUsageMinOllamaVersion = "0.5.5"
+
+// ollamaVersionTTL bounds how long a detected Ollama version is trusted, so upgrades are noticed
+const ollamaVersionTTL = 5 * time.Minute
+
+// ChatCompletionRequest holds the fields of an OpenAI chat completion request the proxy inspects
+type ChatCompletionRequest struct {
+	Model         string         `json:"model"`
+	Messages      []ChatMessage  `json:"messages"`
+	Stream        bool           `json:"stream"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+// StreamOptions represents OpenAI streaming options
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// ChatCompletionUsage represents token usage in the OpenAI format
+type ChatCompletionUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// ChatCompletionResponse represents a non-streamed OpenAI chat completion
+type ChatCompletionResponse struct {
+	ID      string                 `json:"id"`
+	Object  string                 `json:"object"`
+	Created int64                  `json:"created"`
+	Model   string                 `json:"model"`
+	Choices []ChatCompletionChoice `json:"choices"`
+	Usage   *ChatCompletionUsage   `json:"usage,omitempty"`
+}
+
+// ChatCompletionChoice represents one choice of a non-streamed chat completion
+type ChatCompletionChoice struct {
+	Index        int         `json:"index"`
+	Message      ChatMessage `json:"message"`
+	FinishReason string      `json:"finish_reason"`
+}
+
+// OpenAICompletionRequest holds the fields of a legacy OpenAI completion request the proxy inspects
+type OpenAICompletionRequest struct {
+	Model         string         `json:"model"`
+	Prompt        string         `json:"prompt"`
+	Stream        bool           `json:"stream"`
+	MaxTokens     int            `json:"max_tokens,omitempty"`
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+// OpenAICompletionResponse represents a non-streamed legacy OpenAI completion
+type OpenAICompletionResponse struct {
+	ID      string                   `json:"id"`
+	Object  string                   `json:"object"`
+	Created int64                    `json:"created"`
+	Model   string                   `json:"model"`
+	Choices []OpenAICompletionChoice `json:"choices"`
+	Usage   *ChatCompletionUsage     `json:"usage,omitempty"`
+}
+
+// OpenAICompletionChoice represents one choice of a legacy completion
+type OpenAICompletionChoice struct {
+	Index        int    `json:"index"`
+	Text         string `json:"text"`
+	FinishReason string `json:"finish_reason"`
+}
+
+// OpenAIEmbeddingRequest holds the fields of an OpenAI embeddings request the proxy inspects; input is
+// a string or a list of strings
+type OpenAIEmbeddingRequest struct {
+	Model string      `json:"model"`
+	Input interface{} `json:"input"`
+}
+
+// OpenAIEmbeddingResponse represents an OpenAI embeddings response
+type OpenAIEmbeddingResponse struct {
+	Object string                `json:"object"`
+	Data   []OpenAIEmbedding     `json:"data"`
+	Model  string                `json:"model"`
+	Usage  *OpenAIEmbeddingUsage `json:"usage,omitempty"`
+}
+
+// OpenAIEmbedding represents one embedding of an OpenAI embeddings response
+type OpenAIEmbedding struct {
+	Object    string    `json:"object"`
+	Embedding []float32 `json:"embedding"`
+	Index     int       `json:"index"`
+}
+
+// OpenAIEmbeddingUsage represents token usage of an OpenAI embeddings request, which has no completion
+type OpenAIEmbeddingUsage struct {
+	PromptTokens int `json:"prompt_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// OpenAIErrorResponse is the error body OpenAI SDKs expect from the /v1 endpoints
+type OpenAIErrorResponse struct {
+	Error OpenAIError `json:"error"`
+}
+
+// OpenAIError describes a rejected request in the OpenAI format
+type OpenAIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+}
+
+// ChatCompletionChunk represents one streamed OpenAI chat completion chunk
+type ChatCompletionChunk struct {
+	ID      string                `json:"id"`
+	Object  string                `json:"object"`
+	Created int64                 `json:"created"`
+	Model   string                `json:"model"`
+	Choices []ChatCompletionDelta `json:"choices"`
+	Usage   *ChatCompletionUsage  `json:"usage,omitempty"`
+}
+
+// ChatCompletionDelta represents a streamed choice
+type ChatCompletionDelta struct {
+	Index int `json:"index"`
+	Delta struct {
+		Content string `json:"content"`
+	} `json:"delta"`
+	FinishReason *string `json:"finish_reason"`
+}
+
+// wantsStreamUsage reports whether a streaming chat completion asks for a final usage chunk
+func wantsStreamUsage(path string, body []byte) bool {
+	if !strings.HasSuffix(path, "/v1/chat/completions") {
+		return false
+	}
+	var req ChatCompletionRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return false
+	}
+	return req.Stream && req.StreamOptions != nil && req.StreamOptions.IncludeUsage
+}
+
+// stripStreamOptions removes stream_options so Ollama versions that reject unknown options accept the request
+func stripStreamOptions(r *http.Request, body []byte) []byte {
+	rewritten, changed := rewriteJSONBody(body, func(obj map[string]interface{}) bool {
+		if _, exists := obj["stream_options"]; !exists {
+			return false
+		}
+		delete(obj, "stream_options")
+		return true
+	})
+	if !changed {
+		return body
+	}
+	setRequestBody(r, rewritten)
+	return rewritten
+}
+
+// openAIUsage returns the usage of an OpenAI response, read from the response or, for event streams,
+// from the last chunk carrying one, which Ollama only sends when stream_options.include_usage is set
+func openAIUsage(body []byte) *ChatCompletionUsage {
+	var response struct {
+		Usage *ChatCompletionUsage `json:"usage"`
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("data:")) {
+		if err := json.Unmarshal(body, &response); err != nil {
+			return nil
+		}
+		return response.Usage
+	}
+
+	var usage *ChatCompletionUsage
+	for len(body) > 0 {
+		line := body
+		if idx := bytes.IndexByte(body, '\n'); idx >= 0 {
+			line, body = body[:idx], body[idx+1:]
+		} else {
+			body = nil
+		}
+		data, isData := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+		if !isData || !bytes.Contains(data, []byte(`"usage"`)) {
+			continue
+		}
+		response.Usage = nil
+		if err := json.Unmarshal(data, &response); err == nil && response.Usage != nil {
+			usage = response.Usage
+		}
+	}
+	return usage
+}
+
+// estimateSSETokens approximates the tokens of an OpenAI event stream that carried no usage chunk:
+// prompt tokens from the request text, and one completion token per chunk with content, as Ollama
+// streams them. ok is false for responses that aren't event streams.
+func estimateSSETokens(path string, requestBody, responseBody []byte) (inputTokens, outputTokens int, ok bool) {
+	if !bytes.HasPrefix(bytes.TrimSpace(responseBody), []byte("data:")) {
+		return 0, 0, false
+	}
+
+	if strings.HasSuffix(path, "/v1/completions") {
+		var req OpenAICompletionRequest
+		if err := json.Unmarshal(requestBody, &req); err == nil {
+			inputTokens = (len(req.Prompt) + 3) / 4
+		}
+	} else {
+		inputTokens = estimatePromptTokens(requestBody)
+	}
+
+	for len(responseBody) > 0 {
+		line := responseBody
+		if idx := bytes.IndexByte(responseBody, '\n'); idx >= 0 {
+			line, responseBody = responseBody[:idx], responseBody[idx+1:]
+		} else {
+			responseBody = nil
+		}
+		data, isData := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data:"))
+		if !isData {
+			continue
+		}
+		var chunk struct {
+			Choices []struct {
+				Text  string `json:"text"`
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			continue
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Text != "" || choice.Delta.Content != "" {
+				outputTokens++
+				break
+			}
+		}
+	}
+	return inputTokens, outputTokens, true
+}
+
+// estimatePromptTokens approximates prompt tokens from message text at about four characters per token
+func estimatePromptTokens(body []byte) int {
+	var req ChatCompletionRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return 0
+	}
+	chars := 0
+	for _, message := range req.Messages {
+		chars += len(message.Content)
+	}
+	return (chars + 3) / 4
+}
+
+// ollamaVersionCache remembers the upstream Ollama version between requests
+type ollamaVersionCache struct {
+	mu        sync.Mutex
+	url       string
+	version   string
+	fetchedAt time.Time
+}
+
+var ollamaVersions = &ollamaVersionCache{}
+
+// get returns the Ollama version, fetching /api/version when the cached value is stale or for another URL
+func (c *ollamaVersionCache) get() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	baseURL := currentOllamaURL()
+	if c.url == baseURL && time.Since(c.fetchedAt) < ollamaVersionTTL {
+		return c.version
+	}
+
+	c.url = baseURL
+	c.fetchedAt = time.Now()
+	c.version = ""
+	resp, err := getOllamaHTTPClient().Get(baseURL + "/api/version")
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Version string `json:"version"`
+	}
+	if resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&body) == nil {
+		c.version = body.Version
+	}
+	return c.version
+}
+
+// ollamaHonorsIncludeUsage reports whether Ollama sends the usage chunk itself; unknown versions are assumed not to
+func ollamaHonorsIncludeUsage() bool {
+	version := ollamaVersions.get()
+	return version != "" && compareVersions(version, includeUsageMinOllamaVersion) >= 0
+}
+
+// compareVersions compares dotted numeric versions, ignoring any pre-release suffix
+func compareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < 3; i++ {
+		if pa[i] != pb[i] {
+			if pa[i] < pb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionParts(version string) [3]int {
+	var parts [3]int
+	version = strings.TrimPrefix(version, "v")
+	if idx := strings.IndexAny(version, "-+"); idx >= 0 {
+		version = version[:idx]
+	}
+	for i, part := range strings.SplitN(version, ".", 3) {
+		parts[i], _ = strconv.Atoi(part)
+	}
+	return parts
+}
+
+// streamUsageWriter inserts an OpenAI usage chunk before the [DONE] sentinel of a chat completion stream
+type streamUsageWriter struct {
+	http.ResponseWriter
+	active        bool
+	pending       []byte
+	promptTokens  int
+	contentChunks int
+	last          ChatCompletionChunk
+}
+
+func newStreamUsageWriter(w http.ResponseWriter, promptTokens int) *streamUsageWriter {
+	return &streamUsageWriter{ResponseWriter: w, promptTokens: promptTokens}
+}
+
+// WriteHeader only rewrites successful event streams
+func (uw *streamUsageWriter) WriteHeader(statusCode int) {
+	mediaType, _, _ := mime.ParseMediaType(uw.Header().Get("Content-Type"))
+	uw.active = statusCode == http.StatusOK && mediaType == "text/event-stream"
+	uw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (uw *streamUsageWriter) Write(b []byte) (int, error) {
+	if !uw.active {
+		return uw.ResponseWriter.Write(b)
+	}
+
+	uw.pending = append(uw.pending, b...)
+	idx := bytes.LastIndexByte(uw.pending, '\n')
+	if idx < 0 {
+		return len(b), nil
+	}
+	lines := uw.pending[:idx+1]
+	if err := uw.writeLines(lines); err != nil {
+		return 0, err
+	}
+	uw.pending = append(uw.pending[:0], uw.pending[idx+1:]...)
+	return len(b), nil
+}
+
+// writeLines passes complete lines through, tracking chunks and inserting usage ahead of [DONE]
+func (uw *streamUsageWriter) writeLines(lines []byte) error {
+	start := 0
+	for start < len(lines) {
+		end := bytes.IndexByte(lines[start:], '\n') + start + 1
+		line := lines[start:end]
+		data, isData := bytes.CutPrefix(bytes.TrimSpace(line), []byte("data: "))
+		if isData && string(data) == "[DONE]" {
+			if _, err := uw.ResponseWriter.Write(lines[:start]); err != nil {
+				return err
+			}
+			if err := uw.writeUsage(); err != nil {
+				return err
+			}
+			lines, start = lines[start:], 0
+			end = len(line)
+		} else if isData {
+			uw.observe(data)
+		}
+		start = end
+	}
+	_, err := uw.ResponseWriter.Write(lines)
+	return err
+}
+
+// observe records a chunk's identity and whether it carried content
+func (uw *streamUsageWriter) observe(data []byte) {
+	var chunk ChatCompletionChunk
+	if err := json.Unmarshal(data, &chunk); err != nil {
+		return
+	}
+	uw.last = chunk
+	for _, choice := range chunk.Choices {
+		if choice.Delta.Content != "" {
+			uw.contentChunks++
+			break
+		}
+	}
+}
+
+// writeUsage emits the usage chunk, counting one completion token per content chunk as Ollama streams them
+func (uw *streamUsageWriter) writeUsage() error {
+	usage := ChatCompletionChunk{
+		ID:      uw.last.ID,
+		Object:  "chat.completion.chunk",
+		Created: uw.last.Created,
+		Model:   uw.last.Model,
+		Choices: []ChatCompletionDelta{},
+		Usage: &ChatCompletionUsage{
+			PromptTokens:     uw.promptTokens,
+			CompletionTokens: uw.contentChunks,
+			TotalTokens:      uw.promptTokens + uw.contentChunks,
+		},
+	}
+	data, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	_, err = uw.ResponseWriter.Write([]byte("data: " + string(data) + "\n\n"))
+	return err
+}
+
+// finish writes any trailing partial line
+func (uw *streamUsageWriter) finish() {
+	if len(uw.pending) > 0 {
+		uw.ResponseWriter.Write(uw.pending)
+		uw.pending = nil
+	}
+}
+
+func (uw *streamUsageWriter) Flush() {
+	http.NewResponseController(uw.ResponseWriter).Flush()
+}
+
+func (uw *streamUsageWriter) Unwrap() http.ResponseWriter {
+	return uw.ResponseWriter
+}
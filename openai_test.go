@@ -0,0 +1,372 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// mockOpenAIServer streams OpenAI chat completion chunks from an Ollama reporting the given version,
+// recording the request body it received
+func mockOpenAIServer(t *testing.T, version string, received *map[string]interface{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/version" {
+			json.NewEncoder(w).Encode(map[string]string{"version": version})
+			return
+		}
+
+		json.NewDecoder(r.Body).Decode(received)
+		if stream, _ := (*received)["stream"].(bool); !stream {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"id":"chatcmpl-1","object":"chat.completion","choices":[],"usage":{"prompt_tokens":4,"completion_tokens":2,"total_tokens":6}}`))
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, content := range []string{"Hel", "lo"} {
+			w.Write([]byte(`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"llama2","choices":[{"index":0,"delta":{"content":"` + content + `"},"finish_reason":null}]}` + "\n\n"))
+		}
+		w.Write([]byte(`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"llama2","choices":[{"index":0,"delta":{"content":""},"finish_reason":"stop"}]}` + "\n\n"))
+		if _, ok := (*received)["stream_options"]; ok {
+			w.Write([]byte(`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"llama2","choices":[],"usage":{"prompt_tokens":9,"completion_tokens":2,"total_tokens":11}}` + "\n\n"))
+		}
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// sseData returns the data payloads of an event stream in order
+func sseData(body string) []string {
+	var data []string
+	for _, line := range strings.Split(body, "\n") {
+		if payload, ok := strings.CutPrefix(line, "data: "); ok {
+			data = append(data, payload)
+		}
+	}
+	return data
+}
+
+// TestStreamIncludeUsage tests that the usage chunk is synthesized only for Ollama versions that ignore the option
+func TestStreamIncludeUsage(t *testing.T) {
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, _ := recordingMetricsServer(t)
+	defer metricsServer.Close()
+	useProxyTargets(t, ollamaURL, validationServer.URL, metricsServer.URL)
+
+	includeUsage := map[string]interface{}{"include_usage": true}
+	testCases := []struct {
+		name             string
+		version          string
+		stream           bool
+		streamOptions    map[string]interface{}
+		expectForwarded  bool
+		expectUsage      *ChatCompletionUsage
+		expectDataEvents int
+	}{
+		{"Old Ollama", "0.3.14", true, includeUsage, false, &ChatCompletionUsage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5}, 5},
+		{"Unknown Version", "", true, includeUsage, false, &ChatCompletionUsage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5}, 5},
+		{"Ollama Honors Option", "0.6.2", true, includeUsage, true, &ChatCompletionUsage{PromptTokens: 9, CompletionTokens: 2, TotalTokens: 11}, 5},
+		{"Without Option", "0.3.14", true, nil, false, nil, 4},
+		{"Non-Streaming", "0.3.14", false, includeUsage, true, nil, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var received map[string]interface{}
+			ollamaServer := mockOpenAIServer(t, tc.version, &received)
+			useProxyTargets(t, ollamaServer.URL, "", "")
+
+			request := map[string]interface{}{
+				"model":    "llama2",
+				"messages": []ChatMessage{{Role: "user", Content: "Hello world!"}},
+				"stream":   tc.stream,
+			}
+			if tc.streamOptions != nil {
+				request["stream_options"] = tc.streamOptions
+			}
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/v1/chat/completions", request, "test-key"))
+			assertResponseStatus(t, rr, http.StatusOK)
+
+			if _, forwarded := received["stream_options"]; forwarded != tc.expectForwarded {
+				t.Errorf("Expected stream_options forwarded=%v, got %v", tc.expectForwarded, received)
+			}
+
+			body, _ := io.ReadAll(rr.Body)
+			if !tc.stream {
+				if !json.Valid(body) {
+					t.Errorf("Expected non-streaming response untouched, got %s", body)
+				}
+				return
+			}
+
+			data := sseData(string(body))
+			if len(data) != tc.expectDataEvents {
+				t.Fatalf("Expected %d data events, got %d: %v", tc.expectDataEvents, len(data), data)
+			}
+			if data[len(data)-1] != "[DONE]" {
+				t.Errorf("Expected stream to end with [DONE], got %s", data[len(data)-1])
+			}
+
+			var usageChunks []ChatCompletionChunk
+			for _, payload := range data[:len(data)-1] {
+				var chunk ChatCompletionChunk
+				json.Unmarshal([]byte(payload), &chunk)
+				if chunk.Usage != nil {
+					usageChunks = append(usageChunks, chunk)
+				}
+			}
+			if tc.expectUsage == nil {
+				if len(usageChunks) != 0 {
+					t.Errorf("Expected no usage chunk, got %+v", usageChunks)
+				}
+				return
+			}
+			if len(usageChunks) != 1 {
+				t.Fatalf("Expected exactly one usage chunk, got %d", len(usageChunks))
+			}
+
+			// The usage chunk immediately precedes [DONE] and matches OpenAI's shape
+			var last map[string]json.RawMessage
+			json.Unmarshal([]byte(data[len(data)-2]), &last)
+			if string(last["choices"]) != "[]" || string(last["object"]) != `"chat.completion.chunk"` || string(last["id"]) != `"chatcmpl-1"` || string(last["model"]) != `"llama2"` {
+				t.Errorf("Expected usage chunk with empty choices before [DONE], got %s", data[len(data)-2])
+			}
+			if *usageChunks[0].Usage != *tc.expectUsage {
+				t.Errorf("Expected usage %+v, got %+v", *tc.expectUsage, *usageChunks[0].Usage)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerChatCompletionMetrics tests that OpenAI chat completions are validated and metered per model
+func TestProxyHandlerChatCompletionMetrics(t *testing.T) {
+	var received map[string]interface{}
+	ollamaServer := mockOpenAIServer(t, "0.6.0", &received)
+	validatedModels := make(chan string, 1)
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var details RequestDetails
+		json.NewDecoder(r.Body).Decode(&details)
+		validatedModels <- details.Model
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true})
+	}))
+	defer validationServer.Close()
+	metricsServer, metrics := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/v1/chat/completions", ChatCompletionRequest{
+		Model:    "llama3",
+		Messages: []ChatMessage{{Role: "user", Content: "Hi"}},
+	}, "test-key"))
+	assertResponseStatus(t, rr, http.StatusOK)
+
+	if model := <-validatedModels; model != "llama3" {
+		t.Errorf("Expected validation for llama3, got %q", model)
+	}
+	m := waitForMetrics(t, metrics)
+	if m.Model != "llama3" || m.InputTokenLength != 4 || m.OutputTokenLength != 2 || m.TokenSource != tokenSourceOllama {
+		t.Errorf("Expected llama3 with 4/2 tokens from Ollama, got %q with %d/%d (%s)", m.Model, m.InputTokenLength, m.OutputTokenLength, m.TokenSource)
+	}
+}
+
+// TestProxyHandlerCompletionMetrics tests that streamed legacy completions are metered from their usage chunk
+func TestProxyHandlerCompletionMetrics(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"id":"cmpl-1","object":"text_completion","model":"mistral","choices":[{"index":0,"text":"there was","finish_reason":"length"}]}` + "\n\n"))
+		w.Write([]byte(`data: {"id":"cmpl-1","object":"text_completion","model":"mistral","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}` + "\n\n"))
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, metrics := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/v1/completions", OpenAICompletionRequest{
+		Model:         "mistral",
+		Prompt:        "Once upon a time",
+		Stream:        true,
+		MaxTokens:     2,
+		StreamOptions: &StreamOptions{IncludeUsage: true},
+	}, "test-key"))
+	assertResponseStatus(t, rr, http.StatusOK)
+
+	m := waitForMetrics(t, metrics)
+	if m.Model != "mistral" || m.InputTokenLength != 5 || m.OutputTokenLength != 2 {
+		t.Errorf("Expected mistral with 5/2 tokens, got %q with %d/%d", m.Model, m.InputTokenLength, m.OutputTokenLength)
+	}
+}
+
+// TestProxyHandlerOpenAIEmbeddings tests that OpenAI embeddings requests are validated and metered per model
+func TestProxyHandlerOpenAIEmbeddings(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req OpenAIEmbeddingRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		inputs := 1
+		if list, ok := req.Input.([]interface{}); ok {
+			inputs = len(list)
+		}
+		resp := OpenAIEmbeddingResponse{Object: "list", Model: req.Model, Usage: &OpenAIEmbeddingUsage{PromptTokens: 4 * inputs, TotalTokens: 4 * inputs}}
+		for i := 0; i < inputs; i++ {
+			resp.Data = append(resp.Data, OpenAIEmbedding{Object: "embedding", Embedding: []float32{0.1, 0.2}, Index: i})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer ollamaServer.Close()
+	validatedModels := make(chan string, 1)
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var details RequestDetails
+		json.NewDecoder(r.Body).Decode(&details)
+		validatedModels <- details.Model
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true})
+	}))
+	defer validationServer.Close()
+	metricsServer, metrics := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	testCases := []struct {
+		name         string
+		input        interface{}
+		promptTokens int
+	}{
+		{"String Input", "The sky is blue", 4},
+		{"Array Input", []string{"The sky is blue", "Grass is green"}, 8},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/v1/embeddings", OpenAIEmbeddingRequest{Model: "nomic-embed-text", Input: tc.input}, "test-key"))
+			assertResponseStatus(t, rr, http.StatusOK)
+
+			if model := <-validatedModels; model != "nomic-embed-text" {
+				t.Errorf("Expected validation for nomic-embed-text, got %q", model)
+			}
+			m := waitForMetrics(t, metrics)
+			if m.Model != "nomic-embed-text" || m.InputTokenLength != tc.promptTokens || m.OutputTokenLength != 0 {
+				t.Errorf("Expected nomic-embed-text with %d/0 tokens, got %q with %d/%d", tc.promptTokens, m.Model, m.InputTokenLength, m.OutputTokenLength)
+			}
+		})
+	}
+}
+
+// TestEstimateSSETokens tests estimating the tokens of event streams without a usage chunk
+func TestEstimateSSETokens(t *testing.T) {
+	chatRequest := []byte(`{"model":"llama2","messages":[{"role":"user","content":"Tell me a joke"}],"stream":true}`)
+	chatStream := []byte(`data: {"choices":[{"index":0,"delta":{"role":"assistant","content":"Why"},"finish_reason":null}]}
+
+data: {"choices":[{"index":0,"delta":{"content":" not?"},"finish_reason":null}]}
+
+data: {"choices":[{"index":0,"delta":{"content":""},"finish_reason":"stop"}]}
+
+data: [DONE]
+
+`)
+	completionRequest := []byte(`{"model":"mistral","prompt":"Once upon a time","stream":true}`)
+	completionStream := []byte(`data: {"choices":[{"index":0,"text":"there","finish_reason":null}]}
+
+data: {"choices":[{"index":0,"text":" was","finish_reason":"length"}]}
+
+data: [DONE]
+
+`)
+
+	testCases := []struct {
+		name         string
+		path         string
+		request      []byte
+		response     []byte
+		inputTokens  int
+		outputTokens int
+		ok           bool
+	}{
+		{"Chat Stream", "/v1/chat/completions", chatRequest, chatStream, 4, 2, true},
+		{"Completion Stream", "/v1/completions", completionRequest, completionStream, 4, 2, true},
+		{"Not An Event Stream", "/v1/chat/completions", chatRequest, []byte(`{"choices":[]}`), 0, 0, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			in, out, ok := estimateSSETokens(tc.path, tc.request, tc.response)
+			if in != tc.inputTokens || out != tc.outputTokens || ok != tc.ok {
+				t.Errorf("Expected (%d, %d, %v), got (%d, %d, %v)", tc.inputTokens, tc.outputTokens, tc.ok, in, out, ok)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerStreamedChatCompletionMetrics tests that streamed chat completions are metered from
+// the usage chunk when there is one, and estimated from the chunks otherwise
+func TestProxyHandlerStreamedChatCompletionMetrics(t *testing.T) {
+	var received map[string]interface{}
+	ollamaServer := mockOpenAIServer(t, "0.6.0", &received)
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, metrics := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	testCases := []struct {
+		name          string
+		streamOptions *StreamOptions
+		inputTokens   int
+		outputTokens  int
+		tokenSource   string
+	}{
+		{"Usage Chunk", &StreamOptions{IncludeUsage: true}, 9, 2, tokenSourceOllama},
+		{"Estimated", nil, 1, 2, tokenSourceEstimated},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			received = nil
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/v1/chat/completions", ChatCompletionRequest{
+				Model:         "llama2",
+				Messages:      []ChatMessage{{Role: "user", Content: "Hi"}},
+				Stream:        true,
+				StreamOptions: tc.streamOptions,
+			}, "test-key"))
+			assertResponseStatus(t, rr, http.StatusOK)
+
+			m := waitForMetrics(t, metrics)
+			if m.InputTokenLength != tc.inputTokens || m.OutputTokenLength != tc.outputTokens || m.TokenSource != tc.tokenSource {
+				t.Errorf("Expected %d/%d tokens (%s), got %d/%d (%s)", tc.inputTokens, tc.outputTokens, tc.tokenSource, m.InputTokenLength, m.OutputTokenLength, m.TokenSource)
+			}
+		})
+	}
+}
+
+// TestCompareVersions tests Ollama version comparison
+func TestCompareVersions(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"0.5.5", "0.5.5", 0},
+		{"0.5.4", "0.5.5", -1},
+		{"0.10.0", "0.5.5", 1},
+		{"v0.6.0-rc1", "0.5.5", 1},
+		{"1.0", "0.5.5", 1},
+	}
+	for _, tc := range testCases {
+		if got := compareVersions(tc.a, tc.b); got != tc.expected {
+			t.Errorf("compareVersions(%q, %q) = %d, expected %d", tc.a, tc.b, got, tc.expected)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+)
+
+// Response preview configuration
+var (
+	logResponsePreviewBytes int // 0 disables
+)
+
+// getResponsePreview returns up to limit bytes of generated text from a captured response, concatenating
+// streamed chunks in order; embeddings are never previewed
+func getResponsePreview(path string, responseBody []byte, limit int) string {
+	if limit <= 0 || strings.HasSuffix(path, "/api/embed") || strings.HasSuffix(path, "/api/embeddings") {
+		return ""
+	}
+
+	var preview strings.Builder
+	decoder := json.NewDecoder(bytes.NewReader(responseBody))
+	for preview.Len() < limit {
+		var chunk struct {
+			Response string       `json:"response"`
+			Message  *ChatMessage `json:"message"`
+		}
+		if err := decoder.Decode(&chunk); err != nil {
+			break
+		}
+		preview.WriteString(chunk.Response)
+		if chunk.Message != nil {
+			preview.WriteString(chunk.Message.Content)
+		}
+	}
+
+	return truncateUTF8(preview.String(), limit)
+}
+
+// truncateUTF8 cuts s to at most limit bytes without splitting a character
+func truncateUTF8(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	for limit > 0 && !utf8.RuneStart(s[limit]) {
+		limit--
+	}
+	return s[:limit]
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"ollama-proxy/logger"
+)
+
+func TestGetResponsePreview(t *testing.T) {
+	testCases := []struct {
+		name     string
+		path     string
+		body     string
+		limit    int
+		expected string
+	}{
+		{
+			name:     "Non-Streaming Generate",
+			path:     "/api/generate",
+			body:     `{"model":"llama2","response":"The sky is blue because of Rayleigh scattering.","done":true}`,
+			limit:    16,
+			expected: "The sky is blue ",
+		},
+		{
+			name:     "Non-Streaming Chat",
+			path:     "/api/chat",
+			body:     `{"model":"llama2","message":{"role":"assistant","content":"Hello there"},"done":true}`,
+			limit:    100,
+			expected: "Hello there",
+		},
+		{
+			name: "Streamed Chunks Concatenated",
+			path: "/api/chat",
+			body: `{"message":{"role":"assistant","content":"Hel"},"done":false}` + "\n" +
+				`{"message":{"role":"assistant","content":"lo, "},"done":false}` + "\n" +
+				`{"message":{"role":"assistant","content":"world"},"done":false}` + "\n" +
+				`{"message":{"role":"assistant","content":""},"done":true}` + "\n",
+			limit:    9,
+			expected: "Hello, wo",
+		},
+		{
+			name:     "Multi-Byte Character At Limit",
+			path:     "/api/generate",
+			body:     `{"response":"café!","done":true}`,
+			limit:    4,
+			expected: "caf",
+		},
+		{
+			name:     "Embed Excluded",
+			path:     "/api/embed",
+			body:     `{"model":"nomic-embed-text","embeddings":[[0.1,0.2,0.3]]}`,
+			limit:    100,
+			expected: "",
+		},
+		{
+			name:     "Disabled",
+			path:     "/api/generate",
+			body:     `{"response":"Hi","done":true}`,
+			limit:    0,
+			expected: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if preview := getResponsePreview(tc.path, []byte(tc.body), tc.limit); preview != tc.expected {
+				t.Errorf("Expected preview %q, got %q", tc.expected, preview)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerResponsePreview tests that the preview reaches the request log only where allowed
+func TestProxyHandlerResponsePreview(t *testing.T) {
+	testCases := []struct {
+		name          string
+		path          string
+		body          interface{}
+		chunks        []string
+		zeroRetention bool
+		expected      string
+	}{
+		{
+			name: "Streaming Generate Truncated",
+			path: "/api/generate",
+			body: map[string]interface{}{"model": "llama2", "prompt": "hi"},
+			chunks: []string{
+				`{"model":"llama2","response":"Once upon ","done":false}`,
+				`{"model":"llama2","response":"a time there was","done":false}`,
+				`{"model":"llama2","response":"","done":true,"prompt_eval_count":2,"eval_count":2}`,
+			},
+			expected: `"response_preview":"Once upon a time"`,
+		},
+		{
+			name: "Embed Excluded",
+			path: "/api/embed",
+			body: map[string]interface{}{"model": "nomic-embed-text", "input": "hi"},
+			chunks: []string{
+				`{"model":"nomic-embed-text","embeddings":[[0.1,0.2,0.3]],"prompt_eval_count":1}`,
+			},
+		},
+		{
+			name: "Zero Retention Key",
+			path: "/api/generate",
+			body: map[string]interface{}{"model": "llama2", "prompt": "hi"},
+			chunks: []string{
+				`{"model":"llama2","response":"Secret answer","done":true,"prompt_eval_count":2,"eval_count":2}`,
+			},
+			zeroRetention: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ollamaServer := mockStreamingOllamaServer(t, tc.chunks, 0)
+			defer ollamaServer.Close()
+			validationServer := mockValidationServer(t, true, false)
+			defer validationServer.Close()
+			metricsServer, received := recordingMetricsServer(t)
+			defer metricsServer.Close()
+
+			useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+			logResponsePreviewBytes = 16
+			defer func() { logResponsePreviewBytes = 0 }()
+			if tc.zeroRetention {
+				zeroRetentionKeys = map[string]bool{"test-key": true}
+				defer func() { zeroRetentionKeys = nil }()
+			}
+
+			var logs bytes.Buffer
+			logger.SetOutput(&logs)
+			defer logger.SetOutput(os.Stdout)
+
+			req := createTestRequest(t, "POST", tc.path, tc.body, "test-key")
+			proxyHandler(httptest.NewRecorder(), req)
+			waitForMetrics(t, received)
+
+			if tc.expected == "" {
+				if strings.Contains(logs.String(), "response_preview") {
+					t.Errorf("Expected no response preview, got %s", logs.String())
+				}
+			} else if !strings.Contains(logs.String(), tc.expected) {
+				t.Errorf("Expected %s in logs, got %s", tc.expected, logs.String())
+			}
+		})
+	}
+}
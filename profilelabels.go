@@ -0,0 +1,27 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"runtime/pprof"
+)
+
+// Profiling label configuration
+var (
+	profileLabels bool
+)
+
+// withProfileLabels labels the handler goroutine, and the goroutines it starts, with the request's
+// endpoint and model so continuous profilers can attribute CPU to them. The returned function restores
+// the connection goroutine's labels for the next request on the connection.
+func withProfileLabels(r *http.Request, model string) (*http.Request, func()) {
+	if !profileLabels {
+		return r, func() {}
+	}
+	ctx := pprof.WithLabels(r.Context(), pprof.Labels(
+		"endpoint", canonicalEndpoint(r.URL.Path),
+		"model", model,
+	))
+	pprof.SetGoroutineLabels(ctx)
+	return r.WithContext(ctx), func() { pprof.SetGoroutineLabels(context.Background()) }
+}
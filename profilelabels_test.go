@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http/httptest"
+	"runtime/pprof"
+	"testing"
+)
+
+// TestWithProfileLabels tests labeling requests by endpoint and model only when enabled
+func TestWithProfileLabels(t *testing.T) {
+	req := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+
+	labeled, restore := withProfileLabels(req, "llama3")
+	restore()
+	if _, ok := pprof.Label(labeled.Context(), "endpoint"); ok {
+		t.Error("Expected no labels when PROFILE_LABELS is off")
+	}
+
+	profileLabels = true
+	defer func() { profileLabels = false }()
+	labeled, restore = withProfileLabels(req, "llama3")
+	defer restore()
+	if endpoint, _ := pprof.Label(labeled.Context(), "endpoint"); endpoint != "chat" {
+		t.Errorf("Expected endpoint label chat, got %q", endpoint)
+	}
+	if model, _ := pprof.Label(labeled.Context(), "model"); model != "llama3" {
+		t.Errorf("Expected model label llama3, got %q", model)
+	}
+}
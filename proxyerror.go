@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// isOpenAIPath reports whether a path belongs to Ollama's OpenAI-compatible API
+func isOpenAIPath(path string) bool {
+	return strings.HasPrefix(path, "/v1/")
+}
+
+// openAIErrorType maps a status code to the error type OpenAI uses for it
+func openAIErrorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusNotFound:
+		return "not_found_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= http.StatusInternalServerError:
+		return "server_error"
+	default:
+		return "invalid_request_error"
+	}
+}
+
+// writeProxyError rejects a request with a JSON error in the format of the API the client called:
+// the OpenAI error object on /v1 paths, which SDKs fail to parse otherwise, and ErrorResponse elsewhere
+func writeProxyError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if isOpenAIPath(r.URL.Path) {
+		json.NewEncoder(w).Encode(OpenAIErrorResponse{Error: OpenAIError{
+			Message: message,
+			Type:    openAIErrorType(status),
+			Code:    code,
+		}})
+		return
+	}
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message, Code: code})
+}
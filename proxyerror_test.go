@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestWriteProxyError tests that the error body follows the API family of the path
+func TestWriteProxyError(t *testing.T) {
+	t.Run("Ollama Path", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		writeProxyError(rr, httptest.NewRequest("POST", "/api/chat", nil), http.StatusTooManyRequests, "rate_limit_exceeded", "Too Many Requests: Rate limit exceeded")
+		assertResponseStatus(t, rr, http.StatusTooManyRequests)
+
+		var body ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Expected an Ollama-style error, got %q: %v", rr.Body.String(), err)
+		}
+		if body.Error != "Too Many Requests: Rate limit exceeded" || body.Code != "rate_limit_exceeded" {
+			t.Errorf("Unexpected error body %+v", body)
+		}
+	})
+
+	t.Run("OpenAI Path", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		writeProxyError(rr, httptest.NewRequest("POST", "/v1/chat/completions", nil), http.StatusTooManyRequests, "rate_limit_exceeded", "Too Many Requests: Rate limit exceeded")
+		assertResponseStatus(t, rr, http.StatusTooManyRequests)
+		if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Expected Content-Type application/json, got %q", ct)
+		}
+
+		var body OpenAIErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Expected an OpenAI error, got %q: %v", rr.Body.String(), err)
+		}
+		want := OpenAIError{Message: "Too Many Requests: Rate limit exceeded", Type: "rate_limit_error", Code: "rate_limit_exceeded"}
+		if body.Error != want {
+			t.Errorf("Expected %+v, got %+v", want, body.Error)
+		}
+	})
+}
+
+// TestOpenAIErrorType tests the error type reported for each status
+func TestOpenAIErrorType(t *testing.T) {
+	testCases := map[int]string{
+		http.StatusBadRequest:          "invalid_request_error",
+		http.StatusUnauthorized:        "authentication_error",
+		http.StatusForbidden:           "permission_error",
+		http.StatusNotFound:            "not_found_error",
+		http.StatusTooManyRequests:     "rate_limit_error",
+		http.StatusBadGateway:          "server_error",
+		http.StatusInternalServerError: "server_error",
+	}
+	for status, want := range testCases {
+		if got := openAIErrorType(status); got != want {
+			t.Errorf("openAIErrorType(%d) = %q, want %q", status, got, want)
+		}
+	}
+}
+
+// TestProxyHandlerOpenAIErrors tests that rejected /v1 requests carry OpenAI error objects
+func TestProxyHandlerOpenAIErrors(t *testing.T) {
+	validationServer := mockValidationServer(t, false, false)
+	defer validationServer.Close()
+	useProxyTargets(t, ollamaURL, validationServer.URL, "")
+
+	testCases := []struct {
+		name    string
+		apiKey  string
+		status  int
+		errType string
+		code    string
+	}{
+		{"Missing API Key", "", http.StatusUnauthorized, "authentication_error", "missing_api_key"},
+		{"Validation Failure", "test-key", http.StatusUnauthorized, "authentication_error", "invalid_api_key"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/v1/chat/completions", ChatCompletionRequest{
+				Model:    "llama2",
+				Messages: []ChatMessage{{Role: "user", Content: "Hi"}},
+			}, tc.apiKey))
+			assertResponseStatus(t, rr, tc.status)
+
+			var body OpenAIErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Expected an OpenAI error, got %q: %v", rr.Body.String(), err)
+			}
+			if body.Error.Type != tc.errType || body.Error.Code != tc.code || body.Error.Message == "" {
+				t.Errorf("Expected type %q and code %q, got %+v", tc.errType, tc.code, body.Error)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"ollama-proxy/logger"
+)
+
+// Rate limiting configuration
+var (
+	rateLimit         float64
+	rateLimitBurst    int
+	rateLimitBackend  string
+	rateLimitFailMode string
+	redisAddr         string
+	redisTimeout      time.Duration
+	limiter           Limiter
+)
+
+// Limiter decides whether another request for a key may proceed
+type Limiter interface {
+	Allow(ctx context.Context, key string) bool
+}
+
+// rateLimitKey returns the bucket a request is counted against: its API key, or for keyless requests to
+// public endpoints its client address, so anonymous callers don't share one bucket
+func rateLimitKey(r *http.Request, apiKey string) string {
+	if apiKey != "" {
+		return apiKey
+	}
+	if addr, ok := clientIP(r); ok {
+		return "ip:" + addr.String()
+	}
+	return "ip:" + r.RemoteAddr
+}
+
+// newRateLimiter builds the limiter selected by the rate limiting configuration
+func newRateLimiter() Limiter {
+	if rateLimit <= 0 {
+		return nil
+	}
+
+	local := newLocalLimiter(rateLimit, rateLimitBurst)
+	if rateLimitBackend != "redis" {
+		return local
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:         redisAddr,
+		DialTimeout:  redisTimeout,
+		ReadTimeout:  redisTimeout,
+		WriteTimeout: redisTimeout,
+	})
+	return newRedisLimiter(client, rateLimit, rateLimitBurst, redisTimeout, rateLimitFailMode, local)
+}
+
+// tokenBucket tracks the remaining tokens for one key
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// localLimiter is an in-process token bucket limiter keyed by API key
+type localLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+// localLimiterPruneSize is the bucket count above which idle buckets are dropped
+const localLimiterPruneSize = 10000
+
+func newLocalLimiter(rate float64, burst int) *localLimiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &localLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Allow consumes a token for key if one is available
+func (l *localLimiter) Allow(ctx context.Context, key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= localLimiterPruneSize {
+			l.pruneLocked(now)
+		}
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.tokens = math.Min(l.burst, bucket.tokens+elapsed*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// pruneLocked drops buckets that have refilled completely and carry no state
+func (l *localLimiter) pruneLocked(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// redisTokenBucketScript atomically refills and consumes a token bucket stored in a hash. It reads the
+// time from Redis, since proxy replicas' clocks may disagree about how much a shared bucket has refilled.
+var redisTokenBucketScript = redis.NewScript(`
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return allowed
+`)
+
+// redisLimiter shares token buckets between proxy replicas through Redis
+type redisLimiter struct {
+	client   *redis.Client
+	rate     float64
+	burst    int
+	timeout  time.Duration
+	failMode string
+	fallback Limiter
+	down     atomic.Bool // whether the last call failed, so outages are logged once instead of per request
+}
+
+func newRedisLimiter(client *redis.Client, rate float64, burst int, timeout time.Duration, failMode string, fallback Limiter) *redisLimiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rate)))
+	}
+	return &redisLimiter{
+		client:   client,
+		rate:     rate,
+		burst:    burst,
+		timeout:  timeout,
+		failMode: failMode,
+		fallback: fallback,
+	}
+}
+
+// Allow consumes a token from the shared bucket, falling back per failMode when Redis is unavailable
+func (l *redisLimiter) Allow(ctx context.Context, key string) bool {
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+
+	allowed, err := redisTokenBucketScript.Run(ctx, l.client,
+		[]string{"ollama-proxy:ratelimit:" + hashAPIKey(key)},
+		l.rate, l.burst,
+	).Int()
+	if err == nil {
+		if l.down.CompareAndSwap(true, false) {
+			logger.Info("Redis rate limiter recovered", map[string]interface{}{
+				"fail_mode": l.failMode,
+			})
+		}
+		return allowed == 1
+	}
+
+	if l.down.CompareAndSwap(false, true) {
+		logger.Warning("Redis rate limiter unavailable", map[string]interface{}{
+			"error":     err.Error(),
+			"fail_mode": l.failMode,
+		})
+	}
+	switch l.failMode {
+	case "open":
+		return true
+	case "closed":
+		return false
+	default:
+		return l.fallback.Allow(ctx, key)
+	}
+}
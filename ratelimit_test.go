@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"ollama-proxy/logger"
+)
+
+// TestLocalLimiter tests the in-process token bucket limiter
+func TestLocalLimiter(t *testing.T) {
+	now := time.Now()
+	l := newLocalLimiter(1, 2)
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if !l.Allow(ctx, "key-a") || !l.Allow(ctx, "key-a") {
+		t.Error("Expected burst of 2 to be allowed")
+	}
+	if l.Allow(ctx, "key-a") {
+		t.Error("Expected third request to be limited")
+	}
+	if !l.Allow(ctx, "key-b") {
+		t.Error("Expected other keys to have their own bucket")
+	}
+
+	now = now.Add(time.Second)
+	if !l.Allow(ctx, "key-a") {
+		t.Error("Expected a token to be refilled after one second")
+	}
+	if l.Allow(ctx, "key-a") {
+		t.Error("Expected bucket to be empty again")
+	}
+}
+
+// TestRedisLimiterSharedAcrossInstances tests that two proxies share one budget through Redis
+func TestRedisLimiterSharedAcrossInstances(t *testing.T) {
+	store := miniredis.RunT(t)
+	ctx := context.Background()
+
+	newInstance := func() *redisLimiter {
+		client := redis.NewClient(&redis.Options{Addr: store.Addr()})
+		t.Cleanup(func() { client.Close() })
+		return newRedisLimiter(client, 1, 3, time.Second, "closed", newLocalLimiter(1, 3))
+	}
+	first := newInstance()
+	second := newInstance()
+	now := time.Now()
+	store.SetTime(now)
+
+	allowed := 0
+	for i := 0; i < 6; i++ {
+		instance := first
+		if i%2 == 1 {
+			instance = second
+		}
+		if instance.Allow(ctx, "shared-key") {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Errorf("Expected 3 requests allowed across both instances, got %d", allowed)
+	}
+
+	store.SetTime(now.Add(time.Second))
+	if !second.Allow(ctx, "shared-key") {
+		t.Error("Expected a refilled token after one second")
+	}
+	if first.Allow(ctx, "shared-key") {
+		t.Error("Expected refilled token to be consumed for both instances")
+	}
+}
+
+// TestRedisLimiterFallback tests the behavior when Redis is unavailable
+func TestRedisLimiterFallback(t *testing.T) {
+	store := miniredis.RunT(t)
+	addr := store.Addr()
+	store.Close()
+
+	client := redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1})
+	defer client.Close()
+	ctx := context.Background()
+
+	open := newRedisLimiter(client, 1, 1, 50*time.Millisecond, "open", newLocalLimiter(1, 1))
+	if !open.Allow(ctx, "key") || !open.Allow(ctx, "key") {
+		t.Error("Expected fail-open limiter to allow requests")
+	}
+
+	closed := newRedisLimiter(client, 1, 1, 50*time.Millisecond, "closed", newLocalLimiter(1, 1))
+	if closed.Allow(ctx, "key") {
+		t.Error("Expected fail-closed limiter to reject requests")
+	}
+
+	local := newRedisLimiter(client, 1, 1, 50*time.Millisecond, "local", newLocalLimiter(1, 1))
+	start := time.Now()
+	if !local.Allow(ctx, "key") {
+		t.Error("Expected local fallback to allow the first request")
+	}
+	if local.Allow(ctx, "key") {
+		t.Error("Expected local fallback to enforce the local limit")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected Redis failures to be bounded by the timeout, took %v", elapsed)
+	}
+}
+
+// TestRedisLimiterOutageLogging tests that a Redis outage is logged once when it starts and once when it ends
+func TestRedisLimiterOutageLogging(t *testing.T) {
+	store := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: store.Addr(), MaxRetries: -1})
+	defer client.Close()
+	ctx := context.Background()
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+
+	rl := newRedisLimiter(client, 100, 100, 50*time.Millisecond, "open", newLocalLimiter(100, 100))
+	rl.Allow(ctx, "key")
+	store.Close()
+	for i := 0; i < 5; i++ {
+		rl.Allow(ctx, "key")
+	}
+	if err := store.Restart(); err != nil {
+		t.Fatalf("Error restarting Redis: %v", err)
+	}
+	rl.Allow(ctx, "key")
+	rl.Allow(ctx, "key")
+
+	if n := strings.Count(logs.String(), "Redis rate limiter unavailable"); n != 1 {
+		t.Errorf("Expected the outage to be logged once, got %d times: %s", n, logs.String())
+	}
+	if n := strings.Count(logs.String(), "Redis rate limiter recovered"); n != 1 {
+		t.Errorf("Expected the recovery to be logged once, got %d times: %s", n, logs.String())
+	}
+}
+
+// TestProxyHandlerRateLimit tests that limited keys receive 429 responses
+func TestProxyHandlerRateLimit(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, validationServer.URL)
+
+	limiter = newLocalLimiter(0.001, 1)
+	defer func() { limiter = nil }()
+
+	body := GenerateRequest{Model: "mistral", Prompt: "hi"}
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/generate", body, "limited-key"))
+	assertResponseStatus(t, rr, http.StatusOK)
+
+	rr = httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/generate", body, "limited-key"))
+	assertResponseStatus(t, rr, http.StatusTooManyRequests)
+}
+
+// TestProxyHandlerRateLimitPublic tests that keyless requests to public endpoints are limited per client address
+func TestProxyHandlerRateLimitPublic(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version":"0.1.0"}`))
+	}))
+	defer ollamaServer.Close()
+	useProxyTargets(t, ollamaServer.URL, "", "")
+
+	publicEndpoints = parseKeyList("/api/version")
+	limiter = newLocalLimiter(0.001, 1)
+	defer func() {
+		publicEndpoints = nil
+		limiter = nil
+	}()
+
+	testCases := []struct {
+		remoteAddr string
+		expected   int
+	}{
+		{"192.0.2.1:1234", http.StatusOK},
+		{"192.0.2.2:1234", http.StatusOK},
+		{"192.0.2.1:5678", http.StatusTooManyRequests},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", "/api/version", nil)
+		req.RemoteAddr = tc.remoteAddr
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, req)
+		if rr.Code != tc.expected {
+			t.Errorf("Expected status %d for %s, got %d", tc.expected, tc.remoteAddr, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Headers telling clients how much of their quota is left, copied from the validation service's answer
+const (
+	rateLimitLimitHeader     = "X-RateLimit-Limit"
+	rateLimitRemainingHeader = "X-RateLimit-Remaining"
+	rateLimitResetHeader     = "X-RateLimit-Reset"
+)
+
+// setRateLimitHeaders copies the quota fields the validation service sent onto the client response;
+// fields it left out produce no header. Rate-limited responses also get Retry-After, from
+// retryAfterSeconds or, failing that, rateLimitResetSeconds.
+func setRateLimitHeaders(h http.Header, validation ValidationResponse) {
+	if validation.RateLimitLimit != nil {
+		h.Set(rateLimitLimitHeader, strconv.Itoa(*validation.RateLimitLimit))
+	}
+	if validation.RateLimitRemaining != nil {
+		h.Set(rateLimitRemainingHeader, strconv.Itoa(*validation.RateLimitRemaining))
+	}
+	if validation.RateLimitResetSeconds != nil {
+		h.Set(rateLimitResetHeader, strconv.Itoa(*validation.RateLimitResetSeconds))
+	}
+
+	if !validation.RateLimited {
+		return
+	}
+	if validation.RetryAfterSeconds > 0 {
+		h.Set("Retry-After", strconv.Itoa(validation.RetryAfterSeconds))
+	} else if validation.RateLimitResetSeconds != nil && *validation.RateLimitResetSeconds > 0 {
+		h.Set("Retry-After", strconv.Itoa(*validation.RateLimitResetSeconds))
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func intPtr(n int) *int {
+	return &n
+}
+
+// TestSetRateLimitHeaders tests which headers each validation answer produces
+func TestSetRateLimitHeaders(t *testing.T) {
+	testCases := []struct {
+		name       string
+		validation ValidationResponse
+		expected   map[string]string
+	}{
+		{"No Quota Fields", ValidationResponse{Valid: true}, map[string]string{}},
+		{"All Fields", ValidationResponse{Valid: true, RateLimitLimit: intPtr(100), RateLimitRemaining: intPtr(0), RateLimitResetSeconds: intPtr(30)},
+			map[string]string{rateLimitLimitHeader: "100", rateLimitRemainingHeader: "0", rateLimitResetHeader: "30"}},
+		{"Partial Fields", ValidationResponse{Valid: true, RateLimitRemaining: intPtr(7)}, map[string]string{rateLimitRemainingHeader: "7"}},
+		{"Rate Limited Uses Retry After", ValidationResponse{RateLimited: true, RetryAfterSeconds: 5, RateLimitResetSeconds: intPtr(30)},
+			map[string]string{rateLimitResetHeader: "30", "Retry-After": "5"}},
+		{"Rate Limited Falls Back To Reset", ValidationResponse{RateLimited: true, RateLimitResetSeconds: intPtr(30)},
+			map[string]string{rateLimitResetHeader: "30", "Retry-After": "30"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := http.Header{}
+			setRateLimitHeaders(h, tc.validation)
+			if len(h) != len(tc.expected) {
+				t.Errorf("Expected headers %v, got %v", tc.expected, h)
+			}
+			for name, value := range tc.expected {
+				if h.Get(name) != value {
+					t.Errorf("Expected %s: %s, got %q", name, value, h.Get(name))
+				}
+			}
+		})
+	}
+}
+
+// TestProxyHandlerRateLimitHeaders tests that quota headers reach clients on proxied responses and on 429s
+func TestProxyHandlerRateLimitHeaders(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	useProxyTargets(t, ollamaServer.URL, "", "")
+
+	testCases := []struct {
+		name               string
+		validation         ValidationResponse
+		expectedStatus     int
+		expectedRemaining  string
+		expectedRetryAfter string
+	}{
+		{"Allowed", ValidationResponse{Valid: true, RateLimitLimit: intPtr(60), RateLimitRemaining: intPtr(59), RateLimitResetSeconds: intPtr(42)}, http.StatusOK, "59", ""},
+		{"Rate Limited", ValidationResponse{Valid: true, RateLimited: true, RateLimitLimit: intPtr(60), RateLimitRemaining: intPtr(0), RateLimitResetSeconds: intPtr(42)}, http.StatusTooManyRequests, "0", "42"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			validationServer := mockValidationServerWith(t, tc.validation)
+			defer validationServer.Close()
+			externalValidationURL = validationServer.URL
+
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "Hi"}}}, "test-key"))
+			assertResponseStatus(t, rr, tc.expectedStatus)
+			if rr.Header().Get(rateLimitLimitHeader) != "60" || rr.Header().Get(rateLimitRemainingHeader) != tc.expectedRemaining || rr.Header().Get(rateLimitResetHeader) != "42" {
+				t.Errorf("Unexpected quota headers %v", rr.Header())
+			}
+			if rr.Header().Get("Retry-After") != tc.expectedRetryAfter {
+				t.Errorf("Expected Retry-After %q, got %q", tc.expectedRetryAfter, rr.Header().Get("Retry-After"))
+			}
+		})
+	}
+
+	t.Run("Absent Fields", func(t *testing.T) {
+		validationServer := mockValidationServer(t, true, false)
+		defer validationServer.Close()
+		externalValidationURL = validationServer.URL
+
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "Hi"}}}, "test-key"))
+		assertResponseStatus(t, rr, http.StatusOK)
+		for _, name := range []string{rateLimitLimitHeader, rateLimitRemainingHeader, rateLimitResetHeader} {
+			if _, ok := rr.Header()[name]; ok {
+				t.Errorf("Expected no %s header, got %q", name, rr.Header().Get(name))
+			}
+		}
+	})
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"ollama-proxy/logger"
+)
+
+// Ollama redirect configuration
+var (
+	followOllamaRedirects bool
+	ollamaMaxRedirects    int
+)
+
+// ollamaURLMu guards ollamaURL, which moves when Ollama redirects to a new origin
+var ollamaURLMu sync.RWMutex
+
+// currentOllamaURL returns the Ollama base URL requests are sent to
+func currentOllamaURL() string {
+	ollamaURLMu.RLock()
+	defer ollamaURLMu.RUnlock()
+	return ollamaURL
+}
+
+func setOllamaURL(u string) {
+	ollamaURLMu.Lock()
+	defer ollamaURLMu.Unlock()
+	ollamaURL = u
+	recordConfig("OLLAMA_URL", u)
+}
+
+// allowBodyReplay lets a buffered request body be sent again when Ollama redirects the request;
+// streamed bodies such as blob uploads can't be, so their redirects reach the client
+func allowBodyReplay(r *http.Request, body []byte) {
+	if !followOllamaRedirects {
+		return
+	}
+	r.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+}
+
+// originMove records that Ollama permanently answers for one origin from another
+type originMove struct {
+	fromScheme, fromHost string
+	toScheme, toHost     string
+}
+
+// redirectTransport follows Ollama's redirects, which http.Transport leaves to the caller, and
+// once a redirect only changed the origin (typically http:// to https://) sends later requests
+// straight to the new one
+type redirectTransport struct {
+	next  http.RoundTripper
+	moved atomic.Pointer[originMove]
+}
+
+func newRedirectTransport(next http.RoundTripper) *redirectTransport {
+	return &redirectTransport{next: next}
+}
+
+func (t *redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if move := t.moved.Load(); move != nil && req.URL.Scheme == move.fromScheme && req.URL.Host == move.fromHost {
+		req = withOrigin(req, req.URL, move.toScheme, move.toHost)
+	}
+
+	original := req
+	permanent := true
+	for hop := 0; ; hop++ {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || !isRedirect(resp.StatusCode) {
+			if err == nil && req != original && permanent {
+				t.learn(original.URL, req.URL)
+			}
+			return resp, err
+		}
+		permanent = permanent && (resp.StatusCode == http.StatusMovedPermanently || resp.StatusCode == http.StatusPermanentRedirect)
+		if hop >= ollamaMaxRedirects {
+			return resp, nil
+		}
+		location, err := resp.Location()
+		if err != nil {
+			return resp, nil
+		}
+		next, ok := redirectedRequest(req, location)
+		if !ok {
+			return resp, nil
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		req = next
+	}
+}
+
+// learn moves later requests, and ollamaURL, to the final origin when permanent redirects kept the
+// path and the host name, changing only the scheme or port
+func (t *redirectTransport) learn(from, to *url.URL) {
+	if from.Path != to.Path || from.RawQuery != to.RawQuery || from.Hostname() != to.Hostname() || (from.Scheme == to.Scheme && from.Host == to.Host) {
+		return
+	}
+	t.moved.Store(&originMove{fromScheme: from.Scheme, fromHost: from.Host, toScheme: to.Scheme, toHost: to.Host})
+
+	previous := currentOllamaURL()
+	base, err := url.Parse(previous)
+	if err != nil || base.Scheme != from.Scheme || base.Host != from.Host {
+		return
+	}
+	base.Scheme = to.Scheme
+	base.Host = to.Host
+	setOllamaURL(base.String())
+	logger.Info("Ollama URL updated after redirect", map[string]interface{}{
+		"old_url": previous,
+		"new_url": base.String(),
+	})
+}
+
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectedRequest repeats req at location with the same method and body, since Ollama's API is
+// POST-based and a scheme upgrade must not turn requests into GETs
+func redirectedRequest(req *http.Request, location *url.URL) (*http.Request, bool) {
+	next := withOrigin(req, location, location.Scheme, location.Host)
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, false
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, false
+		}
+		next.Body = body
+	}
+	return next, true
+}
+
+// credentialHeaders are dropped from requests redirected to another host, as net/http does, along
+// with the client's API key header
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Www-Authenticate", "Cookie", "Cookie2", "X-API-Key"}
+
+// withOrigin copies req to u with the scheme and host replaced, letting the Host header follow.
+// Credentials are only kept when the host name stays the same.
+func withOrigin(req *http.Request, u *url.URL, scheme, host string) *http.Request {
+	next := req.Clone(req.Context())
+	target := *u
+	target.Scheme = scheme
+	target.Host = host
+	next.URL = &target
+	next.Host = ""
+	if target.Hostname() != req.URL.Hostname() {
+		for _, name := range credentialHeaders {
+			next.Header.Del(name)
+		}
+		next.Header.Del(apiKeyHeaderName)
+	}
+	return next
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// TestProxyHandlerFollowsOllamaRedirect tests that a redirect to a new origin is followed with the
+// request body intact, and that later requests go straight to the new origin
+func TestProxyHandlerFollowsOllamaRedirect(t *testing.T) {
+	var received atomic.Value
+	newServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received.Store(r.Method + " " + r.URL.Path + " " + string(body))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"model":"llama2","message":{"role":"assistant","content":"Hi"},"done":true,"prompt_eval_count":5,"eval_count":1}`))
+	}))
+	defer newServer.Close()
+	var redirected atomic.Int32
+	oldServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirected.Add(1)
+		http.Redirect(w, r, newServer.URL+r.URL.RequestURI(), http.StatusMovedPermanently)
+	}))
+	defer oldServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+
+	useProxyTargets(t, oldServer.URL, validationServer.URL, "")
+	followOllamaRedirects = true
+	ollamaMaxRedirects = 3
+	defer func() { followOllamaRedirects = false }()
+	resetReverseProxy()
+
+	chat := ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "Hello"}}}
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", chat, "test-api-key"))
+		assertResponseStatus(t, rr, http.StatusOK)
+		if got, _ := received.Load().(string); got != `POST /api/chat {"model":"llama2","messages":[{"role":"user","content":"Hello"}],"stream":false}` {
+			t.Errorf("Expected the chat request to reach the new origin unchanged, got %q", got)
+		}
+	}
+
+	if n := redirected.Load(); n != 1 {
+		t.Errorf("Expected only the first request to be redirected, got %d", n)
+	}
+	if currentOllamaURL() != newServer.URL {
+		t.Errorf("Expected ollamaURL to move to %s, got %s", newServer.URL, currentOllamaURL())
+	}
+}
+
+// TestProxyHandlerOllamaRedirectLimits tests that redirects reach the client when following is off or
+// the chain is longer than OLLAMA_MAX_REDIRECTS
+func TestProxyHandlerOllamaRedirectLimits(t *testing.T) {
+	var hops atomic.Int32
+	loopServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hops.Add(1)
+		http.Redirect(w, r, r.URL.Path, http.StatusTemporaryRedirect)
+	}))
+	defer loopServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaURL, validationServer.URL, "")
+	defer func() { followOllamaRedirects = false }()
+	chat := ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "Hello"}}}
+
+	testCases := []struct {
+		name     string
+		follow   bool
+		expected int32
+	}{
+		{"Disabled", false, 1},
+		{"Stops After Max Redirects", true, 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			useProxyTargets(t, loopServer.URL, "", "")
+			followOllamaRedirects = tc.follow
+			ollamaMaxRedirects = 2
+			hops.Store(0)
+			resetReverseProxy()
+
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", chat, "test-api-key"))
+			assertResponseStatus(t, rr, http.StatusTemporaryRedirect)
+			if n := hops.Load(); n != tc.expected {
+				t.Errorf("Expected %d upstream requests, got %d", tc.expected, n)
+			}
+		})
+	}
+}
+
+// TestOllamaRedirectCredentials tests that credentials only follow redirects on the same host, and that
+// only permanent redirects move ollamaURL
+func TestOllamaRedirectCredentials(t *testing.T) {
+	var seen atomic.Value
+	newServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen.Store(r.Header.Get("Authorization") + "|" + r.Header.Get("X-API-Key"))
+	}))
+	defer newServer.Close()
+	apiKeyHeaderName = "X-API-Key"
+	ollamaMaxRedirects = 3
+	defer setOllamaURL(currentOllamaURL())
+
+	testCases := []struct {
+		name          string
+		status        int
+		host          string
+		expectedSeen  string
+		expectedMoved bool
+	}{
+		{"Permanent Same Host", http.StatusPermanentRedirect, "127.0.0.1", "Bearer tenant|tenant-key", true},
+		{"Temporary Same Host", http.StatusTemporaryRedirect, "127.0.0.1", "Bearer tenant|tenant-key", false},
+		{"Permanent Other Host", http.StatusMovedPermanently, "localhost", "|", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			target := strings.Replace(newServer.URL, "127.0.0.1", tc.host, 1)
+			oldServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, target+r.URL.RequestURI(), tc.status)
+			}))
+			defer oldServer.Close()
+			setOllamaURL(oldServer.URL)
+
+			req := httptest.NewRequest("GET", oldServer.URL+"/api/tags", nil)
+			req.RequestURI = ""
+			req.Header.Set("Authorization", "Bearer tenant")
+			req.Header.Set("X-API-Key", "tenant-key")
+			resp, err := newRedirectTransport(http.DefaultTransport).RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if got := seen.Load(); got != tc.expectedSeen {
+				t.Errorf("Expected credentials %q at the new origin, got %q", tc.expectedSeen, got)
+			}
+			if moved := currentOllamaURL() != oldServer.URL; moved != tc.expectedMoved {
+				t.Errorf("Expected ollamaURL moved=%v, got %s", tc.expectedMoved, currentOllamaURL())
+			}
+		})
+	}
+}
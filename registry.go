@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+)
+
+// Model registry configuration
+var (
+	modelRegistryPath string
+	modelRegistry     map[string]string
+	modelVersionPins  map[string]string
+)
+
+// loadModelRegistry reads a JSON file mapping short model names to full Ollama references
+func loadModelRegistry(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MODEL_REGISTRY: %v", err)
+	}
+	var registry map[string]string
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return nil, fmt.Errorf("failed to parse MODEL_REGISTRY: %v", err)
+	}
+	return registry, nil
+}
+
+// resolveModelAlias returns the full model reference registered for a short name
+func resolveModelAlias(model string) (string, bool) {
+	full, ok := modelRegistry[model]
+	return full, ok && full != ""
+}
+
+// pinnedModelVersion returns the exact version an operator pinned a model to; only requests that run
+// the model are pinned, so pulls, copies and creates keep the names clients asked for
+func pinnedModelVersion(path, model string) (string, bool) {
+	switch canonicalEndpoint(path) {
+	case "chat", "generate", "embed":
+	default:
+		return "", false
+	}
+	pinned, ok := modelVersionPins[model]
+	return pinned, ok && pinned != "" && pinned != model
+}
+
+// modelAliases returns the registered short names in order
+func modelAliases() []string {
+	aliases := make([]string, 0, len(modelRegistry))
+	for alias := range modelRegistry {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+// rewriteModelName replaces the model, and the deprecated name field model transfers may use, in the request body
+func rewriteModelName(r *http.Request, body []byte, alias, full string) []byte {
+	rewritten, changed := rewriteJSONBody(body, func(obj map[string]interface{}) bool {
+		changed := false
+		for _, key := range []string{"model", "name"} {
+			if obj[key] == alias {
+				obj[key] = full
+				changed = true
+			}
+		}
+		return changed
+	})
+	if !changed {
+		return body
+	}
+	setRequestBody(r, rewritten)
+	return rewritten
+}
+
+// ModelNotFoundResponse is returned when neither the registry nor Ollama knows a model
+type ModelNotFoundResponse struct {
+	Error   string   `json:"error"`
+	Aliases []string `json:"aliases"`
+}
+
+// aliasErrorWriter replaces Ollama's model-not-found response with one listing the registered aliases
+type aliasErrorWriter struct {
+	http.ResponseWriter
+	notFound bool
+	upstream bytes.Buffer
+}
+
+func newAliasErrorWriter(w http.ResponseWriter) *aliasErrorWriter {
+	return &aliasErrorWriter{ResponseWriter: w}
+}
+
+func (aw *aliasErrorWriter) WriteHeader(statusCode int) {
+	if statusCode == http.StatusNotFound {
+		aw.notFound = true
+		aw.Header().Set("Content-Type", "application/json")
+		aw.Header().Del("Content-Length")
+	}
+	aw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (aw *aliasErrorWriter) Write(b []byte) (int, error) {
+	if aw.notFound {
+		return aw.upstream.Write(b)
+	}
+	return aw.ResponseWriter.Write(b)
+}
+
+// finish writes the replacement error, keeping Ollama's message when it sent one
+func (aw *aliasErrorWriter) finish() {
+	if !aw.notFound {
+		return
+	}
+	var upstream struct {
+		Error string `json:"error"`
+	}
+	json.Unmarshal(aw.upstream.Bytes(), &upstream)
+	if upstream.Error == "" {
+		upstream.Error = "model not found"
+	}
+	json.NewEncoder(aw.ResponseWriter).Encode(ModelNotFoundResponse{
+		Error:   upstream.Error,
+		Aliases: modelAliases(),
+	})
+}
+
+func (aw *aliasErrorWriter) Flush() {
+	http.NewResponseController(aw.ResponseWriter).Flush()
+}
+
+func (aw *aliasErrorWriter) Unwrap() http.ResponseWriter {
+	return aw.ResponseWriter
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"ollama-proxy/logger"
+)
+
+// TestModelRegistry tests that short names are rewritten and unknown names list the registered aliases
+func TestModelRegistry(t *testing.T) {
+	var forwarded string
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req GenerateRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		forwarded = req.Model
+		if req.Model != "llama3.2:70b" && req.Model != "mistral:7b" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":"model '` + req.Model + `' not found"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(GenerateResponse{Model: req.Model, Response: "Hi", Done: true})
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	path := filepath.Join(t.TempDir(), "registry.json")
+	os.WriteFile(path, []byte(`{"gpt4":"llama3.2:70b","fast":"mistral:7b"}`), 0600)
+	registry, err := loadModelRegistry(path)
+	if err != nil {
+		t.Fatalf("Error loading registry: %v", err)
+	}
+	modelRegistry = registry
+	defer func() { modelRegistry = nil }()
+
+	generate := func(model string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, createTestRequest(t, "POST", "/api/generate", GenerateRequest{Model: model, Prompt: "Hi"}, "test-key"))
+		return rr
+	}
+
+	// Aliases are rewritten before forwarding and reported under the full name
+	rr := generate("gpt4")
+	assertResponseStatus(t, rr, http.StatusOK)
+	if forwarded != "llama3.2:70b" {
+		t.Errorf("Expected alias to be rewritten, Ollama got %q", forwarded)
+	}
+	if metrics := waitForMetrics(t, received); metrics.Model != "llama3.2:70b" {
+		t.Errorf("Expected metrics for the full model, got %q", metrics.Model)
+	}
+
+	// Full names pass through untouched
+	assertResponseStatus(t, generate("mistral:7b"), http.StatusOK)
+	waitForMetrics(t, received)
+	if forwarded != "mistral:7b" {
+		t.Errorf("Expected full name to pass through, Ollama got %q", forwarded)
+	}
+
+	// Unknown names fail with the list of aliases
+	rr = generate("claude")
+	assertResponseStatus(t, rr, http.StatusNotFound)
+	waitForMetrics(t, received)
+	var notFound ModelNotFoundResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &notFound); err != nil {
+		t.Fatalf("Expected JSON error, got %s", rr.Body.String())
+	}
+	if notFound.Error != "model 'claude' not found" || !reflect.DeepEqual(notFound.Aliases, []string{"fast", "gpt4"}) {
+		t.Errorf("Expected error listing aliases, got %+v", notFound)
+	}
+}
+
+// TestLoadModelRegistryInvalid tests that unreadable registries are reported
+func TestLoadModelRegistryInvalid(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.json")
+	os.WriteFile(invalid, []byte(`["not","a","map"]`), 0600)
+
+	for _, path := range []string{filepath.Join(dir, "missing.json"), invalid} {
+		if _, err := loadModelRegistry(path); err == nil {
+			t.Errorf("Expected error loading %s", path)
+		}
+	}
+}
+
+// TestModelVersionPins tests that pinned models are substituted for inference requests and logged
+func TestModelVersionPins(t *testing.T) {
+	var forwarded string
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		forwarded, _ = req["model"].(string)
+		json.NewEncoder(w).Encode(GenerateResponse{Model: forwarded, Response: "Hi", Done: true})
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, Scopes: []string{adminScope}})
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	modelVersionPins = map[string]string{"llama3": "llama3:8b-instruct-q4_0"}
+	defer func() { modelVersionPins = nil }()
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+
+	testCases := []struct {
+		name     string
+		path     string
+		body     interface{}
+		expected string
+	}{
+		{"Generate Pinned", "/api/generate", GenerateRequest{Model: "llama3", Prompt: "Hi"}, "llama3:8b-instruct-q4_0"},
+		{"Unpinned Model", "/api/generate", GenerateRequest{Model: "mistral", Prompt: "Hi"}, "mistral"},
+		{"Create Keeps Name", "/api/create", CreateRequest{Model: "llama3", From: "llama3:latest"}, "llama3"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs.Reset()
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", tc.path, tc.body, "test-key"))
+			assertResponseStatus(t, rr, http.StatusOK)
+			metrics := waitForMetrics(t, received)
+
+			if forwarded != tc.expected || metrics.Model != tc.expected {
+				t.Errorf("Expected %q forwarded and reported, got %q and %q", tc.expected, forwarded, metrics.Model)
+			}
+			pinned := strings.Contains(logs.String(), `"message":"Model version pinned"`)
+			if pinned != (tc.expected == "llama3:8b-instruct-q4_0") {
+				t.Errorf("Unexpected pin log state %v: %s", pinned, logs.String())
+			}
+		})
+	}
+}
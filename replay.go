@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ollama-proxy/logger"
+)
+
+// Replay capture configuration
+var (
+	replayCapturePath     string
+	replaySampleRate      float64
+	replayAllowRawPrompts bool
+	replayCapture         *replayRecorder
+)
+
+// replayBufferSize bounds the number of captured entries waiting to be written
+const replayBufferSize = 1024
+
+// replayPlaceholder is repeated to build length-preserving stand-ins for redacted text
+const replayPlaceholder = "lorem ipsum dolor sit amet "
+
+// redactedBodyKeys lists the request body fields that may carry prompt content
+var redactedBodyKeys = map[string]bool{
+	"prompt":   true,
+	"content":  true,
+	"system":   true,
+	"input":    true,
+	"template": true,
+	"suffix":   true,
+	"images":   true,
+	// tool_calls[].function.arguments echoes what the model pulled out of the conversation
+	"arguments": true,
+}
+
+// replayRecorder writes sampled requests to an NDJSON replay file in the background
+type replayRecorder struct {
+	start   time.Time
+	file    *os.File
+	entries chan ReplayEntry
+	done    chan struct{}
+	dropped atomic.Int64
+
+	// mu guards closed, so requests still running when shutdown gives up don't send on a closed queue
+	mu     sync.RWMutex
+	closed bool
+}
+
+// startReplayRecorder opens the replay file and starts the background writer
+func startReplayRecorder(path string) (*replayRecorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replay capture file: %v", err)
+	}
+
+	recorder := &replayRecorder{
+		start:   time.Now(),
+		file:    file,
+		entries: make(chan ReplayEntry, replayBufferSize),
+		done:    make(chan struct{}),
+	}
+	go recorder.run()
+	return recorder, nil
+}
+
+func (rec *replayRecorder) run() {
+	defer close(rec.done)
+
+	writer := bufio.NewWriter(rec.file)
+	encoder := json.NewEncoder(writer)
+	for entry := range rec.entries {
+		if err := encoder.Encode(entry); err != nil {
+			logger.Error("Error writing replay entry", err, nil)
+			continue
+		}
+		// Flush once the queue drains so a crash loses at most the current burst
+		if len(rec.entries) == 0 {
+			writer.Flush()
+		}
+	}
+	writer.Flush()
+}
+
+// Close stops accepting entries and waits for pending ones to be written
+func (rec *replayRecorder) Close() error {
+	rec.mu.Lock()
+	rec.closed = true
+	close(rec.entries)
+	rec.mu.Unlock()
+	<-rec.done
+	if dropped := rec.dropped.Load(); dropped > 0 {
+		logger.Warning("Replay capture dropped entries", map[string]interface{}{
+			"dropped": dropped,
+		})
+	}
+	return rec.file.Close()
+}
+
+// Capture samples a request into the replay file without blocking the caller
+func (rec *replayRecorder) Capture(r *http.Request, apiKey, model string, body []byte, receivedAt time.Time) {
+	if rand.Float64() >= replaySampleRate {
+		return
+	}
+
+	entry := ReplayEntry{
+		OffsetMs: receivedAt.Sub(rec.start).Milliseconds(),
+		Method:   r.Method,
+		Endpoint: r.URL.Path,
+		Model:    model,
+		Stream:   requestStreams(r.URL.Path, body),
+		KeyHash:  hashAPIKey(apiKey),
+		Body:     replayBodySkeleton(body, replayAllowRawPrompts),
+	}
+
+	rec.mu.RLock()
+	defer rec.mu.RUnlock()
+	if rec.closed {
+		return
+	}
+	select {
+	case rec.entries <- entry:
+	default:
+		rec.dropped.Add(1)
+	}
+}
+
+// hashAPIKey returns a short stable fingerprint of an API key
+func hashAPIKey(apiKey string) string {
+	if apiKey == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// replayBodySkeleton returns the request body with prompt content replaced by placeholder text
+func replayBodySkeleton(body []byte, allowRaw bool) json.RawMessage {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var parsed interface{}
+	if err := decoder.Decode(&parsed); err != nil {
+		return nil
+	}
+	if !allowRaw {
+		parsed = redactValue(parsed, false)
+	}
+
+	skeleton, err := json.Marshal(parsed)
+	if err != nil {
+		return nil
+	}
+	return skeleton
+}
+
+// redactValue walks a decoded JSON value and replaces strings under prompt-bearing keys
+func redactValue(value interface{}, redact bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = redactValue(child, redact || redactedBodyKeys[key])
+		}
+		return v
+	case []interface{}:
+		for i, child := range v {
+			v[i] = redactValue(child, redact)
+		}
+		return v
+	case string:
+		if redact {
+			return placeholderText(len([]rune(v)))
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// placeholderText returns filler text with exactly n characters
+func placeholderText(n int) string {
+	if n <= 0 {
+		return ""
+	}
+	repeats := n/len(replayPlaceholder) + 1
+	return strings.Repeat(replayPlaceholder, repeats)[:n]
+}
+
+// readReplayFile loads all entries from an NDJSON replay file
+func readReplayFile(path string) ([]ReplayEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open replay file: %v", err)
+	}
+	defer file.Close()
+
+	var entries []ReplayEntry
+	decoder := json.NewDecoder(file)
+	for {
+		var entry ReplayEntry
+		if err := decoder.Decode(&entry); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse replay entry %d: %v", len(entries)+1, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].OffsetMs < entries[j].OffsetMs
+	})
+	return entries, nil
+}
+
+// replayOptions controls how a replay file is sent to the target
+type replayOptions struct {
+	TargetURL    string
+	Speed        float64
+	APIKey       string
+	APIKeyHeader string
+}
+
+// replayResult records the outcome of one replayed request
+type replayResult struct {
+	Index      int
+	Endpoint   string
+	StatusCode int
+	Latency    time.Duration
+	Err        error
+}
+
+// replayEntries sends entries to the target preserving their relative timing scaled by Speed
+func replayEntries(ctx context.Context, entries []ReplayEntry, opts replayOptions) []replayResult {
+	if opts.Speed <= 0 {
+		opts.Speed = 1
+	}
+
+	client := &http.Client{}
+	results := make([]replayResult, len(entries))
+	start := time.Now()
+
+	var wg sync.WaitGroup
+	for i, entry := range entries {
+		due := time.Duration(float64(entry.OffsetMs)*float64(time.Millisecond)/opts.Speed) - time.Since(start)
+		if due > 0 {
+			select {
+			case <-time.After(due):
+			case <-ctx.Done():
+				wg.Wait()
+				return results[:i]
+			}
+		}
+
+		wg.Add(1)
+		go func(i int, entry ReplayEntry) {
+			defer wg.Done()
+			results[i] = sendReplayEntry(ctx, client, i, entry, opts)
+		}(i, entry)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func sendReplayEntry(ctx context.Context, client *http.Client, index int, entry ReplayEntry, opts replayOptions) replayResult {
+	result := replayResult{Index: index, Endpoint: entry.Endpoint}
+
+	req, err := http.NewRequestWithContext(ctx, entry.Method, singleJoiningSlash(opts.TargetURL, entry.Endpoint), bytes.NewReader(entry.Body))
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if opts.APIKey != "" {
+		req.Header.Set(opts.APIKeyHeader, opts.APIKey)
+	}
+
+	sentAt := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so streaming responses are timed to completion
+	_, err = io.Copy(io.Discard, resp.Body)
+	result.Latency = time.Since(sentAt)
+	result.StatusCode = resp.StatusCode
+	result.Err = err
+	return result
+}
+
+// latencyDistribution summarizes a set of latencies
+type latencyDistribution struct {
+	Count int
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+func newLatencyDistribution(latencies []time.Duration) latencyDistribution {
+	dist := latencyDistribution{Count: len(latencies)}
+	if len(latencies) == 0 {
+		return dist
+	}
+
+	sorted := append([]time.Duration(nil), latencies...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	percentile := func(p float64) time.Duration {
+		return sorted[int(p*float64(len(sorted)-1))]
+	}
+	dist.P50 = percentile(0.50)
+	dist.P90 = percentile(0.90)
+	dist.P99 = percentile(0.99)
+	dist.Max = sorted[len(sorted)-1]
+	return dist
+}
+
+// writeReplayReport prints overall and per-endpoint latency distributions
+func writeReplayReport(w io.Writer, results []replayResult) {
+	var all []time.Duration
+	byEndpoint := make(map[string][]time.Duration)
+	failures := 0
+	for _, result := range results {
+		if result.Err != nil || result.StatusCode >= 400 {
+			failures++
+			continue
+		}
+		all = append(all, result.Latency)
+		byEndpoint[result.Endpoint] = append(byEndpoint[result.Endpoint], result.Latency)
+	}
+
+	fmt.Fprintf(w, "replayed %d requests, %d failed\n", len(results), failures)
+	fmt.Fprintf(w, "%-24s %8s %10s %10s %10s %10s\n", "endpoint", "count", "p50", "p90", "p99", "max")
+	printRow := func(name string, dist latencyDistribution) {
+		fmt.Fprintf(w, "%-24s %8d %10s %10s %10s %10s\n", name, dist.Count,
+			dist.P50.Round(time.Millisecond), dist.P90.Round(time.Millisecond),
+			dist.P99.Round(time.Millisecond), dist.Max.Round(time.Millisecond))
+	}
+
+	endpoints := make([]string, 0, len(byEndpoint))
+	for endpoint := range byEndpoint {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		printRow(endpoint, newLatencyDistribution(byEndpoint[endpoint]))
+	}
+	printRow("all", newLatencyDistribution(all))
+}
+
+// runReplay replays a capture file against a target and prints the latency report
+func runReplay(path string, opts replayOptions) error {
+	entries, err := readReplayFile(path)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Starting replay", map[string]interface{}{
+		"file":    path,
+		"target":  opts.TargetURL,
+		"speed":   opts.Speed,
+		"entries": len(entries),
+	})
+	results := replayEntries(context.Background(), entries, opts)
+	writeReplayReport(os.Stdout, results)
+	return nil
+}
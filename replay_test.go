@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestReplayCapture tests that proxied requests are captured with redacted prompts
+func TestReplayCapture(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	path := filepath.Join(t.TempDir(), "capture.ndjson")
+	recorder, err := startReplayRecorder(path)
+	if err != nil {
+		t.Fatalf("Error starting replay recorder: %v", err)
+	}
+	replayCapture = recorder
+	replaySampleRate = 1
+	replayAllowRawPrompts = false
+	defer func() { replayCapture = nil }()
+
+	prompt := "What is the capital of France?"
+	requests := []struct {
+		path string
+		body interface{}
+	}{
+		{"/api/generate", GenerateRequest{Model: "mistral", Prompt: prompt, Stream: false}},
+		{"/api/chat", map[string]interface{}{
+			"model":    "llama2",
+			"messages": []ChatMessage{{Role: "user", Content: prompt}},
+		}},
+		{"/api/embed", EmbedRequest{Model: "nomic-embed", Input: []string{prompt}}},
+	}
+	for _, tr := range requests {
+		req := createTestRequest(t, "POST", tr.path, tr.body, "test-api-key")
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, req)
+		assertResponseStatus(t, rr, http.StatusOK)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Error closing replay recorder: %v", err)
+	}
+
+	entries, err := readReplayFile(path)
+	if err != nil {
+		t.Fatalf("Error reading replay file: %v", err)
+	}
+	if len(entries) != len(requests) {
+		t.Fatalf("Expected %d entries, got %d", len(requests), len(entries))
+	}
+
+	expectedStream := []bool{false, true, false}
+	for i, entry := range entries {
+		if entry.Endpoint != requests[i].path {
+			t.Errorf("Entry %d: expected endpoint %s, got %s", i, requests[i].path, entry.Endpoint)
+		}
+		if entry.Stream != expectedStream[i] {
+			t.Errorf("Entry %d: expected stream %v, got %v", i, expectedStream[i], entry.Stream)
+		}
+		if entry.KeyHash == "" || entry.KeyHash == "test-api-key" {
+			t.Errorf("Entry %d: expected hashed API key, got %q", i, entry.KeyHash)
+		}
+		if i > 0 && entry.OffsetMs < entries[i-1].OffsetMs {
+			t.Errorf("Entry %d: offsets out of order", i)
+		}
+		if bytes.Contains(entry.Body, []byte("capital")) {
+			t.Errorf("Entry %d: raw prompt leaked into replay file: %s", i, entry.Body)
+		}
+	}
+	if entries[0].Model != "mistral" {
+		t.Errorf("Expected model mistral, got %s", entries[0].Model)
+	}
+
+	var generate GenerateRequest
+	if err := json.Unmarshal(entries[0].Body, &generate); err != nil {
+		t.Fatalf("Error decoding captured body: %v", err)
+	}
+	if len(generate.Prompt) != len(prompt) {
+		t.Errorf("Expected redacted prompt of length %d, got %d", len(prompt), len(generate.Prompt))
+	}
+}
+
+// TestReplayBodySkeleton tests prompt redaction in captured bodies
+func TestReplayBodySkeleton(t *testing.T) {
+	body := []byte(`{"model":"llama2","messages":[{"role":"user","content":"héllo"}],"options":{"seed":12345678901234567}}`)
+
+	skeleton := replayBodySkeleton(body, false)
+	var chat ChatRequest
+	if err := json.Unmarshal(skeleton, &chat); err != nil {
+		t.Fatalf("Error decoding skeleton: %v", err)
+	}
+	if chat.Messages[0].Role != "user" {
+		t.Errorf("Expected role to be kept, got %s", chat.Messages[0].Role)
+	}
+	if chat.Messages[0].Content != "lorem" {
+		t.Errorf("Expected placeholder content, got %s", chat.Messages[0].Content)
+	}
+	if !bytes.Contains(skeleton, []byte("12345678901234567")) {
+		t.Errorf("Expected numeric options to be preserved exactly, got %s", skeleton)
+	}
+
+	raw := replayBodySkeleton(body, true)
+	if !bytes.Contains(raw, []byte("héllo")) {
+		t.Errorf("Expected raw prompt when explicitly allowed, got %s", raw)
+	}
+
+	if replayBodySkeleton([]byte("not json"), false) != nil {
+		t.Error("Expected nil skeleton for invalid JSON")
+	}
+
+	for _, arguments := range []string{`{"city":"Paris","days":3}`, `"{\"city\":\"Paris\"}"`} {
+		toolCall := []byte(`{"model":"llama3","messages":[{"role":"assistant","tool_calls":[{"function":{"name":"get_weather","arguments":` + arguments + `}}]}]}`)
+		skeleton := replayBodySkeleton(toolCall, false)
+		if bytes.Contains(skeleton, []byte("Paris")) || !bytes.Contains(skeleton, []byte("get_weather")) {
+			t.Errorf("Expected tool call arguments redacted and the tool name kept, got %s", skeleton)
+		}
+	}
+}
+
+// TestReplayRecorderClosed tests that requests finishing after shutdown closed the recorder are dropped quietly
+func TestReplayRecorderClosed(t *testing.T) {
+	recorder, err := startReplayRecorder(filepath.Join(t.TempDir(), "capture.ndjson"))
+	if err != nil {
+		t.Fatalf("Error starting replay recorder: %v", err)
+	}
+	replaySampleRate = 1
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("Error closing replay recorder: %v", err)
+	}
+	req := httptest.NewRequest("POST", "/api/generate", nil)
+	recorder.Capture(req, "test-api-key", "mistral", []byte(`{"model":"mistral"}`), time.Now())
+}
+
+// TestReplayEntries tests replay ordering and pacing against a mock backend
+func TestReplayEntries(t *testing.T) {
+	var mu sync.Mutex
+	var arrivals []string
+	var arrivalTimes []time.Time
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Replay-Key") != "replay-key" {
+			t.Errorf("Expected replay API key header, got %q", r.Header.Get("X-Replay-Key"))
+		}
+		var body struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		arrivals = append(arrivals, body.Model)
+		arrivalTimes = append(arrivalTimes, time.Now())
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer backend.Close()
+
+	entries := []ReplayEntry{
+		{OffsetMs: 0, Method: "POST", Endpoint: "/api/generate", Body: json.RawMessage(`{"model":"first"}`)},
+		{OffsetMs: 200, Method: "POST", Endpoint: "/api/generate", Body: json.RawMessage(`{"model":"second"}`)},
+		{OffsetMs: 400, Method: "POST", Endpoint: "/api/chat", Body: json.RawMessage(`{"model":"third"}`)},
+	}
+
+	start := time.Now()
+	results := replayEntries(context.Background(), entries, replayOptions{
+		TargetURL:    backend.URL,
+		Speed:        2,
+		APIKey:       "replay-key",
+		APIKeyHeader: "X-Replay-Key",
+	})
+
+	if strings.Join(arrivals, ",") != "first,second,third" {
+		t.Errorf("Expected requests in capture order, got %v", arrivals)
+	}
+	for i, expected := range []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		elapsed := arrivalTimes[i].Sub(start)
+		if elapsed < expected || elapsed > expected+80*time.Millisecond {
+			t.Errorf("Request %d: expected arrival near %v, got %v", i, expected, elapsed)
+		}
+	}
+	for _, result := range results {
+		if result.Err != nil || result.StatusCode != http.StatusOK {
+			t.Errorf("Request %d failed: status %d, error %v", result.Index, result.StatusCode, result.Err)
+		}
+	}
+
+	var report bytes.Buffer
+	writeReplayReport(&report, results)
+	if !strings.Contains(report.String(), "replayed 3 requests, 0 failed") {
+		t.Errorf("Unexpected replay report: %s", report.String())
+	}
+}
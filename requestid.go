@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultRequestIDHeader = "X-Request-ID"
+
+	// maxRequestIDLength bounds incoming IDs, which are echoed back and forwarded to Ollama
+	maxRequestIDLength = 128
+)
+
+// Request ID configuration; the default holds even before configuration is loaded
+var (
+	requestIDHeader = defaultRequestIDHeader
+)
+
+// requestIDFor returns the client's request ID from REQUEST_ID_HEADER, or a new one when the client
+// sent none or one that can't be passed on safely
+func requestIDFor(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); validRequestID(id) {
+		return id
+	}
+	return newRequestID()
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDKey carries a request's ID in its context to the calls made on its behalf
+type requestIDKey struct{}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFrom returns the ID of the request ctx belongs to, or a new one for calls made on no request's behalf
+func requestIDFrom(ctx context.Context) string {
+	if id, ok := ctx.Value(requestIDKey{}).(string); ok {
+		return id
+	}
+	return newRequestID()
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(id)
+}
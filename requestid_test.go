@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"ollama-proxy/logger"
+)
+
+// TestProxyHandlerRequestID tests passing request IDs through under the configured header
+func TestProxyHandlerRequestID(t *testing.T) {
+	upstreamIDs := make(chan string, 1)
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamIDs <- r.Header.Get(requestIDHeader)
+		json.NewEncoder(w).Encode(ChatResponse{Model: "llama2", Done: true})
+	}))
+	defer ollamaServer.Close()
+	validationIDs := make(chan string, 1)
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		validationIDs <- r.Header.Get(requestIDHeader)
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true})
+	}))
+	defer validationServer.Close()
+	metricsIDs := make(chan string, 1)
+	metricsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metricsIDs <- r.Header.Get(requestIDHeader)
+	}))
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+	defer func() { requestIDHeader = defaultRequestIDHeader }()
+
+	testCases := []struct {
+		name      string
+		header    string
+		incoming  string
+		generated bool
+	}{
+		{"Default Header Passed Through", "X-Request-ID", "req-123", false},
+		{"Custom Header Passed Through", "X-Correlation-Id", "corr-456", false},
+		{"Generated When Missing", "X-Trace-Id", "", true},
+		{"Generated When Malformed", "X-Trace-Id", strings.Repeat("x", maxRequestIDLength+1), true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			requestIDHeader = tc.header
+			t.Cleanup(metricsDeliveries.Wait) // metrics read the header name
+
+			var logs bytes.Buffer
+			logger.SetOutput(&logs)
+			defer logger.SetOutput(os.Stdout)
+
+			req := createTestRequest(t, "POST", "/api/chat", ChatRequest{Model: "llama2"}, "test-key")
+			if tc.incoming != "" {
+				req.Header.Set(tc.header, tc.incoming)
+			}
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, req)
+			assertResponseStatus(t, rr, http.StatusOK)
+
+			id := rr.Header().Get(tc.header)
+			if tc.generated && (id == "" || id == tc.incoming) {
+				t.Errorf("Expected a generated request ID, got %q", id)
+			}
+			if !tc.generated && id != tc.incoming {
+				t.Errorf("Expected request ID %q on the response, got %q", tc.incoming, id)
+			}
+			if upstream := <-upstreamIDs; upstream != id {
+				t.Errorf("Expected request ID %q sent to Ollama, got %q", id, upstream)
+			}
+			if validation := <-validationIDs; validation != id {
+				t.Errorf("Expected request ID %q sent to the validation service, got %q", id, validation)
+			}
+			if metrics := <-metricsIDs; metrics != id {
+				t.Errorf("Expected request ID %q sent with metrics, got %q", id, metrics)
+			}
+			if !strings.Contains(logs.String(), `"request_id":"`+id+`"`) {
+				t.Errorf("Expected request ID %q in the logs", id)
+			}
+		})
+	}
+}
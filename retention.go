@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+	"sync"
+)
+
+// Zero-retention configuration
+var (
+	zeroRetentionKeys map[string]bool
+	retentionAnswers  = newRetentionMemory(defaultRetentionMemorySize)
+)
+
+// defaultRetentionMemorySize bounds the keys whose retention answers are remembered
+const defaultRetentionMemorySize = 10000
+
+// contentLogFields lists log fields whose values are derived from request or response bodies
+var contentLogFields = []string{
+	"upstream_error",
+	"field_error",
+	"response_preview",
+}
+
+// RetentionPolicy decides what a request's prompts and completions may be retained by.
+// Every feature that stores or logs body content must consult it.
+type RetentionPolicy struct {
+	zeroRetention bool
+	pending       bool // the validation service may still ask for zero retention
+}
+
+// retentionPolicyFor builds the policy for a key from configuration and, once known, its validation result
+func retentionPolicyFor(apiKey string, validation ValidationResponse) RetentionPolicy {
+	return RetentionPolicy{
+		zeroRetention: zeroRetentionKeys[apiKey] || validation.ZeroRetention,
+	}
+}
+
+// retentionPolicyBeforeValidation builds the policy for a key before it is validated, from configuration and
+// the validation service's last answer for the key. Keys without one are pending until they are validated.
+func retentionPolicyBeforeValidation(apiKey string) RetentionPolicy {
+	if zeroRetentionKeys[apiKey] {
+		return RetentionPolicy{zeroRetention: true}
+	}
+	zeroRetention, known := retentionAnswers.recall(apiKey)
+	return RetentionPolicy{zeroRetention: zeroRetention, pending: !known}
+}
+
+// ZeroRetention reports whether the request's content must not be retained anywhere
+func (p RetentionPolicy) ZeroRetention() bool {
+	return p.zeroRetention
+}
+
+// AllowReplayCapture reports whether the request may be sampled into the replay file
+func (p RetentionPolicy) AllowReplayCapture() bool {
+	return !p.zeroRetention
+}
+
+// AllowTokenVerification reports whether the request's text may be kept for token count verification
+func (p RetentionPolicy) AllowTokenVerification() bool {
+	return !p.zeroRetention
+}
+
+// Fingerprint returns the hex SHA-256 and size of a request body, or only its size while the request may
+// be zero-retention
+func (p RetentionPolicy) Fingerprint(body []byte) (string, int) {
+	if p.zeroRetention || p.pending {
+		return "", len(body)
+	}
+	return bodyFingerprint(body)
+}
+
+// LogFields removes body-derived fields so only metadata reaches the logs
+func (p RetentionPolicy) LogFields(fields map[string]interface{}) map[string]interface{} {
+	if !p.zeroRetention {
+		return fields
+	}
+	for _, key := range contentLogFields {
+		delete(fields, key)
+	}
+	return fields
+}
+
+// Metrics removes body-derived values while keeping token counts and timings
+func (p RetentionPolicy) Metrics(metrics MetricsData) MetricsData {
+	if p.zeroRetention {
+		metrics.UpstreamError = ""
+	}
+	return metrics
+}
+
+// retentionMemory remembers whether the validation service asked for zero retention for each key, so a key's
+// later requests know their policy before they are validated
+type retentionMemory struct {
+	mu      sync.Mutex
+	size    int
+	answers map[string]bool
+}
+
+func newRetentionMemory(size int) *retentionMemory {
+	return &retentionMemory{size: size, answers: make(map[string]bool)}
+}
+
+// remember records a key's latest answer; once full, earlier keys are forgotten and pending again
+func (m *retentionMemory) remember(apiKey string, zeroRetention bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.answers[apiKey]; !ok && len(m.answers) >= m.size {
+		clear(m.answers)
+	}
+	m.answers[apiKey] = zeroRetention
+}
+
+// recall returns a key's latest answer and whether there is one
+func (m *retentionMemory) recall(apiKey string) (zeroRetention, known bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	zeroRetention, known = m.answers[apiKey]
+	return zeroRetention, known
+}
+
+// parseKeyList splits a comma-separated list of keys into a set
+func parseKeyList(raw string) map[string]bool {
+	keys := make(map[string]bool)
+	for _, key := range strings.Split(raw, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys[key] = true
+		}
+	}
+	return keys
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"ollama-proxy/logger"
+)
+
+const (
+	secretPrompt     = "SECRET-PROMPT"
+	secretCompletion = "SECRET-COMPLETION"
+)
+
+// TestZeroRetention tests that zero-retention keys leave no content in logs, replay files or metrics
+func TestZeroRetention(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(`{"model":"llama2","message":{"role":"assistant","content":"` + secretCompletion + `"},"done":false}` + "\n"))
+		if strings.Contains(string(body), "fail") {
+			w.Write([]byte(`{"error":"model failed while generating ` + secretCompletion + `"}` + "\n"))
+			return
+		}
+		w.Write([]byte(`{"model":"llama2","message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":7,"eval_count":1}` + "\n"))
+	}))
+	defer ollamaServer.Close()
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var details RequestDetails
+		json.NewDecoder(r.Body).Decode(&details)
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true, ZeroRetention: details.APIKey == "zr-key"})
+	}))
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	// Enable every content-touching feature
+	path := filepath.Join(t.TempDir(), "capture.ndjson")
+	recorder, err := startReplayRecorder(path)
+	if err != nil {
+		t.Fatalf("Error starting replay recorder: %v", err)
+	}
+	replayCapture = recorder
+	replaySampleRate = 1
+	replayAllowRawPrompts = true
+	defer func() {
+		replayCapture = nil
+		replayAllowRawPrompts = false
+	}()
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+
+	runKey := func(apiKey string) (string, []MetricsData) {
+		logs.Reset()
+		var metrics []MetricsData
+		for _, prompt := range []string{secretPrompt, secretPrompt + " fail"} {
+			req := createTestRequest(t, "POST", "/api/chat", map[string]interface{}{
+				"model":    "llama2",
+				"messages": []ChatMessage{{Role: "user", Content: prompt}},
+			}, apiKey)
+			proxyHandler(httptest.NewRecorder(), req)
+			metrics = append(metrics, waitForMetrics(t, received))
+		}
+		return logs.String(), metrics
+	}
+
+	normalLogs, normalMetrics := runKey("normal-key")
+	zrLogs, zrMetrics := runKey("zr-key")
+	recorder.Close()
+	capture, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading replay file: %v", err)
+	}
+
+	// A normal key produces every content artifact
+	if !strings.Contains(normalLogs, secretCompletion) {
+		t.Error("Expected upstream error content in logs for a normal key")
+	}
+	if !strings.Contains(string(capture), secretPrompt) || !strings.Contains(string(capture), hashAPIKey("normal-key")) {
+		t.Error("Expected replay capture for a normal key")
+	}
+	if !strings.Contains(normalMetrics[1].UpstreamError, secretCompletion) {
+		t.Errorf("Expected upstream error in metrics for a normal key, got %q", normalMetrics[1].UpstreamError)
+	}
+
+	// A zero-retention key produces none, but its metadata still flows to metrics
+	if strings.Contains(zrLogs, secretCompletion) || strings.Contains(zrLogs, secretPrompt) {
+		t.Errorf("Expected no content in logs for a zero-retention key, got %s", zrLogs)
+	}
+	if !strings.Contains(zrLogs, `"zero_retention":true`) {
+		t.Error("Expected zero-retention requests to still be logged")
+	}
+	if strings.Contains(string(capture), hashAPIKey("zr-key")) {
+		t.Error("Expected no replay capture for a zero-retention key")
+	}
+	if zrMetrics[1].UpstreamError != "" || !zrMetrics[1].Failed {
+		t.Errorf("Expected failure without error content in metrics, got %+v", zrMetrics[1])
+	}
+	if zrMetrics[0].InputTokenLength != 7 || zrMetrics[0].OutputTokenLength != 1 {
+		t.Errorf("Expected token counts for a zero-retention key, got %+v", zrMetrics[0])
+	}
+}
+
+// TestZeroRetentionConfiguredKeys tests that configured keys are protected before validation runs
+func TestZeroRetentionConfiguredKeys(t *testing.T) {
+	zeroRetentionKeys = parseKeyList("zr-key, other-key")
+	defer func() { zeroRetentionKeys = nil }()
+	apiKeyHeaderName = "X-API-Key"
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+
+	handler := formDecodeMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected invalid form to be rejected")
+	})
+	for _, apiKey := range []string{"zr-key", "normal-key"} {
+		logs.Reset()
+		req := httptest.NewRequest("POST", "/api/generate", strings.NewReader("model=llama2&prompt=hi&stream=SECRET"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-API-Key", apiKey)
+		handler(httptest.NewRecorder(), req)
+
+		leaked := strings.Contains(logs.String(), "SECRET")
+		if apiKey == "zr-key" && leaked {
+			t.Errorf("Expected no form content in logs for a configured zero-retention key, got %s", logs.String())
+		}
+		if apiKey == "normal-key" && !leaked {
+			t.Errorf("Expected form error details in logs for a normal key, got %s", logs.String())
+		}
+	}
+
+	if !retentionPolicyFor("other-key", ValidationResponse{}).ZeroRetention() {
+		t.Error("Expected configured key to have zero retention")
+	}
+	if retentionPolicyFor("normal-key", ValidationResponse{}).ZeroRetention() {
+		t.Error("Expected unlisted key to retain content")
+	}
+}
+
+// TestZeroRetentionClientDisconnect tests that a zero-retention stream the client abandons logs no response preview
+func TestZeroRetentionClientDisconnect(t *testing.T) {
+	cancels := make(chan context.CancelFunc, 1)
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte(`{"model":"llama2","message":{"role":"assistant","content":"` + secretCompletion + `"},"done":false}` + "\n"))
+		w.(http.Flusher).Flush()
+		time.Sleep(20 * time.Millisecond)
+		(<-cancels)()
+		<-r.Context().Done()
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+	logResponsePreviewBytes = 64
+	zeroRetentionKeys = parseKeyList("zr-key")
+	defer func() {
+		logResponsePreviewBytes = 0
+		zeroRetentionKeys = nil
+	}()
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+
+	for _, apiKey := range []string{"normal-key", "zr-key"} {
+		logs.Reset()
+		// The server context key makes the reverse proxy abort the handler, as it does behind a real server,
+		// and the handler passes the abort on once it has logged
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), http.ServerContextKey, &http.Server{}))
+		cancels <- cancel
+		req := createTestRequest(t, "POST", "/api/chat", map[string]interface{}{
+			"model":    "llama2",
+			"messages": []ChatMessage{{Role: "user", Content: "hi"}},
+		}, apiKey)
+		func() {
+			defer func() { recover() }()
+			proxyHandler(httptest.NewRecorder(), req.WithContext(ctx))
+		}()
+		metricsDeliveries.Wait()
+
+		var disconnect string
+		for _, line := range strings.Split(logs.String(), "\n") {
+			if strings.Contains(line, "Client disconnected mid-stream") {
+				disconnect = line
+			}
+		}
+		if disconnect == "" {
+			t.Fatalf("Expected a disconnect warning for %s, got %s", apiKey, logs.String())
+		}
+		leaked := strings.Contains(logs.String(), secretCompletion)
+		if apiKey == "normal-key" && !strings.Contains(disconnect, secretCompletion) {
+			t.Errorf("Expected the response preview in the disconnect warning for a normal key, got %s", disconnect)
+		}
+		if apiKey == "zr-key" && leaked {
+			t.Errorf("Expected no response content in logs for a zero-retention key, got %s", logs.String())
+		}
+	}
+}
+
+// TestRetentionPolicyBeforeValidation tests that bodies are only fingerprinted once a key's retention is known
+func TestRetentionPolicyBeforeValidation(t *testing.T) {
+	oldAnswers := retentionAnswers
+	retentionAnswers = newRetentionMemory(2)
+	defer func() { retentionAnswers = oldAnswers }()
+
+	fingerprinted := func(apiKey string) bool {
+		digest, size := retentionPolicyBeforeValidation(apiKey).Fingerprint([]byte(secretPrompt))
+		if size != len(secretPrompt) {
+			t.Errorf("Expected the body size for %s, got %d", apiKey, size)
+		}
+		return digest != ""
+	}
+
+	if fingerprinted("key-a") {
+		t.Error("Expected no fingerprint before the key's first answer")
+	}
+	retentionAnswers.remember("key-a", false)
+	retentionAnswers.remember("key-b", true)
+	if !fingerprinted("key-a") || fingerprinted("key-b") {
+		t.Error("Expected only the key validation didn't mark zero-retention to be fingerprinted")
+	}
+
+	// Beyond the limit, remembered answers are forgotten rather than growing without bound
+	retentionAnswers.remember("key-c", false)
+	if fingerprinted("key-a") || !fingerprinted("key-c") {
+		t.Error("Expected earlier answers to be forgotten once the memory is full")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Request rewrite configuration
+var (
+	injectGPUOptions  map[string]interface{}
+	forceNonStreaming bool
+	defaultThink      *bool
+
+	allowHeaderOptions bool
+)
+
+// headerOptions maps the option override headers to Ollama option names and value parsers
+var headerOptions = []struct {
+	header string
+	option string
+	parse  func(string) (interface{}, error)
+}{
+	{"X-Proxy-Option-Temperature", "temperature", parseFloatOption},
+	{"X-Proxy-Option-Top-P", "top_p", parseFloatOption},
+	{"X-Proxy-Option-Max-Tokens", "num_predict", parseIntOption},
+}
+
+func parseFloatOption(value string) (interface{}, error) {
+	return strconv.ParseFloat(value, 64)
+}
+
+func parseIntOption(value string) (interface{}, error) {
+	return strconv.Atoi(value)
+}
+
+// applyRequestRewrites applies configured body rewrites and returns the body to forward
+func applyRequestRewrites(r *http.Request, body []byte) []byte {
+	path := r.URL.Path
+	if !strings.HasSuffix(path, "/api/chat") && !strings.HasSuffix(path, "/api/generate") {
+		return body
+	}
+	if len(injectGPUOptions) == 0 && !forceNonStreaming && defaultThink == nil {
+		return body
+	}
+
+	rewritten, changed := rewriteJSONBody(body, func(obj map[string]interface{}) bool {
+		changed := false
+		if len(injectGPUOptions) > 0 && mergeMissingOptions(obj, injectGPUOptions) {
+			changed = true
+		}
+		if forceNonStreaming && obj["stream"] != false {
+			obj["stream"] = false
+			changed = true
+		}
+		if _, exists := obj["think"]; !exists && defaultThink != nil {
+			obj["think"] = *defaultThink
+			changed = true
+		}
+		return changed
+	})
+	if !changed {
+		return body
+	}
+
+	setRequestBody(r, rewritten)
+	return rewritten
+}
+
+// rewriteJSONBody decodes a JSON object, applies mutate, and re-encodes it if mutate changed anything
+func rewriteJSONBody(body []byte, mutate func(obj map[string]interface{}) bool) ([]byte, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var obj map[string]interface{}
+	if err := decoder.Decode(&obj); err != nil || obj == nil {
+		return body, false
+	}
+	if !mutate(obj) {
+		return body, false
+	}
+
+	rewritten, err := json.Marshal(obj)
+	if err != nil {
+		return body, false
+	}
+	return rewritten, true
+}
+
+// getHeaderOptions extracts option overrides from the request headers, removing them so they are not forwarded
+func getHeaderOptions(r *http.Request) (map[string]interface{}, error) {
+	overrides := make(map[string]interface{})
+	for _, h := range headerOptions {
+		raw := r.Header.Get(h.header)
+		if raw == "" {
+			continue
+		}
+		r.Header.Del(h.header)
+		value, err := h.parse(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header: %q", h.header, raw)
+		}
+		overrides[h.option] = value
+	}
+	return overrides, nil
+}
+
+// applyHeaderOptions merges header option overrides into the request's options, replacing client values
+func applyHeaderOptions(r *http.Request, body []byte, overrides map[string]interface{}) []byte {
+	path := r.URL.Path
+	if len(overrides) == 0 || (!strings.HasSuffix(path, "/api/chat") && !strings.HasSuffix(path, "/api/generate")) {
+		return body
+	}
+
+	rewritten, changed := rewriteJSONBody(body, func(obj map[string]interface{}) bool {
+		options, ok := obj["options"].(map[string]interface{})
+		if !ok {
+			if obj["options"] != nil {
+				// Leave malformed options for Ollama to reject
+				return false
+			}
+			options = make(map[string]interface{})
+		}
+		for key, value := range overrides {
+			options[key] = value
+		}
+		obj["options"] = options
+		return true
+	})
+	if !changed {
+		return body
+	}
+
+	setRequestBody(r, rewritten)
+	return rewritten
+}
+
+// mergeMissingOptions adds defaults to the request's options object without overriding client values
+func mergeMissingOptions(obj map[string]interface{}, defaults map[string]interface{}) bool {
+	options, ok := obj["options"].(map[string]interface{})
+	if !ok {
+		if obj["options"] != nil {
+			// Leave malformed options for Ollama to reject
+			return false
+		}
+		options = make(map[string]interface{})
+	}
+
+	changed := false
+	for key, value := range defaults {
+		if _, exists := options[key]; !exists {
+			options[key] = value
+			changed = true
+		}
+	}
+	if changed {
+		obj["options"] = options
+	}
+	return changed
+}
+
+// getThinkFromRequest returns the think setting of a chat or generate request, or nil if unset
+func getThinkFromRequest(path string, body []byte) *bool {
+	if !strings.HasSuffix(path, "/api/chat") && !strings.HasSuffix(path, "/api/generate") {
+		return nil
+	}
+	var req struct {
+		Think *bool `json:"think"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil
+	}
+	return req.Think
+}
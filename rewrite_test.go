@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestInjectGPUOptions tests injection of configured GPU options into request bodies
+func TestInjectGPUOptions(t *testing.T) {
+	injectGPUOptions = map[string]interface{}{"num_gpu": json.Number("1"), "main_gpu": json.Number("0")}
+	defer func() { injectGPUOptions = nil }()
+
+	testCases := []struct {
+		name            string
+		path            string
+		body            string
+		expectedOptions map[string]interface{}
+	}{
+		{
+			name:            "Missing Options",
+			path:            "/api/generate",
+			body:            `{"model":"llama3","prompt":"hi"}`,
+			expectedOptions: map[string]interface{}{"num_gpu": 1.0, "main_gpu": 0.0},
+		},
+		{
+			name:            "Client Value Wins",
+			path:            "/api/chat",
+			body:            `{"model":"llama3","messages":[],"options":{"num_gpu":2,"temperature":0.1}}`,
+			expectedOptions: map[string]interface{}{"num_gpu": 2.0, "main_gpu": 0.0, "temperature": 0.1},
+		},
+		{
+			name:            "Other Endpoint Untouched",
+			path:            "/api/embed",
+			body:            `{"model":"nomic-embed","input":"hi"}`,
+			expectedOptions: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+			body := applyRequestRewrites(req, []byte(tc.body))
+
+			forwarded, _ := io.ReadAll(req.Body)
+			if string(forwarded) != string(body) {
+				t.Errorf("Expected request body to match returned body, got %s and %s", forwarded, body)
+			}
+			if req.ContentLength != int64(len(body)) {
+				t.Errorf("Expected ContentLength %d, got %d", len(body), req.ContentLength)
+			}
+
+			var parsed struct {
+				Options map[string]interface{} `json:"options"`
+			}
+			json.Unmarshal(body, &parsed)
+			expected, _ := json.Marshal(tc.expectedOptions)
+			actual, _ := json.Marshal(parsed.Options)
+			if string(expected) != string(actual) {
+				t.Errorf("Expected options %s, got %s", expected, actual)
+			}
+		})
+	}
+}
+
+// TestInjectGPUOptionsUpstream tests that Ollama receives the injected options
+func TestInjectGPUOptionsUpstream(t *testing.T) {
+	var upstreamBody map[string]interface{}
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.ContentLength != int64(len(body)) {
+			t.Errorf("Expected upstream ContentLength %d, got %d", len(body), r.ContentLength)
+		}
+		json.Unmarshal(body, &upstreamBody)
+		json.NewEncoder(w).Encode(GenerateResponse{Model: "llama3", Done: true})
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, validationServer.URL)
+
+	injectGPUOptions = map[string]interface{}{"num_gpu": json.Number("1")}
+	defer func() { injectGPUOptions = nil }()
+
+	req := createTestRequest(t, "POST", "/api/generate", map[string]interface{}{
+		"model":  "llama3",
+		"prompt": "hi",
+		"keep":   "unknown fields",
+	}, "test-api-key")
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, req)
+
+	assertResponseStatus(t, rr, http.StatusOK)
+	options, _ := upstreamBody["options"].(map[string]interface{})
+	if options["num_gpu"] != 1.0 {
+		t.Errorf("Expected upstream num_gpu 1, got %v", upstreamBody["options"])
+	}
+	if upstreamBody["keep"] != "unknown fields" {
+		t.Errorf("Expected unknown fields to be preserved, got %v", upstreamBody)
+	}
+}
+
+// TestForceNonStreaming tests that chat and generate requests are forwarded with stream disabled
+func TestForceNonStreaming(t *testing.T) {
+	var upstreamBodies []map[string]interface{}
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.ContentLength != int64(len(body)) {
+			t.Errorf("Expected upstream ContentLength %d, got %d", len(body), r.ContentLength)
+		}
+		var parsed map[string]interface{}
+		json.Unmarshal(body, &parsed)
+		upstreamBodies = append(upstreamBodies, parsed)
+		json.NewEncoder(w).Encode(ChatResponse{Model: "llama3", Done: true})
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, validationServer.URL)
+
+	forceNonStreaming = true
+	defer func() { forceNonStreaming = false }()
+
+	requests := []struct {
+		path           string
+		body           map[string]interface{}
+		expectedStream interface{}
+	}{
+		{"/api/chat", map[string]interface{}{"model": "llama3", "messages": []interface{}{}, "stream": true, "custom": "kept"}, false},
+		{"/api/generate", map[string]interface{}{"model": "llama3", "prompt": "hi", "custom": "kept"}, false},
+		{"/api/embed", map[string]interface{}{"model": "nomic-embed", "input": "hi", "custom": "kept"}, nil},
+	}
+	for _, tr := range requests {
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, createTestRequest(t, "POST", tr.path, tr.body, "test-api-key"))
+		assertResponseStatus(t, rr, http.StatusOK)
+	}
+
+	if len(upstreamBodies) != len(requests) {
+		t.Fatalf("Expected %d upstream requests, got %d", len(requests), len(upstreamBodies))
+	}
+	for i, tr := range requests {
+		if upstreamBodies[i]["stream"] != tr.expectedStream {
+			t.Errorf("%s: expected upstream stream %v, got %v", tr.path, tr.expectedStream, upstreamBodies[i]["stream"])
+		}
+		if upstreamBodies[i]["custom"] != "kept" {
+			t.Errorf("%s: expected unknown fields to be preserved, got %v", tr.path, upstreamBodies[i])
+		}
+	}
+}
+
+// TestDefaultThink tests that the configured think default only applies to requests without one
+func TestDefaultThink(t *testing.T) {
+	enabled := true
+	defaultThink = &enabled
+	defer func() { defaultThink = nil }()
+
+	testCases := []struct {
+		name          string
+		path          string
+		body          string
+		expectedThink interface{}
+	}{
+		{"Missing Think", "/api/chat", `{"model":"qwen3","messages":[]}`, true},
+		{"Client Value Wins", "/api/generate", `{"model":"qwen3","prompt":"hi","think":false}`, false},
+		{"Other Endpoint Untouched", "/api/embed", `{"model":"nomic-embed","input":"hi"}`, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+			body := applyRequestRewrites(req, []byte(tc.body))
+
+			var parsed map[string]interface{}
+			json.Unmarshal(body, &parsed)
+			if parsed["think"] != tc.expectedThink {
+				t.Errorf("Expected think %v, got %v", tc.expectedThink, parsed["think"])
+			}
+
+			think := getThinkFromRequest(tc.path, body)
+			if (think == nil) != (tc.expectedThink == nil) || (think != nil && *think != tc.expectedThink) {
+				t.Errorf("Expected logged think %v, got %v", tc.expectedThink, think)
+			}
+		})
+	}
+}
+
+// TestHeaderOptions tests that option headers override client options when enabled
+func TestHeaderOptions(t *testing.T) {
+	var upstreamBody map[string]interface{}
+	var upstreamHeader http.Header
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamBody = nil
+		upstreamHeader = r.Header
+		json.NewDecoder(r.Body).Decode(&upstreamBody)
+		json.NewEncoder(w).Encode(ChatResponse{Model: "llama3", Done: true})
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, validationServer.URL)
+	defer func() { allowHeaderOptions = false }()
+
+	testCases := []struct {
+		name            string
+		enabled         bool
+		headers         map[string]string
+		expectedStatus  int
+		expectedOptions map[string]interface{}
+	}{
+		{
+			name:    "Headers Override Client Values",
+			enabled: true,
+			headers: map[string]string{
+				"X-Proxy-Option-Temperature": "0.2",
+				"X-Proxy-Option-Top-P":       "0.9",
+				"X-Proxy-Option-Max-Tokens":  "128",
+			},
+			expectedStatus:  http.StatusOK,
+			expectedOptions: map[string]interface{}{"temperature": 0.2, "top_p": 0.9, "num_predict": 128.0, "seed": 42.0},
+		},
+		{
+			name:            "No Headers",
+			enabled:         true,
+			expectedStatus:  http.StatusOK,
+			expectedOptions: map[string]interface{}{"temperature": 0.8, "seed": 42.0},
+		},
+		{
+			name:           "Invalid Value",
+			enabled:        true,
+			headers:        map[string]string{"X-Proxy-Option-Max-Tokens": "lots"},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:            "Disabled",
+			enabled:         false,
+			headers:         map[string]string{"X-Proxy-Option-Temperature": "0.2"},
+			expectedStatus:  http.StatusOK,
+			expectedOptions: map[string]interface{}{"temperature": 0.8, "seed": 42.0},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			allowHeaderOptions = tc.enabled
+			upstreamBody = nil
+			req := createTestRequest(t, "POST", "/api/chat", map[string]interface{}{
+				"model":    "llama3",
+				"messages": []ChatMessage{{Role: "user", Content: "hi"}},
+				"stream":   false,
+				"options":  map[string]interface{}{"temperature": 0.8, "seed": 42},
+			}, "test-api-key")
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, req)
+
+			assertResponseStatus(t, rr, tc.expectedStatus)
+			if tc.expectedStatus != http.StatusOK {
+				if upstreamBody != nil {
+					t.Error("Expected invalid request not to be forwarded")
+				}
+				return
+			}
+			expected, _ := json.Marshal(tc.expectedOptions)
+			actual, _ := json.Marshal(upstreamBody["options"])
+			if string(expected) != string(actual) {
+				t.Errorf("Expected options %s, got %s", expected, actual)
+			}
+			if tc.enabled && upstreamHeader.Get("X-Proxy-Option-Temperature") != "" {
+				t.Error("Expected option headers to be stripped before forwarding")
+			}
+		})
+	}
+}
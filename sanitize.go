@@ -0,0 +1,60 @@
+package main
+
+import "slices"
+
+const (
+	defaultMaxAPIKeyLength       = 512
+	defaultMaxRequestValueLength = 1024
+)
+
+// Request sanitization configuration; the limits hold even before configuration is loaded
+var (
+	maxAPIKeyLength       = defaultMaxAPIKeyLength
+	maxRequestValueLength = defaultMaxRequestValueLength
+)
+
+// validAPIKeyFormat reports whether a key is short enough and made only of printable, non-space ASCII,
+// so a malformed key is rejected before it's copied anywhere
+func validAPIKeyFormat(key string) bool {
+	if len(key) > maxAPIKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// capRequestValue truncates a client-supplied value to MAX_REQUEST_VALUE_LENGTH bytes
+func capRequestValue(value string) string {
+	return truncateUTF8(value, maxRequestValueLength)
+}
+
+// sanitizeRequestDetails caps every client-supplied value in the details in one pass, since the
+// details are copied into logs and the validation and metrics payloads
+func sanitizeRequestDetails(details *RequestDetails) {
+	details.APIKey = capRequestValue(details.APIKey)
+	details.IPAddress = capRequestValue(details.IPAddress)
+	details.UserAgent = capRequestValue(details.UserAgent)
+	details.Endpoint = capRequestValue(details.Endpoint)
+	details.Model = capRequestValue(details.Model)
+	details.DestinationModel = capRequestValue(details.DestinationModel)
+
+	// Header values are shared with the request forwarded to Ollama, so a slice is copied before capping
+	headers := make(map[string][]string, len(details.Headers))
+	for name, values := range details.Headers {
+		copied := false
+		for i, value := range values {
+			if capped := capRequestValue(value); len(capped) != len(value) {
+				if !copied {
+					values, copied = slices.Clone(values), true
+				}
+				values[i] = capped
+			}
+		}
+		headers[capRequestValue(name)] = values
+	}
+	details.Headers = headers
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ollama-proxy/logger"
+)
+
+// TestProxyHandlerMalformedKeys tests that oversized and garbage keys are rejected before reaching validation or the logs
+func TestProxyHandlerMalformedKeys(t *testing.T) {
+	var validations int32
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&validations, 1)
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true})
+	}))
+	defer validationServer.Close()
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+
+	testCases := []struct {
+		name string
+		key  string
+	}{
+		{"Oversized", strings.Repeat("k", 1<<20)},
+		{"Just Over Limit", strings.Repeat("k", defaultMaxAPIKeyLength+1)},
+		{"Binary Garbage", "key\x00\x01\xff\xfe"},
+		{"Control Characters", "key\r\nX-Injected: 1"},
+		{"Embedded Space", "two words"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs.Reset()
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", ChatRequest{Model: "llama2"}, tc.key))
+			assertResponseStatus(t, rr, http.StatusUnauthorized)
+
+			var errResp ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &errResp); err != nil || errResp.Code != "invalid_key_format" {
+				t.Errorf("Expected code invalid_key_format, got %s", rr.Body.String())
+			}
+			if logs.Len() > 1024 {
+				t.Errorf("Expected the key kept out of the logs, got %d bytes of logs", logs.Len())
+			}
+		})
+	}
+
+	if n := atomic.LoadInt32(&validations); n != 0 {
+		t.Errorf("Expected malformed keys never to reach validation, got %d calls", n)
+	}
+
+	// A key at the limit is still accepted
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", ChatRequest{Model: "llama2"}, strings.Repeat("k", defaultMaxAPIKeyLength)))
+	assertResponseStatus(t, rr, http.StatusOK)
+}
+
+// TestProxyHandlerCapsRequestValues tests that no oversized client value reaches the validation or metrics payloads
+func TestProxyHandlerCapsRequestValues(t *testing.T) {
+	validationBodies := make(chan []byte, 1)
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		validationBodies <- body
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true})
+	}))
+	defer validationServer.Close()
+	metricsBodies := make(chan []byte, 1)
+	metricsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		metricsBodies <- body
+	}))
+	defer metricsServer.Close()
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	huge := strings.Repeat("x", 1<<20)
+	req := createTestRequest(t, "POST", "/api/chat", map[string]interface{}{"model": "llama2" + huge}, "test-key")
+	req.Header.Set("User-Agent", huge)
+	req.Header.Set("X-Custom", huge)
+	req.Header.Set(huge[:4096], "value")
+	proxyHandler(httptest.NewRecorder(), req)
+	if len(req.Header.Get("X-Custom")) != len(huge) {
+		t.Error("Expected capping the validation payload to leave the request's headers alone")
+	}
+
+	limit := 16 * defaultMaxRequestValueLength
+	select {
+	case body := <-validationBodies:
+		if len(body) > limit {
+			t.Errorf("Expected a capped validation payload, got %d bytes", len(body))
+		}
+		var details RequestDetails
+		json.Unmarshal(body, &details)
+		custom := details.Headers["X-Custom"]
+		if len(details.UserAgent) != defaultMaxRequestValueLength || len(custom) != 1 || len(custom[0]) != defaultMaxRequestValueLength {
+			t.Errorf("Expected values capped at %d bytes, got user agent %d and header %v", defaultMaxRequestValueLength, len(details.UserAgent), len(strings.Join(custom, "")))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for validation")
+	}
+
+	select {
+	case body := <-metricsBodies:
+		if len(body) > limit {
+			t.Errorf("Expected a capped metrics payload, got %d bytes", len(body))
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for metrics")
+	}
+}
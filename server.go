@@ -0,0 +1,27 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// HTTP server configuration
+var (
+	serverIdleTimeout       time.Duration
+	serverReadTimeout       time.Duration
+	serverWriteTimeout      time.Duration
+	serverReadHeaderTimeout time.Duration
+)
+
+// newServer returns the proxy's HTTP server. The read timeout defaults to none because blob uploads
+// can take far longer than any sensible limit, while the idle and header timeouts close connections
+// left open by clients that went away.
+func newServer(addr string) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		IdleTimeout:       serverIdleTimeout,
+		ReadTimeout:       serverReadTimeout,
+		WriteTimeout:      serverWriteTimeout,
+		ReadHeaderTimeout: serverReadHeaderTimeout,
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestNewServer tests that the configured timeouts reach the HTTP server
+func TestNewServer(t *testing.T) {
+	serverIdleTimeout = time.Minute
+	serverReadTimeout = 5 * time.Minute
+	serverWriteTimeout = 30 * time.Second
+	serverReadHeaderTimeout = 10 * time.Second
+	defer func() {
+		serverIdleTimeout = 0
+		serverReadTimeout = 0
+		serverWriteTimeout = 0
+		serverReadHeaderTimeout = 0
+	}()
+
+	server := newServer(":8080")
+	if server.Addr != ":8080" {
+		t.Errorf("Expected address :8080, got %s", server.Addr)
+	}
+	if server.IdleTimeout != time.Minute || server.ReadTimeout != 5*time.Minute ||
+		server.WriteTimeout != 30*time.Second || server.ReadHeaderTimeout != 10*time.Second {
+		t.Errorf("Expected configured timeouts, got idle %v read %v write %v header %v",
+			server.IdleTimeout, server.ReadTimeout, server.WriteTimeout, server.ReadHeaderTimeout)
+	}
+}
+
+// TestServerClosesIdleConnections tests that keep-alive connections are closed once idle too long
+func TestServerClosesIdleConnections(t *testing.T) {
+	serverIdleTimeout = 50 * time.Millisecond
+	defer func() { serverIdleTimeout = 0 }()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	server := newServer(listener.Addr().String())
+	server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	go server.Serve(listener)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: proxy\r\n\r\n")); err != nil {
+		t.Fatalf("Error writing request: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Error reading response: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// The server should hang up on the idle connection well before the read deadline
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected the idle connection to be closed, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+
+	"ollama-proxy/logger"
+)
+
+// validationSessions holds the accepted answers that came with a session token, per API key. Unlike
+// VALIDATION_CACHE_TTL the validation service sets each token's lifetime, and sessions are used
+// whether or not caching is enabled. The cache is bounded by VALIDATION_CACHE_SIZE.
+var validationSessions = newValidationCache()
+
+// metricsErrorBodyLimit caps how much of a metrics service 401 is searched for a session token
+const metricsErrorBodyLimit = 4096
+
+// sessionDetails is the cache key for a session: it covers every model and endpoint the key uses
+func sessionDetails(apiKey string) RequestDetails {
+	return RequestDetails{APIKey: apiKey}
+}
+
+// validationSession returns the answer that came with the key's session token while it's fresh
+func validationSession(apiKey string) (ValidationResponse, bool) {
+	return validationSessions.get(sessionDetails(apiKey))
+}
+
+// rememberValidationSession keeps an accepted answer carrying a session token for sessionTTLSeconds
+func rememberValidationSession(apiKey string, response ValidationResponse) {
+	if response.SessionToken == "" || response.SessionTTLSeconds <= 0 {
+		return
+	}
+	validationSessions.put(sessionDetails(apiKey), response, time.Duration(response.SessionTTLSeconds)*time.Second)
+}
+
+// evictValidationSession drops the key's session after Ollama or the metrics service refused a request
+// made under token, unless a newer session has already replaced it
+func evictValidationSession(apiKey, token string, source string) {
+	evicted := validationSessions.remove(sessionDetails(apiKey), func(response ValidationResponse) bool {
+		return response.SessionToken == token
+	})
+	if evicted {
+		logger.Warning("Validation session evicted", map[string]interface{}{
+			"api_key": apiKey,
+			"source":  source,
+		})
+	}
+}
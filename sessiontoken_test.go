@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useValidationSessions gives the test an empty session cache
+func useValidationSessions(t *testing.T) {
+	old := validationSessions
+	t.Cleanup(func() { validationSessions = old })
+	validationSessions = newValidationCache()
+}
+
+// sessionValidationServer answers every validation call with a new session token, counting calls
+func sessionValidationServer(t *testing.T, ttlSeconds int) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := calls.Add(1)
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true, SessionToken: "session-" + string(rune('0'+n)), SessionTTLSeconds: ttlSeconds})
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+// TestValidationSession tests that a session token skips validation for any model until it expires
+func TestValidationSession(t *testing.T) {
+	useValidationSessions(t)
+	validationServer, calls := sessionValidationServer(t, 60)
+	useProxyTargets(t, ollamaURL, validationServer.URL, "")
+
+	if _, ok, cached, _ := validateRequestCached(context.Background(), RequestDetails{APIKey: "test-key", Model: "llama2", Endpoint: "/api/chat"}); !ok || cached {
+		t.Fatalf("Expected the first request to be validated, got ok %v, cached %v", ok, cached)
+	}
+	response, ok, cached, _ := validateRequestCached(context.Background(), RequestDetails{APIKey: "test-key", Model: "mistral", Endpoint: "/api/generate"})
+	if !ok || !cached || response.SessionToken != "session-1" || calls.Load() != 1 {
+		t.Errorf("Expected the session to answer, got %+v, ok %v, cached %v after %d calls", response, ok, cached, calls.Load())
+	}
+	if _, _, cached, _ := validateRequestCached(context.Background(), RequestDetails{APIKey: "other-key"}); cached || calls.Load() != 2 {
+		t.Errorf("Expected sessions to be per key, got cached %v after %d calls", cached, calls.Load())
+	}
+
+	validationSessions.now = func() time.Time { return time.Now().Add(time.Minute) }
+	if response, _, cached, _ := validateRequestCached(context.Background(), RequestDetails{APIKey: "test-key"}); cached || response.SessionToken != "session-3" {
+		t.Errorf("Expected an expired session to be validated again, got %+v, cached %v", response, cached)
+	}
+
+	t.Run("No TTL", func(t *testing.T) {
+		useValidationSessions(t)
+		validationServer, calls := sessionValidationServer(t, 0)
+		externalValidationURL = validationServer.URL
+		validateRequestCached(context.Background(), RequestDetails{APIKey: "test-key"})
+		validateRequestCached(context.Background(), RequestDetails{APIKey: "test-key"})
+		if calls.Load() != 2 {
+			t.Errorf("Expected a token without a TTL not to be kept, got %d calls", calls.Load())
+		}
+	})
+}
+
+// TestEvictValidationSession tests that eviction only drops the session holding the refused token
+func TestEvictValidationSession(t *testing.T) {
+	useValidationSessions(t)
+	rememberValidationSession("test-key", ValidationResponse{Valid: true, SessionToken: "session-2", SessionTTLSeconds: 60})
+
+	evictValidationSession("test-key", "session-1", "ollama")
+	if _, fresh := validationSession("test-key"); !fresh {
+		t.Error("Expected a stale token not to evict the newer session")
+	}
+	evictValidationSession("test-key", "session-2", "ollama")
+	if _, fresh := validationSession("test-key"); fresh {
+		t.Error("Expected the session to be evicted")
+	}
+}
+
+// TestProxyHandlerValidationSession tests that metrics carry the token and 401s from Ollama and the
+// metrics service evict the session
+func TestProxyHandlerValidationSession(t *testing.T) {
+	useValidationSessions(t)
+	validationServer, calls := sessionValidationServer(t, 60)
+	var ollamaStatus, metricsStatus atomic.Int32
+	var anonymous401 atomic.Bool
+	ollamaStatus.Store(http.StatusOK)
+	metricsStatus.Store(http.StatusOK)
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(ollamaStatus.Load()))
+		json.NewEncoder(w).Encode(ChatResponse{Model: "llama2", Done: true, PromptEvalCount: 1, EvalCount: 1})
+	}))
+	defer ollamaServer.Close()
+	received := make(chan MetricsData, 16)
+	metricsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var metrics MetricsData
+		json.NewDecoder(r.Body).Decode(&metrics)
+		w.WriteHeader(int(metricsStatus.Load()))
+		if anonymous401.Load() {
+			w.Write([]byte(`{"error": "invalid proxy credentials"}`))
+		} else {
+			w.Write([]byte(`{"error": "session revoked: ` + metrics.SessionToken + `"}`))
+		}
+		received <- metrics
+	}))
+	defer metricsServer.Close()
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	chat := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "Hi"}}}, "test-key"))
+		return rr
+	}
+	// waitForEviction waits for the session to go, since metrics are sent asynchronously
+	waitForEviction := func() {
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			if _, fresh := validationSession("test-key"); !fresh {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Error("Expected the session to be evicted")
+	}
+
+	assertResponseStatus(t, chat(), http.StatusOK)
+	assertResponseStatus(t, chat(), http.StatusOK)
+	if calls.Load() != 1 {
+		t.Errorf("Expected one validation call for two requests, got %d", calls.Load())
+	}
+	for i := 0; i < 2; i++ {
+		if metrics := waitForMetrics(t, received); metrics.SessionToken != "session-1" {
+			t.Errorf("Expected metrics to carry the session token, got %q", metrics.SessionToken)
+		}
+	}
+
+	t.Run("Ollama 401", func(t *testing.T) {
+		defer func() {
+			assertResponseStatus(t, chat(), http.StatusOK)
+			if metrics := waitForMetrics(t, received); metrics.SessionToken != "session-2" || calls.Load() != 2 {
+				t.Errorf("Expected a new session after eviction, got %q after %d calls", metrics.SessionToken, calls.Load())
+			}
+		}()
+		ollamaStatus.Store(http.StatusUnauthorized)
+		defer ollamaStatus.Store(http.StatusOK)
+		assertResponseStatus(t, chat(), http.StatusUnauthorized)
+		waitForMetrics(t, received)
+		waitForEviction()
+	})
+
+	t.Run("Metrics 401 Not Naming The Token", func(t *testing.T) {
+		metricsStatus.Store(http.StatusUnauthorized)
+		anonymous401.Store(true)
+		defer func() { metricsStatus.Store(http.StatusOK); anonymous401.Store(false) }()
+		assertResponseStatus(t, chat(), http.StatusOK)
+		waitForMetrics(t, received)
+		time.Sleep(50 * time.Millisecond)
+		if _, fresh := validationSession("test-key"); !fresh {
+			t.Error("Expected a 401 about the proxy's credentials to keep the session")
+		}
+	})
+
+	t.Run("Metrics 401", func(t *testing.T) {
+		metricsStatus.Store(http.StatusUnauthorized)
+		defer metricsStatus.Store(http.StatusOK)
+		assertResponseStatus(t, chat(), http.StatusOK)
+		waitForMetrics(t, received)
+		waitForEviction()
+	})
+}
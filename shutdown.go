@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Graceful shutdown configuration
+var (
+	shutdownGracePeriod  time.Duration
+	shutdownStreamMargin time.Duration
+	streamShutdown       = newShutdownSignal()
+)
+
+// doneReasonProxyShutdown marks streams the proxy ended because it was shutting down
+const doneReasonProxyShutdown = "proxy_shutdown"
+
+// shutdownSignal is closed when streams still running during shutdown must be ended
+type shutdownSignal struct {
+	once sync.Once
+	ch   chan struct{}
+}
+
+func newShutdownSignal() *shutdownSignal {
+	return &shutdownSignal{ch: make(chan struct{})}
+}
+
+// fire ends every active stream now
+func (s *shutdownSignal) fire() {
+	s.once.Do(func() { close(s.ch) })
+}
+
+// schedule ends active streams once the cutoff has passed, leaving margin before the hard deadline
+func (s *shutdownSignal) schedule(cutoff time.Duration) {
+	if cutoff <= 0 {
+		s.fire()
+		return
+	}
+	time.AfterFunc(cutoff, s.fire)
+}
+
+// streamCutoff stops a stream's upstream copy when the shutdown signal fires
+type streamCutoff struct {
+	cut    atomic.Bool
+	cancel context.CancelFunc
+}
+
+// watchStreamCutoff returns the request to forward, whose upstream is cancelled at the shutdown cutoff
+func watchStreamCutoff(r *http.Request) (*http.Request, *streamCutoff) {
+	ctx, cancel := context.WithCancel(r.Context())
+	cutoff := &streamCutoff{cancel: cancel}
+	signal := streamShutdown.ch
+
+	go func() {
+		select {
+		case <-signal:
+			cutoff.cut.Store(true)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return r.WithContext(ctx), cutoff
+}
+
+// fired reports whether the stream was cut off by shutdown
+func (c *streamCutoff) fired() bool {
+	return c.cut.Load()
+}
+
+// stop releases the watcher once the request is done
+func (c *streamCutoff) stop() {
+	c.cancel()
+}
+
+// shutdownNotice builds the final message telling a stream's client the proxy ended it, in the stream's own format
+func shutdownNotice(path, model string, header http.Header) []byte {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-ndjson":
+		return terminalDoneChunk(path, model, doneReasonProxyShutdown)
+	case "text/event-stream":
+		return sseShutdownEvent()
+	}
+	return nil
+}
+
+// sseShutdownEvent is the error event sent to SSE clients whose stream was ended by shutdown
+func sseShutdownEvent() []byte {
+	data, _ := json.Marshal(map[string]string{
+		"error":       "proxy shutting down",
+		"done_reason": doneReasonProxyShutdown,
+	})
+	return []byte("event: error\ndata: " + string(data) + "\n\n")
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupShutdownProxy serves the proxy over a real server in front of a stream of the given length
+func setupShutdownProxy(t *testing.T, chunks int) (*httptest.Server, chan MetricsData) {
+	var stream []string
+	for i := 0; i < chunks; i++ {
+		stream = append(stream, `{"model":"llama2","response":"x","done":false}`)
+	}
+	stream = append(stream, `{"model":"llama2","response":"","done":true,"done_reason":"stop","prompt_eval_count":3,"eval_count":5}`)
+	ollamaServer := mockStreamingOllamaServer(t, stream, 20*time.Millisecond)
+	t.Cleanup(ollamaServer.Close)
+	validationServer := mockValidationServer(t, true, false)
+	t.Cleanup(validationServer.Close)
+	metricsServer, received := recordingMetricsServer(t)
+	t.Cleanup(metricsServer.Close)
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+	streamShutdown = newShutdownSignal()
+	t.Cleanup(func() { streamShutdown = newShutdownSignal() })
+
+	proxyServer := httptest.NewServer(http.HandlerFunc(proxyHandler))
+	t.Cleanup(proxyServer.Close)
+	return proxyServer, received
+}
+
+// streamThroughShutdown starts a stream, shuts the server down with the given stream cutoff, and returns what the client read
+func streamThroughShutdown(t *testing.T, proxyServer *httptest.Server, accept string, cutoff time.Duration) (string, error) {
+	body, _ := json.Marshal(map[string]interface{}{"model": "llama2", "prompt": "Hi"})
+	req, _ := http.NewRequest("POST", proxyServer.URL+"/api/generate", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", "test-key")
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Error calling proxy: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Shut down once the stream is flowing
+	reader := bufio.NewReader(resp.Body)
+	first, _ := reader.ReadString('\n')
+	shutdownDone := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		streamShutdown.schedule(cutoff)
+		shutdownDone <- proxyServer.Config.Shutdown(ctx)
+	}()
+
+	rest, err := io.ReadAll(reader)
+	if shutdownErr := <-shutdownDone; shutdownErr != nil {
+		t.Errorf("Expected graceful shutdown to complete, got %v", shutdownErr)
+	}
+	return first + string(rest), err
+}
+
+// TestShutdownTerminatesStreams tests that streams still running at the cutoff end with a notice instead of a reset
+func TestShutdownTerminatesStreams(t *testing.T) {
+	t.Run("NDJSON", func(t *testing.T) {
+		proxyServer, received := setupShutdownProxy(t, 200)
+		body, err := streamThroughShutdown(t, proxyServer, "", 100*time.Millisecond)
+		if err != nil {
+			t.Fatalf("Expected a clean close, got %v", err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(body), "\n")
+		var last GenerateResponse
+		json.Unmarshal([]byte(lines[len(lines)-1]), &last)
+		if !last.Done || last.DoneReason != doneReasonProxyShutdown || last.Model != "llama2" {
+			t.Errorf("Expected a proxy_shutdown done chunk, got %s", lines[len(lines)-1])
+		}
+		if len(lines) >= 200 {
+			t.Errorf("Expected the stream to be cut short, got %d lines", len(lines))
+		}
+
+		metrics := waitForMetrics(t, received)
+		if !metrics.ShutdownTerminated || metrics.DoneReason != doneReasonProxyShutdown || metrics.ClientAborted {
+			t.Errorf("Expected shutdown termination in metrics, got %+v", metrics)
+		}
+	})
+
+	t.Run("SSE", func(t *testing.T) {
+		proxyServer, _ := setupShutdownProxy(t, 200)
+		body, err := streamThroughShutdown(t, proxyServer, "text/event-stream", 100*time.Millisecond)
+		if err != nil {
+			t.Fatalf("Expected a clean close, got %v", err)
+		}
+		if !strings.HasSuffix(body, "event: error\ndata: "+`{"done_reason":"proxy_shutdown","error":"proxy shutting down"}`+"\n\n") {
+			t.Errorf("Expected a shutdown error event at the end, got %q", body[max(0, len(body)-200):])
+		}
+	})
+
+	t.Run("Stream Finishing Before Cutoff", func(t *testing.T) {
+		proxyServer, received := setupShutdownProxy(t, 3)
+		body, err := streamThroughShutdown(t, proxyServer, "", time.Second)
+		if err != nil || strings.Contains(body, doneReasonProxyShutdown) {
+			t.Errorf("Expected the stream to finish naturally, got err=%v body=%s", err, body)
+		}
+		if metrics := waitForMetrics(t, received); metrics.ShutdownTerminated || metrics.DoneReason != "stop" {
+			t.Errorf("Expected a natural finish in metrics, got %+v", metrics)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+// Package signing signs requests from the proxy to the validation and metrics services with an
+// HMAC-SHA256, and verifies them on the receiving side.
+//
+// The signature is the hex HMAC-SHA256, under the shared secret, of the X-Timestamp value (Unix
+// seconds), a ".", and the exact request body (empty for GET requests).
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers carrying the signature and the time it was made
+const (
+	SignatureHeader = "X-Signature"
+	TimestampHeader = "X-Timestamp"
+)
+
+// Errors returned by Verify
+var (
+	ErrMissing   = errors.New("missing signature headers")
+	ErrTimestamp = errors.New("timestamp outside allowed clock skew")
+	ErrMismatch  = errors.New("signature mismatch")
+)
+
+// Signature returns the hex HMAC-SHA256 of timestamp, ".", and body under secret
+func Signature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Sign sets the signature headers on req for body, signed at now
+func Sign(req *http.Request, secret string, body []byte, now time.Time) {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Signature(secret, timestamp, body))
+}
+
+// Verify checks the signature headers in header against body, rejecting timestamps more than
+// maxSkew away from now in either direction
+func Verify(header http.Header, secret string, body []byte, maxSkew time.Duration, now time.Time) error {
+	timestamp, signature := header.Get(TimestampHeader), header.Get(SignatureHeader)
+	if timestamp == "" || signature == "" {
+		return ErrMissing
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrTimestamp
+	}
+	if skew := now.Sub(time.Unix(seconds, 0)); skew > maxSkew || skew < -maxSkew {
+		return ErrTimestamp
+	}
+	if !hmac.Equal([]byte(signature), []byte(Signature(secret, timestamp, body))) {
+		return ErrMismatch
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"ollama-proxy/signing"
+)
+
+// signatureCheckingServer verifies the signature of every request the way the mock service does,
+// reports the result on the returned channel, and passes verified requests to next
+func signatureCheckingServer(t *testing.T, secret string, next http.Handler) (*httptest.Server, chan error) {
+	results := make(chan error, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		err := signing.Verify(r.Header, secret, body, time.Minute, time.Now())
+		results <- err
+		if err != nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	}))
+	return server, results
+}
+
+// TestSignExternalRequest tests the signature headers and their verification
+func TestSignExternalRequest(t *testing.T) {
+	oldSecret := externalServerHMACSecret
+	defer func() { externalServerHMACSecret = oldSecret }()
+	body := []byte(`{"apiKey":"test-key"}`)
+
+	t.Run("Unsigned Without Secret", func(t *testing.T) {
+		externalServerHMACSecret = ""
+		req := httptest.NewRequest("POST", "/validate", nil)
+		signExternalRequest(req, body)
+		if req.Header.Get(signing.SignatureHeader) != "" || req.Header.Get(signing.TimestampHeader) != "" {
+			t.Errorf("Expected no signature headers, got %v", req.Header)
+		}
+	})
+
+	externalServerHMACSecret = "shared-secret"
+	req := httptest.NewRequest("POST", "/validate", nil)
+	signExternalRequest(req, body)
+	now := time.Now()
+
+	testCases := []struct {
+		name    string
+		secret  string
+		body    []byte
+		maxSkew time.Duration
+		now     time.Time
+		wantErr error
+	}{
+		{"Valid", "shared-secret", body, time.Minute, now, nil},
+		{"Wrong Secret", "other-secret", body, time.Minute, now, signing.ErrMismatch},
+		{"Tampered Body", "shared-secret", []byte(`{"apiKey":"other-key"}`), time.Minute, now, signing.ErrMismatch},
+		{"Outside Skew", "shared-secret", body, time.Minute, now.Add(2 * time.Minute), signing.ErrTimestamp},
+		{"Within Configured Skew", "shared-secret", body, 5 * time.Minute, now.Add(2 * time.Minute), nil},
+		{"Clock Behind", "shared-secret", body, time.Minute, now.Add(-2 * time.Minute), signing.ErrTimestamp},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := signing.Verify(req.Header, tc.secret, tc.body, tc.maxSkew, tc.now)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Expected %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	t.Run("Missing Headers", func(t *testing.T) {
+		if err := signing.Verify(http.Header{}, "shared-secret", body, time.Minute, now); !errors.Is(err, signing.ErrMissing) {
+			t.Errorf("Expected %v, got %v", signing.ErrMissing, err)
+		}
+	})
+
+	t.Run("Timestamp Is Unix Seconds", func(t *testing.T) {
+		seconds, err := strconv.ParseInt(req.Header.Get(signing.TimestampHeader), 10, 64)
+		if err != nil || seconds != now.Unix() && seconds != now.Unix()-1 {
+			t.Errorf("Expected the current Unix time, got %q", req.Header.Get(signing.TimestampHeader))
+		}
+	})
+}
+
+// TestProxyHandlerSignedRequests tests that validation and metrics calls verify end to end, and that
+// a validation service rejecting the signature rejects the client request
+func TestProxyHandlerSignedRequests(t *testing.T) {
+	oldSecret := externalServerHMACSecret
+	defer func() { externalServerHMACSecret = oldSecret }()
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	useProxyTargets(t, ollamaServer.URL, "", "")
+
+	validation := mockValidationServer(t, true, false)
+	defer validation.Close()
+	metrics, received := recordingMetricsServer(t)
+	defer metrics.Close()
+
+	validationServer, validationResults := signatureCheckingServer(t, "shared-secret", validation.Config.Handler)
+	defer validationServer.Close()
+	metricsServer, metricsResults := signatureCheckingServer(t, "shared-secret", metrics.Config.Handler)
+	defer metricsServer.Close()
+	externalValidationURL = validationServer.URL
+	externalMetricsURL = metricsServer.URL
+
+	chat := ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "Hi"}}}
+
+	t.Run("Signed", func(t *testing.T) {
+		externalServerHMACSecret = "shared-secret"
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", chat, "test-key"))
+		assertResponseStatus(t, rr, http.StatusOK)
+		if err := <-validationResults; err != nil {
+			t.Errorf("Expected the validation call to verify, got %v", err)
+		}
+		waitForMetrics(t, received)
+		if err := <-metricsResults; err != nil {
+			t.Errorf("Expected the metrics call to verify, got %v", err)
+		}
+	})
+
+	t.Run("Wrong Secret", func(t *testing.T) {
+		externalServerHMACSecret = "other-secret"
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", chat, "test-key"))
+		assertResponseStatus(t, rr, http.StatusServiceUnavailable)
+		if err := <-validationResults; !errors.Is(err, signing.ErrMismatch) {
+			t.Errorf("Expected %v, got %v", signing.ErrMismatch, err)
+		}
+	})
+}
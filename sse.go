@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// sseQueryFlag is the query parameter that opts a request into SSE output
+const sseQueryFlag = "sse"
+
+// wantsSSE reports whether the client asked for Server-Sent Events and strips the opt-in query flag
+func wantsSSE(r *http.Request) bool {
+	query := r.URL.Query()
+	if query.Has(sseQueryFlag) {
+		enabled := query.Get(sseQueryFlag) != "false"
+		query.Del(sseQueryFlag)
+		r.URL.RawQuery = query.Encode()
+		return enabled
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		if mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept)); err == nil && mediaType == "text/event-stream" {
+			return true
+		}
+	}
+	return false
+}
+
+// sseWriter re-emits Ollama's NDJSON stream chunks as Server-Sent Events
+type sseWriter struct {
+	http.ResponseWriter
+	converting bool
+	pending    []byte
+	sawDone    bool
+	sawError   bool
+}
+
+func newSSEWriter(w http.ResponseWriter) *sseWriter {
+	return &sseWriter{ResponseWriter: w}
+}
+
+// WriteHeader switches to event-stream output when upstream answers with an NDJSON stream
+func (sw *sseWriter) WriteHeader(statusCode int) {
+	mediaType, _, _ := mime.ParseMediaType(sw.Header().Get("Content-Type"))
+	if statusCode == http.StatusOK && mediaType == "application/x-ndjson" {
+		sw.converting = true
+		sw.Header().Set("Content-Type", "text/event-stream")
+		sw.Header().Set("Cache-Control", "no-cache")
+		sw.Header().Del("Content-Length")
+	}
+	sw.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (sw *sseWriter) Write(b []byte) (int, error) {
+	if !sw.converting {
+		return sw.ResponseWriter.Write(b)
+	}
+
+	sw.pending = append(sw.pending, b...)
+	for {
+		idx := bytes.IndexByte(sw.pending, '\n')
+		if idx < 0 {
+			break
+		}
+		line := sw.pending[:idx]
+		sw.pending = sw.pending[idx+1:]
+		if err := sw.writeChunk(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// writeChunk emits one NDJSON line as an SSE event and flushes it to the client
+func (sw *sseWriter) writeChunk(line []byte) error {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil
+	}
+
+	var chunk struct {
+		Done  bool            `json:"done"`
+		Error json.RawMessage `json:"error"`
+	}
+	event := ""
+	if err := json.Unmarshal(line, &chunk); err == nil {
+		sw.sawDone = sw.sawDone || chunk.Done
+		if len(chunk.Error) > 0 && string(chunk.Error) != "null" {
+			sw.sawError = true
+			event = "error"
+		}
+	}
+
+	return sw.writeEvent(event, line)
+}
+
+func (sw *sseWriter) writeEvent(event string, data []byte) error {
+	var buf bytes.Buffer
+	if event != "" {
+		buf.WriteString("event: " + event + "\n")
+	}
+	buf.WriteString("data: ")
+	buf.Write(data)
+	buf.WriteString("\n\n")
+
+	if _, err := sw.ResponseWriter.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	sw.Flush()
+	return nil
+}
+
+// finish terminates the event stream with [DONE], or an error event if the stream broke off
+func (sw *sseWriter) finish(aborted bool) {
+	if !sw.converting {
+		return
+	}
+	if len(sw.pending) > 0 {
+		sw.writeChunk(sw.pending)
+		sw.pending = nil
+	}
+
+	if sw.sawError {
+		return
+	}
+	if aborted || !sw.sawDone {
+		data, _ := json.Marshal(map[string]string{"error": "upstream stream ended before completion"})
+		sw.writeEvent("error", data)
+		return
+	}
+	sw.writeEvent("", []byte("[DONE]"))
+}
+
+// shutdown ends the event stream with an error event because the proxy is shutting down
+func (sw *sseWriter) shutdown() {
+	if !sw.converting {
+		return
+	}
+	if len(sw.pending) > 0 {
+		sw.writeChunk(sw.pending)
+		sw.pending = nil
+	}
+	sw.ResponseWriter.Write(sseShutdownEvent())
+	sw.Flush()
+}
+
+func (sw *sseWriter) Flush() {
+	http.NewResponseController(sw.ResponseWriter).Flush()
+}
+
+func (sw *sseWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// readSSEEvents parses an event stream body into "event|data" pairs
+func readSSEEvents(body string) []string {
+	var events []string
+	event := ""
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			events = append(events, event+"|"+strings.TrimPrefix(line, "data: "))
+			event = ""
+		}
+	}
+	return events
+}
+
+// TestWantsSSE tests detection of the SSE opt-in
+func TestWantsSSE(t *testing.T) {
+	req := httptest.NewRequest("POST", "/api/chat?sse=true&keep=1", nil)
+	if !wantsSSE(req) {
+		t.Error("Expected query flag to enable SSE")
+	}
+	if req.URL.RawQuery != "keep=1" {
+		t.Errorf("Expected SSE flag to be stripped from query, got %s", req.URL.RawQuery)
+	}
+
+	req = httptest.NewRequest("POST", "/api/chat", nil)
+	req.Header.Set("Accept", "application/json, text/event-stream;q=0.9")
+	if !wantsSSE(req) {
+		t.Error("Expected Accept header to enable SSE")
+	}
+
+	req = httptest.NewRequest("POST", "/api/chat", nil)
+	if wantsSSE(req) {
+		t.Error("Expected SSE to be off by default")
+	}
+}
+
+// TestProxyHandlerSSE tests conversion of NDJSON streams to Server-Sent Events
+func TestProxyHandlerSSE(t *testing.T) {
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaURL, validationServer.URL, metricsServer.URL)
+
+	testCases := []struct {
+		name           string
+		chunks         []string
+		expectedEvents []string
+		expectedOutput int
+	}{
+		{
+			name: "Complete Stream",
+			chunks: []string{
+				`{"model":"llama2","message":{"role":"assistant","content":"Hel"},"done":false}`,
+				`{"model":"llama2","message":{"role":"assistant","content":"lo"},"done":false}`,
+				`{"model":"llama2","message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":7,"eval_count":2}`,
+			},
+			expectedEvents: []string{
+				`|{"model":"llama2","message":{"role":"assistant","content":"Hel"},"done":false}`,
+				`|{"model":"llama2","message":{"role":"assistant","content":"lo"},"done":false}`,
+				`|{"model":"llama2","message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":7,"eval_count":2}`,
+				`|[DONE]`,
+			},
+			expectedOutput: 2,
+		},
+		{
+			name: "Null Error",
+			chunks: []string{
+				`{"model":"llama2","message":{"role":"assistant","content":"Hi"},"done":false,"error":null}`,
+				`{"model":"llama2","message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":7,"eval_count":1}`,
+			},
+			expectedEvents: []string{
+				`|{"model":"llama2","message":{"role":"assistant","content":"Hi"},"done":false,"error":null}`,
+				`|{"model":"llama2","message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":7,"eval_count":1}`,
+				`|[DONE]`,
+			},
+			expectedOutput: 1,
+		},
+		{
+			name: "Error Mid Stream",
+			chunks: []string{
+				`{"model":"llama2","message":{"role":"assistant","content":"Hel"},"done":false}`,
+				`{"error":"model runner has unexpectedly stopped"}`,
+			},
+			expectedEvents: []string{
+				`|{"model":"llama2","message":{"role":"assistant","content":"Hel"},"done":false}`,
+				`error|{"error":"model runner has unexpectedly stopped"}`,
+			},
+			expectedOutput: 1,
+		},
+		{
+			name: "Stream Ends Without Done",
+			chunks: []string{
+				`{"model":"llama2","message":{"role":"assistant","content":"Hel"},"done":false}`,
+			},
+			expectedEvents: []string{
+				`|{"model":"llama2","message":{"role":"assistant","content":"Hel"},"done":false}`,
+				`error|{"error":"upstream stream ended before completion"}`,
+			},
+			expectedOutput: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ollamaServer := mockStreamingOllamaServer(t, tc.chunks, 0)
+			defer ollamaServer.Close()
+			useProxyTargets(t, ollamaServer.URL, "", "")
+
+			req := createTestRequest(t, "POST", "/api/chat", map[string]interface{}{
+				"model":    "llama2",
+				"messages": []ChatMessage{{Role: "user", Content: "hi"}},
+			}, "test-api-key")
+			req.Header.Set("Accept", "text/event-stream")
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, req)
+
+			assertResponseStatus(t, rr, http.StatusOK)
+			if ct := rr.Header().Get("Content-Type"); ct != "text/event-stream" {
+				t.Errorf("Expected Content-Type text/event-stream, got %s", ct)
+			}
+			if !rr.Flushed {
+				t.Error("Expected events to be flushed")
+			}
+
+			events := readSSEEvents(rr.Body.String())
+			if strings.Join(events, "\n") != strings.Join(tc.expectedEvents, "\n") {
+				t.Errorf("Expected events:\n%s\ngot:\n%s", strings.Join(tc.expectedEvents, "\n"), strings.Join(events, "\n"))
+			}
+
+			metrics := waitForMetrics(t, received)
+			if metrics.OutputTokenLength != tc.expectedOutput {
+				t.Errorf("Expected %d output tokens, got %d", tc.expectedOutput, metrics.OutputTokenLength)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerSSENonStreaming tests that non-streaming requests are not converted
+func TestProxyHandlerSSENonStreaming(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, validationServer.URL)
+
+	req := createTestRequest(t, "POST", "/api/generate?sse=true", GenerateRequest{Model: "mistral", Prompt: "hi"}, "test-api-key")
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, req)
+
+	assertResponseStatus(t, rr, http.StatusOK)
+	if strings.HasPrefix(rr.Body.String(), "data:") {
+		t.Errorf("Expected plain JSON for non-streaming request, got %s", rr.Body.String())
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Token sources reported with metrics
+const (
+	tokenSourceOllama    = "ollama"
+	tokenSourceEstimated = "estimated"
+)
+
+// Stream handling configuration
+var (
+	appendDoneChunk         bool
+	maxStreamingConnsPerKey int
+
+	// streamingConns maps API keys to their count of active streaming responses; keys with none are
+	// removed, so the map only holds keys that are streaming
+	streamingConns   = make(map[string]int)
+	streamingConnsMu sync.Mutex
+)
+
+// streamSummary describes an NDJSON response stream captured from Ollama
+type streamSummary struct {
+	Chunks          int
+	ContentChunks   int
+	ToolCalls       int
+	PromptEvalCount int
+	SawDone         bool
+	Error           string
+	Model           string
+}
+
+// isNDJSONResponse reports whether the response headers describe an Ollama stream
+func isNDJSONResponse(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "application/x-ndjson"
+}
+
+// summarizeStream walks a captured NDJSON body chunk by chunk
+func summarizeStream(body []byte) streamSummary {
+	var summary streamSummary
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(body)+1)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		// Only a top-level "error" key marks a failure, never generated text mentioning errors
+		var chunk struct {
+			Model           string          `json:"model"`
+			Response        string          `json:"response"`
+			Message         *ChatMessage    `json:"message"`
+			PromptEvalCount int             `json:"prompt_eval_count"`
+			Done            bool            `json:"done"`
+			Error           json.RawMessage `json:"error"`
+		}
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			continue
+		}
+
+		summary.Chunks++
+		if chunk.Model != "" {
+			summary.Model = chunk.Model
+		}
+		if chunk.Response != "" || (chunk.Message != nil && (chunk.Message.Content != "" || len(chunk.Message.ToolCalls) > 0)) {
+			summary.ContentChunks++
+		}
+		if chunk.Message != nil {
+			summary.ToolCalls += len(chunk.Message.ToolCalls)
+		}
+		if chunk.PromptEvalCount > 0 {
+			summary.PromptEvalCount = chunk.PromptEvalCount
+		}
+		if chunk.Done {
+			summary.SawDone = true
+		}
+		if len(chunk.Error) > 0 && string(chunk.Error) != "null" {
+			var text string
+			if err := json.Unmarshal(chunk.Error, &text); err != nil {
+				text = string(chunk.Error)
+			}
+			summary.Error = text
+		}
+	}
+
+	return summary
+}
+
+// estimatedTokens approximates token counts for a stream that ended without its done chunk,
+// counting one output token per content-bearing chunk as Ollama streams them
+func (s streamSummary) estimatedTokens() (int, int) {
+	return s.PromptEvalCount, s.ContentChunks
+}
+
+// clientGone reports whether the client cancelled the request or disconnected
+func clientGone(r *http.Request) bool {
+	select {
+	case <-r.Context().Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// terminalDoneChunk builds a final chunk so clients waiting for done:true terminate cleanly
+func terminalDoneChunk(path, model, reason string) []byte {
+	chunk := map[string]interface{}{
+		"model":       model,
+		"created_at":  time.Now().UTC().Format(time.RFC3339Nano),
+		"done":        true,
+		"done_reason": reason,
+	}
+	if strings.HasSuffix(path, "/api/chat") {
+		chunk["message"] = ChatMessage{Role: "assistant", Content: ""}
+	} else {
+		chunk["response"] = ""
+	}
+
+	data, _ := json.Marshal(chunk)
+	return append(data, '\n')
+}
+
+// acquireStreamingConn reserves a streaming slot for the key, returning a release func when one is free
+func acquireStreamingConn(apiKey string) (func(), bool) {
+	if maxStreamingConnsPerKey <= 0 {
+		return func() {}, true
+	}
+
+	streamingConnsMu.Lock()
+	defer streamingConnsMu.Unlock()
+	if streamingConns[apiKey] >= maxStreamingConnsPerKey {
+		return nil, false
+	}
+	streamingConns[apiKey]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			streamingConnsMu.Lock()
+			defer streamingConnsMu.Unlock()
+			if streamingConns[apiKey]--; streamingConns[apiKey] <= 0 {
+				delete(streamingConns, apiKey)
+			}
+		})
+	}, true
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSummarizeStream tests detection of error chunks in NDJSON streams
+func TestSummarizeStream(t *testing.T) {
+	testCases := []struct {
+		name          string
+		body          string
+		expectedError string
+		expectedDone  bool
+	}{
+		{
+			name: "Complete Stream",
+			body: `{"model":"llama2","response":"Hi","done":false}` + "\n" +
+				`{"model":"llama2","response":"","done":true}` + "\n",
+			expectedDone: true,
+		},
+		{
+			name:          "Error Mid Stream",
+			body:          `{"model":"llama2","response":"Hi","done":false}` + "\n" + `{"error":"out of memory"}` + "\n",
+			expectedError: "out of memory",
+		},
+		{
+			name: "Content Mentioning Error",
+			body: `{"model":"llama2","response":"{\"error\": \"not real\"}","done":false}` + "\n" +
+				`{"model":"llama2","message":{"role":"assistant","content":"error"},"done":true}` + "\n",
+			expectedDone: true,
+		},
+		{
+			name:          "Structured Error",
+			body:          `{"error":{"message":"bad"}}`,
+			expectedError: `{"message":"bad"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			summary := summarizeStream([]byte(tc.body))
+			if summary.Error != tc.expectedError {
+				t.Errorf("Expected error %q, got %q", tc.expectedError, summary.Error)
+			}
+			if summary.SawDone != tc.expectedDone {
+				t.Errorf("Expected done %v, got %v", tc.expectedDone, summary.SawDone)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerMidStreamError tests that mid-stream errors fail the request and terminate the stream
+func TestProxyHandlerMidStreamError(t *testing.T) {
+	ollamaServer := mockStreamingOllamaServer(t, []string{
+		`{"model":"llama2","message":{"role":"assistant","content":"Hel"},"done":false}`,
+		`{"model":"llama2","message":{"role":"assistant","content":"lo"},"done":false}`,
+		`{"error":"CUDA error: out of memory"}`,
+	}, 0)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	appendDoneChunk = true
+	defer func() { appendDoneChunk = false }()
+
+	req := createTestRequest(t, "POST", "/api/chat", map[string]interface{}{
+		"model":    "llama2",
+		"messages": []ChatMessage{{Role: "user", Content: "hi"}},
+	}, "test-api-key")
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, req)
+
+	assertResponseStatus(t, rr, http.StatusOK)
+
+	// The client sees the upstream error followed by a well-formed terminal chunk
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(rr.Body.String()))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 lines, got %d: %s", len(lines), rr.Body.String())
+	}
+	var final ChatResponse
+	if err := json.Unmarshal([]byte(lines[3]), &final); err != nil {
+		t.Fatalf("Error decoding terminal chunk: %v", err)
+	}
+	if !final.Done || final.DoneReason != "error" || final.Model != "llama2" {
+		t.Errorf("Expected terminal done chunk, got %s", lines[3])
+	}
+
+	metrics := waitForMetrics(t, received)
+	if !metrics.Failed {
+		t.Error("Expected metrics to mark the request as failed")
+	}
+	if metrics.UpstreamError != "CUDA error: out of memory" {
+		t.Errorf("Expected upstream error text in metrics, got %q", metrics.UpstreamError)
+	}
+}
+
+// TestProxyHandlerStreamWithoutErrors tests that successful streams are not marked as failed
+func TestProxyHandlerStreamWithoutErrors(t *testing.T) {
+	ollamaServer := mockStreamingOllamaServer(t, []string{
+		`{"model":"llama2","message":{"role":"assistant","content":"an error occurred"},"done":false}`,
+		`{"model":"llama2","message":{"role":"assistant","content":""},"done":true,"eval_count":3}`,
+	}, 0)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	appendDoneChunk = true
+	defer func() { appendDoneChunk = false }()
+
+	req := createTestRequest(t, "POST", "/api/chat", map[string]interface{}{
+		"model":    "llama2",
+		"messages": []ChatMessage{{Role: "user", Content: "hi"}},
+	}, "test-api-key")
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, req)
+
+	if strings.Count(rr.Body.String(), "\n") != 2 {
+		t.Errorf("Expected no extra terminal chunk, got %s", rr.Body.String())
+	}
+	metrics := waitForMetrics(t, received)
+	if metrics.Failed || metrics.UpstreamError != "" {
+		t.Errorf("Expected successful stream, got failed=%v error=%q", metrics.Failed, metrics.UpstreamError)
+	}
+	if metrics.OutputTokenLength != 3 {
+		t.Errorf("Expected 3 output tokens, got %d", metrics.OutputTokenLength)
+	}
+	if metrics.TokenSource != tokenSourceOllama {
+		t.Errorf("Expected token source %q, got %q", tokenSourceOllama, metrics.TokenSource)
+	}
+}
+
+// TestProxyHandlerStreamWithoutDone tests token estimation when a stream ends without its done chunk
+func TestProxyHandlerStreamWithoutDone(t *testing.T) {
+	ollamaServer := mockStreamingOllamaServer(t, []string{
+		`{"model":"llama2","response":"The","done":false}`,
+		`{"model":"llama2","response":" sky","done":false,"prompt_eval_count":11}`,
+		`{"model":"llama2","response":"","done":false}`,
+		`{"model":"llama2","response":" is","done":false}`,
+	}, 0)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	req := createTestRequest(t, "POST", "/api/generate", map[string]interface{}{
+		"model":  "llama2",
+		"prompt": "Why is the sky blue?",
+	}, "test-api-key")
+	proxyHandler(httptest.NewRecorder(), req)
+
+	metrics := waitForMetrics(t, received)
+	if metrics.TokenSource != tokenSourceEstimated {
+		t.Errorf("Expected token source %q, got %q", tokenSourceEstimated, metrics.TokenSource)
+	}
+	if metrics.OutputTokenLength != 3 {
+		t.Errorf("Expected 3 estimated output tokens, got %d", metrics.OutputTokenLength)
+	}
+	if metrics.InputTokenLength != 11 {
+		t.Errorf("Expected prompt_eval_count 11 from an intermediate chunk, got %d", metrics.InputTokenLength)
+	}
+}
+
+// TestStreamingConnLimit tests that streaming requests beyond the per-key limit are rejected
+func TestStreamingConnLimit(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		started <- struct{}{}
+		<-release
+		w.Write([]byte(`{"model":"llama2","response":"","done":true}` + "\n"))
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, validationServer.URL)
+
+	maxStreamingConnsPerKey = 1
+	defer func() { maxStreamingConnsPerKey = 0 }()
+
+	streamBody := map[string]interface{}{"model": "llama2", "prompt": "hi"}
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, createTestRequest(t, "POST", "/api/generate", streamBody, "stream-key"))
+		done <- rr
+	}()
+	<-started
+
+	// A second stream for the same key is rejected while the first is open
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/generate", streamBody, "stream-key"))
+	assertResponseStatus(t, rr, http.StatusTooManyRequests)
+
+	// Non-streaming requests and other keys are unaffected
+	go func() {
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, createTestRequest(t, "POST", "/api/generate", streamBody, "other-key"))
+		done <- rr
+	}()
+	<-started
+	close(release)
+	assertResponseStatus(t, <-done, http.StatusOK)
+	assertResponseStatus(t, <-done, http.StatusOK)
+
+	rr = httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/generate", map[string]interface{}{
+		"model": "llama2", "prompt": "hi", "stream": false,
+	}, "stream-key"))
+	assertResponseStatus(t, rr, http.StatusOK)
+
+	// The slot is released once the stream completes, and keys with no open streams are forgotten
+	rr = httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/generate", streamBody, "stream-key"))
+	assertResponseStatus(t, rr, http.StatusOK)
+	streamingConnsMu.Lock()
+	defer streamingConnsMu.Unlock()
+	if len(streamingConns) != 0 {
+		t.Errorf("Expected no keys tracked once streams close, got %v", streamingConns)
+	}
+}
+
+// TestProxyHandlerClientAbort tests that a client disconnecting mid-stream cancels upstream and reports partial metrics
+func TestProxyHandlerClientAbort(t *testing.T) {
+	upstreamCancelled := make(chan struct{})
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		for i := 0; i < 50; i++ {
+			fmt.Fprintf(w, `{"model":"llama2","message":{"role":"assistant","content":"tok%d"},"done":false}`+"\n", i)
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(20 * time.Millisecond):
+			case <-r.Context().Done():
+				close(upstreamCancelled)
+				return
+			}
+		}
+		w.Write([]byte(`{"model":"llama2","message":{"role":"assistant","content":""},"done":true,"eval_count":50}` + "\n"))
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	proxyServer := httptest.NewServer(http.HandlerFunc(proxyHandler))
+	defer proxyServer.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := createTestRequest(t, "POST", "/api/chat", map[string]interface{}{
+		"model":    "llama2",
+		"messages": []ChatMessage{{Role: "user", Content: "hi"}},
+	}, "test-api-key")
+	req.RequestURI = ""
+	req.URL.Scheme = "http"
+	req.URL.Host = strings.TrimPrefix(proxyServer.URL, "http://")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatalf("Error sending request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Read the first chunk, then walk away
+	if _, err := bufio.NewReader(resp.Body).ReadString('\n'); err != nil {
+		t.Fatalf("Error reading first chunk: %v", err)
+	}
+	cancel()
+
+	select {
+	case <-upstreamCancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the upstream request to be cancelled")
+	}
+
+	metrics := waitForMetrics(t, received)
+	if !metrics.ClientAborted {
+		t.Error("Expected metrics to mark the request as client aborted")
+	}
+	if metrics.OutputTokenLength < 1 || metrics.OutputTokenLength >= 50 {
+		t.Errorf("Expected partial output token count, got %d", metrics.OutputTokenLength)
+	}
+	if metrics.Failed {
+		t.Error("Expected a client abort not to be reported as an upstream failure")
+	}
+}
+
+// TestProxyHandlerToolCalls tests that tool calls in chat responses reach the metrics payload
+func TestProxyHandlerToolCalls(t *testing.T) {
+	toolCall := `{"function":{"name":"get_weather","arguments":{"city":"Lagos"}}}`
+	testCases := []struct {
+		name           string
+		chunks         []string
+		expectedCalls  int
+		expectedOutput int
+	}{
+		{
+			name: "Non-Streaming",
+			chunks: []string{
+				`{"model":"llama2","message":{"role":"assistant","content":"","tool_calls":[` + toolCall + `,` + toolCall + `]},"done":true,"prompt_eval_count":12,"eval_count":9}`,
+			},
+			expectedCalls:  2,
+			expectedOutput: 9,
+		},
+		{
+			name: "Streamed Tool Call Chunk",
+			chunks: []string{
+				`{"model":"llama2","message":{"role":"assistant","content":"","tool_calls":[` + toolCall + `]},"done":false}`,
+				`{"model":"llama2","message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":12,"eval_count":9}`,
+			},
+			expectedCalls:  1,
+			expectedOutput: 9,
+		},
+		{
+			// Without the done chunk the tool call chunk still counts towards the estimate
+			name: "Truncated Stream",
+			chunks: []string{
+				`{"model":"llama2","message":{"role":"assistant","content":"","tool_calls":[` + toolCall + `]},"done":false}`,
+			},
+			expectedCalls:  1,
+			expectedOutput: 1,
+		},
+		{
+			name: "No Tools",
+			chunks: []string{
+				`{"model":"llama2","message":{"role":"assistant","content":"Hi"},"done":true,"prompt_eval_count":12,"eval_count":1}`,
+			},
+			expectedCalls:  0,
+			expectedOutput: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ollamaServer := mockStreamingOllamaServer(t, tc.chunks, 0)
+			defer ollamaServer.Close()
+			validationServer := mockValidationServer(t, true, false)
+			defer validationServer.Close()
+			metricsServer, received := recordingMetricsServer(t)
+			defer metricsServer.Close()
+
+			useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+			req := createTestRequest(t, "POST", "/api/chat", ChatRequest{
+				Model:    "llama2",
+				Messages: []ChatMessage{{Role: "user", Content: "Weather in Lagos?"}},
+			}, "test-key")
+			proxyHandler(httptest.NewRecorder(), req)
+
+			metrics := waitForMetrics(t, received)
+			if metrics.ToolCallCount != tc.expectedCalls {
+				t.Errorf("Expected %d tool calls, got %d", tc.expectedCalls, metrics.ToolCallCount)
+			}
+			if metrics.OutputTokenLength != tc.expectedOutput {
+				t.Errorf("Expected %d output tokens, got %d", tc.expectedOutput, metrics.OutputTokenLength)
+			}
+		})
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Request tagging configuration
+var (
+	tagRulesConfig string
+	tagRules       []tagRule
+)
+
+// TagRuleConfig is one TAG_RULES entry: the tag to attach and the expression a request must match.
+// Final rules stop evaluation when they match, so earlier rules take precedence over later ones.
+type TagRuleConfig struct {
+	Name  string `json:"name"`
+	Match string `json:"match"`
+	Final bool   `json:"final,omitempty"`
+}
+
+// tagRule is a TAG_RULES entry compiled at config load
+type tagRule struct {
+	name  string
+	final bool
+	match tagExpr
+}
+
+// requestAttributes are the request properties tag rules can match on
+type requestAttributes struct {
+	Endpoint  string
+	Model     string
+	BodySize  int
+	Stream    bool
+	UserAgent string
+	KeyTier   string
+}
+
+// tagValueKind is the type of an attribute or literal in a tag rule
+type tagValueKind int
+
+const (
+	tagString tagValueKind = iota
+	tagNumber
+	tagBool
+)
+
+func (k tagValueKind) String() string {
+	switch k {
+	case tagNumber:
+		return "number"
+	case tagBool:
+		return "bool"
+	}
+	return "string"
+}
+
+// tagValue is an attribute or literal value
+type tagValue struct {
+	kind tagValueKind
+	str  string
+	num  float64
+	b    bool
+}
+
+// tagAttribute reads one attribute from a request
+type tagAttribute struct {
+	kind tagValueKind
+	get  func(*requestAttributes) tagValue
+}
+
+// tagAttributes is the fixed set of attributes rules may reference
+var tagAttributes = map[string]tagAttribute{
+	"endpoint": {tagString, func(a *requestAttributes) tagValue { return tagValue{kind: tagString, str: a.Endpoint} }},
+	"model":    {tagString, func(a *requestAttributes) tagValue { return tagValue{kind: tagString, str: a.Model} }},
+	"body_size": {tagNumber, func(a *requestAttributes) tagValue {
+		return tagValue{kind: tagNumber, num: float64(a.BodySize)}
+	}},
+	"stream":     {tagBool, func(a *requestAttributes) tagValue { return tagValue{kind: tagBool, b: a.Stream} }},
+	"user_agent": {tagString, func(a *requestAttributes) tagValue { return tagValue{kind: tagString, str: a.UserAgent} }},
+	"key_tier":   {tagString, func(a *requestAttributes) tagValue { return tagValue{kind: tagString, str: a.KeyTier} }},
+}
+
+// compileTagRules parses TAG_RULES, a JSON list of rules, reporting the first invalid rule
+func compileTagRules(raw string) ([]tagRule, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var configs []TagRuleConfig
+	if err := json.Unmarshal([]byte(raw), &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse TAG_RULES: %v", err)
+	}
+
+	rules := make([]tagRule, 0, len(configs))
+	for i, config := range configs {
+		if config.Name == "" {
+			return nil, fmt.Errorf("TAG_RULES rule %d has no name", i+1)
+		}
+		match, err := parseTagExpr(config.Match)
+		if err != nil {
+			return nil, fmt.Errorf("TAG_RULES rule %q: %v", config.Name, err)
+		}
+		rules = append(rules, tagRule{name: config.Name, final: config.Final, match: match})
+	}
+	return rules, nil
+}
+
+// evaluateTagRules returns the tags of the rules a request matches, in rule order
+func evaluateTagRules(rules []tagRule, attrs requestAttributes) []string {
+	var tags []string
+	for _, rule := range rules {
+		if !rule.match.eval(&attrs) {
+			continue
+		}
+		if !containsString(tags, rule.name) {
+			tags = append(tags, rule.name)
+		}
+		if rule.final {
+			break
+		}
+	}
+	return tags
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// requestTagsKey is the context key for a request's rule tags
+type requestTagsKey struct{}
+
+// withRequestTags attaches rule tags to a request context
+func withRequestTags(ctx context.Context, tags []string) context.Context {
+	return context.WithValue(ctx, requestTagsKey{}, tags)
+}
+
+// requestTagsFromContext returns the rule tags attached to a request context
+func requestTagsFromContext(ctx context.Context) []string {
+	tags, _ := ctx.Value(requestTagsKey{}).([]string)
+	return tags
+}
+
+// tagExpr is a compiled rule expression
+type tagExpr interface {
+	eval(*requestAttributes) bool
+}
+
+type tagAnd struct{ left, right tagExpr }
+type tagOr struct{ left, right tagExpr }
+type tagNot struct{ expr tagExpr }
+
+// tagBoolAttr is a bare boolean attribute such as stream
+type tagBoolAttr struct{ attr tagAttribute }
+
+// tagCompare compares an attribute with a literal
+type tagCompare struct {
+	attr    tagAttribute
+	op      string
+	literal tagValue
+}
+
+func (e tagAnd) eval(a *requestAttributes) bool      { return e.left.eval(a) && e.right.eval(a) }
+func (e tagOr) eval(a *requestAttributes) bool       { return e.left.eval(a) || e.right.eval(a) }
+func (e tagNot) eval(a *requestAttributes) bool      { return !e.expr.eval(a) }
+func (e tagBoolAttr) eval(a *requestAttributes) bool { return e.attr.get(a).b }
+
+func (e tagCompare) eval(a *requestAttributes) bool {
+	value := e.attr.get(a)
+	switch value.kind {
+	case tagString:
+		switch e.op {
+		case "==":
+			return value.str == e.literal.str
+		case "!=":
+			return value.str != e.literal.str
+		case "contains":
+			return strings.Contains(value.str, e.literal.str)
+		}
+	case tagNumber:
+		switch e.op {
+		case "==":
+			return value.num == e.literal.num
+		case "!=":
+			return value.num != e.literal.num
+		case "<":
+			return value.num < e.literal.num
+		case "<=":
+			return value.num <= e.literal.num
+		case ">":
+			return value.num > e.literal.num
+		case ">=":
+			return value.num >= e.literal.num
+		}
+	case tagBool:
+		switch e.op {
+		case "==":
+			return value.b == e.literal.b
+		case "!=":
+			return value.b != e.literal.b
+		}
+	}
+	return false
+}
+
+// tagOperators lists the comparisons each kind supports
+var tagOperators = map[tagValueKind][]string{
+	tagString: {"==", "!=", "contains"},
+	tagNumber: {"==", "!=", "<", "<=", ">", ">="},
+	tagBool:   {"==", "!="},
+}
+
+// tagToken is a lexical token of a rule expression
+type tagToken struct {
+	text string
+	pos  int
+}
+
+// lexTagExpr splits an expression into identifiers, literals, operators and parentheses
+func lexTagExpr(input string) ([]tagToken, error) {
+	var tokens []tagToken
+	for i := 0; i < len(input); {
+		c := rune(input[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(input) && input[end] != '"' {
+				if input[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(input) {
+				return nil, fmt.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, tagToken{input[i : end+1], i})
+			i = end + 1
+		case unicode.IsLetter(c) || c == '_':
+			end := i
+			for end < len(input) && (unicode.IsLetter(rune(input[end])) || unicode.IsDigit(rune(input[end])) || input[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, tagToken{input[i:end], i})
+			i = end
+		case unicode.IsDigit(c) || c == '-':
+			end := i + 1
+			for end < len(input) && (unicode.IsDigit(rune(input[end])) || input[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, tagToken{input[i:end], i})
+			i = end
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"} {
+				if strings.HasPrefix(input[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected %q at position %d", c, i)
+			}
+			tokens = append(tokens, tagToken{op, i})
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+// tagParser is a recursive descent parser over rule expression tokens:
+//
+//	expr    = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | "(" expr ")" | attribute [ op literal ]
+type tagParser struct {
+	tokens []tagToken
+	pos    int
+	input  string
+}
+
+// parseTagExpr compiles a rule expression, type-checking attributes, operators and literals
+func parseTagExpr(input string) (tagExpr, error) {
+	tokens, err := lexTagExpr(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty match expression")
+	}
+	p := &tagParser{tokens: tokens, input: input}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok, ok := p.peek(); ok {
+		return nil, fmt.Errorf("unexpected %q at position %d", tok.text, tok.pos)
+	}
+	return expr, nil
+}
+
+func (p *tagParser) peek() (tagToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return tagToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *tagParser) next() (tagToken, error) {
+	tok, ok := p.peek()
+	if !ok {
+		return tagToken{}, fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+	return tok, nil
+}
+
+func (p *tagParser) parseOr() (tagExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for tok, ok := p.peek(); ok && tok.text == "||"; tok, ok = p.peek() {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = tagOr{left, right}
+	}
+	return left, nil
+}
+
+func (p *tagParser) parseAnd() (tagExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for tok, ok := p.peek(); ok && tok.text == "&&"; tok, ok = p.peek() {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = tagAnd{left, right}
+	}
+	return left, nil
+}
+
+func (p *tagParser) parseUnary() (tagExpr, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok.text {
+	case "!":
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return tagNot{expr}, nil
+	case "(":
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		closing, err := p.next()
+		if err != nil || closing.text != ")" {
+			return nil, fmt.Errorf("missing ) for ( at position %d", tok.pos)
+		}
+		return expr, nil
+	}
+
+	attr, ok := tagAttributes[tok.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown attribute %q at position %d", tok.text, tok.pos)
+	}
+
+	// A boolean attribute may stand alone
+	op, ok := p.peek()
+	if !ok || !isTagOperator(op.text) {
+		if attr.kind != tagBool {
+			return nil, fmt.Errorf("%s attribute %q needs a comparison at position %d", attr.kind, tok.text, tok.pos)
+		}
+		return tagBoolAttr{attr}, nil
+	}
+	p.pos++
+	if !containsString(tagOperators[attr.kind], op.text) {
+		return nil, fmt.Errorf("operator %q not supported for %s attribute %q", op.text, attr.kind, tok.text)
+	}
+
+	litTok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	literal, err := parseTagLiteral(litTok)
+	if err != nil {
+		return nil, err
+	}
+	if literal.kind != attr.kind {
+		return nil, fmt.Errorf("cannot compare %s attribute %q with %s at position %d", attr.kind, tok.text, literal.kind, litTok.pos)
+	}
+	return tagCompare{attr: attr, op: op.text, literal: literal}, nil
+}
+
+func isTagOperator(text string) bool {
+	switch text {
+	case "==", "!=", "<", "<=", ">", ">=", "contains":
+		return true
+	}
+	return false
+}
+
+// parseTagLiteral parses a quoted string, number or boolean literal
+func parseTagLiteral(tok tagToken) (tagValue, error) {
+	switch {
+	case strings.HasPrefix(tok.text, `"`):
+		s, err := strconv.Unquote(tok.text)
+		if err != nil {
+			return tagValue{}, fmt.Errorf("invalid string %s at position %d", tok.text, tok.pos)
+		}
+		return tagValue{kind: tagString, str: s}, nil
+	case tok.text == "true", tok.text == "false":
+		return tagValue{kind: tagBool, b: tok.text == "true"}, nil
+	}
+	n, err := strconv.ParseFloat(tok.text, 64)
+	if err != nil {
+		return tagValue{}, fmt.Errorf("expected a literal at position %d, got %q", tok.pos, tok.text)
+	}
+	return tagValue{kind: tagNumber, num: n}, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"ollama-proxy/logger"
+)
+
+const testTagRules = `[
+	{"name": "suspicious", "match": "user_agent == \"\" || user_agent contains \"curl\"", "final": true},
+	{"name": "batch", "match": "(endpoint == \"/api/embed\" || endpoint == \"/api/embeddings\") && body_size > 100"},
+	{"name": "interactive", "match": "stream && !(key_tier == \"free\")"},
+	{"name": "premium", "match": "key_tier == \"pro\" && model != \"tinyllama\""},
+	{"name": "batch", "match": "body_size >= 1000"}
+]`
+
+// TestEvaluateTagRules tests multiple matches, rule order and final rules
+func TestEvaluateTagRules(t *testing.T) {
+	rules, err := compileTagRules(testTagRules)
+	if err != nil {
+		t.Fatalf("Error compiling rules: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		attrs    requestAttributes
+		expected []string
+	}{
+		{
+			name:     "Multiple Matches In Rule Order",
+			attrs:    requestAttributes{Endpoint: "/api/chat", Model: "llama3", Stream: true, UserAgent: "app/1.0", KeyTier: "pro"},
+			expected: []string{"interactive", "premium"},
+		},
+		{
+			name:     "Final Rule Stops Evaluation",
+			attrs:    requestAttributes{Endpoint: "/api/chat", Model: "llama3", Stream: true, UserAgent: "curl/8.0", KeyTier: "pro"},
+			expected: []string{"suspicious"},
+		},
+		{
+			name:     "Repeated Tag Attached Once",
+			attrs:    requestAttributes{Endpoint: "/api/embed", BodySize: 5000, UserAgent: "batcher", KeyTier: "free"},
+			expected: []string{"batch"},
+		},
+		{
+			name:     "No Matches",
+			attrs:    requestAttributes{Endpoint: "/api/embed", BodySize: 50, UserAgent: "app/1.0", KeyTier: "free"},
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tags := evaluateTagRules(rules, tc.attrs); !reflect.DeepEqual(tags, tc.expected) {
+				t.Errorf("Expected tags %v, got %v", tc.expected, tags)
+			}
+		})
+	}
+}
+
+// TestCompileTagRulesInvalid tests that invalid rules are rejected at config load
+func TestCompileTagRulesInvalid(t *testing.T) {
+	testCases := []struct {
+		rules    string
+		expected string
+	}{
+		{`not json`, "failed to parse TAG_RULES"},
+		{`[{"match": "stream"}]`, "rule 1 has no name"},
+		{`[{"name": "x", "match": ""}]`, "empty match expression"},
+		{`[{"name": "x", "match": "region == \"eu\""}]`, `unknown attribute "region"`},
+		{`[{"name": "x", "match": "body_size > \"big\""}]`, `cannot compare number attribute "body_size" with string`},
+		{`[{"name": "x", "match": "model > \"a\""}]`, `operator ">" not supported for string attribute "model"`},
+		{`[{"name": "x", "match": "model"}]`, `string attribute "model" needs a comparison`},
+		{`[{"name": "x", "match": "(stream && model == \"a\""}]`, "missing ) for ("},
+		{`[{"name": "x", "match": "model == \"a"}]`, "unterminated string"},
+		{`[{"name": "x", "match": "stream stream"}]`, `unexpected "stream" at position 7`},
+		{`[{"name": "x", "match": "stream; os.Exit(1)"}]`, `unexpected ';'`},
+	}
+
+	for _, tc := range testCases {
+		_, err := compileTagRules(tc.rules)
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.rules, tc.expected, err)
+		}
+	}
+}
+
+// TestProxyHandlerTagRules tests that tags reach the request log and metrics, using the tier from validation
+func TestProxyHandlerTagRules(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServerWith(t, ValidationResponse{Valid: true, Tier: "pro"})
+	defer validationServer.Close()
+	metricsServer, received := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	rules, err := compileTagRules(testTagRules)
+	if err != nil {
+		t.Fatalf("Error compiling rules: %v", err)
+	}
+	tagRules = rules
+	defer func() { tagRules = nil }()
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+
+	// Ollama streams when the request leaves stream unset
+	req := createTestRequest(t, "POST", "/api/generate", map[string]interface{}{"model": "mistral", "prompt": "Hi"}, "test-key")
+	req.Header.Set("User-Agent", "app/1.0")
+	proxyHandler(httptest.NewRecorder(), req)
+
+	metrics := waitForMetrics(t, received)
+	if !reflect.DeepEqual(metrics.RuleTags, []string{"interactive", "premium"}) {
+		t.Errorf("Expected rule tags in metrics, got %v", metrics.RuleTags)
+	}
+	if !strings.Contains(logs.String(), `"tags":["interactive","premium"]`) {
+		t.Errorf("Expected rule tags in the request log, got %s", logs.String())
+	}
+}
+
+func BenchmarkEvaluateTagRules(b *testing.B) {
+	rules, err := compileTagRules(testTagRules)
+	if err != nil {
+		b.Fatalf("Error compiling rules: %v", err)
+	}
+	attrs := requestAttributes{Endpoint: "/api/chat", Model: "llama3", Stream: true, UserAgent: "app/1.0", KeyTier: "pro"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		evaluateTagRules(rules, attrs)
+	}
+}
@@ -5,9 +5,41 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 )
 
+// resetReverseProxy forces the next request to build a proxy for the current ollamaURL
+func resetReverseProxy() {
+	proxyOnce = sync.Once{}
+	reverseProxy = nil
+}
+
+// useProxyTargets points the proxy at test servers for the rest of the test, with keys read from
+// X-API-Key; an empty validation or metrics URL leaves that one alone. When the test ends, metrics
+// still being delivered are waited for, so they can't race later tests, and the previous targets
+// are restored.
+func useProxyTargets(tb testing.TB, ollama, validation, metrics string) {
+	tb.Helper()
+	oldOllama, oldValidation, oldMetrics, oldHeader := ollamaURL, externalValidationURL, externalMetricsURL, apiKeyHeaderName
+	tb.Cleanup(func() {
+		metricsDeliveries.Wait()
+		ollamaURL, externalValidationURL, externalMetricsURL, apiKeyHeaderName = oldOllama, oldValidation, oldMetrics, oldHeader
+		resetReverseProxy()
+	})
+
+	ollamaURL = ollama
+	if validation != "" {
+		externalValidationURL = validation
+	}
+	if metrics != "" {
+		externalMetricsURL = metrics
+	}
+	apiKeyHeaderName = "X-API-Key"
+	resetReverseProxy()
+}
+
 // mockOllamaServer creates a test server that simulates Ollama's behavior
 func mockOllamaServer(t *testing.T) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -19,6 +51,13 @@ func mockOllamaServer(t *testing.T) *httptest.Server {
 		// Handle different endpoints
 		switch r.URL.Path {
 		case "/api/chat":
+			// Ollama rejects chats that don't name a model
+			var req ChatRequest
+			if json.NewDecoder(r.Body).Decode(&req); req.Model == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "model is required"})
+				return
+			}
 			response := ChatResponse{
 				Model:           "llama2",
 				CreatedAt:       "2024-01-01T00:00:00Z",
@@ -48,14 +87,44 @@ func mockOllamaServer(t *testing.T) *httptest.Server {
 			}
 			json.NewEncoder(w).Encode(response)
 
+		case "/api/embeddings":
+			response := EmbeddingsResponse{
+				Embedding: []float32{0.1, 0.2, 0.3},
+			}
+			json.NewEncoder(w).Encode(response)
+
 		default:
 			w.WriteHeader(http.StatusNotFound)
 		}
 	}))
 }
 
+// mockStreamingOllamaServer creates a test server that streams the given NDJSON chunks with a delay between them
+func mockStreamingOllamaServer(t *testing.T, chunks []string, delay time.Duration) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		for i, chunk := range chunks {
+			if i > 0 && delay > 0 {
+				select {
+				case <-time.After(delay):
+				case <-r.Context().Done():
+					return
+				}
+			}
+			w.Write([]byte(chunk + "\n"))
+			w.(http.Flusher).Flush()
+		}
+	}))
+}
+
 // mockValidationServer creates a test server that simulates the validation service
 func mockValidationServer(t *testing.T, valid bool, rateLimited bool) *httptest.Server {
+	return mockValidationServerWith(t, ValidationResponse{Valid: valid, RateLimited: rateLimited})
+}
+
+// mockValidationServerWith creates a test validation service that answers every request with response
+func mockValidationServerWith(t *testing.T, response ValidationResponse) *httptest.Server {
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Verify request headers
 		if r.Header.Get("Content-Type") != "application/json" {
@@ -71,10 +140,6 @@ func mockValidationServer(t *testing.T, valid bool, rateLimited bool) *httptest.
 		}
 
 		// Send validation response
-		response := ValidationResponse{
-			Valid:       valid,
-			RateLimited: rateLimited,
-		}
 		json.NewEncoder(w).Encode(response)
 	}))
 }
@@ -88,12 +153,20 @@ func mockMetricsServer(t *testing.T) *httptest.Server {
 		}
 
 		// Verify request body
-		var metrics MetricsData
-		if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
+		var raw map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
 			t.Errorf("Error decoding request body: %v", err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		for _, key := range []string{"stream", "doneReason"} {
+			if _, ok := raw[key]; !ok {
+				t.Errorf("Missing %s field in metrics data", key)
+			}
+		}
+		var metrics MetricsData
+		body, _ := json.Marshal(raw)
+		json.Unmarshal(body, &metrics)
 
 		// Verify required fields
 		if metrics.APIKey == "" || metrics.Model == "" {
@@ -106,6 +179,32 @@ func mockMetricsServer(t *testing.T) *httptest.Server {
 	}))
 }
 
+// recordingMetricsServer creates a test server that forwards every received metrics payload to the returned channel
+func recordingMetricsServer(t *testing.T) (*httptest.Server, chan MetricsData) {
+	received := make(chan MetricsData, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var metrics MetricsData
+		if err := json.NewDecoder(r.Body).Decode(&metrics); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- metrics
+		w.WriteHeader(http.StatusOK)
+	}))
+	return server, received
+}
+
+// waitForMetrics returns the next metrics payload or fails the test after a timeout
+func waitForMetrics(t *testing.T, received chan MetricsData) MetricsData {
+	select {
+	case metrics := <-received:
+		return metrics
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for metrics")
+		return MetricsData{}
+	}
+}
+
 // createTestRequest creates a test HTTP request with the given parameters
 func createTestRequest(t *testing.T, method, path string, body interface{}, apiKey string) *http.Request {
 	var bodyBytes []byte
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"ollama-proxy/logger"
+)
+
+// Token verification configuration
+var (
+	tokenVerifySampleRate float64 // 0 disables
+	tokenVerifyTolerance  float64
+	tokenVerifyMaxText    int
+	tokenVerifier         *tokenCountVerifier
+)
+
+var tokenCountDiscrepancies = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "proxy_token_count_discrepancies_total",
+	Help: "Sampled requests whose Ollama-reported token counts diverged from the local estimate beyond the tolerance.",
+}, []string{"model", "direction"})
+
+// Tokenizer counts tokens in text independently of Ollama
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// heuristicTokenizer approximates BPE tokenizers: short words are one token and longer words take one
+// token per four characters, with each punctuation mark counted separately
+type heuristicTokenizer struct{}
+
+func (heuristicTokenizer) CountTokens(text string) int {
+	tokens := 0
+	for _, word := range strings.FieldsFunc(text, unicode.IsSpace) {
+		letters := 0
+		for _, r := range word {
+			if unicode.IsPunct(r) || unicode.IsSymbol(r) {
+				tokens++
+			} else {
+				letters++
+			}
+		}
+		tokens += (letters + 3) / 4
+	}
+	return tokens
+}
+
+// tokenSample is a completed request queued for verification, holding text truncated to TOKEN_VERIFY_MAX_TEXT
+type tokenSample struct {
+	Model          string
+	InputText      string
+	OutputText     string
+	InputComplete  bool
+	OutputComplete bool
+	ReportedInput  int
+	ReportedOutput int
+}
+
+// ModelTokenStats summarizes how a model's reported token counts compare with the local estimate
+type ModelTokenStats struct {
+	Samples              int     `json:"samples"`
+	InputDiscrepancies   int     `json:"inputDiscrepancies"`
+	OutputDiscrepancies  int     `json:"outputDiscrepancies"`
+	MeanInputDivergence  float64 `json:"meanInputDivergence"`
+	MeanOutputDivergence float64 `json:"meanOutputDivergence"`
+	MaxDivergence        float64 `json:"maxDivergence"`
+
+	inputCompared  int
+	outputCompared int
+}
+
+// tokenCountVerifier re-counts a sample of requests off the request path
+type tokenCountVerifier struct {
+	sampleRate float64
+	tolerance  float64
+	tokenizer  Tokenizer
+	samples    chan tokenSample
+	dropped    atomic.Int64
+
+	mu    sync.Mutex
+	stats map[string]*ModelTokenStats
+}
+
+func newTokenCountVerifier(sampleRate, tolerance float64, tokenizer Tokenizer) *tokenCountVerifier {
+	return &tokenCountVerifier{
+		sampleRate: sampleRate,
+		tolerance:  tolerance,
+		tokenizer:  tokenizer,
+		samples:    make(chan tokenSample, 256),
+		stats:      make(map[string]*ModelTokenStats),
+	}
+}
+
+// start verifies queued samples in the background
+func (v *tokenCountVerifier) start() {
+	go func() {
+		for sample := range v.samples {
+			v.verify(sample)
+		}
+	}()
+}
+
+// Sample queues a sampled request without blocking; text is extracted and truncated before queueing
+// so only bounded text is retained
+func (v *tokenCountVerifier) Sample(path, model string, requestBody, responseBody []byte, reportedInput, reportedOutput int) {
+	if rand.Float64() >= v.sampleRate {
+		return
+	}
+
+	input, inputComplete := truncateForVerification(getRequestText(path, requestBody))
+	output, outputComplete := truncateForVerification(getResponsePreview(path, responseBody, tokenVerifyMaxText+1))
+	sample := tokenSample{
+		Model:          model,
+		InputText:      input,
+		OutputText:     output,
+		InputComplete:  inputComplete,
+		OutputComplete: outputComplete,
+		ReportedInput:  reportedInput,
+		ReportedOutput: reportedOutput,
+	}
+
+	select {
+	case v.samples <- sample:
+	default:
+		v.dropped.Add(1)
+	}
+}
+
+func truncateForVerification(text string) (string, bool) {
+	if len(text) > tokenVerifyMaxText {
+		return truncateUTF8(text, tokenVerifyMaxText), false
+	}
+	return text, true
+}
+
+// verify compares a sample's reported counts with the estimate. Truncated text can't be counted
+// exactly, so only the complete sides of a sample are compared.
+func (v *tokenCountVerifier) verify(sample tokenSample) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	stats, ok := v.stats[sample.Model]
+	if !ok {
+		stats = &ModelTokenStats{}
+		v.stats[sample.Model] = stats
+	}
+	stats.Samples++
+
+	if sample.InputComplete {
+		divergence := tokenDivergence(v.tokenizer.CountTokens(sample.InputText), sample.ReportedInput)
+		stats.inputCompared++
+		stats.MeanInputDivergence += (divergence - stats.MeanInputDivergence) / float64(stats.inputCompared)
+		stats.MaxDivergence = math.Max(stats.MaxDivergence, divergence)
+		if divergence > v.tolerance {
+			stats.InputDiscrepancies++
+			v.reportDiscrepancy(sample, "input", divergence)
+		}
+	}
+	if sample.OutputComplete {
+		divergence := tokenDivergence(v.tokenizer.CountTokens(sample.OutputText), sample.ReportedOutput)
+		stats.outputCompared++
+		stats.MeanOutputDivergence += (divergence - stats.MeanOutputDivergence) / float64(stats.outputCompared)
+		stats.MaxDivergence = math.Max(stats.MaxDivergence, divergence)
+		if divergence > v.tolerance {
+			stats.OutputDiscrepancies++
+			v.reportDiscrepancy(sample, "output", divergence)
+		}
+	}
+}
+
+func (v *tokenCountVerifier) reportDiscrepancy(sample tokenSample, direction string, divergence float64) {
+	tokenCountDiscrepancies.WithLabelValues(sample.Model, direction).Inc()
+	logger.Warning("Token count discrepancy", map[string]interface{}{
+		"model":           sample.Model,
+		"direction":       direction,
+		"reported_input":  sample.ReportedInput,
+		"reported_output": sample.ReportedOutput,
+		"divergence":      math.Round(divergence*1000) / 1000,
+	})
+}
+
+// tokenDivergence is the estimate's relative distance from the reported count
+func tokenDivergence(estimated, reported int) float64 {
+	if reported == 0 {
+		if estimated == 0 {
+			return 0
+		}
+		return 1
+	}
+	return math.Abs(float64(estimated-reported)) / float64(reported)
+}
+
+// Stats returns a copy of the per-model verification stats
+func (v *tokenCountVerifier) Stats() map[string]ModelTokenStats {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	stats := make(map[string]ModelTokenStats, len(v.stats))
+	for model, s := range v.stats {
+		stats[model] = *s
+	}
+	return stats
+}
+
+// getRequestText returns the text a request asks the model to read
+func getRequestText(path string, body []byte) string {
+	var req struct {
+		Prompt   string        `json:"prompt"`
+		System   string        `json:"system"`
+		Messages []ChatMessage `json:"messages"`
+		Input    interface{}   `json:"input"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return ""
+	}
+
+	var parts []string
+	switch {
+	case strings.HasSuffix(path, "/api/chat"):
+		for _, message := range req.Messages {
+			parts = append(parts, message.Content)
+		}
+	case strings.HasSuffix(path, "/api/embed"):
+		switch input := req.Input.(type) {
+		case string:
+			parts = append(parts, input)
+		case []interface{}:
+			for _, item := range input {
+				if text, ok := item.(string); ok {
+					parts = append(parts, text)
+				}
+			}
+		}
+	default:
+		parts = append(parts, req.System, req.Prompt)
+	}
+	return strings.Join(parts, "\n")
+}
+
+// StatsResponse is served at GET /stats
+type StatsResponse struct {
+	TokenVerification map[string]ModelTokenStats `json:"tokenVerification"`
+}
+
+// statsHandler reports per-model token verification stats to admins
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if adminAPIKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !isAdminRequest(r) {
+		logger.Warning("Unauthorized: Invalid admin key", map[string]interface{}{
+			"endpoint": r.URL.Path,
+		})
+		http.Error(w, "Unauthorized: Invalid admin key", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := StatsResponse{TokenVerification: map[string]ModelTokenStats{}}
+	if tokenVerifier != nil {
+		response.TokenVerification = tokenVerifier.Stats()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
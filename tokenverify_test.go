@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"ollama-proxy/logger"
+)
+
+// TestHeuristicTokenizer tests the local token estimate
+func TestHeuristicTokenizer(t *testing.T) {
+	testCases := []struct {
+		text     string
+		expected int
+	}{
+		{"", 0},
+		{"the cat sat", 3},
+		{"Hello, world!", 6},
+		{"internationalization", 5},
+	}
+
+	for _, tc := range testCases {
+		if got := (heuristicTokenizer{}).CountTokens(tc.text); got != tc.expected {
+			t.Errorf("%q: expected %d tokens, got %d", tc.text, tc.expected, got)
+		}
+	}
+}
+
+// TestTokenVerifierDiscrepancies tests discrepancy detection and per-model stats from samples with known counts
+func TestTokenVerifierDiscrepancies(t *testing.T) {
+	tokenVerifyMaxText = 1024
+	verifier := newTokenCountVerifier(1, 0.25, heuristicTokenizer{})
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+	before := testutil.ToFloat64(tokenCountDiscrepancies.WithLabelValues("drifty", "output"))
+
+	// "the cat sat" estimates 3 tokens each way
+	verifier.verify(tokenSample{Model: "exact", InputText: "the cat sat", OutputText: "the cat sat", InputComplete: true, OutputComplete: true, ReportedInput: 3, ReportedOutput: 3})
+	verifier.verify(tokenSample{Model: "drifty", InputText: "the cat sat", OutputText: "the cat sat", InputComplete: true, OutputComplete: true, ReportedInput: 3, ReportedOutput: 12})
+	// A truncated side isn't compared
+	verifier.verify(tokenSample{Model: "drifty", InputText: "the cat", OutputText: "the cat sat", InputComplete: false, OutputComplete: true, ReportedInput: 100, ReportedOutput: 3})
+
+	stats := verifier.Stats()
+	if exact := stats["exact"]; exact.Samples != 1 || exact.InputDiscrepancies != 0 || exact.OutputDiscrepancies != 0 || exact.MaxDivergence != 0 {
+		t.Errorf("Expected no divergence for exact, got %+v", exact)
+	}
+	drifty := stats["drifty"]
+	if drifty.Samples != 2 || drifty.InputDiscrepancies != 0 || drifty.OutputDiscrepancies != 1 {
+		t.Errorf("Expected one output discrepancy for drifty, got %+v", drifty)
+	}
+	if drifty.MaxDivergence != 0.75 || drifty.MeanOutputDivergence != 0.375 || drifty.MeanInputDivergence != 0 {
+		t.Errorf("Expected divergence 0.75 averaging 0.375 over outputs, got %+v", drifty)
+	}
+
+	if got := testutil.ToFloat64(tokenCountDiscrepancies.WithLabelValues("drifty", "output")) - before; got != 1 {
+		t.Errorf("Expected 1 discrepancy counted, got %v", got)
+	}
+	if strings.Count(logs.String(), `"message":"Token count discrepancy"`) != 1 || !strings.Contains(logs.String(), `"divergence":0.75`) {
+		t.Errorf("Expected one discrepancy warning, got %s", logs.String())
+	}
+}
+
+// TestProxyHandlerTokenVerification tests that completed requests are verified in the background and reported at /stats
+func TestProxyHandlerTokenVerification(t *testing.T) {
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Exact for the prompt, but four times the output estimate
+		json.NewEncoder(w).Encode(GenerateResponse{Model: "llama3", Response: "the cat sat", Done: true, PromptEvalCount: 3, EvalCount: 12})
+	}))
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+	adminAPIKey = "admin-key"
+	resetReverseProxy()
+
+	tokenVerifyMaxText = 1024
+	tokenVerifier = newTokenCountVerifier(1, 0.25, heuristicTokenizer{})
+	tokenVerifier.start()
+	defer func() {
+		tokenVerifier = nil
+		adminAPIKey = ""
+	}()
+
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/generate", GenerateRequest{Model: "llama3", Prompt: "one two six"}, "test-key"))
+	assertResponseStatus(t, rr, http.StatusOK)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for tokenVerifier.Stats()["llama3"].Samples == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the request to be verified")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	req := httptest.NewRequest("GET", "/stats", nil)
+	req.Header.Set("X-API-Key", "admin-key")
+	rr = httptest.NewRecorder()
+	statsHandler(rr, req)
+	assertResponseStatus(t, rr, http.StatusOK)
+
+	var resp StatsResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Error decoding stats: %v", err)
+	}
+	if stats := resp.TokenVerification["llama3"]; stats.Samples != 1 || stats.InputDiscrepancies != 0 || stats.OutputDiscrepancies != 1 {
+		t.Errorf("Expected one sample with an output discrepancy, got %+v", stats)
+	}
+
+	req = httptest.NewRequest("GET", "/stats", nil)
+	req.Header.Set("X-API-Key", "wrong-key")
+	rr = httptest.NewRecorder()
+	statsHandler(rr, req)
+	assertResponseStatus(t, rr, http.StatusUnauthorized)
+}
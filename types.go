@@ -1,35 +1,128 @@
 package main
 
+//go:generate go run ./cmd/apidoc -input types.go -output docs/api.md
+
 import (
 	// "bytes"
+	"encoding/json"
 	"net/http"
+	"time"
 )
 
+// requestDetailsVersion is the RequestDetails payload format; version 2 sent every value of each header
+// where version 1 sent only the first
+const requestDetailsVersion = 2
+
 // RequestDetails contains information about the incoming request
 type RequestDetails struct {
-	APIKey           string            `json:"apiKey"`
-	IPAddress        string            `json:"ipAddress"`
-	UserAgent        string            `json:"userAgent"`
-	Headers          map[string]string `json:"headers"`
-	Model            string            `json:"model"`
-	InputTokenLength int               `json:"inputTokenLength"`
-	Endpoint         string            `json:"endpoint"`
+	Version             int                 `json:"version"`                       // Payload format version, currently 2; absent from version 1 payloads
+	APIKey              string              `json:"apiKey"`                        // Key from API_KEY_HEADER_NAME
+	IPAddress           string              `json:"ipAddress"`                     // Client IP, from X-Forwarded-For when TRUST_PROXY_HEADERS is set
+	UserAgent           string              `json:"userAgent"`                     // Client User-Agent
+	Headers             map[string][]string `json:"headers"`                       // Every value of each request header in arrival order, under its canonical name (e.g. X-Forwarded-For), capped at MAX_REQUEST_VALUE_LENGTH
+	Model               string              `json:"model"`                         // Model named in the request body, after alias and pin resolution
+	InputTokenLength    int                 `json:"inputTokenLength"`              // Prompt tokens estimated from the request body at about four characters per token
+	InputTokenEstimated bool                `json:"inputTokenEstimated,omitempty"` // Marks inputTokenLength as an estimate; false on endpoints without a prompt
+	Endpoint            string              `json:"endpoint"`                      // Request path, e.g. /api/chat
+	DestinationModel    string              `json:"destinationModel,omitempty"`    // New name a /api/copy request creates
+	BodySHA256          string              `json:"bodySHA256,omitempty"`          // Hex SHA-256 of the raw request body, for spotting identical prompts across keys; unset when the body isn't read, for zero-retention keys and until the key's retention is known
+	BodyBytes           int                 `json:"bodyBytes,omitempty"`           // Size of the raw request body
 }
 
 // ValidationResponse represents the response from the external validation server
 type ValidationResponse struct {
 	Valid       bool `json:"valid"`
 	RateLimited bool `json:"rateLimited"`
+	// Reason explains a rejection; "model_not_allowed" and "endpoint_not_allowed" return 403 instead of 401
+	Reason string `json:"reason,omitempty"`
+	// RetryAfterSeconds is sent to rate-limited clients in the Retry-After header
+	RetryAfterSeconds int `json:"retryAfterSeconds,omitempty"`
+	// ZeroRetention keeps the key's prompts and responses out of logs, previews, replays and fingerprints, like ZERO_RETENTION_KEYS
+	ZeroRetention bool `json:"zeroRetention,omitempty"`
+	// RateLimitLimit, RateLimitRemaining and RateLimitResetSeconds describe the key's quota and are sent to
+	// clients as X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset; absent fields send no header
+	RateLimitLimit        *int `json:"rateLimitLimit,omitempty"`
+	RateLimitRemaining    *int `json:"rateLimitRemaining,omitempty"`
+	RateLimitResetSeconds *int `json:"rateLimitResetSeconds,omitempty"`
+	// AllowedEndpoints lists the canonical endpoints (chat, generate, embed, tags, ...) the key may call; absent allows all
+	AllowedEndpoints []string `json:"allowedEndpoints,omitempty"`
+	// AllowedModels lists the models the key may use, matched case-insensitively and with * as a suffix glob
+	// (e.g. "llama3:*"); other models get 403 and model lists only show these. Absent allows all.
+	AllowedModels []string `json:"allowedModels,omitempty"`
+	// AllowedCIDRs lists the client IP ranges (IPv4 or IPv6) the key may be used from; other addresses get 403.
+	// Absent allows all, and a list with an invalid range is ignored.
+	AllowedCIDRs []string `json:"allowedCIDRs,omitempty"`
+	// Scopes grants extra permissions; "admin" allows PROTECTED_ENDPOINTS
+	Scopes []string `json:"scopes,omitempty"`
+	// Tier is the key's plan, available to TAG_RULES as key_tier
+	Tier string `json:"tier,omitempty"`
+	// MaxKeyAgeDays is how many days after keyIssuedAt the key is accepted; 0 means keys never expire
+	MaxKeyAgeDays int `json:"maxKeyAgeDays,omitempty"`
+	// KeyIssuedAt is when the key was issued, checked against maxKeyAgeDays
+	KeyIssuedAt *time.Time `json:"keyIssuedAt,omitempty"`
+	// MaxOutputTokens caps num_predict on /api/chat and /api/generate and max_tokens on /v1 completions; 0 means no cap
+	MaxOutputTokens int `json:"maxOutputTokens,omitempty"`
+	// SessionToken lets the proxy accept the key without asking again for SessionTTLSeconds; it is sent
+	// with metrics to tie usage back to this answer
+	SessionToken      string `json:"sessionToken,omitempty"`
+	SessionTTLSeconds int    `json:"sessionTTLSeconds,omitempty"`
+}
+
+// ErrorResponse is a JSON error carrying a machine-readable code
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// BatchValidationRequest wraps several requests validated in a single call
+type BatchValidationRequest struct {
+	Requests []RequestDetails `json:"requests"`
+}
+
+// BatchValidationResponse contains one validation response per batched request, in order
+type BatchValidationResponse struct {
+	Responses []ValidationResponse `json:"responses"`
 }
 
 // MetricsData contains information to be sent to the metrics server
 type MetricsData struct {
-	APIKey            string `json:"apiKey"`
-	Model             string `json:"model"`
-	InputTokenLength  int    `json:"inputTokenLength"`
-	OutputTokenLength int    `json:"outputTokenLength"`
-	RequestDurationMs int64  `json:"requestDurationMs"`
-	Endpoint          string `json:"endpoint"`
+	APIKey             string `json:"apiKey"`                  // Key the request was made with
+	Model              string `json:"model"`                   // Model that served the request
+	InputTokenLength   int    `json:"inputTokenLength"`        // Prompt tokens
+	OutputTokenLength  int    `json:"outputTokenLength"`       // Generated tokens
+	TokenSource        string `json:"tokenSource"`             // ollama when the counts come from Ollama, estimated otherwise
+	Stream             bool   `json:"stream"`                  // Whether the response was streamed
+	DoneReason         string `json:"doneReason"`              // Why generation stopped, e.g. stop, length or proxy_shutdown
+	ToolCallCount      int    `json:"toolCallCount"`           // Tool calls in the response
+	RequestDurationMs  int64  `json:"requestDurationMs"`       // Time from receiving the request to finishing the response
+	TTFTMs             int64  `json:"ttftMs"`                  // Time to the first response byte
+	Endpoint           string `json:"endpoint"`                // Request path
+	Failed             bool   `json:"failed"`                  // Ollama reported an error mid-stream
+	UpstreamError      string `json:"upstreamError,omitempty"` // Ollama's mid-stream error message
+	ClientAborted      bool   `json:"clientAborted"`           // The client disconnected before the response finished
+	ShutdownTerminated bool   `json:"shutdownTerminated"`      // The proxy ended the stream while shutting down
+	KeySource          string `json:"keySource"`               // external, ephemeral, public, or unvalidated for VALIDATION_SKIP_PATHS
+	ProxyVersion       string `json:"proxyVersion"`            // Version of the proxy that handled the request
+	BytesTransferred   int64  `json:"bytesTransferred"`        // Response bytes written to the client
+	ContentLength      int64  `json:"contentLength,omitempty"` // Size of a blob upload, which has no token counts
+	ChunkCount         int    `json:"chunkCount"`              // Streamed chunks
+	ChunkGapMinUs      int64  `json:"chunkGapMinUs"`           // Shortest gap between chunks, in microseconds
+	ChunkGapMeanUs     int64  `json:"chunkGapMeanUs"`          // Mean gap between chunks, in microseconds
+	ChunkGapP95Us      int64  `json:"chunkGapP95Us"`           // 95th percentile gap between chunks, in microseconds
+	LongestStallUs     int64  `json:"longestStallUs"`          // Longest gap between chunks, in microseconds
+
+	CreateStatus     string `json:"createStatus,omitempty"`     // Outcome of a /api/create: success, Ollama's error, or the last progress status if it ended early
+	Quantize         string `json:"quantize,omitempty"`         // Quantization a /api/create requested, e.g. q4_K_M
+	SourceModel      string `json:"sourceModel,omitempty"`      // Model a /api/copy request copied; model is also set to it
+	DestinationModel string `json:"destinationModel,omitempty"` // New name a /api/copy request created
+	EmbedInputCount  int    `json:"embedInputCount,omitempty"`  // Inputs in an /api/embed request
+	EmbeddingCount   int    `json:"embeddingCount,omitempty"`   // Embeddings in an /api/embed response
+	EmbeddingDim     int    `json:"embeddingDim,omitempty"`     // Dimension of the first embedding in an /api/embed response
+
+	Tags     map[string]string `json:"tags,omitempty"`     // Key metadata from METADATA_ENRICHMENT_URL
+	RuleTags []string          `json:"ruleTags,omitempty"` // Tags from matching TAG_RULES
+
+	SessionToken string `json:"sessionToken,omitempty"` // Session token of the validation answer the request was accepted under
 }
 
 // ChatRequest represents the structure of a chat request to Ollama
@@ -39,6 +132,7 @@ type ChatRequest struct {
 	Stream   bool          `json:"stream"`
 	Format   interface{}   `json:"format,omitempty"`
 	Options  interface{}   `json:"options,omitempty"`
+	Think    *bool         `json:"think,omitempty"`
 }
 
 // ChatMessage represents a single message in a chat request
@@ -65,6 +159,7 @@ type GenerateRequest struct {
 	Format  interface{} `json:"format,omitempty"`
 	Options interface{} `json:"options,omitempty"`
 	Images  []string    `json:"images,omitempty"`
+	Think   *bool       `json:"think,omitempty"`
 }
 
 // EmbedRequest represents the structure of an embedding request to Ollama
@@ -74,6 +169,13 @@ type EmbedRequest struct {
 	Options interface{} `json:"options,omitempty"`
 }
 
+// EmbeddingsRequest represents a request to the legacy single-prompt /api/embeddings endpoint
+type EmbeddingsRequest struct {
+	Model   string      `json:"model"`
+	Prompt  string      `json:"prompt"`
+	Options interface{} `json:"options,omitempty"`
+}
+
 // CreateRequest represents the structure of a model creation request
 type CreateRequest struct {
 	Model      string            `json:"model"`
@@ -89,6 +191,65 @@ type CreateRequest struct {
 	Quantize   string            `json:"quantize,omitempty"`
 }
 
+// PullRequest represents a request to download a model; Ollama accepts the deprecated name field in place of model
+type PullRequest struct {
+	Model    string `json:"model"`
+	Name     string `json:"name,omitempty"`
+	Insecure bool   `json:"insecure,omitempty"`
+	Stream   *bool  `json:"stream,omitempty"`
+}
+
+// PushRequest represents a request to upload a model to a registry, with the same model spellings as a pull
+type PushRequest struct {
+	Model    string `json:"model"`
+	Name     string `json:"name,omitempty"`
+	Insecure bool   `json:"insecure,omitempty"`
+	Stream   *bool  `json:"stream,omitempty"`
+}
+
+// ShowRequest represents a request for a model's details
+type ShowRequest struct {
+	Model   string `json:"model"`
+	Name    string `json:"name,omitempty"`
+	Verbose bool   `json:"verbose,omitempty"`
+}
+
+// DeleteRequest represents a request to remove a local model
+type DeleteRequest struct {
+	Model string `json:"model"`
+	Name  string `json:"name,omitempty"`
+}
+
+// CopyRequest represents a request to copy a local model under a new name
+type CopyRequest struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+}
+
+// transferModel returns the model field, falling back to the deprecated name older clients send; show and
+// delete requests accept the same spellings
+func transferModel(model, name string) string {
+	if model != "" {
+		return model
+	}
+	return name
+}
+
+// TagsResponse represents Ollama's list of local models
+type TagsResponse struct {
+	Models []ModelInfo `json:"models"`
+}
+
+// ModelInfo represents a single model in a tags response
+type ModelInfo struct {
+	Name       string      `json:"name"`
+	Model      string      `json:"model"`
+	ModifiedAt string      `json:"modified_at,omitempty"`
+	Size       int64       `json:"size"`
+	Digest     string      `json:"digest,omitempty"`
+	Details    interface{} `json:"details,omitempty"`
+}
+
 // ChatResponse represents the structure of a chat response from Ollama
 type ChatResponse struct {
 	Model           string      `json:"model"`
@@ -126,6 +287,23 @@ type EmbedResponse struct {
 	PromptEvalCount int         `json:"prompt_eval_count"`
 }
 
+// EmbeddingsResponse represents the legacy /api/embeddings response, a single embedding without the model or counts
+type EmbeddingsResponse struct {
+	Embedding       []float32 `json:"embedding"`
+	PromptEvalCount int       `json:"prompt_eval_count"`
+}
+
+// ReplayEntry represents a single captured request in a replay file
+type ReplayEntry struct {
+	OffsetMs int64           `json:"offsetMs"`
+	Method   string          `json:"method"`
+	Endpoint string          `json:"endpoint"`
+	Model    string          `json:"model,omitempty"`
+	Stream   bool            `json:"stream"`
+	KeyHash  string          `json:"keyHash,omitempty"`
+	Body     json.RawMessage `json:"body,omitempty"`
+}
+
 // // responseWriter is a custom response writer that captures the response body
 // type responseWriter struct {
 // 	http.ResponseWriter
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"ollama-proxy/logger"
+)
+
+// Idle model unloading configuration
+var (
+	modelIdleUnload time.Duration
+	protectedModels map[string]bool
+	janitor         *modelJanitor
+)
+
+var modelUnloads = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "proxy_model_unloads_total",
+	Help: "Idle models the proxy asked Ollama to unload, by result.",
+}, []string{"result"})
+
+// modelActivity tracks when a model was last used and how many requests are using it
+type modelActivity struct {
+	lastUsed time.Time
+	inFlight int
+}
+
+// modelJanitor unloads models from Ollama once they have been idle for longer than idle.
+// Clients rarely set keep_alive, so without it models stay in VRAM long after traffic stops.
+type modelJanitor struct {
+	mu     sync.Mutex
+	idle   time.Duration
+	now    func() time.Time
+	models map[string]*modelActivity
+}
+
+func newModelJanitor(idle time.Duration) *modelJanitor {
+	return &modelJanitor{
+		idle:   idle,
+		now:    time.Now,
+		models: make(map[string]*modelActivity),
+	}
+}
+
+// begin marks a model as in use and returns a function that marks the request finished
+func (j *modelJanitor) begin(model string) func() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	activity, ok := j.models[model]
+	if !ok {
+		activity = &modelActivity{}
+		j.models[model] = activity
+	}
+	activity.inFlight++
+	activity.lastUsed = j.now()
+
+	return func() {
+		j.mu.Lock()
+		defer j.mu.Unlock()
+		activity.inFlight--
+		activity.lastUsed = j.now()
+	}
+}
+
+// idleModels returns the models idle beyond the threshold that are neither protected nor in use
+func (j *modelJanitor) idleModels() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var idle []string
+	now := j.now()
+	for model, activity := range j.models {
+		if activity.inFlight == 0 && !protectedModels[model] && now.Sub(activity.lastUsed) >= j.idle {
+			idle = append(idle, model)
+		}
+	}
+	return idle
+}
+
+// sweep unloads every idle model, forgetting it once Ollama has released it
+func (j *modelJanitor) sweep() {
+	for _, model := range j.idleModels() {
+		if err := unloadModel(model); err != nil {
+			modelUnloads.WithLabelValues("failed").Inc()
+			logger.Error("Failed to unload idle model", err, map[string]interface{}{
+				"model": model,
+			})
+			continue
+		}
+
+		// A request may have started while the unload was in flight; it reloads the model itself
+		j.mu.Lock()
+		if activity, ok := j.models[model]; ok && activity.inFlight == 0 {
+			delete(j.models, model)
+		}
+		j.mu.Unlock()
+
+		modelUnloads.WithLabelValues("success").Inc()
+		logger.Info("Unloaded idle model", map[string]interface{}{
+			"model":     model,
+			"idle_secs": j.idle.Seconds(),
+		})
+	}
+}
+
+// start sweeps for idle models periodically until stop is closed
+func (j *modelJanitor) start(stop <-chan struct{}) {
+	go func() {
+		ticker := time.NewTicker(j.idle / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.sweep()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// unloadModel asks Ollama to release a model by sending an empty generate request with keep_alive 0
+func unloadModel(model string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"model":      model,
+		"keep_alive": 0,
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := getOllamaHTTPClient().Post(currentOllamaURL()+"/api/generate", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Ollama returned non-OK status: %d", resp.StatusCode)
+	}
+	return nil
+}
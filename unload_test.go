@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// fakeClock is a manually advanced clock for idle timing
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// TestModelJanitor tests that idle models are unloaded with keep_alive 0, skipping protected and in-use models
+func TestModelJanitor(t *testing.T) {
+	var mu sync.Mutex
+	var unloaded []string
+	ollamaServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&req)
+		if r.URL.Path != "/api/generate" || req["keep_alive"] != float64(0) || len(req) != 2 {
+			t.Errorf("Expected minimal generate request with keep_alive 0, got %s %v", r.URL.Path, req)
+		}
+		mu.Lock()
+		unloaded = append(unloaded, req["model"].(string))
+		mu.Unlock()
+		json.NewEncoder(w).Encode(GenerateResponse{Model: req["model"].(string), Done: true, DoneReason: "unload"})
+	}))
+	defer ollamaServer.Close()
+	useProxyTargets(t, ollamaServer.URL, "", "")
+	protectedModels = parseKeyList("pinned")
+	defer func() { protectedModels = nil }()
+
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	j := newModelJanitor(10 * time.Minute)
+	j.now = clock.Now
+
+	for _, model := range []string{"idle", "pinned", "busy", "recent"} {
+		j.begin(model)()
+	}
+	finishBusy := j.begin("busy")
+	before := testutil.ToFloat64(modelUnloads.WithLabelValues("success"))
+
+	// Nothing is idle long enough yet
+	clock.Advance(9 * time.Minute)
+	j.begin("recent")()
+	j.sweep()
+	if len(unloaded) != 0 {
+		t.Fatalf("Expected no unloads before the idle threshold, got %v", unloaded)
+	}
+
+	clock.Advance(2 * time.Minute)
+	j.sweep()
+	if len(unloaded) != 1 || unloaded[0] != "idle" {
+		t.Fatalf("Expected only the idle model to be unloaded, got %v", unloaded)
+	}
+	if got := testutil.ToFloat64(modelUnloads.WithLabelValues("success")) - before; got != 1 {
+		t.Errorf("Expected 1 counted unload, got %v", got)
+	}
+
+	// Unloaded models are forgotten until used again, and in-use models become eligible once finished
+	finishBusy()
+	clock.Advance(10 * time.Minute)
+	j.sweep()
+	sort.Strings(unloaded)
+	expected := []string{"busy", "idle", "recent"}
+	if len(unloaded) != len(expected) {
+		t.Fatalf("Expected unloads %v, got %v", expected, unloaded)
+	}
+	for i := range expected {
+		if unloaded[i] != expected[i] {
+			t.Errorf("Expected unloads %v, got %v", expected, unloaded)
+		}
+	}
+}
+
+// TestModelJanitorTracksProxiedRequests tests that proxied requests keep their model marked in use
+func TestModelJanitorTracksProxiedRequests(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, _ := recordingMetricsServer(t)
+	defer metricsServer.Close()
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	janitor = newModelJanitor(time.Minute)
+	defer func() { janitor = nil }()
+
+	req := createTestRequest(t, "POST", "/api/generate", GenerateRequest{Model: "mistral", Prompt: "Hi"}, "test-key")
+	proxyHandler(httptest.NewRecorder(), req)
+
+	activity, ok := janitor.models["mistral"]
+	if !ok || activity.inFlight != 0 || activity.lastUsed.IsZero() {
+		t.Errorf("Expected finished request to be tracked, got %+v", activity)
+	}
+}
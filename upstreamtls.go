@@ -0,0 +1,72 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Upstream TLS configuration, used when Ollama sits behind a TLS terminator
+var (
+	ollamaTLSCAFile     string
+	ollamaTLSCertFile   string
+	ollamaTLSKeyFile    string
+	ollamaTLSServerName string
+	ollamaTLSInsecure   bool
+)
+
+// upstreamTLSConfig builds the TLS settings for connections to Ollama from configuration
+func upstreamTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         ollamaTLSServerName,
+		InsecureSkipVerify: ollamaTLSInsecure,
+	}
+
+	if ollamaTLSCAFile != "" {
+		pem, err := os.ReadFile(ollamaTLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read OLLAMA_TLS_CA_FILE: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in OLLAMA_TLS_CA_FILE %s", ollamaTLSCAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if (ollamaTLSCertFile == "") != (ollamaTLSKeyFile == "") {
+		return nil, fmt.Errorf("OLLAMA_TLS_CERT_FILE and OLLAMA_TLS_KEY_FILE must be set together")
+	}
+	if ollamaTLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(ollamaTLSCertFile, ollamaTLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load Ollama client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// validateUpstreamTLSConfig checks that every file referenced by the upstream TLS configuration loads
+func validateUpstreamTLSConfig() error {
+	_, err := upstreamTLSConfig()
+	return err
+}
+
+// getOllamaHTTPClient returns a client for direct calls to Ollama, such as health checks,
+// using the same TLS settings as the proxy
+func getOllamaHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config, err := upstreamTLSConfig(); err == nil {
+		transport.TLSClientConfig = config
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   10 * time.Second,
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert is a generated certificate with its key, written as PEM files
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	certFile string
+	keyFile  string
+}
+
+// tlsPair returns the certificate for use in a tls.Config
+func (c testCert) tlsPair() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+// generateTestCert creates a certificate signed by parent, or self-signed when parent is nil
+func generateTestCert(t *testing.T, dir, name string, parent *testCert, template *x509.Certificate) testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating key: %v", err)
+	}
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.Subject = pkix.Name{CommonName: name}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatalf("Error creating certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	out := testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	os.WriteFile(out.certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(out.keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	return out
+}
+
+// resetUpstreamTLS clears the upstream TLS configuration
+func resetUpstreamTLS() {
+	ollamaTLSCAFile = ""
+	ollamaTLSCertFile = ""
+	ollamaTLSKeyFile = ""
+	ollamaTLSServerName = ""
+	ollamaTLSInsecure = false
+	resetReverseProxy()
+}
+
+// TestUpstreamTLS tests proxying to a TLS backend that requires a client certificate and SNI override, and to a plain HTTP backend
+func TestUpstreamTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := generateTestCert(t, dir, "ca", nil, &x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	})
+	server := generateTestCert(t, dir, "server", &ca, &x509.Certificate{
+		DNSNames:    []string{"ollama.internal"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	client := generateTestCert(t, dir, "client", &ca, &x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	// Both backends answer health checks as well as chat requests
+	ollama := mockOllamaServer(t)
+	defer ollama.Close()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			w.Write([]byte(`{"models":[]}`))
+			return
+		}
+		ollama.Config.Handler.ServeHTTP(w, r)
+	})
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	tlsBackend := httptest.NewUnstartedServer(handler)
+	tlsBackend.TLS = &tls.Config{
+		Certificates: []tls.Certificate{server.tlsPair()},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	tlsBackend.StartTLS()
+	defer tlsBackend.Close()
+	plainBackend := httptest.NewServer(handler)
+	defer plainBackend.Close()
+	validationServer := mockValidationServer(t, true, false)
+	defer validationServer.Close()
+	metricsServer, _ := recordingMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaURL, validationServer.URL, metricsServer.URL)
+	defer resetUpstreamTLS()
+
+	testCases := []struct {
+		name      string
+		url       string
+		configure func()
+		expected  int
+		healthy   bool
+	}{
+		{"TLS With Client Cert", tlsBackend.URL, func() {
+			ollamaTLSCAFile = ca.certFile
+			ollamaTLSCertFile = client.certFile
+			ollamaTLSKeyFile = client.keyFile
+			ollamaTLSServerName = "ollama.internal"
+		}, http.StatusOK, true},
+		{"TLS Without Client Cert", tlsBackend.URL, func() {
+			ollamaTLSCAFile = ca.certFile
+			ollamaTLSServerName = "ollama.internal"
+		}, http.StatusBadGateway, false},
+		{"TLS Without Server Name", tlsBackend.URL, func() {
+			ollamaTLSCAFile = ca.certFile
+			ollamaTLSCertFile = client.certFile
+			ollamaTLSKeyFile = client.keyFile
+		}, http.StatusBadGateway, false},
+		{"Plain HTTP", plainBackend.URL, func() {}, http.StatusOK, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resetUpstreamTLS()
+			tc.configure()
+			useProxyTargets(t, tc.url, "", "")
+
+			req := createTestRequest(t, "POST", "/api/chat", ChatRequest{
+				Model:    "llama2",
+				Messages: []ChatMessage{{Role: "user", Content: "Hello"}},
+			}, "test-key")
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, req)
+			assertResponseStatus(t, rr, tc.expected)
+
+			// Health checks reach the backend with the same TLS settings
+			if err := validateOllamaService(); (err == nil) != tc.healthy {
+				t.Errorf("Expected healthy=%v, got error %v", tc.healthy, err)
+			}
+		})
+	}
+}
+
+// TestValidateUpstreamTLSConfig tests that referenced files are checked at config load
+func TestValidateUpstreamTLSConfig(t *testing.T) {
+	dir := t.TempDir()
+	cert := generateTestCert(t, dir, "client", nil, &x509.Certificate{})
+	garbage := filepath.Join(dir, "garbage.pem")
+	os.WriteFile(garbage, []byte("not a certificate"), 0600)
+	defer resetUpstreamTLS()
+
+	testCases := []struct {
+		name      string
+		configure func()
+		valid     bool
+	}{
+		{"Empty", func() {}, true},
+		{"Valid", func() {
+			ollamaTLSCAFile = cert.certFile
+			ollamaTLSCertFile = cert.certFile
+			ollamaTLSKeyFile = cert.keyFile
+		}, true},
+		{"Missing CA File", func() { ollamaTLSCAFile = filepath.Join(dir, "missing.pem") }, false},
+		{"Invalid CA File", func() { ollamaTLSCAFile = garbage }, false},
+		{"Cert Without Key", func() { ollamaTLSCertFile = cert.certFile }, false},
+		{"Mismatched Key", func() {
+			ollamaTLSCertFile = cert.certFile
+			ollamaTLSKeyFile = garbage
+		}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resetUpstreamTLS()
+			tc.configure()
+			if err := validateUpstreamTLSConfig(); (err == nil) != tc.valid {
+				t.Errorf("Expected valid=%v, got %v", tc.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"ollama-proxy/logger"
+)
+
+// Batch validation configuration
+var (
+	externalValidationType string
+	validationBatchSize    int
+	validationBatchWait    time.Duration
+
+	batcherOnce sync.Once
+	batcher     *validationBatcher
+)
+
+// pendingValidation is a request waiting for its batch to be validated
+type pendingValidation struct {
+	details RequestDetails
+	result  chan batchResult
+}
+
+// batchResult is one request's share of a batch call: its response, or the error that failed the batch
+type batchResult struct {
+	response ValidationResponse
+	err      error
+}
+
+// validationBatcher groups concurrent validation requests into batch calls
+type validationBatcher struct {
+	pending chan pendingValidation
+}
+
+// batchValidationEnabled reports whether validation calls should be batched
+func batchValidationEnabled() bool {
+	return externalValidationType == "batch" && validationBatchSize > 1
+}
+
+// getValidationBatcher returns the shared batcher, starting it on first use
+func getValidationBatcher() *validationBatcher {
+	batcherOnce.Do(func() {
+		batcher = &validationBatcher{
+			pending: make(chan pendingValidation),
+		}
+		go batcher.run()
+	})
+	return batcher
+}
+
+// validate queues details for the next batch and waits for its result, or until ctx is done
+func (b *validationBatcher) validate(ctx context.Context, details RequestDetails) (ValidationResponse, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return ValidationResponse{}, false, err
+	}
+	result := make(chan batchResult, 1)
+	select {
+	case b.pending <- pendingValidation{details: details, result: result}:
+	case <-ctx.Done():
+		return ValidationResponse{}, false, ctx.Err()
+	}
+
+	select {
+	case res := <-result:
+		if res.err != nil {
+			return ValidationResponse{}, false, res.err
+		}
+		return res.response, res.response.Valid && !res.response.RateLimited, nil
+	case <-ctx.Done():
+		return ValidationResponse{}, false, ctx.Err()
+	}
+}
+
+func (b *validationBatcher) run() {
+	for first := range b.pending {
+		batch := []pendingValidation{first}
+		timer := time.NewTimer(validationBatchWait)
+
+	collect:
+		for len(batch) < validationBatchSize {
+			select {
+			case next := <-b.pending:
+				batch = append(batch, next)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		go flushValidationBatch(batch)
+	}
+}
+
+// flushValidationBatch validates a batch and delivers each result to its waiting request. The call
+// belongs to no single request, so it runs under its own request ID and is bounded by the validation
+// timeouts and retry budget rather than by any one caller's context.
+func flushValidationBatch(batch []pendingValidation) {
+	requests := make([]RequestDetails, len(batch))
+	for i, pending := range batch {
+		requests[i] = pending.details
+	}
+
+	ctx := withRequestID(context.Background(), newRequestID())
+	responses, err := sendValidationBatch(ctx, requests)
+	if err != nil {
+		logger.Error("Error calling batch validation server", err, map[string]interface{}{
+			"batch_size": len(batch),
+		})
+	}
+
+	for i, pending := range batch {
+		if err != nil {
+			pending.result <- batchResult{err: err}
+			continue
+		}
+		pending.result <- batchResult{response: responses[i]}
+	}
+}
+
+// sendValidationBatch posts a batch of request details to each validation URL in turn, healthy ones
+// first, retrying transient failures within one budget as single validation calls do
+func sendValidationBatch(ctx context.Context, requests []RequestDetails) ([]ValidationResponse, error) {
+	jsonData, err := json.Marshal(BatchValidationRequest{Requests: requests})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal batch: %v", err)
+	}
+
+	fields := map[string]interface{}{"batch_size": len(requests)}
+	deadline := time.Now().Add(validationRetryBudget)
+	for _, target := range validationTargets() {
+		if isGRPCValidationURL(target.url) {
+			continue
+		}
+
+		var batchResp BatchValidationResponse
+		err := retryValidationCall(ctx, target, deadline, fields, func() error {
+			callFields := map[string]interface{}{
+				"batch_size":     len(requests),
+				"validation_url": target.url,
+			}
+			if err := postValidation(ctx, target, jsonData, callFields, &batchResp); err != nil {
+				return err
+			}
+			if len(batchResp.Responses) != len(requests) {
+				err := fmt.Errorf("batch validation returned %d responses for %d requests", len(batchResp.Responses), len(requests))
+				logger.Error("Error decoding validation response", err, callFields)
+				return &validationDecodeError{err: err}
+			}
+			return nil
+		})
+		if err != nil {
+			continue
+		}
+		return batchResp.Responses, nil
+	}
+	return nil, errNoValidationTarget
+}
+
+// anyHTTPValidationURL reports whether any validation URL can take a batch call; gRPC URLs can't and
+// are skipped
+func anyHTTPValidationURL() bool {
+	for _, target := range validationTargets() {
+		if !isGRPCValidationURL(target.url) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestBatchValidation tests that concurrent validations are sent as one batch
+func TestBatchValidation(t *testing.T) {
+	var calls atomic.Int32
+	var batchSizes []int
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var batch BatchValidationRequest
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("Error decoding batch: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		batchSizes = append(batchSizes, len(batch.Requests))
+		mu.Unlock()
+
+		var response BatchValidationResponse
+		for _, details := range batch.Requests {
+			response.Responses = append(response.Responses, ValidationResponse{
+				Valid:       details.APIKey != "invalid-key",
+				RateLimited: details.APIKey == "limited-key",
+			})
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	useProxyTargets(t, ollamaURL, server.URL, "")
+	externalValidationType = "batch"
+	validationBatchSize = 3
+	validationBatchWait = time.Second
+	defer func() {
+		externalValidationType = "single"
+		validationBatchSize = 1
+	}()
+
+	keys := []string{"valid-key", "invalid-key", "limited-key"}
+	results := make([]bool, len(keys))
+	var wg sync.WaitGroup
+	for i, key := range keys {
+		wg.Add(1)
+		go func(i int, key string) {
+			defer wg.Done()
+			_, results[i], _ = validateRequest(context.Background(), RequestDetails{APIKey: key, Model: "llama2"})
+		}(i, key)
+	}
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("Expected a single batch call, got %d", calls.Load())
+	}
+	if len(batchSizes) != 1 || batchSizes[0] != 3 {
+		t.Errorf("Expected one batch of 3, got %v", batchSizes)
+	}
+	expected := []bool{true, false, false}
+	for i := range keys {
+		if results[i] != expected[i] {
+			t.Errorf("%s: expected %v, got %v", keys[i], expected[i], results[i])
+		}
+	}
+
+	// A partial batch is flushed once the wait expires
+	validationBatchWait = 20 * time.Millisecond
+	if _, ok, _ := validateRequest(context.Background(), RequestDetails{APIKey: "valid-key"}); !ok {
+		t.Error("Expected partial batch to be validated after the wait")
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected a second batch call, got %d", calls.Load())
+	}
+
+	// A malformed batch response fails every request in the batch
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(BatchValidationResponse{})
+	})
+	if _, ok, err := validateRequest(context.Background(), RequestDetails{APIKey: "valid-key"}); ok || !errors.Is(err, errNoValidationTarget) {
+		t.Errorf("Expected an incomplete batch response to fail with errNoValidationTarget, got ok=%v err=%v", ok, err)
+	}
+}
+
+// TestBatchValidationFailover tests that batches are retried and fail over between validation URLs
+// like single validation calls
+func TestBatchValidationFailover(t *testing.T) {
+	var primaryCalls atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryCalls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer primary.Close()
+
+	var secondaryCalls atomic.Int32
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondaryCalls.Add(1)
+		var batch BatchValidationRequest
+		json.NewDecoder(r.Body).Decode(&batch)
+		response := BatchValidationResponse{Responses: make([]ValidationResponse, len(batch.Requests))}
+		for i := range response.Responses {
+			response.Responses[i].Valid = true
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer secondary.Close()
+
+	useProxyTargets(t, ollamaURL, primary.URL, "")
+	externalValidationURLs = []string{primary.URL, secondary.URL}
+	externalValidationType = "batch"
+	validationBatchSize = 2
+	validationBatchWait = 10 * time.Millisecond
+	validationRetryBackoff = time.Millisecond
+	defer func() {
+		externalValidationURLs = nil
+		externalValidationType = "single"
+		validationBatchSize = 1
+		validationRetryBackoff = defaultValidationRetryBackoff
+	}()
+
+	if _, ok, err := validateRequest(context.Background(), RequestDetails{APIKey: "valid-key"}); !ok || err != nil {
+		t.Fatalf("Expected the batch to be validated by the second URL, got ok=%v err=%v", ok, err)
+	}
+	if primaryCalls.Load() != int32(validationRetryAttempts) {
+		t.Errorf("Expected %d attempts on the failing URL, got %d", validationRetryAttempts, primaryCalls.Load())
+	}
+	if secondaryCalls.Load() != 1 {
+		t.Errorf("Expected one call to the second URL, got %d", secondaryCalls.Load())
+	}
+
+	// Every URL failing is an error, not an invalid key
+	secondary.Config.Handler = primary.Config.Handler
+	if _, ok, err := validateRequest(context.Background(), RequestDetails{APIKey: "valid-key"}); ok || !errors.Is(err, errNoValidationTarget) {
+		t.Errorf("Expected errNoValidationTarget when every URL fails, got ok=%v err=%v", ok, err)
+	}
+
+	// A caller whose context ends stops waiting for its batch
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, ok, err := validateRequest(ctx, RequestDetails{APIKey: "valid-key"}); ok || !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled caller to get context.Canceled, got ok=%v err=%v", ok, err)
+	}
+}
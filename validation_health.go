@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"ollama-proxy/logger"
+	"ollama-proxy/version"
+)
+
+// Validation failover configuration
+var (
+	externalValidationURLs        []string
+	validationHealthCheckInterval time.Duration
+	validationHealth              = newValidationHealthTracker()
+)
+
+// unhealthyValidationTimeout gives validation URLs marked unhealthy longer to answer when every healthy one failed
+const unhealthyValidationTimeout = 30 * time.Second
+
+// validationHealthTracker records the result of the latest health check for each validation URL
+type validationHealthTracker struct {
+	mu        sync.RWMutex
+	unhealthy map[string]bool
+}
+
+func newValidationHealthTracker() *validationHealthTracker {
+	return &validationHealthTracker{unhealthy: make(map[string]bool)}
+}
+
+// set records whether a validation URL passed its health check
+func (h *validationHealthTracker) set(url string, healthy bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.unhealthy[url] == !healthy {
+		return
+	}
+	h.unhealthy[url] = !healthy
+	logger.Info("Validation service health changed", map[string]interface{}{
+		"validation_url": url,
+		"healthy":        healthy,
+	})
+}
+
+// healthy reports whether a validation URL passed its latest health check; unchecked URLs count as healthy
+func (h *validationHealthTracker) healthy(url string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return !h.unhealthy[url]
+}
+
+// validationTarget is a validation URL to try and whether it was healthy when chosen
+type validationTarget struct {
+	url     string
+	healthy bool
+}
+
+// validationTargets returns the configured validation URLs, healthy ones first in configured order
+func validationTargets() []validationTarget {
+	urls := externalValidationURLs
+	if len(urls) == 0 {
+		urls = []string{currentValidationURL()}
+	}
+
+	targets := make([]validationTarget, 0, len(urls))
+	var unhealthy []validationTarget
+	for _, url := range urls {
+		if validationHealth.healthy(url) {
+			targets = append(targets, validationTarget{url: url, healthy: true})
+		} else {
+			unhealthy = append(unhealthy, validationTarget{url: url})
+		}
+	}
+	return append(targets, unhealthy...)
+}
+
+// checkValidationHealth pings every validation URL and records which ones respond
+func checkValidationHealth() {
+	client := getValidationHTTPClient()
+	for _, url := range externalValidationURLs {
+		if isGRPCValidationURL(url) {
+			validationHealth.set(url, checkGRPCValidationURL(url) == nil)
+			continue
+		}
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			validationHealth.set(url, false)
+			continue
+		}
+		req.Header.Set("X-API-Key", externalServerAPIKey)
+		req.Header.Set(version.Header, version.String())
+		req.Header.Set(requestIDHeader, newRequestID())
+		signExternalRequest(req, nil)
+
+		resp, err := client.Do(req)
+		if err != nil {
+			validationHealth.set(url, false)
+			continue
+		}
+		resp.Body.Close()
+		validationHealth.set(url, resp.StatusCode == http.StatusOK)
+	}
+}
+
+// startValidationHealthChecks checks the validation URLs every interval until stop is closed
+func startValidationHealthChecks(stop <-chan struct{}) {
+	if len(externalValidationURLs) < 2 || validationHealthCheckInterval <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(validationHealthCheckInterval)
+		defer ticker.Stop()
+		for {
+			checkValidationHealth()
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// callValidationService sends a validation request to a single validation URL
+func callValidationService(ctx context.Context, target validationTarget, jsonData []byte, details RequestDetails) (ValidationResponse, error) {
+	if isGRPCValidationURL(target.url) {
+		return callGRPCValidationService(ctx, target, details)
+	}
+
+	fields := map[string]interface{}{
+		"api_key":        details.APIKey,
+		"endpoint":       details.Endpoint,
+		"validation_url": target.url,
+	}
+
+	var validationResp ValidationResponse
+	if err := postValidation(ctx, target, jsonData, fields, &validationResp); err != nil {
+		return ValidationResponse{}, err
+	}
+	return validationResp, nil
+}
+
+// postValidation posts a JSON payload to an http:// or https:// validation URL and decodes its answer into out
+func postValidation(ctx context.Context, target validationTarget, jsonData []byte, fields map[string]interface{}, out interface{}) error {
+	// Create request with authentication
+	req, err := http.NewRequestWithContext(ctx, "POST", target.url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		logger.Error("Error creating validation request", err, fields)
+		return err
+	}
+
+	// Add security headers
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-Key", externalServerAPIKey)
+	req.Header.Set(version.Header, version.String())
+	req.Header.Set(requestIDHeader, requestIDFrom(ctx))
+	signExternalRequest(req, jsonData)
+
+	// Wait longer on URLs that already failed a health check
+	client := getValidationHTTPClient()
+	if !target.healthy {
+		client.Timeout = max(validationTimeout, unhealthyValidationTimeout)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Error("Error calling validation server", err, fields)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		fields["status_code"] = resp.StatusCode
+		logger.Warning("Validation server returned non-OK status", fields)
+		return &validationStatusError{status: resp.StatusCode}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		logger.Error("Error decoding validation response", err, fields)
+		return &validationDecodeError{err: err}
+	}
+	return nil
+}
+
+// parseURLList splits a comma-separated list of URLs, keeping their order
+func parseURLList(raw string) []string {
+	var urls []string
+	for _, url := range strings.Split(raw, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingValidationServer answers health checks with healthStatus and counts validation requests
+func countingValidationServer(t *testing.T, healthStatus int) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.WriteHeader(healthStatus)
+			return
+		}
+		calls.Add(1)
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true})
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+// TestValidationFailover tests that validation prefers healthy URLs and falls back to unhealthy ones
+func TestValidationFailover(t *testing.T) {
+	unhealthy, unhealthyCalls := countingValidationServer(t, http.StatusServiceUnavailable)
+	healthy, healthyCalls := countingValidationServer(t, http.StatusOK)
+	externalValidationURLs = []string{unhealthy.URL, healthy.URL}
+	validationHealth = newValidationHealthTracker()
+	defer func() {
+		externalValidationURLs = nil
+		validationHealth = newValidationHealthTracker()
+	}()
+
+	// Before any health check, URLs are tried in configured order
+	if _, ok, _ := validateRequest(context.Background(), RequestDetails{APIKey: "test-key"}); !ok || unhealthyCalls.Load() != 1 {
+		t.Fatalf("Expected first configured URL to be used, got ok=%v calls=%d", ok, unhealthyCalls.Load())
+	}
+
+	checkValidationHealth()
+	targets := validationTargets()
+	if targets[0].url != healthy.URL || !targets[0].healthy || targets[1].url != unhealthy.URL || targets[1].healthy {
+		t.Fatalf("Expected healthy URL first, got %+v", targets)
+	}
+	if _, ok, _ := validateRequest(context.Background(), RequestDetails{APIKey: "test-key"}); !ok || healthyCalls.Load() != 1 || unhealthyCalls.Load() != 1 {
+		t.Errorf("Expected only the healthy URL to be called, got ok=%v healthy=%d unhealthy=%d", ok, healthyCalls.Load(), unhealthyCalls.Load())
+	}
+
+	// When the healthy URL fails, the unhealthy one is retried last
+	healthy.Close()
+	if _, ok, _ := validateRequest(context.Background(), RequestDetails{APIKey: "test-key"}); !ok || unhealthyCalls.Load() != 2 {
+		t.Errorf("Expected fallback to the unhealthy URL, got ok=%v calls=%d", ok, unhealthyCalls.Load())
+	}
+}
+
+// TestValidationHealthChecks tests that the background checker tracks URLs recovering
+func TestValidationHealthChecks(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusServiceUnavailable)
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer flaky.Close()
+	stable, _ := countingValidationServer(t, http.StatusOK)
+
+	externalValidationURLs = []string{flaky.URL, stable.URL}
+	validationHealth = newValidationHealthTracker()
+	validationHealthCheckInterval = 10 * time.Millisecond
+	stop := make(chan struct{})
+	defer func() {
+		close(stop)
+		externalValidationURLs = nil
+		validationHealth = newValidationHealthTracker()
+	}()
+	startValidationHealthChecks(stop)
+
+	waitForHealth := func(want bool) {
+		deadline := time.Now().Add(2 * time.Second)
+		for validationHealth.healthy(flaky.URL) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for healthy=%v", want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitForHealth(false)
+	status.Store(http.StatusOK)
+	waitForHealth(true)
+}
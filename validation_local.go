@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"ollama-proxy/logger"
+)
+
+// Local validation configuration, for deployments without a validation server
+var (
+	validationMode        = "external" // validators in order: external, local (keys from API_KEYS_FILE), or both comma-separated
+	apiKeysFile           string
+	apiKeysReloadInterval time.Duration // how often API_KEYS_FILE is checked for changes; 0 reloads only on SIGHUP
+	localKeys             = &localKeyStore{}
+)
+
+// localKey is a key's entry in a JSON API_KEYS_FILE: the answer the validation service would give,
+// plus an optional in-process rate limit in requests per second
+type localKey struct {
+	ValidationResponse
+	RateLimit      float64 `json:"rateLimit,omitempty"`
+	RateLimitBurst int     `json:"rateLimitBurst,omitempty"`
+
+	limiter *localLimiter
+}
+
+// localKeyStore holds the keys loaded from API_KEYS_FILE
+type localKeyStore struct {
+	mu      sync.RWMutex
+	keys    map[string]*localKey
+	modTime time.Time
+	size    int64
+}
+
+// parseAPIKeysFile reads either a JSON object mapping each key to its localKey entry, or one key per
+// line with # comments, where every key is valid for everything
+func parseAPIKeysFile(data []byte) (map[string]*localKey, error) {
+	keys := make(map[string]*localKey)
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, &keys); err != nil {
+			return nil, fmt.Errorf("invalid API keys JSON: %v", err)
+		}
+		for key, entry := range keys {
+			if entry == nil {
+				entry = &localKey{}
+				keys[key] = entry
+			}
+			entry.Valid = true
+		}
+		return keys, nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys[line] = &localKey{ValidationResponse: ValidationResponse{Valid: true}}
+	}
+	return keys, scanner.Err()
+}
+
+// reload re-reads the keys file when it changed since the last load, or always when force is set.
+// Keys whose rate limit is unchanged keep their limiter, so rotating other keys doesn't reset them.
+func (s *localKeyStore) reload(path string, force bool) (bool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	s.mu.RLock()
+	unchanged := info.ModTime().Equal(s.modTime) && info.Size() == s.size
+	s.mu.RUnlock()
+	if unchanged && !force {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	keys, err := parseAPIKeysFile(data)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, entry := range keys {
+		if entry.RateLimit <= 0 {
+			continue
+		}
+		if old, ok := s.keys[key]; ok && old.limiter != nil && old.RateLimit == entry.RateLimit && old.RateLimitBurst == entry.RateLimitBurst {
+			entry.limiter = old.limiter
+		} else {
+			entry.limiter = newLocalLimiter(entry.RateLimit, entry.RateLimitBurst)
+		}
+	}
+	s.keys = keys
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	logger.Info("Loaded API keys file", map[string]interface{}{
+		"path": path,
+		"keys": len(keys),
+	})
+	return true, nil
+}
+
+// validate answers a validation request from the loaded keys
+func (s *localKeyStore) validate(details RequestDetails) (ValidationResponse, bool) {
+	s.mu.RLock()
+	entry, ok := s.keys[details.APIKey]
+	s.mu.RUnlock()
+	if !ok {
+		return ValidationResponse{Reason: "invalid_key"}, false
+	}
+
+	validation := entry.ValidationResponse
+	if entry.limiter != nil && !entry.limiter.Allow(context.Background(), details.APIKey) {
+		validation.RateLimited = true
+		validation.RetryAfterSeconds = int(math.Ceil(1 / entry.RateLimit))
+		return validation, false
+	}
+	return validation, true
+}
+
+// Validate implements Validator with the loaded keys
+func (s *localKeyStore) Validate(ctx context.Context, details RequestDetails) (ValidationResult, error) {
+	response, ok := s.validate(details)
+	return ValidationResult{ValidationResponse: response, Allowed: ok}, nil
+}
+
+// startLocalKeyReloads re-reads API_KEYS_FILE when it changes and on SIGHUP; a file that fails to load
+// is logged and the previous keys stay in use
+func startLocalKeyReloads(stop <-chan struct{}) {
+	if !validationModeEnabled("local") {
+		return
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		var tick <-chan time.Time
+		if apiKeysReloadInterval > 0 {
+			ticker := time.NewTicker(apiKeysReloadInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		for {
+			force := false
+			select {
+			case <-tick:
+			case <-hup:
+				force = true
+			case <-stop:
+				return
+			}
+			if _, err := localKeys.reload(apiKeysFile, force); err != nil {
+				logger.Error("Failed to reload API keys file, keeping previous keys", err, map[string]interface{}{
+					"path": apiKeysFile,
+				})
+			}
+		}
+	}()
+}
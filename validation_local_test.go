@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// useLocalValidation switches to local validation from a keys file with contents for the rest of the test
+func useLocalValidation(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	oldMode, oldFile, oldKeys := validationMode, apiKeysFile, localKeys
+	t.Cleanup(func() { validationMode, apiKeysFile, localKeys = oldMode, oldFile, oldKeys })
+	useValidator(t, requestValidator)
+	validationMode, apiKeysFile, localKeys = "local", path, &localKeyStore{}
+	if err := validateValidationModeConfig(); err != nil {
+		t.Fatalf("Expected the keys file to load: %v", err)
+	}
+	return path
+}
+
+// TestParseAPIKeysFile tests both keys file formats
+func TestParseAPIKeysFile(t *testing.T) {
+	t.Run("One Key Per Line", func(t *testing.T) {
+		keys, err := parseAPIKeysFile([]byte("# team keys\nkey-one\n\n  key-two  \n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 2 || keys["key-one"] == nil || keys["key-two"] == nil || !keys["key-two"].Valid {
+			t.Errorf("Expected two valid keys, got %+v", keys)
+		}
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		keys, err := parseAPIKeysFile([]byte(`{
+			"key-one": {"allowedModels": ["llama3:*"], "tier": "pro", "rateLimit": 2, "rateLimitBurst": 5},
+			"key-two": {}
+		}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		one := keys["key-one"]
+		if one == nil || !one.Valid || one.Tier != "pro" || len(one.AllowedModels) != 1 || one.RateLimit != 2 || one.RateLimitBurst != 5 {
+			t.Errorf("Unexpected entry %+v", one)
+		}
+		if keys["key-two"] == nil || !keys["key-two"].Valid {
+			t.Errorf("Expected key-two to be valid, got %+v", keys["key-two"])
+		}
+	})
+
+	t.Run("Invalid JSON", func(t *testing.T) {
+		if _, err := parseAPIKeysFile([]byte(`{"key-one": [}`)); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
+
+// TestValidateValidationModeConfig tests the configuration errors of VALIDATION_MODE
+func TestValidateValidationModeConfig(t *testing.T) {
+	oldMode, oldFile := validationMode, apiKeysFile
+	defer func() { validationMode, apiKeysFile = oldMode, oldFile }()
+	useValidator(t, requestValidator)
+
+	testCases := []struct {
+		name    string
+		mode    string
+		file    string
+		wantErr string
+	}{
+		{"External", "external", "", ""},
+		{"Local Without File", "local", "", "requires API_KEYS_FILE"},
+		{"Local Missing File", "local", filepath.Join(t.TempDir(), "missing"), "no such file"},
+		{"Unknown Mode", "remote", "", "must be external, local"},
+		{"Empty Mode", " , ", "", "at least one validator"},
+		{"Chain Without File", "local,external", "", "requires API_KEYS_FILE"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			validationMode, apiKeysFile = tc.mode, tc.file
+			err := validateValidationModeConfig()
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestLocalKeyStoreValidate tests answers for unknown, valid and rate-limited keys
+func TestLocalKeyStoreValidate(t *testing.T) {
+	useLocalValidation(t, `{"limited": {"rateLimit": 0.5, "rateLimitBurst": 1}, "open": {"tier": "pro"}}`)
+
+	if resp, ok, _ := validateRequest(context.Background(), RequestDetails{APIKey: "unknown"}); ok || resp.Valid || resp.Reason != "invalid_key" {
+		t.Errorf("Expected an invalid key, got %+v (%v)", resp, ok)
+	}
+	if resp, ok, _ := validateRequest(context.Background(), RequestDetails{APIKey: "open"}); !ok || resp.Tier != "pro" {
+		t.Errorf("Expected a valid pro key, got %+v (%v)", resp, ok)
+	}
+	if _, ok, _ := validateRequest(context.Background(), RequestDetails{APIKey: "limited"}); !ok {
+		t.Error("Expected the first request within the burst")
+	}
+	resp, ok, _ := validateRequest(context.Background(), RequestDetails{APIKey: "limited"})
+	if ok || !resp.RateLimited || resp.RetryAfterSeconds != 2 {
+		t.Errorf("Expected a rate-limited answer retrying after 2s, got %+v (%v)", resp, ok)
+	}
+}
+
+// TestLocalKeyStoreReload tests picking up changed files, keeping keys after a bad edit, and keeping limiters
+func TestLocalKeyStoreReload(t *testing.T) {
+	path := useLocalValidation(t, `{"key-one": {"rateLimit": 1}}`)
+	limiter := localKeys.keys["key-one"].limiter
+
+	if changed, err := localKeys.reload(path, false); changed || err != nil {
+		t.Errorf("Expected no reload of an unchanged file, got %v, %v", changed, err)
+	}
+
+	os.WriteFile(path, []byte(`{"key-one": {"rateLimit": 1}, "key-two": {}}`), 0600)
+	if changed, err := localKeys.reload(path, false); !changed || err != nil {
+		t.Fatalf("Expected the changed file to reload, got %v, %v", changed, err)
+	}
+	if _, ok := localKeys.validate(RequestDetails{APIKey: "key-two"}); !ok {
+		t.Error("Expected the added key to be valid")
+	}
+	if localKeys.keys["key-one"].limiter != limiter {
+		t.Error("Expected an unchanged rate limit to keep its limiter")
+	}
+
+	os.WriteFile(path, []byte(`{"key-one": `), 0600)
+	if _, err := localKeys.reload(path, false); err == nil {
+		t.Error("Expected a broken file to fail to load")
+	}
+	if _, ok := localKeys.validate(RequestDetails{APIKey: "key-two"}); !ok {
+		t.Error("Expected the previous keys to stay in use")
+	}
+}
+
+// TestLocalKeyReloadOnSIGHUP tests that SIGHUP reloads the keys file
+func TestLocalKeyReloadOnSIGHUP(t *testing.T) {
+	path := useLocalValidation(t, "key-one\n")
+	oldInterval := apiKeysReloadInterval
+	apiKeysReloadInterval = 0
+	defer func() { apiKeysReloadInterval = oldInterval }()
+	stop := make(chan struct{})
+	defer close(stop)
+	startLocalKeyReloads(stop)
+
+	os.WriteFile(path, []byte("key-two\n"), 0600)
+	syscall.Kill(os.Getpid(), syscall.SIGHUP)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := localKeys.validate(RequestDetails{APIKey: "key-two"}); ok {
+			if _, ok := localKeys.validate(RequestDetails{APIKey: "key-one"}); ok {
+				t.Error("Expected the removed key to be rejected")
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Expected SIGHUP to load the rotated key")
+}
+
+// TestProxyHandlerLocalValidation tests that local validation gives the same answers without a validation service
+func TestProxyHandlerLocalValidation(t *testing.T) {
+	useLocalValidation(t, `{"team-key": {"allowedModels": ["llama2"]}, "limited-key": {"rateLimit": 0.1}}`)
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the validation service not to be called")
+	}))
+	defer validationServer.Close()
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+
+	testCases := []struct {
+		name           string
+		apiKey         string
+		model          string
+		expectedStatus int
+	}{
+		{"Valid Key", "team-key", "llama2", http.StatusOK},
+		{"Model Not Allowed", "team-key", "mistral", http.StatusForbidden},
+		{"Unknown Key", "other-key", "llama2", http.StatusUnauthorized},
+		{"Within Rate Limit", "limited-key", "llama2", http.StatusOK},
+		{"Rate Limited", "limited-key", "llama2", http.StatusTooManyRequests},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", ChatRequest{Model: tc.model, Messages: []ChatMessage{{Role: "user", Content: "Hi"}}}, tc.apiKey))
+			assertResponseStatus(t, rr, tc.expectedStatus)
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"ollama-proxy/logger"
+)
+
+// Mock validation configuration, for local development without a validation server
+var (
+	validationMock            bool
+	validationMockValid       bool
+	validationMockRateLimited bool
+)
+
+// validateValidationMockConfig refuses mock validation in production, where it would let any key through
+func validateValidationMockConfig() error {
+	if validationMock && os.Getenv("GO_ENV") == "production" {
+		return fmt.Errorf("VALIDATION_MOCK cannot be enabled when GO_ENV=production")
+	}
+	return nil
+}
+
+// mockValidateRequest answers a validation request from configuration instead of the validation service
+func mockValidateRequest(details RequestDetails) (ValidationResponse, bool) {
+	logger.Warning("Mock validation active, validation service not called", map[string]interface{}{
+		"api_key":  details.APIKey,
+		"endpoint": details.Endpoint,
+	})
+	validation := ValidationResponse{
+		Valid:       validationMockValid,
+		RateLimited: validationMockRateLimited,
+	}
+	return validation, validation.Valid && !validation.RateLimited
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"ollama-proxy/logger"
+)
+
+// TestMockValidation tests that mock validation answers from configuration without calling the service
+func TestMockValidation(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the validation service not to be called")
+	}))
+	defer validationServer.Close()
+	metricsServer := mockMetricsServer(t)
+	defer metricsServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, metricsServer.URL)
+
+	validationMock = true
+	defer func() { validationMock, validationMockValid, validationMockRateLimited = false, false, false }()
+	useValidator(t, requestValidator)
+	if err := validateValidationModeConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name           string
+		valid          bool
+		rateLimited    bool
+		expectedStatus int
+	}{
+		{"Valid", true, false, http.StatusOK},
+		{"Invalid", false, false, http.StatusUnauthorized},
+		{"Rate Limited", true, true, http.StatusTooManyRequests},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			validationMockValid = tc.valid
+			validationMockRateLimited = tc.rateLimited
+
+			var logs bytes.Buffer
+			logger.SetOutput(&logs)
+			defer logger.SetOutput(os.Stdout)
+
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/api/generate", GenerateRequest{Model: "mistral", Prompt: "Hi"}, "any-key"))
+			assertResponseStatus(t, rr, tc.expectedStatus)
+			if !strings.Contains(logs.String(), `"level":"WARNING","message":"Mock validation active`) {
+				t.Errorf("Expected a mock validation warning, got %s", logs.String())
+			}
+		})
+	}
+}
+
+// TestValidateValidationMockConfig tests that mock validation is refused in production
+func TestValidateValidationMockConfig(t *testing.T) {
+	defer func() { validationMock = false }()
+	t.Setenv("GO_ENV", "production")
+
+	validationMock = true
+	if err := validateValidationMockConfig(); err == nil {
+		t.Error("Expected mock validation to be refused in production")
+	}
+	validationMock = false
+	if err := validateValidationMockConfig(); err != nil {
+		t.Errorf("Expected production without mock validation to be accepted, got %v", err)
+	}
+
+	t.Setenv("GO_ENV", "development")
+	validationMock = true
+	if err := validateValidationMockConfig(); err != nil {
+		t.Errorf("Expected mock validation outside production, got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Validation cache configuration
+var (
+	validationCacheTTL            time.Duration // how long an accepted key is trusted without asking again; 0 disables
+	validationCacheRateLimitedTTL time.Duration // how long a rate-limited answer is reused, kept short so keys recover promptly
+	validationCacheSize           = defaultValidationCacheSize
+	validationResults             = newValidationCache()
+)
+
+// defaultValidationCacheSize bounds the cache so spraying keys evicts old entries instead of growing memory
+const defaultValidationCacheSize = 10000
+
+// validationCacheKey identifies the requests a validation answer applies to. The body, client address and
+// user agent aren't part of it, so a cached answer is reused for requests that differ only in those.
+type validationCacheKey struct {
+	apiKey   string
+	model    string
+	endpoint string
+}
+
+// validationCacheEntry is a cached validation answer
+type validationCacheEntry struct {
+	key       validationCacheKey
+	response  ValidationResponse
+	expiresAt time.Time
+}
+
+// validationCache remembers validation answers per key, model and endpoint, evicting the least recently used
+type validationCache struct {
+	mu      sync.Mutex
+	order   *list.List // front is most recently used
+	entries map[validationCacheKey]*list.Element
+	now     func() time.Time
+}
+
+func newValidationCache() *validationCache {
+	return &validationCache{order: list.New(), entries: make(map[validationCacheKey]*list.Element), now: time.Now}
+}
+
+// get returns the cached answer for a request, if there is one that hasn't expired
+func (c *validationCache) get(details RequestDetails) (ValidationResponse, bool) {
+	key := validationCacheKey{details.APIKey, details.Model, details.Endpoint}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return ValidationResponse{}, false
+	}
+	entry := elem.Value.(*validationCacheEntry)
+	if !c.now().Before(entry.expiresAt) {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return ValidationResponse{}, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.response, true
+}
+
+// put caches an answer for ttl, evicting the least recently used entries beyond validationCacheSize
+func (c *validationCache) put(details RequestDetails, response ValidationResponse, ttl time.Duration) {
+	key := validationCacheKey{details.APIKey, details.Model, details.Endpoint}
+	entry := &validationCacheEntry{key: key, response: response, expiresAt: c.now().Add(ttl)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.order.PushFront(entry)
+	}
+	for c.order.Len() > validationCacheSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*validationCacheEntry).key)
+	}
+}
+
+// remove drops the entry for a request if match accepts its answer, reporting whether it did
+func (c *validationCache) remove(details RequestDetails, match func(ValidationResponse) bool) bool {
+	key := validationCacheKey{details.APIKey, details.Model, details.Endpoint}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok || !match(elem.Value.(*validationCacheEntry).response) {
+		return false
+	}
+	c.order.Remove(elem)
+	delete(c.entries, key)
+	return true
+}
+
+// validateRequestCached validates a request, answering from a fresh session token or, when VALIDATION_CACHE_TTL
+// is set, the cache, and sharing calls among concurrent identical requests when VALIDATION_DEDUPLICATE is set.
+// Only accepted and rate-limited answers are cached, so a rejected key is asked about again on its next
+// request, and failed validation calls are never cached. A shared call outlives the request that started
+// it, so the others waiting on it aren't refused if that client leaves.
+func validateRequestCached(ctx context.Context, details RequestDetails) (response ValidationResponse, ok bool, cached bool, err error) {
+	if response, fresh := validationSession(details.APIKey); fresh {
+		return response, true, true, nil
+	}
+	if validationCacheTTL > 0 {
+		if response, hit := validationResults.get(details); hit {
+			return response, response.Valid && !response.RateLimited, true, nil
+		}
+	}
+	if validationDeduplicate {
+		response, ok, _, err = validationCalls.do(details, func(details RequestDetails) (ValidationResponse, bool, error) {
+			return cacheValidation(context.WithoutCancel(ctx), details)
+		})
+	} else {
+		response, ok, err = cacheValidation(ctx, details)
+	}
+	return response, ok, false, err
+}
+
+// cacheValidation calls the validation service and caches the answer when caching is enabled
+func cacheValidation(ctx context.Context, details RequestDetails) (ValidationResponse, bool, error) {
+	response, ok, err := validateRequest(ctx, details)
+	if err != nil {
+		return response, false, err
+	}
+	if ok {
+		rememberValidationSession(details.APIKey, response)
+	}
+	if validationCacheTTL <= 0 {
+		return response, ok, nil
+	}
+	switch {
+	case response.RateLimited:
+		if validationCacheRateLimitedTTL > 0 {
+			validationResults.put(details, response, min(validationCacheRateLimitedTTL, validationCacheTTL))
+		}
+	case response.Valid:
+		validationResults.put(details, response, validationCacheTTL)
+	}
+	return response, ok, nil
+}
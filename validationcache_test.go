@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ollama-proxy/logger"
+)
+
+// useValidationCache enables a fresh validation cache for the rest of the test
+func useValidationCache(t *testing.T, ttl, rateLimitedTTL time.Duration, size int) *validationCache {
+	t.Helper()
+	oldTTL, oldRateLimitedTTL, oldSize, oldResults := validationCacheTTL, validationCacheRateLimitedTTL, validationCacheSize, validationResults
+	t.Cleanup(func() {
+		validationCacheTTL, validationCacheRateLimitedTTL, validationCacheSize, validationResults = oldTTL, oldRateLimitedTTL, oldSize, oldResults
+	})
+	validationCacheTTL, validationCacheRateLimitedTTL, validationCacheSize = ttl, rateLimitedTTL, size
+	validationResults = newValidationCache()
+	return validationResults
+}
+
+// answeringValidationServer answers every validation with response and counts the calls
+func answeringValidationServer(t *testing.T, response ValidationResponse) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			return
+		}
+		calls.Add(1)
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+// TestValidationCacheEviction tests that the least recently used answers are evicted beyond the size limit
+func TestValidationCacheEviction(t *testing.T) {
+	cache := useValidationCache(t, time.Minute, time.Second, 2)
+	a := RequestDetails{APIKey: "key-a", Model: "llama2", Endpoint: "/api/chat"}
+	b := RequestDetails{APIKey: "key-b", Model: "llama2", Endpoint: "/api/chat"}
+	c := RequestDetails{APIKey: "key-c", Model: "llama2", Endpoint: "/api/chat"}
+
+	cache.put(a, ValidationResponse{Valid: true}, time.Minute)
+	cache.put(b, ValidationResponse{Valid: true}, time.Minute)
+	cache.get(a)
+	cache.put(c, ValidationResponse{Valid: true}, time.Minute)
+
+	if _, ok := cache.get(b); ok {
+		t.Error("Expected the least recently used entry to be evicted")
+	}
+	for _, details := range []RequestDetails{a, c} {
+		if _, ok := cache.get(details); !ok {
+			t.Errorf("Expected %s to stay cached", details.APIKey)
+		}
+	}
+	if cache.order.Len() != 2 || len(cache.entries) != 2 {
+		t.Errorf("Expected 2 entries, got %d in order and %d in the index", cache.order.Len(), len(cache.entries))
+	}
+}
+
+// TestValidationCacheKey tests that answers only apply to the same key, model and endpoint
+func TestValidationCacheKey(t *testing.T) {
+	cache := useValidationCache(t, time.Minute, time.Second, 10)
+	details := RequestDetails{APIKey: "test-key", Model: "llama2", Endpoint: "/api/chat"}
+	cache.put(details, ValidationResponse{Valid: true, Tier: "pro"}, time.Minute)
+
+	if resp, ok := cache.get(details); !ok || resp.Tier != "pro" {
+		t.Errorf("Expected the cached response, got %+v (%v)", resp, ok)
+	}
+	for _, other := range []RequestDetails{
+		{APIKey: "other-key", Model: "llama2", Endpoint: "/api/chat"},
+		{APIKey: "test-key", Model: "mistral", Endpoint: "/api/chat"},
+		{APIKey: "test-key", Model: "llama2", Endpoint: "/api/generate"},
+	} {
+		if _, ok := cache.get(other); ok {
+			t.Errorf("Expected no answer for %+v", other)
+		}
+	}
+}
+
+// TestValidationCacheExpiry tests that answers are dropped once their TTL passes
+func TestValidationCacheExpiry(t *testing.T) {
+	cache := useValidationCache(t, time.Minute, time.Second, 10)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	details := RequestDetails{APIKey: "test-key", Model: "llama2", Endpoint: "/api/chat"}
+	cache.put(details, ValidationResponse{Valid: true}, time.Minute)
+
+	now = now.Add(59 * time.Second)
+	if _, ok := cache.get(details); !ok {
+		t.Error("Expected the answer before its TTL")
+	}
+	now = now.Add(time.Second)
+	if _, ok := cache.get(details); ok {
+		t.Error("Expected no answer once the TTL passed")
+	}
+	if len(cache.entries) != 0 {
+		t.Errorf("Expected the expired entry to be removed, got %d entries", len(cache.entries))
+	}
+}
+
+// TestValidateRequestCached tests which validation answers are reused
+func TestValidateRequestCached(t *testing.T) {
+	details := RequestDetails{APIKey: "test-key", Model: "llama2", Endpoint: "/api/chat"}
+
+	testCases := []struct {
+		name           string
+		response       ValidationResponse
+		ttl            time.Duration
+		rateLimitedTTL time.Duration
+		expectedCalls  int32
+		expectedOK     bool
+	}{
+		{"Disabled", ValidationResponse{Valid: true}, 0, time.Second, 2, true},
+		{"Valid", ValidationResponse{Valid: true}, time.Minute, time.Second, 1, true},
+		{"Invalid Never Cached", ValidationResponse{Valid: false}, time.Minute, time.Second, 2, false},
+		{"Rate Limited", ValidationResponse{Valid: true, RateLimited: true}, time.Minute, time.Second, 1, false},
+		{"Rate Limited Not Cached", ValidationResponse{Valid: true, RateLimited: true}, time.Minute, 0, 2, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			useValidationCache(t, tc.ttl, tc.rateLimitedTTL, 10)
+			server, calls := answeringValidationServer(t, tc.response)
+			useProxyTargets(t, ollamaURL, server.URL, "")
+
+			_, _, cached, _ := validateRequestCached(context.Background(), details)
+			if cached {
+				t.Error("Expected the first answer to come from the validation service")
+			}
+			resp, ok, cached, _ := validateRequestCached(context.Background(), details)
+			if ok != tc.expectedOK || resp.Valid != tc.response.Valid || resp.RateLimited != tc.response.RateLimited {
+				t.Errorf("Expected %+v (ok %v), got %+v (ok %v)", tc.response, tc.expectedOK, resp, ok)
+			}
+			if got := calls.Load(); got != tc.expectedCalls {
+				t.Errorf("Expected %d validation calls, got %d", tc.expectedCalls, got)
+			}
+			if cached != (tc.expectedCalls == 1) {
+				t.Errorf("Expected cached %v, got %v", tc.expectedCalls == 1, cached)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerValidationCache tests that cache hits skip the validation service and are logged
+func TestProxyHandlerValidationCache(t *testing.T) {
+	useValidationCache(t, time.Minute, time.Second, 10)
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer, calls := answeringValidationServer(t, ValidationResponse{Valid: true})
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+
+	chat := ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "hi"}}}
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", chat, "test-api-key"))
+		assertResponseStatus(t, rr, http.StatusOK)
+	}
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Expected 1 validation call, got %d", got)
+	}
+	if !strings.Contains(logs.String(), `"validation_cached":true`) {
+		t.Errorf("Expected the cache hit in the request log, got %s", logs.String())
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import "sync"
+
+// Validation deduplication configuration
+var (
+	validationDeduplicate bool // share one validation call among concurrent identical requests
+	validationCalls       = newValidationFlight()
+)
+
+// validationCall is a validation call in progress, whose answer is shared with every request waiting on it
+type validationCall struct {
+	done     chan struct{}
+	response ValidationResponse
+	ok       bool
+	err      error
+}
+
+// validationFlight tracks validation calls in progress per key, model and endpoint. Calls are forgotten
+// as soon as they finish, so a failed call only affects the requests that were already waiting on it.
+type validationFlight struct {
+	mu    sync.Mutex
+	calls map[validationCacheKey]*validationCall
+}
+
+func newValidationFlight() *validationFlight {
+	return &validationFlight{calls: make(map[validationCacheKey]*validationCall)}
+}
+
+// do runs validate for the request unless an identical one is already in flight, in which case it waits
+// for that call's answer, or error, instead. shared reports whether the answer came from another request's call.
+func (f *validationFlight) do(details RequestDetails, validate func(RequestDetails) (ValidationResponse, bool, error)) (response ValidationResponse, ok bool, shared bool, err error) {
+	key := validationCacheKey{details.APIKey, details.Model, details.Endpoint}
+
+	f.mu.Lock()
+	if call, inFlight := f.calls[key]; inFlight {
+		f.mu.Unlock()
+		<-call.done
+		return call.response, call.ok, true, call.err
+	}
+	call := &validationCall{done: make(chan struct{})}
+	f.calls[key] = call
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		delete(f.calls, key)
+		f.mu.Unlock()
+		close(call.done)
+	}()
+	call.response, call.ok, call.err = validate(details)
+	return call.response, call.ok, false, call.err
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestValidationFlight tests that concurrent identical requests share one validation call
+func TestValidationFlight(t *testing.T) {
+	flight := newValidationFlight()
+	details := RequestDetails{APIKey: "test-key", Model: "llama2", Endpoint: "/api/chat"}
+	release := make(chan struct{})
+	var calls atomic.Int32
+	validate := func(RequestDetails) (ValidationResponse, bool, error) {
+		calls.Add(1)
+		<-release
+		return ValidationResponse{Valid: true, Tier: "pro"}, true, nil
+	}
+
+	const n = 10
+	var wg sync.WaitGroup
+	var sharedCount atomic.Int32
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, ok, shared, _ := flight.do(details, validate)
+			if !ok || resp.Tier != "pro" {
+				t.Errorf("Expected the shared answer, got %+v (%v)", resp, ok)
+			}
+			if shared {
+				sharedCount.Add(1)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Errorf("Expected 1 validation call, got %d", got)
+	}
+	if got := sharedCount.Load(); got != n-1 {
+		t.Errorf("Expected %d requests to share the call, got %d", n-1, got)
+	}
+	if len(flight.calls) != 0 {
+		t.Errorf("Expected finished calls to be forgotten, got %d", len(flight.calls))
+	}
+}
+
+// TestValidationFlightFailure tests that a failed call isn't reused by later requests
+func TestValidationFlightFailure(t *testing.T) {
+	flight := newValidationFlight()
+	details := RequestDetails{APIKey: "test-key", Model: "llama2", Endpoint: "/api/chat"}
+
+	if _, ok, _, err := flight.do(details, func(RequestDetails) (ValidationResponse, bool, error) {
+		return ValidationResponse{}, false, errNoValidationTarget
+	}); ok || err != errNoValidationTarget {
+		t.Fatalf("Expected the failed call's error, got %v (ok %v)", err, ok)
+	}
+	resp, ok, shared, err := flight.do(details, func(RequestDetails) (ValidationResponse, bool, error) {
+		return ValidationResponse{Valid: true}, true, nil
+	})
+	if !ok || !resp.Valid || shared || err != nil {
+		t.Errorf("Expected a fresh successful call, got %+v (ok %v, shared %v)", resp, ok, shared)
+	}
+}
+
+// TestProxyHandlerValidationDeduplicate tests that parallel requests with the same key make one validation call
+func TestProxyHandlerValidationDeduplicate(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	var calls atomic.Int32
+	validationServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			return
+		}
+		calls.Add(1)
+		// Hold the call open so every parallel request arrives while it is in flight
+		time.Sleep(200 * time.Millisecond)
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: true})
+	}))
+	defer validationServer.Close()
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+
+	testCases := []struct {
+		name     string
+		cacheTTL time.Duration
+	}{
+		{"Without Cache", 0},
+		{"With Cache", time.Minute},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			useValidationCache(t, tc.cacheTTL, time.Second, 10)
+			validationDeduplicate = true
+			defer func() { validationDeduplicate = false }()
+			calls.Store(0)
+
+			const n = 20
+			chat := ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "hi"}}}
+			var wg sync.WaitGroup
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					rr := httptest.NewRecorder()
+					proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", chat, "test-api-key"))
+					if rr.Code != http.StatusOK {
+						t.Errorf("Expected status 200, got %d", rr.Code)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if got := calls.Load(); got != 1 {
+				t.Errorf("Expected 1 validation call for %d parallel requests, got %d", n, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"ollama-proxy/logger"
+)
+
+// Validation retry configuration
+var (
+	validationRetryAttempts = defaultValidationRetryAttempts // attempts per validation URL, including the first
+	validationRetryBackoff  = defaultValidationRetryBackoff  // wait before the first retry, doubled for each one after
+	validationRetryBudget   = defaultValidationRetryBudget   // no retry starts once this long has passed since validation began
+)
+
+const (
+	defaultValidationRetryAttempts = 2
+	defaultValidationRetryBackoff  = 100 * time.Millisecond
+	defaultValidationRetryBudget   = 2 * time.Second
+)
+
+// validationStatusError is a validation call answered with a status other than 200
+type validationStatusError struct {
+	status int
+}
+
+func (e *validationStatusError) Error() string {
+	return fmt.Sprintf("validation server returned non-OK status: %d", e.status)
+}
+
+// validationDecodeError is a validation call answered with a body that isn't a ValidationResponse
+type validationDecodeError struct {
+	err error
+}
+
+func (e *validationDecodeError) Error() string {
+	return "invalid validation response: " + e.err.Error()
+}
+
+func (e *validationDecodeError) Unwrap() error {
+	return e.err
+}
+
+// retryableValidationError reports whether a failed validation call may succeed if repeated: connection
+// errors and timeouts, gateway statuses, and gRPC UNAVAILABLE and DEADLINE_EXCEEDED. Other statuses and
+// undecodable answers are the service's verdict.
+func retryableValidationError(err error) bool {
+	var grpcErr *grpcStatusError
+	if errors.As(err, &grpcErr) {
+		return grpcErr.code == grpcStatusUnavailable || grpcErr.code == grpcStatusDeadlineExceeded
+	}
+	var statusErr *validationStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.status {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	var decodeErr *validationDecodeError
+	return !errors.As(err, &decodeErr)
+}
+
+// validationRetryDelay returns the backoff before a retry, doubling per retry with jitter so clients
+// retrying together don't hit the validation service in lockstep
+func validationRetryDelay(retry int) time.Duration {
+	delay := validationRetryBackoff << (retry - 1)
+	if delay <= 0 || delay > validationRetryBudget {
+		delay = validationRetryBudget
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// callValidationServiceWithRetry calls a validation URL, retrying transient failures until
+// VALIDATION_RETRY_ATTEMPTS is used up or the next retry would start after deadline
+func callValidationServiceWithRetry(ctx context.Context, target validationTarget, jsonData []byte, details RequestDetails, deadline time.Time) (ValidationResponse, error) {
+	fields := map[string]interface{}{
+		"api_key":  details.APIKey,
+		"endpoint": details.Endpoint,
+	}
+	var validationResp ValidationResponse
+	err := retryValidationCall(ctx, target, deadline, fields, func() error {
+		var err error
+		validationResp, err = callValidationService(ctx, target, jsonData, details)
+		return err
+	})
+	if err != nil {
+		return ValidationResponse{}, err
+	}
+	return validationResp, nil
+}
+
+// retryValidationCall runs call against target, retrying transient failures until
+// VALIDATION_RETRY_ATTEMPTS is used up or the next retry would start after deadline. fields
+// identify the call in retry logs.
+func retryValidationCall(ctx context.Context, target validationTarget, deadline time.Time, fields map[string]interface{}, call func() error) error {
+	logFields := func(retries int) map[string]interface{} {
+		f := map[string]interface{}{
+			"validation_url": target.url,
+			"retries":        retries,
+		}
+		for k, v := range fields {
+			f[k] = v
+		}
+		return f
+	}
+
+	for retry := 0; ; retry++ {
+		err := call()
+		if err == nil {
+			if retry > 0 {
+				logger.Info("Validation call succeeded after retry", logFields(retry))
+			}
+			return nil
+		}
+		if retry+1 >= validationRetryAttempts || !retryableValidationError(err) {
+			return err
+		}
+
+		delay := validationRetryDelay(retry + 1)
+		if time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		retryFields := logFields(retry + 1)
+		retryFields["error"] = err.Error()
+		logger.Warning("Retrying validation call", retryFields)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"ollama-proxy/logger"
+)
+
+// useValidationRetries sets the retry policy for the rest of the test
+func useValidationRetries(t *testing.T, attempts int, backoff, budget time.Duration) {
+	t.Helper()
+	oldAttempts, oldBackoff, oldBudget := validationRetryAttempts, validationRetryBackoff, validationRetryBudget
+	t.Cleanup(func() {
+		validationRetryAttempts, validationRetryBackoff, validationRetryBudget = oldAttempts, oldBackoff, oldBudget
+	})
+	validationRetryAttempts, validationRetryBackoff, validationRetryBudget = attempts, backoff, budget
+}
+
+// flakyValidationServer fails the first failures calls with fail, then answers with response
+func flakyValidationServer(t *testing.T, failures int32, fail func(w http.ResponseWriter), response ValidationResponse) (*httptest.Server, *atomic.Int32) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			return
+		}
+		if calls.Add(1) <= failures {
+			fail(w)
+			return
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+// failWithStatus fails a validation call with an HTTP status
+func failWithStatus(status int) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) { w.WriteHeader(status) }
+}
+
+// dropConnection fails a validation call by closing the connection without answering
+func dropConnection(w http.ResponseWriter) {
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err == nil {
+		conn.Close()
+	}
+}
+
+// TestValidationRetry tests which failed validation calls are retried
+func TestValidationRetry(t *testing.T) {
+	testCases := []struct {
+		name          string
+		failures      int32
+		fail          func(w http.ResponseWriter)
+		response      ValidationResponse
+		attempts      int
+		budget        time.Duration
+		expectedOK    bool
+		expectedCalls int32
+	}{
+		{"Dropped Connection Then Success", 1, dropConnection, ValidationResponse{Valid: true}, 2, time.Second, true, 2},
+		{"Service Unavailable Then Success", 1, failWithStatus(http.StatusServiceUnavailable), ValidationResponse{Valid: true}, 2, time.Second, true, 2},
+		{"Bad Gateway Then Success", 1, failWithStatus(http.StatusBadGateway), ValidationResponse{Valid: true}, 2, time.Second, true, 2},
+		{"Gateway Timeout Then Success", 1, failWithStatus(http.StatusGatewayTimeout), ValidationResponse{Valid: true}, 2, time.Second, true, 2},
+		{"Invalid Key Not Retried", 0, nil, ValidationResponse{Valid: false}, 2, time.Second, false, 1},
+		{"Internal Error Not Retried", 1, failWithStatus(http.StatusInternalServerError), ValidationResponse{Valid: true}, 2, time.Second, false, 1},
+		{"Unauthorized Not Retried", 1, failWithStatus(http.StatusUnauthorized), ValidationResponse{Valid: true}, 2, time.Second, false, 1},
+		{"Attempts Used Up", 5, failWithStatus(http.StatusServiceUnavailable), ValidationResponse{Valid: true}, 3, time.Second, false, 3},
+		{"Retries Disabled", 1, failWithStatus(http.StatusServiceUnavailable), ValidationResponse{Valid: true}, 1, time.Second, false, 1},
+		{"Budget Spent", 1, failWithStatus(http.StatusServiceUnavailable), ValidationResponse{Valid: true}, 2, time.Microsecond, false, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			useValidationRetries(t, tc.attempts, time.Millisecond, tc.budget)
+			server, calls := flakyValidationServer(t, tc.failures, tc.fail, tc.response)
+			useProxyTargets(t, ollamaURL, server.URL, "")
+
+			if _, ok, _ := validateRequest(context.Background(), RequestDetails{APIKey: "test-key", Model: "llama2"}); ok != tc.expectedOK {
+				t.Errorf("Expected ok %v, got %v", tc.expectedOK, ok)
+			}
+			if got := calls.Load(); got != tc.expectedCalls {
+				t.Errorf("Expected %d validation calls, got %d", tc.expectedCalls, got)
+			}
+		})
+	}
+}
+
+// TestValidationRetryLogsRetries tests that the number of retries a call needed is logged
+func TestValidationRetryLogsRetries(t *testing.T) {
+	useValidationRetries(t, 2, time.Millisecond, time.Second)
+	server, _ := flakyValidationServer(t, 1, failWithStatus(http.StatusServiceUnavailable), ValidationResponse{Valid: true})
+	useProxyTargets(t, ollamaURL, server.URL, "")
+
+	var logs bytes.Buffer
+	logger.SetOutput(&logs)
+	defer logger.SetOutput(os.Stdout)
+
+	if _, ok, _ := validateRequest(context.Background(), RequestDetails{APIKey: "test-key", Model: "llama2"}); !ok {
+		t.Fatal("Expected the retried call to succeed")
+	}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry struct {
+			Message string                 `json:"message"`
+			Fields  map[string]interface{} `json:"fields"`
+		}
+		if json.Unmarshal([]byte(line), &entry) == nil && entry.Message == "Validation call succeeded after retry" {
+			if entry.Fields["retries"] != float64(1) {
+				t.Errorf("Expected retries 1, got %v", entry.Fields["retries"])
+			}
+			return
+		}
+	}
+	t.Errorf("Expected a retry log entry, got %s", logs.String())
+}
+
+// TestValidationRetryDelay tests that backoff doubles per retry, stays within its jitter range and the budget
+func TestValidationRetryDelay(t *testing.T) {
+	useValidationRetries(t, 5, 100*time.Millisecond, time.Second)
+	for retry, base := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 3: 400 * time.Millisecond, 5: time.Second} {
+		for i := 0; i < 20; i++ {
+			if delay := validationRetryDelay(retry); delay < base/2 || delay > base {
+				t.Errorf("Retry %d: expected a delay between %v and %v, got %v", retry, base/2, base, delay)
+			}
+		}
+	}
+}
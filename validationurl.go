@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"ollama-proxy/logger"
+)
+
+// validationURLMu guards externalValidationURL, which the admin API can replace while requests
+// are being validated
+var validationURLMu sync.RWMutex
+
+// ValidationURLRequest is the body of PUT /admin/config/validation-url
+type ValidationURLRequest struct {
+	URL string `json:"url"`
+}
+
+// ValidationURLResponse reports the validation URL in effect after a swap
+type ValidationURLResponse struct {
+	URL         string `json:"url"`
+	PreviousURL string `json:"previousUrl"`
+}
+
+// currentValidationURL returns the validation URL requests are sent to
+func currentValidationURL() string {
+	validationURLMu.RLock()
+	defer validationURLMu.RUnlock()
+	return externalValidationURL
+}
+
+// swapValidationURL replaces the validation URL and returns the one it replaced
+func swapValidationURL(validationURL string) string {
+	validationURLMu.Lock()
+	defer validationURLMu.Unlock()
+	previous := externalValidationURL
+	externalValidationURL = validationURL
+	recordConfig("EXTERNAL_VALIDATION_URL", validationURL)
+	return previous
+}
+
+// adminValidationURLHandler serves PUT /admin/config/validation-url, switching validation to a new
+// URL once it answers a test GET (or gRPC health check), so the validation service can be migrated without a restart
+func adminValidationURLHandler(w http.ResponseWriter, r *http.Request) {
+	if adminAPIKey == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !isAdminRequest(r) {
+		logger.Warning("Unauthorized: Invalid admin key", map[string]interface{}{
+			"endpoint": r.URL.Path,
+		})
+		http.Error(w, "Unauthorized: Invalid admin key", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(externalValidationURLs) > 0 {
+		// Validation fails over across the list and never consults the single URL
+		http.Error(w, "EXTERNAL_VALIDATION_URLS is set; change it and restart instead", http.StatusConflict)
+		return
+	}
+
+	var req ValidationURLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	parsed, err := url.Parse(req.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https" && !isGRPCValidationURL(req.URL)) || parsed.Host == "" {
+		http.Error(w, "Invalid validation URL", http.StatusBadRequest)
+		return
+	}
+
+	fields := map[string]interface{}{
+		"remote_addr": r.RemoteAddr,
+		"old_url":     currentValidationURL(),
+		"new_url":     req.URL,
+	}
+
+	// Test the new URL before switching, so requests never see a service that doesn't answer
+	if err := checkValidationURL(req.URL); err != nil {
+		logger.Warning("Validation URL change rejected", fields)
+		http.Error(w, "Validation URL failed its test call: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	previous := swapValidationURL(req.URL)
+	fields["old_url"] = previous
+	logger.Info("Validation URL changed", fields)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ValidationURLResponse{URL: req.URL, PreviousURL: previous})
+}
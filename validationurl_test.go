@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// validationServerAnswering creates a validation service that answers health GETs with getStatus
+// and validates every request as valid
+func validationServerAnswering(getStatus int, valid bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.WriteHeader(getStatus)
+			return
+		}
+		json.NewEncoder(w).Encode(ValidationResponse{Valid: valid})
+	}))
+}
+
+func putValidationURL(t *testing.T, url string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(ValidationURLRequest{URL: url})
+	req := httptest.NewRequest("PUT", "/admin/config/validation-url", bytes.NewReader(body))
+	req.Header.Set("X-API-Key", "admin-secret")
+	rr := httptest.NewRecorder()
+	adminValidationURLHandler(rr, req)
+	return rr
+}
+
+// TestAdminValidationURLSwap tests switching validation to a new URL that passes its test call
+func TestAdminValidationURLSwap(t *testing.T) {
+	oldServer := validationServerAnswering(http.StatusOK, false)
+	defer oldServer.Close()
+	newServer := validationServerAnswering(http.StatusOK, true)
+	defer newServer.Close()
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+
+	useProxyTargets(t, ollamaServer.URL, oldServer.URL, "")
+	adminAPIKey = "admin-secret"
+	apiKeyHeaderName = "X-API-Key"
+	defer func() { adminAPIKey = "" }()
+	resetReverseProxy()
+
+	chat := ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "Hello"}}}
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", chat, "test-key"))
+	assertResponseStatus(t, rr, http.StatusUnauthorized)
+
+	rr = putValidationURL(t, newServer.URL)
+	assertResponseStatus(t, rr, http.StatusOK)
+	var resp ValidationURLResponse
+	json.NewDecoder(rr.Body).Decode(&resp)
+	if resp.URL != newServer.URL || resp.PreviousURL != oldServer.URL {
+		t.Errorf("Unexpected swap response %+v", resp)
+	}
+	if currentValidationURL() != newServer.URL {
+		t.Errorf("Expected validation URL %s, got %s", newServer.URL, currentValidationURL())
+	}
+
+	rr = httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", chat, "test-key"))
+	assertResponseStatus(t, rr, http.StatusOK)
+}
+
+// TestAdminValidationURLRejected tests that a URL failing its test call or the request checks is never used
+func TestAdminValidationURLRejected(t *testing.T) {
+	broken := validationServerAnswering(http.StatusServiceUnavailable, true)
+	defer broken.Close()
+
+	useProxyTargets(t, ollamaURL, "http://validation.internal/validate", "")
+	adminAPIKey = "admin-secret"
+	apiKeyHeaderName = "X-API-Key"
+	defer func() {
+		adminAPIKey = ""
+		externalValidationURLs = nil
+	}()
+
+	rr := putValidationURL(t, broken.URL)
+	assertResponseStatus(t, rr, http.StatusBadGateway)
+	rr = putValidationURL(t, "not a url")
+	assertResponseStatus(t, rr, http.StatusBadRequest)
+	if currentValidationURL() != "http://validation.internal/validate" {
+		t.Errorf("Expected the validation URL to be kept, got %s", currentValidationURL())
+	}
+
+	req := httptest.NewRequest("PUT", "/admin/config/validation-url", strings.NewReader(`{"url":"http://other"}`))
+	req.Header.Set("X-API-Key", "wrong")
+	rr = httptest.NewRecorder()
+	adminValidationURLHandler(rr, req)
+	assertResponseStatus(t, rr, http.StatusUnauthorized)
+
+	externalValidationURLs = []string{"http://a.internal", "http://b.internal"}
+	rr = putValidationURL(t, broken.URL)
+	assertResponseStatus(t, rr, http.StatusConflict)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"ollama-proxy/logger"
+)
+
+// Validator decides whether a request may use its API key. An error means no decision could be
+// made, e.g. the validation service was unreachable, and the request is refused.
+type Validator interface {
+	Validate(ctx context.Context, details RequestDetails) (ValidationResult, error)
+}
+
+// ValidationResult is a validator's answer, in the validation service's format, and whether it allows the request
+type ValidationResult struct {
+	ValidationResponse
+	Allowed bool
+}
+
+// requestValidator validates every request; main replaces it with the one VALIDATION_MODE configures
+var requestValidator Validator = httpValidator{}
+
+// errNoValidationTarget is returned when every validation URL failed
+var errNoValidationTarget = errors.New("no validation URL answered")
+
+// validationModes returns the validators named in VALIDATION_MODE, in order
+func validationModes() []string {
+	var modes []string
+	for _, mode := range strings.Split(validationMode, ",") {
+		if mode = strings.TrimSpace(mode); mode != "" {
+			modes = append(modes, mode)
+		}
+	}
+	return modes
+}
+
+// validationModeEnabled reports whether VALIDATION_MODE includes mode
+func validationModeEnabled(mode string) bool {
+	for _, m := range validationModes() {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// newValidator builds the validator VALIDATION_MODE configures, loading API_KEYS_FILE when it includes
+// local. VALIDATION_MOCK overrides the mode.
+func newValidator() (Validator, error) {
+	if validationMock {
+		return mockValidator{}, nil
+	}
+
+	var chain validatorChain
+	for _, mode := range validationModes() {
+		switch mode {
+		case "external":
+			if batchValidationEnabled() && !anyHTTPValidationURL() {
+				return nil, fmt.Errorf("EXTERNAL_VALIDATION_TYPE=batch needs an http:// or https:// validation URL")
+			}
+			chain = append(chain, httpValidator{})
+		case "local":
+			if apiKeysFile == "" {
+				return nil, fmt.Errorf("VALIDATION_MODE=local requires API_KEYS_FILE")
+			}
+			if _, err := localKeys.reload(apiKeysFile, true); err != nil {
+				return nil, err
+			}
+			chain = append(chain, localKeys)
+		default:
+			return nil, fmt.Errorf("VALIDATION_MODE must be external, local, or a comma-separated chain of them, got %q", validationMode)
+		}
+	}
+	switch len(chain) {
+	case 0:
+		return nil, fmt.Errorf("VALIDATION_MODE must name at least one validator")
+	case 1:
+		return chain[0], nil
+	}
+	return chain, nil
+}
+
+// validateValidationModeConfig builds requestValidator from VALIDATION_MODE
+func validateValidationModeConfig() error {
+	validator, err := newValidator()
+	if err != nil {
+		return err
+	}
+	requestValidator = validator
+	return nil
+}
+
+// validatorChain runs validators in order; the first denial or error wins, so a local allowlist can guard
+// a remote quota service. A request all of them allow gets the last validator's answer, restricted by
+// every earlier one: allowed models, endpoints, IP ranges and scopes are only those all of them grant,
+// and any validator can ask for zero retention.
+type validatorChain []Validator
+
+func (c validatorChain) Validate(ctx context.Context, details RequestDetails) (ValidationResult, error) {
+	var merged ValidationResult
+	for i, v := range c {
+		result, err := v.Validate(ctx, details)
+		if err != nil || !result.Allowed {
+			return result, err
+		}
+		if i > 0 {
+			result.ValidationResponse = restrictValidation(result.ValidationResponse, merged.ValidationResponse)
+		}
+		merged = result
+	}
+	return merged, nil
+}
+
+// restrictValidation narrows a validation answer to what an earlier answer also grants. An absent list
+// allows everything, so it takes the other answer's list; two lists keep only what both allow, which can
+// leave an empty list that allows nothing.
+func restrictValidation(last, earlier ValidationResponse) ValidationResponse {
+	last.ZeroRetention = last.ZeroRetention || earlier.ZeroRetention
+	last.AllowedModels = intersectModels(last.AllowedModels, earlier.AllowedModels)
+	last.AllowedEndpoints = intersectRestriction(last.AllowedEndpoints, earlier.AllowedEndpoints)
+	last.AllowedCIDRs = intersectCIDRs(last.AllowedCIDRs, earlier.AllowedCIDRs)
+	last.Scopes = intersectStrings(last.Scopes, earlier.Scopes)
+	return last
+}
+
+// intersectStrings returns the values in both lists
+func intersectStrings(a, b []string) []string {
+	var both []string
+	for _, value := range a {
+		if slices.Contains(b, value) {
+			both = append(both, value)
+		}
+	}
+	return both
+}
+
+// intersectRestriction intersects two allow lists where nil allows everything
+func intersectRestriction(a, b []string) []string {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	both := intersectStrings(a, b)
+	if both == nil {
+		both = []string{}
+	}
+	return both
+}
+
+// intersectModels intersects two allowed model lists, keeping each name or glob the other list allows,
+// e.g. llama3:* and llama3:8b give llama3:8b
+func intersectModels(a, b []string) []string {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	both := []string{}
+	for _, model := range a {
+		if modelAllowed(b, model) {
+			both = append(both, model)
+		}
+	}
+	for _, model := range b {
+		if modelAllowed(a, model) && !slices.Contains(both, model) {
+			both = append(both, model)
+		}
+	}
+	return both
+}
+
+// intersectCIDRs intersects two allowed IP range lists, keeping the narrower of each overlapping pair.
+// A list with an invalid range is ignored when the proxy checks it, so it doesn't restrict the other.
+func intersectCIDRs(a, b []string) []string {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	aPrefixes, aOK := parseCIDRs(a)
+	bPrefixes, bOK := parseCIDRs(b)
+	switch {
+	case !aOK:
+		return b
+	case !bOK:
+		return a
+	}
+	both := []string{}
+	for _, p := range aPrefixes {
+		for _, q := range bPrefixes {
+			if !p.Overlaps(q) {
+				continue
+			}
+			narrower := p
+			if q.Bits() > p.Bits() {
+				narrower = q
+			}
+			if cidr := narrower.String(); !slices.Contains(both, cidr) {
+				both = append(both, cidr)
+			}
+		}
+	}
+	return both
+}
+
+// parseCIDRs parses IP ranges, reporting false if any is invalid
+func parseCIDRs(cidrs []string) ([]netip.Prefix, bool) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, false
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, true
+}
+
+// validateRequest validates a request with requestValidator, giving up when ctx ends. A validator that
+// couldn't decide returns its error, so callers can tell an outage from a refused key.
+func validateRequest(ctx context.Context, details RequestDetails) (ValidationResponse, bool, error) {
+	result, err := requestValidator.Validate(ctx, details)
+	if err != nil {
+		return ValidationResponse{}, false, err
+	}
+	return result.ValidationResponse, result.Allowed, nil
+}
+
+// httpValidator asks the validation service, with JSON over HTTP or, for grpc:// and grpcs:// URLs, gRPC,
+// and in batches when EXTERNAL_VALIDATION_TYPE=batch
+type httpValidator struct{}
+
+func (httpValidator) Validate(ctx context.Context, details RequestDetails) (ValidationResult, error) {
+	details.Version = requestDetailsVersion
+	if batchValidationEnabled() {
+		response, ok, err := getValidationBatcher().validate(ctx, details)
+		return ValidationResult{ValidationResponse: response, Allowed: ok}, err
+	}
+
+	jsonData, err := json.Marshal(details)
+	if err != nil {
+		logger.Error("Error marshaling validation request", err, map[string]interface{}{
+			"api_key":  details.APIKey,
+			"endpoint": details.Endpoint,
+		})
+		return ValidationResult{}, err
+	}
+
+	// Try each validation URL in turn, healthy ones first, retrying transient failures within one budget
+	deadline := time.Now().Add(validationRetryBudget)
+	for _, target := range validationTargets() {
+		validationResp, err := callValidationServiceWithRetry(ctx, target, jsonData, details, deadline)
+		if err != nil {
+			continue
+		}
+		return ValidationResult{ValidationResponse: validationResp, Allowed: validationResp.Valid && !validationResp.RateLimited}, nil
+	}
+	return ValidationResult{}, errNoValidationTarget
+}
+
+// mockValidator answers from VALIDATION_MOCK_VALID and VALIDATION_MOCK_RATE_LIMITED
+type mockValidator struct{}
+
+func (mockValidator) Validate(ctx context.Context, details RequestDetails) (ValidationResult, error) {
+	response, ok := mockValidateRequest(details)
+	return ValidationResult{ValidationResponse: response, Allowed: ok}, nil
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+// validatorFunc adapts a function to Validator
+type validatorFunc func(ctx context.Context, details RequestDetails) (ValidationResult, error)
+
+func (f validatorFunc) Validate(ctx context.Context, details RequestDetails) (ValidationResult, error) {
+	return f(ctx, details)
+}
+
+// useValidator makes v validate requests for the rest of the test
+func useValidator(tb testing.TB, v Validator) {
+	old := requestValidator
+	tb.Cleanup(func() { requestValidator = old })
+	requestValidator = v
+}
+
+// allowing returns a validator that allows every request with response, counting calls
+func allowing(response ValidationResponse, calls *atomic.Int32) Validator {
+	return validatorFunc(func(ctx context.Context, details RequestDetails) (ValidationResult, error) {
+		calls.Add(1)
+		return ValidationResult{ValidationResponse: response, Allowed: true}, nil
+	})
+}
+
+// TestNewValidator tests the validators VALIDATION_MODE builds
+func TestNewValidator(t *testing.T) {
+	path := useLocalValidation(t, "key-one\n")
+	oldMock := validationMock
+	defer func() { validationMock = oldMock }()
+
+	testCases := []struct {
+		name  string
+		mode  string
+		mock  bool
+		check func(Validator) bool
+	}{
+		{"External", "external", false, func(v Validator) bool { _, ok := v.(httpValidator); return ok }},
+		{"Local", "local", false, func(v Validator) bool { return v == Validator(localKeys) }},
+		{"Chain", "local, external", false, func(v Validator) bool {
+			chain, ok := v.(validatorChain)
+			return ok && len(chain) == 2 && chain[0] == Validator(localKeys)
+		}},
+		{"Mock Overrides Mode", "external", true, func(v Validator) bool { _, ok := v.(mockValidator); return ok }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			validationMode, apiKeysFile, validationMock = tc.mode, path, tc.mock
+			v, err := newValidator()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tc.check(v) {
+				t.Errorf("Unexpected validator %#v", v)
+			}
+		})
+	}
+}
+
+// TestValidatorChain tests that the first denial or error wins and allowed requests get the last answer
+// restricted by the earlier ones
+func TestValidatorChain(t *testing.T) {
+	var first, last atomic.Int32
+	denying := validatorFunc(func(ctx context.Context, details RequestDetails) (ValidationResult, error) {
+		return ValidationResult{ValidationResponse: ValidationResponse{Reason: "invalid_key"}}, nil
+	})
+	failing := validatorFunc(func(ctx context.Context, details RequestDetails) (ValidationResult, error) {
+		return ValidationResult{}, errNoValidationTarget
+	})
+
+	result, err := validatorChain{allowing(ValidationResponse{Valid: true, Tier: "free"}, &first), allowing(ValidationResponse{Valid: true, Tier: "pro"}, &last)}.Validate(context.Background(), RequestDetails{})
+	if err != nil || !result.Allowed || result.Tier != "pro" || first.Load() != 1 || last.Load() != 1 {
+		t.Errorf("Expected both validators to allow with the last answer, got %+v, %v", result, err)
+	}
+
+	result, err = validatorChain{denying, allowing(ValidationResponse{Valid: true}, &last)}.Validate(context.Background(), RequestDetails{})
+	if err != nil || result.Allowed || result.Reason != "invalid_key" || last.Load() != 1 {
+		t.Errorf("Expected the first denial to win without calling later validators, got %+v, %v", result, err)
+	}
+
+	result, err = validatorChain{failing, allowing(ValidationResponse{Valid: true}, &last)}.Validate(context.Background(), RequestDetails{})
+	if !errors.Is(err, errNoValidationTarget) || result.Allowed || last.Load() != 1 {
+		t.Errorf("Expected the error to win, got %+v, %v", result, err)
+	}
+
+	testCases := []struct {
+		name           string
+		earlier, later ValidationResponse
+		expected       ValidationResponse
+	}{
+		{
+			"Absent Lists",
+			ValidationResponse{Valid: true},
+			ValidationResponse{Valid: true, AllowedModels: []string{"llama3"}, AllowedEndpoints: []string{"chat"}},
+			ValidationResponse{Valid: true, AllowedModels: []string{"llama3"}, AllowedEndpoints: []string{"chat"}},
+		},
+		{
+			"Intersected Lists",
+			ValidationResponse{Valid: true, AllowedModels: []string{"llama3:*", "mistral"}, AllowedEndpoints: []string{"chat", "embed"}, AllowedCIDRs: []string{"10.0.0.0/8"}, Scopes: []string{"admin", "billing"}},
+			ValidationResponse{Valid: true, AllowedModels: []string{"llama3:8b", "phi3"}, AllowedEndpoints: []string{"chat", "generate"}, AllowedCIDRs: []string{"10.1.0.0/16", "192.168.0.0/16"}, Scopes: []string{"admin"}},
+			ValidationResponse{Valid: true, AllowedModels: []string{"llama3:8b"}, AllowedEndpoints: []string{"chat"}, AllowedCIDRs: []string{"10.1.0.0/16"}, Scopes: []string{"admin"}},
+		},
+		{
+			"Disjoint Lists",
+			ValidationResponse{Valid: true, AllowedModels: []string{"mistral"}, AllowedEndpoints: []string{"embed"}},
+			ValidationResponse{Valid: true, AllowedModels: []string{"llama3"}, AllowedEndpoints: []string{"chat"}},
+			ValidationResponse{Valid: true, AllowedModels: []string{}, AllowedEndpoints: []string{}},
+		},
+		{
+			"Zero Retention",
+			ValidationResponse{Valid: true, ZeroRetention: true, Tier: "free"},
+			ValidationResponse{Valid: true, Tier: "pro"},
+			ValidationResponse{Valid: true, ZeroRetention: true, Tier: "pro"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls atomic.Int32
+			result, err := validatorChain{allowing(tc.earlier, &calls), allowing(tc.later, &calls)}.Validate(context.Background(), RequestDetails{})
+			if err != nil || !result.Allowed || !reflect.DeepEqual(result.ValidationResponse, tc.expected) {
+				t.Errorf("Expected %+v, got %+v, %v", tc.expected, result.ValidationResponse, err)
+			}
+		})
+	}
+}
+
+// TestValidateRequestContext tests that validation gives up once the request's context ends
+func TestValidateRequestContext(t *testing.T) {
+	useValidator(t, validatorFunc(func(ctx context.Context, details RequestDetails) (ValidationResult, error) {
+		if err := ctx.Err(); err != nil {
+			return ValidationResult{}, err
+		}
+		return ValidationResult{ValidationResponse: ValidationResponse{Valid: true}, Allowed: true}, nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, ok, _ := validateRequest(ctx, RequestDetails{APIKey: "test-key"}); !ok {
+		t.Error("Expected validation to succeed before the request ends")
+	}
+	cancel()
+	if _, ok, _ := validateRequest(ctx, RequestDetails{APIKey: "test-key"}); ok {
+		t.Error("Expected validation to fail after the request ends")
+	}
+}
+
+// TestProxyHandlerValidator tests the handler against validators without a validation service
+func TestProxyHandlerValidator(t *testing.T) {
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	useProxyTargets(t, ollamaServer.URL, "", "")
+
+	var seen RequestDetails
+	testCases := []struct {
+		name           string
+		result         ValidationResult
+		err            error
+		expectedStatus int
+	}{
+		{"Allowed", ValidationResult{ValidationResponse: ValidationResponse{Valid: true}, Allowed: true}, nil, http.StatusOK},
+		{"Rate Limited", ValidationResult{ValidationResponse: ValidationResponse{Valid: true, RateLimited: true}}, nil, http.StatusTooManyRequests},
+		{"Model Not Allowed", ValidationResult{ValidationResponse: ValidationResponse{Reason: "model_not_allowed"}}, nil, http.StatusForbidden},
+		{"Undecided", ValidationResult{}, errNoValidationTarget, http.StatusServiceUnavailable},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			useValidator(t, validatorFunc(func(ctx context.Context, details RequestDetails) (ValidationResult, error) {
+				seen = details
+				return tc.result, tc.err
+			}))
+			rr := httptest.NewRecorder()
+			proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "Hi"}}}, "test-key"))
+			assertResponseStatus(t, rr, tc.expectedStatus)
+			var errResp ErrorResponse
+			if json.Unmarshal(rr.Body.Bytes(), &errResp); tc.err != nil && errResp.Code != "validation_unavailable" {
+				t.Errorf("Expected code validation_unavailable, got %s", rr.Body.String())
+			}
+			if seen.APIKey != "test-key" || seen.Model != "llama2" || seen.Endpoint != "/api/chat" {
+				t.Errorf("Unexpected request details %+v", seen)
+			}
+		})
+	}
+}
+
+// TestProxyHandlerValidatorChain tests a local allowlist in front of the validation service
+func TestProxyHandlerValidatorChain(t *testing.T) {
+	useLocalValidation(t, "team-key\n")
+	ollamaServer := mockOllamaServer(t)
+	defer ollamaServer.Close()
+	validationServer, calls := countingValidationServer(t, http.StatusOK)
+	useProxyTargets(t, ollamaServer.URL, validationServer.URL, "")
+
+	validationMode = "local,external"
+	if err := validateValidationModeConfig(); err != nil {
+		t.Fatal(err)
+	}
+
+	chat := ChatRequest{Model: "llama2", Messages: []ChatMessage{{Role: "user", Content: "Hi"}}}
+	rr := httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", chat, "other-key"))
+	assertResponseStatus(t, rr, http.StatusUnauthorized)
+	if calls.Load() != 0 {
+		t.Errorf("Expected a key missing from the allowlist not to reach the validation service, got %d calls", calls.Load())
+	}
+
+	rr = httptest.NewRecorder()
+	proxyHandler(rr, createTestRequest(t, "POST", "/api/chat", chat, "team-key"))
+	assertResponseStatus(t, rr, http.StatusOK)
+	if calls.Load() != 1 {
+		t.Errorf("Expected an allowlisted key to be checked by the validation service, got %d calls", calls.Load())
+	}
+}
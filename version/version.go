@@ -0,0 +1,28 @@
+package version
+
+// Header identifies the proxy instance on responses to clients and requests to Ollama and the
+// validation and metrics services
+const Header = "X-Ollama-Proxy-Version"
+
+// Build information, set at link time:
+//
+//	go build -ldflags "-X ollama-proxy/version.Version=v1.4.0 -X ollama-proxy/version.Commit=3f2a9c1"
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// full is computed once, after the linker has set Version and Commit
+var full = format(Version, Commit)
+
+// String returns the version with the commit as build metadata, e.g. v1.4.0+3f2a9c1
+func String() string {
+	return full
+}
+
+func format(version, commit string) string {
+	if commit == "" {
+		return version
+	}
+	return version + "+" + commit
+}